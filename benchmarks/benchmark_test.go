@@ -33,6 +33,7 @@ import (
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-data-transfer/benchmarks/testinstance"
 	tn "github.com/filecoin-project/go-data-transfer/benchmarks/testnet"
+	dtimpl "github.com/filecoin-project/go-data-transfer/impl"
 	"github.com/filecoin-project/go-data-transfer/testutil"
 )
 
@@ -50,26 +51,53 @@ func BenchmarkRoundtripSuccess(b *testing.B) {
 	tdm, err := newTempDirMaker(b)
 	require.NoError(b, err)
 	b.Run("test-p2p-stress-10-128MB", func(b *testing.B) {
-		p2pStrestTest(ctx, b, 10, allFilesUniformSize(128*(1<<20), 1<<20, 1024, true), tdm, false, false)
+		p2pStrestTest(ctx, b, 10, allFilesUniformSize(128*(1<<20), 1<<20, 1024, true), tdm, false, nil)
 	})
 	b.Run("test-p2p-stress-10-128MB-1KB-chunks", func(b *testing.B) {
-		p2pStrestTest(ctx, b, 10, allFilesUniformSize(128*(1<<20), 1<<10, 1024, true), tdm, false, false)
+		p2pStrestTest(ctx, b, 10, allFilesUniformSize(128*(1<<20), 1<<10, 1024, true), tdm, false, nil)
 	})
 	b.Run("test-p2p-stress-1-1GB", func(b *testing.B) {
-		p2pStrestTest(ctx, b, 1, allFilesUniformSize(1*(1<<30), 1<<20, 1024, true), tdm, true, true)
+		p2pStrestTest(ctx, b, 1, allFilesUniformSize(1*(1<<30), 1<<20, 1024, true), tdm, true, &limitedLink)
 	})
 	b.Run("test-p2p-stress-1-1GB-no-raw-nodes", func(b *testing.B) {
-		p2pStrestTest(ctx, b, 1, allFilesUniformSize(1*(1<<30), 1<<20, 1024, false), tdm, true, true)
+		p2pStrestTest(ctx, b, 1, allFilesUniformSize(1*(1<<30), 1<<20, 1024, false), tdm, true, &limitedLink)
 	})
 }
 
-func p2pStrestTest(ctx context.Context, b *testing.B, numfiles int, df distFunc, tdm *tempDirMaker, diskBasedDatastore bool, limitBandwidth bool) {
+func BenchmarkNetworkProfiles(b *testing.B) {
+	ctx := context.Background()
+	tdm, err := newTempDirMaker(b)
+	require.NoError(b, err)
+	lan := tn.LAN()
+	transatlantic := tn.Transatlantic()
+	mobile := tn.FlakyMobile()
+	b.Run("test-p2p-lan-1-64MB", func(b *testing.B) {
+		p2pStrestTest(ctx, b, 1, allFilesUniformSize(64*(1<<20), 1<<20, 1024, true), tdm, false, &lan)
+	})
+	b.Run("test-p2p-transatlantic-1-64MB", func(b *testing.B) {
+		p2pStrestTest(ctx, b, 1, allFilesUniformSize(64*(1<<20), 1<<20, 1024, true), tdm, false, &transatlantic)
+	})
+	b.Run("test-p2p-flaky-mobile-1-2MB", func(b *testing.B) {
+		p2pStrestTest(ctx, b, 1, allFilesUniformSize(2*(1<<20), 1<<20, 1024, true), tdm, false, &mobile,
+			dtimpl.RestartOnReconnect(100*time.Millisecond, time.Second, 10))
+	})
+}
+
+// limitedLink is a 16MB/s link with 100ms latency
+var limitedLink = tn.Symmetric(tn.LinkProfile{Latency: 100 * time.Millisecond, Bandwidth: 16 << 20})
+
+// p2pStrestTest pushes files from one instance to b.N others. If link is not
+// nil, the instances are on an emulated network with that link between each
+// pair, where the pusher is the first peer.
+func p2pStrestTest(ctx context.Context, b *testing.B, numfiles int, df distFunc, tdm *tempDirMaker, diskBasedDatastore bool, link *tn.LinkConfig, options ...dtimpl.DataTransferOption) {
 	mn := mocknet.New(ctx)
-	if limitBandwidth {
-		mn.SetLinkDefaults(mocknet.LinkOptions{Latency: 100 * time.Millisecond, Bandwidth: 16 << 20})
+	var net tn.Network
+	if link != nil {
+		net = tn.EmulatedNet(ctx, mn, *link)
+	} else {
+		net = tn.StreamNet(ctx, mn)
 	}
-	net := tn.StreamNet(ctx, mn)
-	ig := testinstance.NewTestInstanceGenerator(ctx, net, tdm, diskBasedDatastore)
+	ig := testinstance.NewTestInstanceGenerator(ctx, net, tdm, diskBasedDatastore, options...)
 	instances, err := ig.Instances(1 + b.N)
 	require.NoError(b, err)
 	var allCids []cid.Cid
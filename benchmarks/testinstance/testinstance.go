@@ -35,8 +35,8 @@ type TempDirGenerator interface {
 }
 
 // NewTestInstanceGenerator generates a new InstanceGenerator for the given
-// testnet
-func NewTestInstanceGenerator(ctx context.Context, net tn.Network, tempDirGenerator TempDirGenerator, diskBasedDatastore bool) InstanceGenerator {
+// testnet. The options are passed to every data transfer manager.
+func NewTestInstanceGenerator(ctx context.Context, net tn.Network, tempDirGenerator TempDirGenerator, diskBasedDatastore bool, options ...dtimpl.DataTransferOption) InstanceGenerator {
 	ctx, cancel := context.WithCancel(ctx)
 	return InstanceGenerator{
 		net:                net,
@@ -45,6 +45,7 @@ func NewTestInstanceGenerator(ctx context.Context, net tn.Network, tempDirGenera
 		cancel:             cancel,
 		tempDirGenerator:   tempDirGenerator,
 		diskBasedDatastore: diskBasedDatastore,
+		options:            options,
 	}
 }
 
@@ -56,6 +57,7 @@ type InstanceGenerator struct {
 	cancel             context.CancelFunc
 	tempDirGenerator   TempDirGenerator
 	diskBasedDatastore bool
+	options            []dtimpl.DataTransferOption
 }
 
 // Close closes the clobal context, shutting down all test instances
@@ -67,7 +69,7 @@ func (g *InstanceGenerator) Close() error {
 // Next generates a new instance of graphsync + dependencies
 func (g *InstanceGenerator) Next() (Instance, error) {
 	g.seq++
-	return NewInstance(g.ctx, g.net, g.tempDirGenerator.TempDir(), g.diskBasedDatastore)
+	return NewInstance(g.ctx, g.net, g.tempDirGenerator.TempDir(), g.diskBasedDatastore, g.options...)
 }
 
 // Instances creates N test instances of bitswap + dependencies and connects
@@ -142,7 +144,7 @@ func (i *Instance) SetBlockstoreLatency(t time.Duration) time.Duration {
 // NB: It's easy make mistakes by providing the same peer ID to two different
 // instances. To safeguard, use the InstanceGenerator to generate instances. It's
 // just a much better idea.
-func NewInstance(ctx context.Context, net tn.Network, tempDir string, diskBasedDatastore bool) (Instance, error) {
+func NewInstance(ctx context.Context, net tn.Network, tempDir string, diskBasedDatastore bool, options ...dtimpl.DataTransferOption) (Instance, error) {
 	bsdelay := delay.Fixed(0)
 
 	p, gsNet, dtNet := net.Adapter()
@@ -171,7 +173,7 @@ func NewInstance(ctx context.Context, net tn.Network, tempDir string, diskBasedD
 	gs := gsimpl.New(ctx, gsNet, loader, storer, gsimpl.RejectAllRequestsByDefault())
 	transport := gstransport.NewTransport(p, gs)
 	dtCounter := storedcounter.New(dstore, datastore.NewKey("/data-transfers/counter"))
-	dt, err := dtimpl.NewDataTransfer(namespace.Wrap(dstore, datastore.NewKey("/data-transfers/transfers")), os.TempDir(), dtNet, transport, dtCounter)
+	dt, err := dtimpl.NewDataTransfer(namespace.Wrap(dstore, datastore.NewKey("/data-transfers/transfers")), os.TempDir(), dtNet, transport, dtCounter, options...)
 	if err != nil {
 		return Instance{}, err
 	}
@@ -0,0 +1,502 @@
+package testnet
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	gsnet "github.com/ipfs/go-graphsync/network"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	mockpeernet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"golang.org/x/xerrors"
+
+	dtnet "github.com/filecoin-project/go-data-transfer/network"
+)
+
+var log = logging.Logger("dt-testnet")
+
+// packetSize is the payload of one emulated packet, for packet loss
+const packetSize = 1460
+
+// LinkProfile describes one direction of the link between two peers
+type LinkProfile struct {
+	// Latency is the one way delay of every write
+	Latency time.Duration
+	// Jitter is a random extra delay, up to Jitter, added to every write
+	Jitter time.Duration
+	// Bandwidth is the capacity of the link in bytes per second, shared by all
+	// streams in this direction. Zero means unlimited.
+	Bandwidth float64
+	// PacketLoss is the fraction of packets lost, from 0 to 1. A lost packet
+	// holds up its stream for a round trip, as a TCP retransmit would.
+	PacketLoss float64
+}
+
+// Outage is a time when the link between two peers is down. The peers are
+// disconnected when it starts, and can connect again when it ends.
+type Outage struct {
+	// After is when the outage starts, from when the link was set up
+	After time.Duration
+	// Duration is how long the link stays down
+	Duration time.Duration
+}
+
+// LinkConfig configures the link between two peers
+type LinkConfig struct {
+	// Forward is the direction from the first peer to the second
+	Forward LinkProfile
+	// Reverse is the direction from the second peer to the first
+	Reverse LinkProfile
+	// Outages are the times the link goes down, sorted by start time
+	Outages []Outage
+	// OutagePeriod repeats the outages with this period, unless it is zero
+	OutagePeriod time.Duration
+}
+
+// Symmetric returns a link config with the same profile in both directions
+// and no outages
+func Symmetric(profile LinkProfile) LinkConfig {
+	return LinkConfig{Forward: profile, Reverse: profile}
+}
+
+// LAN is a link on a local gigabit network
+func LAN() LinkConfig {
+	return Symmetric(LinkProfile{
+		Latency:   250 * time.Microsecond,
+		Jitter:    100 * time.Microsecond,
+		Bandwidth: 1e9 / 8,
+	})
+}
+
+// Transatlantic is a 100 megabit link between datacenters on either side of
+// the Atlantic, with an 80ms round trip and a little loss
+func Transatlantic() LinkConfig {
+	return Symmetric(LinkProfile{
+		Latency:    40 * time.Millisecond,
+		Jitter:     5 * time.Millisecond,
+		Bandwidth:  100e6 / 8,
+		PacketLoss: 0.0005,
+	})
+}
+
+// FlakyMobile is a mobile connection from the first peer, with a slow uplink,
+// high jitter and loss, and a two second dropout every thirty seconds
+func FlakyMobile() LinkConfig {
+	return LinkConfig{
+		Forward: LinkProfile{
+			Latency:    60 * time.Millisecond,
+			Jitter:     40 * time.Millisecond,
+			Bandwidth:  2e6 / 8,
+			PacketLoss: 0.01,
+		},
+		Reverse: LinkProfile{
+			Latency:    60 * time.Millisecond,
+			Jitter:     40 * time.Millisecond,
+			Bandwidth:  10e6 / 8,
+			PacketLoss: 0.01,
+		},
+		Outages:      []Outage{{After: 20 * time.Second, Duration: 2 * time.Second}},
+		OutagePeriod: 30 * time.Second,
+	}
+}
+
+type peerPair struct {
+	first  peer.ID
+	second peer.ID
+}
+
+// EmulatedNetwork is a testnet on libp2p's MockNet that emulates latency,
+// jitter, bandwidth, packet loss and outages on the link between each pair of
+// peers. Both graphsync and the data transfer protocol run over it.
+type EmulatedNetwork struct {
+	ctx      context.Context
+	mn       mockpeernet.Mocknet
+	defaults LinkConfig
+
+	lk    sync.Mutex
+	hosts map[peer.ID]host.Host
+	peers []peer.ID
+	links map[peerPair]*emulatedLink
+}
+
+// EmulatedNet returns an emulated network on the given MockNet, which should
+// have no link options of its own. Links between peers use the default
+// config, where the first peer is the one that joined the network first,
+// until they are changed with SetLink. Outages stop when ctx is done.
+func EmulatedNet(ctx context.Context, mn mockpeernet.Mocknet, defaults LinkConfig) *EmulatedNetwork {
+	return &EmulatedNetwork{
+		ctx:      ctx,
+		mn:       mn,
+		defaults: defaults,
+		hosts:    make(map[peer.ID]host.Host),
+		links:    make(map[peerPair]*emulatedLink),
+	}
+}
+
+// Adapter adds a peer to the network, linked to every other peer
+func (en *EmulatedNetwork) Adapter() (peer.ID, gsnet.GraphSyncNetwork, dtnet.DataTransferNetwork) {
+	client, err := en.mn.GenPeer()
+	if err != nil {
+		panic(err.Error())
+	}
+	h := &emulatedHost{Host: client, net: en}
+
+	en.lk.Lock()
+	for _, other := range en.peers {
+		if _, err := en.mn.LinkPeers(other, client.ID()); err != nil {
+			panic(err.Error())
+		}
+		pair := peerPair{other, client.ID()}
+		link := &emulatedLink{
+			forward: newLinkDirection(en.defaults.Forward),
+			reverse: newLinkDirection(en.defaults.Reverse),
+		}
+		en.links[pair] = link
+		en.startOutages(pair, link, en.defaults)
+	}
+	en.peers = append(en.peers, client.ID())
+	en.hosts[client.ID()] = h
+	en.lk.Unlock()
+
+	return client.ID(), gsnet.NewFromLibp2pHost(h), dtnet.NewFromLibp2pHost(h)
+}
+
+// HasPeer returns whether the peer is on the network
+func (en *EmulatedNetwork) HasPeer(p peer.ID) bool {
+	en.lk.Lock()
+	defer en.lk.Unlock()
+	_, ok := en.hosts[p]
+	return ok
+}
+
+// Host returns the emulated host of a peer on the network
+func (en *EmulatedNetwork) Host(p peer.ID) host.Host {
+	en.lk.Lock()
+	defer en.lk.Unlock()
+	return en.hosts[p]
+}
+
+// SetLink changes the link from a to b (Forward) and back (Reverse). Streams
+// that are already open use the new profiles from their next write, and the
+// outage schedule starts over.
+func (en *EmulatedNetwork) SetLink(a, b peer.ID, config LinkConfig) error {
+	en.lk.Lock()
+	defer en.lk.Unlock()
+	pair := peerPair{a, b}
+	link, ok := en.links[pair]
+	if !ok {
+		pair = peerPair{b, a}
+		link, ok = en.links[pair]
+		if !ok {
+			return xerrors.Errorf("no link between %s and %s", a, b)
+		}
+		config.Forward, config.Reverse = config.Reverse, config.Forward
+	}
+	link.forward.setProfile(config.Forward)
+	link.reverse.setProfile(config.Reverse)
+	link.stopOutages()
+	en.startOutages(pair, link, config)
+	return nil
+}
+
+// direction returns the link direction that carries writes from one peer to
+// another
+func (en *EmulatedNetwork) direction(from, to peer.ID) *linkDirection {
+	en.lk.Lock()
+	defer en.lk.Unlock()
+	if link, ok := en.links[peerPair{from, to}]; ok {
+		return link.forward
+	}
+	if link, ok := en.links[peerPair{to, from}]; ok {
+		return link.reverse
+	}
+	return nil
+}
+
+func (en *EmulatedNetwork) wrapStream(from, to peer.ID, s network.Stream) network.Stream {
+	dir := en.direction(from, to)
+	if dir == nil {
+		return s
+	}
+	return newEmulatedStream(s, dir)
+}
+
+// startOutages runs the outage schedule of a link in the background. It must
+// be called with the lock held.
+func (en *EmulatedNetwork) startOutages(pair peerPair, link *emulatedLink, config LinkConfig) {
+	if len(config.Outages) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(en.ctx)
+	link.cancelOutages = cancel
+	go en.runOutages(ctx, pair, config)
+}
+
+func (en *EmulatedNetwork) runOutages(ctx context.Context, pair peerPair, config LinkConfig) {
+	start := time.Now()
+	for period := 0; ; period++ {
+		periodStart := start.Add(time.Duration(period) * config.OutagePeriod)
+		for _, outage := range config.Outages {
+			if !waitUntil(ctx, periodStart.Add(outage.After)) {
+				return
+			}
+			if err := en.mn.UnlinkPeers(pair.first, pair.second); err != nil {
+				log.Warnf("taking down link: %s", err)
+				continue
+			}
+			if err := en.mn.DisconnectPeers(pair.first, pair.second); err != nil {
+				log.Debugf("disconnecting peers: %s", err)
+			}
+			restored := waitUntil(ctx, time.Now().Add(outage.Duration))
+			if _, err := en.mn.LinkPeers(pair.first, pair.second); err != nil {
+				log.Warnf("restoring link: %s", err)
+			}
+			if !restored {
+				return
+			}
+		}
+		if config.OutagePeriod == 0 {
+			return
+		}
+	}
+}
+
+func waitUntil(ctx context.Context, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+type emulatedLink struct {
+	forward       *linkDirection
+	reverse       *linkDirection
+	cancelOutages context.CancelFunc
+}
+
+func (l *emulatedLink) stopOutages() {
+	if l.cancelOutages != nil {
+		l.cancelOutages()
+		l.cancelOutages = nil
+	}
+}
+
+// linkDirection is one direction of a link, shared by every stream that
+// writes in that direction
+type linkDirection struct {
+	lk      sync.Mutex
+	profile LinkProfile
+	freeAt  time.Time
+	rng     *rand.Rand
+}
+
+func newLinkDirection(profile LinkProfile) *linkDirection {
+	return &linkDirection{
+		profile: profile,
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (d *linkDirection) setProfile(profile LinkProfile) {
+	d.lk.Lock()
+	d.profile = profile
+	d.lk.Unlock()
+}
+
+// schedule reserves the link for a write of n bytes. It returns when the
+// write starts going out, once the writes before it have, and when it
+// arrives at the other end.
+func (d *linkDirection) schedule(n int) (time.Time, time.Time) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	now := time.Now()
+	sendAt := now
+	if d.freeAt.After(now) {
+		sendAt = d.freeAt
+	}
+	sent := sendAt
+	if d.profile.Bandwidth > 0 {
+		sent = sendAt.Add(time.Duration(float64(n) / d.profile.Bandwidth * float64(time.Second)))
+		d.freeAt = sent
+	}
+	delay := d.profile.Latency
+	if d.profile.Jitter > 0 {
+		delay += time.Duration(d.rng.Int63n(int64(d.profile.Jitter)))
+	}
+	if d.profile.PacketLoss > 0 {
+		packets := (n + packetSize - 1) / packetSize
+		if d.rng.Float64() < 1-math.Pow(1-d.profile.PacketLoss, float64(packets)) {
+			delay += 2 * d.profile.Latency
+		}
+	}
+	return sendAt, sent.Add(delay)
+}
+
+// emulatedHost wraps the streams of a MockNet host so that writes go
+// through the emulated link
+type emulatedHost struct {
+	host.Host
+	net *EmulatedNetwork
+}
+
+func (h *emulatedHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	s, err := h.Host.NewStream(ctx, p, pids...)
+	if err != nil {
+		return nil, err
+	}
+	return h.net.wrapStream(h.ID(), p, s), nil
+}
+
+func (h *emulatedHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	h.Host.SetStreamHandler(pid, h.wrapHandler(handler))
+}
+
+func (h *emulatedHost) SetStreamHandlerMatch(pid protocol.ID, match func(string) bool, handler network.StreamHandler) {
+	h.Host.SetStreamHandlerMatch(pid, match, h.wrapHandler(handler))
+}
+
+func (h *emulatedHost) wrapHandler(handler network.StreamHandler) network.StreamHandler {
+	return func(s network.Stream) {
+		handler(h.net.wrapStream(h.ID(), s.Conn().RemotePeer(), s))
+	}
+}
+
+type delivery struct {
+	data    []byte
+	arrival time.Time
+}
+
+// emulatedStream delays each write until it would arrive over the link.
+// Writes are delivered in order, in the background.
+type emulatedStream struct {
+	network.Stream
+	dir *linkDirection
+
+	writeLk     sync.Mutex
+	closed      bool
+	lastArrival time.Time
+	queue       chan delivery
+	done        chan struct{}
+
+	errLk sync.Mutex
+	err   error
+	stop  chan struct{}
+}
+
+func newEmulatedStream(s network.Stream, dir *linkDirection) *emulatedStream {
+	es := &emulatedStream{
+		Stream: s,
+		dir:    dir,
+		queue:  make(chan delivery, 64),
+		done:   make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+	go es.deliver()
+	return es
+}
+
+func (s *emulatedStream) Write(p []byte) (int, error) {
+	s.writeLk.Lock()
+	defer s.writeLk.Unlock()
+	if s.closed {
+		return 0, xerrors.New("write on closed stream")
+	}
+	if err := s.error(); err != nil {
+		return 0, err
+	}
+	sendAt, arrival := s.dir.schedule(len(p))
+	time.Sleep(time.Until(sendAt))
+	if arrival.Before(s.lastArrival) {
+		arrival = s.lastArrival
+	}
+	s.lastArrival = arrival
+	data := make([]byte, len(p))
+	copy(data, p)
+	select {
+	case s.queue <- delivery{data, arrival}:
+		return len(p), nil
+	case <-s.stop:
+		return 0, s.error()
+	}
+}
+
+// Close closes the stream once the writes so far have been delivered
+func (s *emulatedStream) Close() error {
+	s.closeQueue()
+	go func() {
+		<-s.done
+		_ = s.Stream.Close()
+	}()
+	return nil
+}
+
+// CloseWrite closes the stream for writing once the writes so far have been
+// delivered
+func (s *emulatedStream) CloseWrite() error {
+	s.closeQueue()
+	go func() {
+		<-s.done
+		_ = s.Stream.CloseWrite()
+	}()
+	return nil
+}
+
+// Reset resets the stream, dropping writes that have not been delivered
+func (s *emulatedStream) Reset() error {
+	s.fail(mux.ErrReset)
+	return s.Stream.Reset()
+}
+
+func (s *emulatedStream) closeQueue() {
+	s.writeLk.Lock()
+	defer s.writeLk.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+}
+
+func (s *emulatedStream) deliver() {
+	defer close(s.done)
+	for d := range s.queue {
+		timer := time.NewTimer(time.Until(d.arrival))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if _, err := s.Stream.Write(d.data); err != nil {
+			s.fail(err)
+			return
+		}
+	}
+}
+
+func (s *emulatedStream) fail(err error) {
+	s.errLk.Lock()
+	defer s.errLk.Unlock()
+	if s.err == nil {
+		s.err = err
+		close(s.stop)
+	}
+}
+
+func (s *emulatedStream) error() error {
+	s.errLk.Lock()
+	defer s.errLk.Unlock()
+	return s.err
+}
+
+var _ Network = (*EmulatedNetwork)(nil)
@@ -0,0 +1,88 @@
+package testnet_test
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+
+	tn "github.com/filecoin-project/go-data-transfer/benchmarks/testnet"
+)
+
+const testProtocol = "/test/emulation"
+
+func TestEmulatedNetwork(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	net := tn.EmulatedNet(ctx, mocknet.New(ctx), tn.Symmetric(tn.LinkProfile{Latency: 50 * time.Millisecond}))
+	p1, _, _ := net.Adapter()
+	p2, _, _ := net.Adapter()
+	require.True(t, net.HasPeer(p1))
+	require.True(t, net.HasPeer(p2))
+
+	// p2 reads everything sent on a stream and reports how much it got
+	received := make(chan int)
+	net.Host(p2).SetStreamHandler(testProtocol, func(s network.Stream) {
+		defer s.Close()
+		data, err := ioutil.ReadAll(s)
+		if err != nil {
+			return
+		}
+		received <- len(data)
+	})
+	send := func(t *testing.T, size int) time.Duration {
+		start := time.Now()
+		s, err := net.Host(p1).NewStream(ctx, p2, testProtocol)
+		require.NoError(t, err)
+		_, err = s.Write(make([]byte, size))
+		require.NoError(t, err)
+		require.NoError(t, s.Close())
+		select {
+		case <-ctx.Done():
+			t.Fatal("did not receive data")
+		case n := <-received:
+			require.Equal(t, size, n)
+		}
+		return time.Since(start)
+	}
+
+	t.Run("latency", func(t *testing.T) {
+		require.GreaterOrEqual(t, int64(send(t, 1000)), int64(50*time.Millisecond))
+	})
+
+	t.Run("bandwidth is per direction", func(t *testing.T) {
+		// 100KB at 1MB/s takes 100ms from p1 to p2
+		require.NoError(t, net.SetLink(p1, p2, tn.LinkConfig{Forward: tn.LinkProfile{Bandwidth: 1 << 20}}))
+		require.GreaterOrEqual(t, int64(send(t, 100<<10)), int64(90*time.Millisecond))
+
+		// and is unlimited the other way
+		require.NoError(t, net.SetLink(p2, p1, tn.LinkConfig{Reverse: tn.LinkProfile{Bandwidth: 1 << 20}}))
+		require.GreaterOrEqual(t, int64(send(t, 100<<10)), int64(90*time.Millisecond))
+		require.NoError(t, net.SetLink(p2, p1, tn.LinkConfig{Forward: tn.LinkProfile{Bandwidth: 1 << 20}}))
+		require.Less(t, int64(send(t, 100<<10)), int64(50*time.Millisecond))
+	})
+
+	t.Run("outages", func(t *testing.T) {
+		require.NoError(t, net.SetLink(p1, p2, tn.LinkConfig{Outages: []tn.Outage{{After: 0, Duration: 200 * time.Millisecond}}}))
+		require.Eventually(t, func() bool {
+			_, err := net.Host(p1).NewStream(ctx, p2, testProtocol)
+			return err != nil
+		}, time.Second, 10*time.Millisecond)
+		require.Eventually(t, func() bool {
+			return net.Host(p1).Network().Connectedness(p2) != network.Connected
+		}, time.Second, 10*time.Millisecond)
+
+		// the link comes back up once the outage is over
+		time.Sleep(200 * time.Millisecond)
+		require.NoError(t, net.Host(p1).Connect(ctx, peer.AddrInfo{ID: p2}))
+		send(t, 1000)
+	})
+
+	require.Error(t, net.SetLink(p1, peer.ID("unknown"), tn.LAN()))
+}
@@ -0,0 +1,118 @@
+package channels
+
+import (
+	"sync"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/channels/internal"
+)
+
+// stateCache holds the decoded state of channels that have not terminated,
+// so that looking up a channel does not go through the state machine and the
+// datastore.
+//
+// The state machines write a channel's state to the datastore after every
+// event they process, whether or not the event was valid, so the cache
+// picks up each new state as it is written. A cached state is only used once
+// every event sent to the channel has been written: until then the caller
+// falls back to a synchronous read, which waits for the events to be
+// processed.
+type stateCache struct {
+	lk     sync.Mutex
+	states map[datatransfer.ChannelID]*cachedState
+}
+
+type cachedState struct {
+	state  internal.ChannelState
+	loaded bool
+	// pending is the number of events sent to the channel that have not been
+	// written yet
+	pending int
+}
+
+func newStateCache() *stateCache {
+	return &stateCache{states: make(map[datatransfer.ChannelID]*cachedState)}
+}
+
+// sending records that an event is about to be sent to the channel
+func (sc *stateCache) sending(chid datatransfer.ChannelID) {
+	sc.lk.Lock()
+	defer sc.lk.Unlock()
+	cs, ok := sc.states[chid]
+	if !ok {
+		cs = &cachedState{}
+		sc.states[chid] = cs
+	}
+	cs.pending++
+}
+
+// sendFailed records that an event could not be sent to the channel, or was
+// sent to a channel that has terminated, so it will never be written
+func (sc *stateCache) sendFailed(chid datatransfer.ChannelID) {
+	sc.lk.Lock()
+	defer sc.lk.Unlock()
+	cs, ok := sc.states[chid]
+	if !ok {
+		return
+	}
+	cs.pending--
+	if !cs.loaded && cs.pending <= 0 {
+		delete(sc.states, chid)
+	}
+}
+
+// written records a channel state written by the state machines
+func (sc *stateCache) written(state internal.ChannelState) {
+	chid := datatransfer.ChannelID{Initiator: state.Initiator, Responder: state.Responder, ID: state.TransferID}
+
+	sc.lk.Lock()
+	defer sc.lk.Unlock()
+	// terminated channels are not cached, as they are rarely looked up
+	// and would otherwise stay in memory forever
+	if IsChannelTerminated(state.Status) {
+		delete(sc.states, chid)
+		return
+	}
+	cs, ok := sc.states[chid]
+	if !ok {
+		cs = &cachedState{}
+		sc.states[chid] = cs
+	}
+	cs.state = state
+	cs.loaded = true
+	if cs.pending > 0 {
+		cs.pending--
+	}
+}
+
+// get returns the cached state of the channel, if it is up to date with all
+// the events sent to the channel
+func (sc *stateCache) get(chid datatransfer.ChannelID) (internal.ChannelState, bool) {
+	sc.lk.Lock()
+	defer sc.lk.Unlock()
+	cs, ok := sc.states[chid]
+	if !ok || !cs.loaded || cs.pending > 0 {
+		return internal.ChannelState{}, false
+	}
+	return cs.state, true
+}
+
+// has returns true if the channel is known to exist
+func (sc *stateCache) has(chid datatransfer.ChannelID) bool {
+	sc.lk.Lock()
+	defer sc.lk.Unlock()
+	cs, ok := sc.states[chid]
+	return ok && cs.loaded
+}
+
+// cachingEnvironment counts the CleanupComplete event that cleanupConnection
+// triggers after cleaning up a channel, as an event sent to the channel
+type cachingEnvironment struct {
+	ChannelEnvironment
+	cache *stateCache
+}
+
+func (ce *cachingEnvironment) CleanupChannel(chid datatransfer.ChannelID) {
+	ce.cache.sending(chid)
+	ce.ChannelEnvironment.CleanupChannel(chid)
+}
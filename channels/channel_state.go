@@ -2,16 +2,19 @@ package channels
 
 import (
 	"bytes"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	basicnode "github.com/ipld/go-ipld-prime/node/basic"
 	peer "github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
 	cbg "github.com/whyrusleeping/cbor-gen"
 
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-data-transfer/channels/internal"
+	"github.com/filecoin-project/go-data-transfer/cidlists"
 )
 
 // channelState is immutable channel data plus mutable state
@@ -42,13 +45,33 @@ type channelState struct {
 	received uint64
 	// more informative status on a channel
 	message string
+	// how long the channel may stay stalled or disconnected before it is failed
+	removeTimeout time.Duration
+	// user defined labels, sorted by key
+	labels []internal.Label
+	// the priority of the channel against other channels with the same peer
+	priority int64
+	// addresses the other peer is known to be reachable at, in binary form
+	peerAddrs [][]byte
+	// the kind of error the channel failed with
+	errorCode datatransfer.ErrorCode
+	// whether restarting the channel may recover from the error
+	retryable bool
+	// the reason given for rejecting or cancelling the transfer
+	reason datatransfer.Reason
+	// the number of automatic restart attempts
+	restartAttempts uint64
+	// the error from the last automatic restart attempt
+	lastRestartError string
 	// additional vouchers
 	vouchers []internal.EncodedVoucher
 	// additional voucherResults
 	voucherResults       []internal.EncodedVoucherResult
 	voucherResultDecoder DecoderByTypeFunc
 	voucherDecoder       DecoderByTypeFunc
-	channelCIDsReader    ChannelCIDsReader
+	voucherJSON          JSONEncoderByTypeFunc
+	voucherResultJSON    JSONEncoderByTypeFunc
+	receivedCidsLists    cidlists.CIDLists
 }
 
 // EmptyChannelState is the zero value for channel state, meaning not present
@@ -89,20 +112,32 @@ func (c channelState) Voucher() datatransfer.Voucher {
 	if len(c.vouchers) == 0 {
 		return nil
 	}
-	decoder, _ := c.voucherDecoder(c.vouchers[0].Type)
+	decoder, has := c.voucherDecoder(c.vouchers[0].Type)
+	if !has {
+		return nil
+	}
 	encodable, _ := decoder.DecodeFromCbor(c.vouchers[0].Voucher.Raw)
 	return encodable.(datatransfer.Voucher)
 }
 
 // ReceivedCids returns the cids received so far on this channel
 func (c channelState) ReceivedCids() []cid.Cid {
-	receivedCids, err := c.channelCIDsReader(c.ChannelID())
+	receivedCids, err := c.receivedCidsLists.ReadList(c.ChannelID())
 	if err != nil {
 		log.Error(err)
 	}
 	return receivedCids
 }
 
+// ReceivedCidsLen returns the number of cids received so far on this channel
+func (c channelState) ReceivedCidsLen() int {
+	count, err := c.receivedCidsLists.ListLen(c.ChannelID())
+	if err != nil {
+		log.Error(err)
+	}
+	return count
+}
+
 // Sender returns the peer id for the node that is sending data
 func (c channelState) Sender() peer.ID { return c.sender }
 
@@ -128,6 +163,65 @@ func (c channelState) Message() string {
 	return c.message
 }
 
+// RemoveTimeout returns the remove timeout the channel was opened with
+func (c channelState) RemoveTimeout() time.Duration {
+	return c.removeTimeout
+}
+
+// Labels returns a copy of the user defined labels on the channel
+func (c channelState) Labels() map[string]string {
+	labels := make(map[string]string, len(c.labels))
+	for _, label := range c.labels {
+		labels[label.Key] = label.Value
+	}
+	return labels
+}
+
+// Priority returns the priority the channel was opened with
+func (c channelState) Priority() int64 {
+	return c.priority
+}
+
+// PeerAddrs returns the addresses of the other peer the channel was opened
+// with. Addresses that can no longer be decoded are skipped.
+func (c channelState) PeerAddrs() []ma.Multiaddr {
+	addrs := make([]ma.Multiaddr, 0, len(c.peerAddrs))
+	for _, encoded := range c.peerAddrs {
+		addr, err := ma.NewMultiaddrBytes(encoded)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// ErrorCode returns the kind of error the channel failed with
+func (c channelState) ErrorCode() datatransfer.ErrorCode {
+	return c.errorCode
+}
+
+// Retryable returns whether restarting the channel may recover from the error
+func (c channelState) Retryable() bool {
+	return c.retryable
+}
+
+// Reason returns the reason given for rejecting or cancelling the transfer
+func (c channelState) Reason() datatransfer.Reason {
+	return c.reason
+}
+
+// RestartAttempts returns the number of times the manager has tried to
+// restart the channel automatically since data last moved on it
+func (c channelState) RestartAttempts() uint64 {
+	return c.restartAttempts
+}
+
+// LastRestartError returns the error from the last automatic restart attempt
+func (c channelState) LastRestartError() string {
+	return c.lastRestartError
+}
+
 func (c channelState) Vouchers() []datatransfer.Voucher {
 	vouchers := make([]datatransfer.Voucher, 0, len(c.vouchers))
 	for _, encoded := range c.vouchers {
@@ -171,7 +265,7 @@ func (c channelState) OtherPeer() peer.ID {
 	return c.sender
 }
 
-func fromInternalChannelState(c internal.ChannelState, voucherDecoder DecoderByTypeFunc, voucherResultDecoder DecoderByTypeFunc, channelCIDsReader ChannelCIDsReader) datatransfer.ChannelState {
+func fromInternalChannelState(c internal.ChannelState, voucherDecoder DecoderByTypeFunc, voucherResultDecoder DecoderByTypeFunc, voucherJSON JSONEncoderByTypeFunc, voucherResultJSON JSONEncoderByTypeFunc, receivedCidsLists cidlists.CIDLists) datatransfer.ChannelState {
 	return channelState{
 		selfPeer:             c.SelfPeer,
 		isPull:               c.Initiator == c.Recipient,
@@ -186,11 +280,22 @@ func fromInternalChannelState(c internal.ChannelState, voucherDecoder DecoderByT
 		sent:                 c.Sent,
 		received:             c.Received,
 		message:              c.Message,
+		removeTimeout:        c.RemoveTimeout,
+		labels:               c.Labels,
+		priority:             c.Priority,
+		peerAddrs:            c.PeerAddrs,
+		errorCode:            c.ErrorCode,
+		retryable:            c.Retryable,
+		reason:               datatransfer.Reason{Code: c.ReasonCode, Text: c.ReasonText},
+		restartAttempts:      c.RestartAttempts,
+		lastRestartError:     c.LastRestartError,
 		vouchers:             c.Vouchers,
 		voucherResults:       c.VoucherResults,
 		voucherResultDecoder: voucherResultDecoder,
 		voucherDecoder:       voucherDecoder,
-		channelCIDsReader:    channelCIDsReader,
+		voucherJSON:          voucherJSON,
+		voucherResultJSON:    voucherResultJSON,
+		receivedCidsLists:    receivedCidsLists,
 	}
 }
 
@@ -3,6 +3,8 @@ package channels
 import (
 	"context"
 	"errors"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/ipfs/go-cid"
@@ -10,6 +12,7 @@ import (
 	"github.com/ipfs/go-datastore/namespace"
 	"github.com/ipld/go-ipld-prime"
 	peer "github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
 	cbg "github.com/whyrusleeping/cbor-gen"
 	"golang.org/x/xerrors"
 
@@ -27,7 +30,9 @@ import (
 
 type DecoderByTypeFunc func(identifier datatransfer.TypeIdentifier) (encoding.Decoder, bool)
 
-type ChannelCIDsReader func(chid datatransfer.ChannelID) ([]cid.Cid, error)
+// JSONEncoderByTypeFunc looks up the JSON encoder for a voucher or voucher
+// result type, if it has one
+type JSONEncoderByTypeFunc func(identifier datatransfer.TypeIdentifier) (datatransfer.JSONEncoder, bool)
 
 type Notifier func(datatransfer.Event, datatransfer.ChannelState)
 
@@ -44,6 +49,10 @@ func NewErrNotFound(chid datatransfer.ChannelID) error {
 	return &ErrNotFound{ChannelID: chid}
 }
 
+// CurrentVersion is the version of the channel state that channels are
+// migrated to and written at
+const CurrentVersion = "3"
+
 // ErrWrongType is returned when a caller attempts to change the type of implementation data after setting it
 var ErrWrongType = errors.New("Cannot change type of implementation specific data after setting it")
 
@@ -52,10 +61,20 @@ type Channels struct {
 	notifier             Notifier
 	voucherDecoder       DecoderByTypeFunc
 	voucherResultDecoder DecoderByTypeFunc
+	voucherJSON          JSONEncoderByTypeFunc
+	voucherResultJSON    JSONEncoderByTypeFunc
 	stateMachines        fsm.Group
 	migrateStateMachines func(context.Context) error
 	cidLists             cidlists.CIDLists
 	seenCIDs             *cidsets.CIDSetManager
+	progressInterval     time.Duration
+	progressMaxBytes     uint64
+	progressLk           sync.Mutex
+	progress             map[progressKey]*pendingProgress
+	cache                *stateCache
+	store                ChannelStore
+	version              versioning.VersionKey
+	selfPeer             peer.ID
 }
 
 // ChannelEnvironment -- just a proxy for DTNetwork for now
@@ -73,7 +92,8 @@ func New(ds datastore.Batching,
 	voucherDecoder DecoderByTypeFunc,
 	voucherResultDecoder DecoderByTypeFunc,
 	env ChannelEnvironment,
-	selfPeer peer.ID) (*Channels, error) {
+	selfPeer peer.ID,
+	options ...Option) (*Channels, error) {
 
 	seenCIDsDS := namespace.Wrap(ds, datastore.NewKey("seencids"))
 	c := &Channels{
@@ -82,29 +102,79 @@ func New(ds datastore.Batching,
 		notifier:             notifier,
 		voucherDecoder:       voucherDecoder,
 		voucherResultDecoder: voucherResultDecoder,
+		progress:             make(map[progressKey]*pendingProgress),
+		cache:                newStateCache(),
+		version:              versioning.VersionKey(CurrentVersion),
+		selfPeer:             selfPeer,
+	}
+	for _, option := range options {
+		option(c)
 	}
 	channelMigrations, err := migrations.GetChannelStateMigrations(selfPeer, cidLists)
 	if err != nil {
 		return nil, err
 	}
-	c.stateMachines, c.migrateStateMachines, err = versionedfsm.NewVersionedFSM(ds, fsm.Parameters{
-		Environment:     env,
+	if c.store == nil {
+		c.store = NewChannelStore(ds)
+	}
+	stateDS := &stateDatastore{ChannelStore: c.store, prefix: datastore.NewKey(string(c.version)), cache: c.cache}
+	c.stateMachines, c.migrateStateMachines, err = versionedfsm.NewVersionedFSM(stateDS, fsm.Parameters{
+		Environment:     &cachingEnvironment{ChannelEnvironment: env, cache: c.cache},
 		StateType:       internal.ChannelState{},
 		StateKeyField:   "Status",
 		Events:          ChannelEvents,
 		StateEntryFuncs: ChannelStateEntryFuncs,
 		Notifier:        c.dispatch,
 		FinalityStates:  ChannelFinalityStates,
-	}, channelMigrations, versioning.VersionKey("2"))
+	}, channelMigrations, c.version)
 	if err != nil {
 		return nil, err
 	}
 	return c, nil
 }
 
-// Start migrates the channel data store as needed
+// Start migrates the channel data store as needed, and builds the channel
+// indexes if they have not been built for the current version of the
+// channel state
 func (c *Channels) Start(ctx context.Context) error {
-	return c.migrateStateMachines(ctx)
+	if err := c.migrateStateMachines(ctx); err != nil {
+		return err
+	}
+	built, err := c.store.IndexesBuilt(c.indexesVersion())
+	if err != nil {
+		return xerrors.Errorf("checking channel indexes: %w", err)
+	}
+	if built {
+		return nil
+	}
+	var internalChannels []internal.ChannelState
+	if err := c.stateMachines.List(&internalChannels); err != nil {
+		return xerrors.Errorf("listing channels to index: %w", err)
+	}
+	indexes := make(map[datatransfer.ChannelID]ChannelIndex, len(internalChannels))
+	for _, internalChannel := range internalChannels {
+		chid := datatransfer.ChannelID{Initiator: internalChannel.Initiator, Responder: internalChannel.Responder, ID: internalChannel.TransferID}
+		indexes[chid] = channelIndex(internalChannel)
+	}
+	if err := c.store.BuildIndexes(c.indexesVersion(), indexes); err != nil {
+		return xerrors.Errorf("building channel indexes: %w", err)
+	}
+	return nil
+}
+
+// Stop applies pending progress and writes out the blocks seen so far, so
+// that nothing is lost when the process exits
+func (c *Channels) Stop(ctx context.Context) error {
+	if err := c.flushAllProgress(ctx); err != nil {
+		return err
+	}
+	return c.seenCIDs.Flush()
+}
+
+// indexesVersion identifies the channel state version and the set of indexes
+// kept on it, so the indexes are rebuilt when either changes
+func (c *Channels) indexesVersion() string {
+	return string(c.version) + "/" + indexesVersion
 }
 
 func (c *Channels) dispatch(eventName fsm.EventName, channel fsm.StateType) {
@@ -122,7 +192,7 @@ func (c *Channels) dispatch(eventName fsm.EventName, channel fsm.StateType) {
 		Timestamp: time.Now(),
 	}
 
-	c.notifier(evt, fromInternalChannelState(realChannel, c.voucherDecoder, c.voucherResultDecoder, c.cidLists.ReadList))
+	c.notifier(evt, fromInternalChannelState(realChannel, c.voucherDecoder, c.voucherResultDecoder, c.voucherJSON, c.voucherResultJSON, c.cidLists))
 
 	// When the channel has been cleaned up, remove the caches of seen cids
 	if evt.Code == datatransfer.CleanupComplete {
@@ -139,8 +209,9 @@ func (c *Channels) dispatch(eventName fsm.EventName, channel fsm.StateType) {
 }
 
 // CreateNew creates a new channel id and channel state and saves to channels.
+// The persistent parts of the given options are saved with the channel state.
 // returns error if the channel exists already.
-func (c *Channels) CreateNew(selfPeer peer.ID, tid datatransfer.TransferID, baseCid cid.Cid, selector ipld.Node, voucher datatransfer.Voucher, initiator, dataSender, dataReceiver peer.ID) (datatransfer.ChannelID, error) {
+func (c *Channels) CreateNew(selfPeer peer.ID, tid datatransfer.TransferID, baseCid cid.Cid, selector ipld.Node, voucher datatransfer.Voucher, initiator, dataSender, dataReceiver peer.ID, options datatransfer.ChannelOptions) (datatransfer.ChannelID, error) {
 	var responder peer.ID
 	if dataSender == initiator {
 		responder = dataReceiver
@@ -156,6 +227,7 @@ func (c *Channels) CreateNew(selfPeer peer.ID, tid datatransfer.TransferID, base
 	if err != nil {
 		return datatransfer.ChannelID{}, err
 	}
+	c.cache.sending(chid)
 	err = c.stateMachines.Begin(chid, &internal.ChannelState{
 		SelfPeer:   selfPeer,
 		TransferID: tid,
@@ -165,6 +237,7 @@ func (c *Channels) CreateNew(selfPeer peer.ID, tid datatransfer.TransferID, base
 		Selector:   &cbg.Deferred{Raw: selBytes},
 		Sender:     dataSender,
 		Recipient:  dataReceiver,
+		TotalSize:  options.TotalSize,
 		Vouchers: []internal.EncodedVoucher{
 			{
 				Type: voucher.Type(),
@@ -173,16 +246,21 @@ func (c *Channels) CreateNew(selfPeer peer.ID, tid datatransfer.TransferID, base
 				},
 			},
 		},
-		Status: datatransfer.Requested,
+		Status:        datatransfer.Requested,
+		RemoveTimeout: options.RemoveTimeout,
+		Labels:        updateLabels(nil, options.Labels),
+		Priority:      options.Priority,
+		PeerAddrs:     encodePeerAddrs(options.PeerAddrs),
 	})
 	if err != nil {
+		c.cache.sendFailed(chid)
 		return datatransfer.ChannelID{}, err
 	}
 	err = c.cidLists.CreateList(chid, nil)
 	if err != nil {
 		return datatransfer.ChannelID{}, err
 	}
-	return chid, c.stateMachines.Send(chid, datatransfer.Open)
+	return chid, c.sendEvent(chid, datatransfer.Open)
 }
 
 // InProgress returns a list of in progress channels
@@ -195,7 +273,70 @@ func (c *Channels) InProgress() (map[datatransfer.ChannelID]datatransfer.Channel
 	channels := make(map[datatransfer.ChannelID]datatransfer.ChannelState, len(internalChannels))
 	for _, internalChannel := range internalChannels {
 		channels[datatransfer.ChannelID{ID: internalChannel.TransferID, Responder: internalChannel.Responder, Initiator: internalChannel.Initiator}] =
-			fromInternalChannelState(internalChannel, c.voucherDecoder, c.voucherResultDecoder, c.cidLists.ReadList)
+			fromInternalChannelState(internalChannel, c.voucherDecoder, c.voucherResultDecoder, c.voucherJSON, c.voucherResultJSON, c.cidLists)
+	}
+	return channels, nil
+}
+
+// ChannelsByPeer returns the channels whose initiator or responder is the
+// given peer
+func (c *Channels) ChannelsByPeer(p peer.ID) (map[datatransfer.ChannelID]datatransfer.ChannelState, error) {
+	chids, err := c.store.ChannelsByPeer(p)
+	if err != nil {
+		return nil, err
+	}
+	return c.channelStates(chids)
+}
+
+// ChannelsByBaseCID returns the channels for the given base CID
+func (c *Channels) ChannelsByBaseCID(baseCid cid.Cid) (map[datatransfer.ChannelID]datatransfer.ChannelState, error) {
+	chids, err := c.store.ChannelsByBaseCID(baseCid)
+	if err != nil {
+		return nil, err
+	}
+	return c.channelStates(chids)
+}
+
+// ChannelsWithLabels returns the channels whose labels match the given
+// selector. Channels are looked up by one of the selector's labels in the
+// label index, so only an empty selector lists every channel.
+func (c *Channels) ChannelsWithLabels(selector datatransfer.LabelSelector) (map[datatransfer.ChannelID]datatransfer.ChannelState, error) {
+	if len(selector) == 0 {
+		return c.InProgress()
+	}
+	keys := make([]string, 0, len(selector))
+	for key := range selector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	chids, err := c.store.ChannelsByLabel(keys[0], selector[keys[0]])
+	if err != nil {
+		return nil, err
+	}
+	channels, err := c.channelStates(chids)
+	if err != nil {
+		return nil, err
+	}
+	for chid, chst := range channels {
+		if !selector.Matches(chst) {
+			delete(channels, chid)
+		}
+	}
+	return channels, nil
+}
+
+// channelStates returns the current states of the given channels. Like
+// InProgress, it does not wait for events that have not been processed yet.
+func (c *Channels) channelStates(chids []datatransfer.ChannelID) (map[datatransfer.ChannelID]datatransfer.ChannelState, error) {
+	channels := make(map[datatransfer.ChannelID]datatransfer.ChannelState, len(chids))
+	for _, chid := range chids {
+		internalChannel, ok := c.cache.get(chid)
+		if !ok {
+			if err := c.stateMachines.Get(chid).Get(&internalChannel); err != nil {
+				return nil, xerrors.Errorf("reading state of channel %s: %w", chid, err)
+			}
+		}
+		channels[chid] = fromInternalChannelState(internalChannel, c.voucherDecoder, c.voucherResultDecoder, c.voucherJSON, c.voucherResultJSON, c.cidLists)
 	}
 	return channels, nil
 }
@@ -203,12 +344,24 @@ func (c *Channels) InProgress() (map[datatransfer.ChannelID]datatransfer.Channel
 // GetByID searches for a channel in the slice of channels with id `chid`.
 // Returns datatransfer.EmptyChannelState if there is no channel with that id
 func (c *Channels) GetByID(ctx context.Context, chid datatransfer.ChannelID) (datatransfer.ChannelState, error) {
+	if internalChannel, ok := c.cache.get(chid); ok {
+		return fromInternalChannelState(internalChannel, c.voucherDecoder, c.voucherResultDecoder, c.voucherJSON, c.voucherResultJSON, c.cidLists), nil
+	}
+
+	// reading synchronously sends an event to the channel
+	c.cache.sending(chid)
 	var internalChannel internal.ChannelState
 	err := c.stateMachines.GetSync(ctx, chid, &internalChannel)
 	if err != nil {
+		c.cache.sendFailed(chid)
 		return nil, NewErrNotFound(chid)
 	}
-	return fromInternalChannelState(internalChannel, c.voucherDecoder, c.voucherResultDecoder, c.cidLists.ReadList), nil
+	// the state machine of a terminated channel has stopped, so the read is
+	// not written and would otherwise be left pending in the cache
+	if IsChannelTerminated(internalChannel.Status) {
+		c.cache.sendFailed(chid)
+	}
+	return fromInternalChannelState(internalChannel, c.voucherDecoder, c.voucherResultDecoder, c.voucherJSON, c.voucherResultJSON, c.cidLists), nil
 }
 
 // Accept marks a data transfer as accepted
@@ -242,6 +395,13 @@ func (c *Channels) DataReceived(chid datatransfer.ChannelID, k cid.Cid, delta ui
 	return c.fireProgressEvent(chid, datatransfer.DataReceived, datatransfer.DataReceivedProgress, k, delta)
 }
 
+// IterateReceivedCids calls f with each cid received so far on the channel,
+// in order, without loading them all into memory. It stops at the first
+// error f returns.
+func (c *Channels) IterateReceivedCids(chid datatransfer.ChannelID, f func(cid.Cid) error) error {
+	return c.cidLists.IterateList(chid, f)
+}
+
 // PauseInitiator pauses the initator of this channel
 func (c *Channels) PauseInitiator(chid datatransfer.ChannelID) error {
 	return c.send(chid, datatransfer.PauseInitiator)
@@ -305,22 +465,52 @@ func (c *Channels) BeginFinalizing(chid datatransfer.ChannelID) error {
 	return c.send(chid, datatransfer.BeginFinalizing)
 }
 
+// UpdateLabels sets the given labels on a channel, removing any label whose
+// value is empty
+func (c *Channels) UpdateLabels(chid datatransfer.ChannelID, labels map[string]string) error {
+	return c.send(chid, datatransfer.LabelsUpdated, labels)
+}
+
 // Cancel indicates a channel was cancelled prematurely
 func (c *Channels) Cancel(chid datatransfer.ChannelID) error {
-	return c.send(chid, datatransfer.Cancel)
+	return c.CancelWithReason(chid, datatransfer.Reason{})
+}
+
+// CancelWithReason indicates a channel was cancelled prematurely, for the given reason
+func (c *Channels) CancelWithReason(chid datatransfer.ChannelID, reason datatransfer.Reason) error {
+	return c.send(chid, datatransfer.Cancel, reason)
 }
 
 // Error indicates something that went wrong on a channel
 func (c *Channels) Error(chid datatransfer.ChannelID, err error) error {
-	return c.send(chid, datatransfer.Error, err)
+	return c.ErrorWithReason(chid, err, datatransfer.Reason{})
+}
+
+// ErrorWithReason indicates something that went wrong on a channel, and the
+// reason the other party gave for it
+func (c *Channels) ErrorWithReason(chid datatransfer.ChannelID, err error, reason datatransfer.Reason) error {
+	return c.send(chid, datatransfer.Error, err, reason)
 }
 
 func (c *Channels) Disconnected(chid datatransfer.ChannelID) error {
 	return c.send(chid, datatransfer.Disconnected)
 }
 
+// RestartAttempted records an automatic attempt to restart a channel, and the
+// error it failed with, if any
+func (c *Channels) RestartAttempted(chid datatransfer.ChannelID, restartErr error) error {
+	var errMsg string
+	if restartErr != nil {
+		errMsg = restartErr.Error()
+	}
+	return c.send(chid, datatransfer.RestartAttempted, errMsg)
+}
+
 // HasChannel returns true if the given channel id is being tracked
 func (c *Channels) HasChannel(chid datatransfer.ChannelID) (bool, error) {
+	if c.cache.has(chid) {
+		return true, nil
+	}
 	return c.stateMachines.Has(chid)
 }
 
@@ -342,43 +532,37 @@ func (c *Channels) removeSeenCIDCaches(chid datatransfer.ChannelID) error {
 	return nil
 }
 
-// onProgress fires an event indicating progress has been made in
-// queuing / sending / receiving blocks.
-// These events are fired only for new blocks (not for example if
-// a block is resent)
-func (c *Channels) fireProgressEvent(chid datatransfer.ChannelID, evt datatransfer.EventCode, progressEvt datatransfer.EventCode, k cid.Cid, delta uint64) error {
-	if err := c.checkChannelExists(chid, evt); err != nil {
+func (c *Channels) send(chid datatransfer.ChannelID, code datatransfer.EventCode, args ...interface{}) error {
+	err := c.checkChannelExists(chid, code)
+	if err != nil {
 		return err
 	}
-
-	// Check if the block has already been seen
-	sid := cidsets.SetID(chid.String() + "/" + datatransfer.Events[evt])
-	seen, err := c.seenCIDs.InsertSetCID(sid, k)
-	if err != nil {
+	// apply any pending progress first, so the channel state is up to date
+	// when the channel completes, pauses etc
+	if err := c.flushProgress(chid); err != nil {
 		return err
 	}
-
-	// If the block has not been seen before, fire the progress event
-	if !seen {
-		if err := c.stateMachines.Send(chid, progressEvt, delta); err != nil {
-			return err
-		}
+	// write out the blocks seen so far when the channel pauses, completes
+	// etc, rather than leaving them for the next batch
+	if err := c.seenCIDs.Flush(); err != nil {
+		return err
 	}
-
-	// Fire the regular event
-	return c.stateMachines.Send(chid, evt)
+	return c.sendEvent(chid, code, args...)
 }
 
-func (c *Channels) send(chid datatransfer.ChannelID, code datatransfer.EventCode, args ...interface{}) error {
-	err := c.checkChannelExists(chid, code)
+// sendEvent sends an event to the channel's state machine, keeping track of
+// it in the state cache
+func (c *Channels) sendEvent(chid datatransfer.ChannelID, code datatransfer.EventCode, args ...interface{}) error {
+	c.cache.sending(chid)
+	err := c.stateMachines.Send(chid, code, args...)
 	if err != nil {
-		return err
+		c.cache.sendFailed(chid)
 	}
-	return c.stateMachines.Send(chid, code, args...)
+	return err
 }
 
 func (c *Channels) checkChannelExists(chid datatransfer.ChannelID, code datatransfer.EventCode) error {
-	has, err := c.stateMachines.Has(chid)
+	has, err := c.HasChannel(chid)
 	if err != nil {
 		return err
 	}
@@ -388,3 +572,43 @@ func (c *Channels) checkChannelExists(chid datatransfer.ChannelID, code datatran
 	}
 	return nil
 }
+
+// encodePeerAddrs converts peer addresses to the binary form they are stored in
+func encodePeerAddrs(addrs []ma.Multiaddr) [][]byte {
+	if len(addrs) == 0 {
+		return nil
+	}
+	encoded := make([][]byte, 0, len(addrs))
+	for _, addr := range addrs {
+		encoded = append(encoded, addr.Bytes())
+	}
+	return encoded
+}
+
+// updateLabels applies label changes to the stored labels, keeping them sorted
+// by key. Labels with an empty value are removed.
+func updateLabels(current []internal.Label, changes map[string]string) []internal.Label {
+	if len(changes) == 0 {
+		return current
+	}
+	merged := make(map[string]string, len(current)+len(changes))
+	for _, label := range current {
+		merged[label.Key] = label.Value
+	}
+	for key, value := range changes {
+		if value == "" {
+			delete(merged, key)
+			continue
+		}
+		merged[key] = value
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	labels := make([]internal.Label, 0, len(merged))
+	for key, value := range merged {
+		labels = append(labels, internal.Label{Key: key, Value: value})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Key < labels[j].Key })
+	return labels
+}
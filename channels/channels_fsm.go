@@ -28,15 +28,22 @@ var ChannelEvents = fsm.Events{
 	fsm.Event(datatransfer.Accept).From(datatransfer.Requested).To(datatransfer.Ongoing),
 	fsm.Event(datatransfer.Restart).FromAny().ToNoChange().Action(func(chst *internal.ChannelState) error {
 		chst.Message = ""
+		chst.ErrorCode = datatransfer.NoError
+		chst.Retryable = false
 		return nil
 	}),
 
-	fsm.Event(datatransfer.Cancel).FromAny().To(datatransfer.Cancelling),
+	fsm.Event(datatransfer.Cancel).FromAny().To(datatransfer.Cancelling).
+		Action(func(chst *internal.ChannelState, reason datatransfer.Reason) error {
+			setReason(chst, reason)
+			return nil
+		}),
 
 	fsm.Event(datatransfer.DataReceived).FromMany(transferringStates...).ToNoChange(),
 	fsm.Event(datatransfer.DataReceivedProgress).FromMany(transferringStates...).ToNoChange().
 		Action(func(chst *internal.ChannelState, delta uint64) error {
 			chst.Received += delta
+			resetRestartAttempts(chst, delta)
 			return nil
 		}),
 
@@ -44,6 +51,7 @@ var ChannelEvents = fsm.Events{
 	fsm.Event(datatransfer.DataSentProgress).FromMany(transferringStates...).ToNoChange().
 		Action(func(chst *internal.ChannelState, delta uint64) error {
 			chst.Sent += delta
+			resetRestartAttempts(chst, delta)
 			return nil
 		}),
 	fsm.Event(datatransfer.DataQueued).FromMany(transferringStates...).ToNoChange(),
@@ -54,13 +62,30 @@ var ChannelEvents = fsm.Events{
 		}),
 	fsm.Event(datatransfer.Disconnected).FromAny().ToNoChange().Action(func(chst *internal.ChannelState) error {
 		chst.Message = datatransfer.ErrDisconnected.Error()
+		chst.ErrorCode = datatransfer.ErrorDisconnected
+		chst.Retryable = datatransfer.ErrorDisconnected.Retryable()
 		return nil
 	}),
 
-	fsm.Event(datatransfer.Error).FromAny().To(datatransfer.Failing).Action(func(chst *internal.ChannelState, err error) error {
-		chst.Message = err.Error()
-		return nil
-	}),
+	fsm.Event(datatransfer.Error).FromAny().To(datatransfer.Failing).
+		Action(func(chst *internal.ChannelState, err error, reason datatransfer.Reason) error {
+			chst.Message = err.Error()
+			chst.ErrorCode = datatransfer.ErrorCodeFor(err)
+			chst.Retryable = chst.ErrorCode.Retryable()
+			setReason(chst, reason)
+			return nil
+		}),
+	fsm.Event(datatransfer.RestartAttempted).FromAny().ToNoChange().
+		Action(func(chst *internal.ChannelState, restartErr string) error {
+			chst.RestartAttempts++
+			chst.LastRestartError = restartErr
+			return nil
+		}),
+	fsm.Event(datatransfer.LabelsUpdated).FromAny().ToNoChange().
+		Action(func(chst *internal.ChannelState, labels map[string]string) error {
+			chst.Labels = updateLabels(chst.Labels, labels)
+			return nil
+		}),
 	fsm.Event(datatransfer.NewVoucher).FromAny().ToNoChange().
 		Action(func(chst *internal.ChannelState, vtype datatransfer.TypeIdentifier, voucherBytes []byte) error {
 			chst.Vouchers = append(chst.Vouchers, internal.EncodedVoucher{Type: vtype, Voucher: &cbg.Deferred{Raw: voucherBytes}})
@@ -169,3 +194,21 @@ func IsChannelCleaningUp(st datatransfer.Status) bool {
 
 	return false
 }
+
+// resetRestartAttempts starts counting restart attempts again once data moves
+// on the channel, so that a restart limit applies to attempts in a row
+func resetRestartAttempts(chst *internal.ChannelState, delta uint64) {
+	if delta > 0 {
+		chst.RestartAttempts = 0
+	}
+}
+
+// setReason records the reason for rejecting or cancelling a transfer, if one
+// was given
+func setReason(chst *internal.ChannelState, reason datatransfer.Reason) {
+	if reason.IsEmpty() {
+		return
+	}
+	chst.ReasonCode = reason.Code
+	chst.ReasonText = reason.Text
+}
@@ -4,17 +4,22 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"io/ioutil"
 	"math/rand"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dss "github.com/ipfs/go-datastore/sync"
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	basicnode "github.com/ipld/go-ipld-prime/node/basic"
 	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
 	peer "github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/require"
 	cbg "github.com/whyrusleeping/cbor-gen"
 	"golang.org/x/xerrors"
@@ -28,6 +33,7 @@ import (
 	"github.com/filecoin-project/go-data-transfer/channels/internal/migrations"
 	v0 "github.com/filecoin-project/go-data-transfer/channels/internal/migrations/v0"
 	v1 "github.com/filecoin-project/go-data-transfer/channels/internal/migrations/v1"
+	v2 "github.com/filecoin-project/go-data-transfer/channels/internal/migrations/v2"
 	"github.com/filecoin-project/go-data-transfer/cidlists"
 	"github.com/filecoin-project/go-data-transfer/encoding"
 	"github.com/filecoin-project/go-data-transfer/testutil"
@@ -60,19 +66,23 @@ func TestChannels(t *testing.T) {
 	err = channelList.Start(ctx)
 	require.NoError(t, err)
 	t.Run("adding channels", func(t *testing.T) {
-		chid, err := channelList.CreateNew(peers[0], tid1, cids[0], selector, fv1, peers[0], peers[0], peers[1])
+		addr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/1234")
+		require.NoError(t, err)
+		chid, err := channelList.CreateNew(peers[0], tid1, cids[0], selector, fv1, peers[0], peers[0], peers[1], datatransfer.ChannelOptions{})
 		require.NoError(t, err)
 		require.Equal(t, peers[0], chid.Initiator)
 		require.Equal(t, tid1, chid.ID)
 
 		// cannot add twice for same channel id
-		_, err = channelList.CreateNew(peers[0], tid1, cids[1], selector, fv2, peers[0], peers[1], peers[0])
+		_, err = channelList.CreateNew(peers[0], tid1, cids[1], selector, fv2, peers[0], peers[1], peers[0], datatransfer.ChannelOptions{})
 		require.Error(t, err)
 		state := checkEvent(ctx, t, received, datatransfer.Open)
 		require.Equal(t, datatransfer.Requested, state.Status())
 
 		// can add for different id
-		chid, err = channelList.CreateNew(peers[2], tid2, cids[1], selector, fv2, peers[3], peers[2], peers[3])
+		chid, err = channelList.CreateNew(peers[2], tid2, cids[1], selector, fv2, peers[3], peers[2], peers[3],
+			datatransfer.NewChannelOptions(datatransfer.WithTotalSize(1000), datatransfer.WithRemoveTimeout(time.Minute),
+				datatransfer.WithLabels(map[string]string{"deal": "1"}), datatransfer.WithPriority(2), datatransfer.WithPeerAddrs(addr)))
 		require.NoError(t, err)
 		require.Equal(t, peers[3], chid.Initiator)
 		require.Equal(t, tid2, chid.ID)
@@ -80,6 +90,11 @@ func TestChannels(t *testing.T) {
 		require.Equal(t, datatransfer.Requested, state.Status())
 		require.Equal(t, peers[2], state.SelfPeer())
 		require.Equal(t, peers[3], state.OtherPeer())
+		require.Equal(t, uint64(1000), state.TotalSize())
+		require.Equal(t, time.Minute, state.RemoveTimeout())
+		require.Equal(t, map[string]string{"deal": "1"}, state.Labels())
+		require.Equal(t, int64(2), state.Priority())
+		require.Equal(t, []ma.Multiaddr{addr}, state.PeerAddrs())
 	})
 
 	t.Run("in progress channels", func(t *testing.T) {
@@ -136,13 +151,14 @@ func TestChannels(t *testing.T) {
 		err = channelList.Start(ctx)
 		require.NoError(t, err)
 
-		_, err = channelList.CreateNew(peers[0], tid1, cids[0], selector, fv1, peers[0], peers[0], peers[1])
+		_, err = channelList.CreateNew(peers[0], tid1, cids[0], selector, fv1, peers[0], peers[0], peers[1], datatransfer.ChannelOptions{})
 		require.NoError(t, err)
 		state := checkEvent(ctx, t, received, datatransfer.Open)
 		require.Equal(t, datatransfer.Requested, state.Status())
 		require.Equal(t, uint64(0), state.Received())
 		require.Equal(t, uint64(0), state.Sent())
 		require.Empty(t, state.ReceivedCids())
+		require.Zero(t, state.ReceivedCidsLen())
 
 		err = channelList.DataReceived(datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: tid1}, cids[0], 50)
 		require.NoError(t, err)
@@ -188,6 +204,7 @@ func TestChannels(t *testing.T) {
 		require.Equal(t, uint64(100), state.Received())
 		require.Equal(t, uint64(100), state.Sent())
 		require.Equal(t, []cid.Cid{cids[0], cids[1], cids[0]}, state.ReceivedCids())
+		require.Equal(t, 3, state.ReceivedCidsLen())
 	})
 
 	t.Run("pause/resume", func(t *testing.T) {
@@ -244,6 +261,31 @@ func TestChannels(t *testing.T) {
 		require.Equal(t, fvr1, state.LastVoucherResult())
 	})
 
+	t.Run("labels", func(t *testing.T) {
+		chid := datatransfer.ChannelID{Initiator: peers[3], Responder: peers[2], ID: tid2}
+		err := channelList.UpdateLabels(chid, map[string]string{"job": "a", "deal": "2"})
+		require.NoError(t, err)
+		state := checkEvent(ctx, t, received, datatransfer.LabelsUpdated)
+		require.Equal(t, map[string]string{"job": "a", "deal": "2"}, state.Labels())
+
+		// empty values remove labels
+		err = channelList.UpdateLabels(chid, map[string]string{"deal": ""})
+		require.NoError(t, err)
+		state = checkEvent(ctx, t, received, datatransfer.LabelsUpdated)
+		require.Equal(t, map[string]string{"job": "a"}, state.Labels())
+		require.True(t, datatransfer.LabelSelector{"job": "a"}.Matches(state))
+		require.False(t, datatransfer.LabelSelector{"job": "b"}.Matches(state))
+
+		state, err = channelList.GetByID(ctx, datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: tid1})
+		require.NoError(t, err)
+		require.Empty(t, state.Labels())
+		require.True(t, datatransfer.LabelSelector{}.Matches(state))
+		require.False(t, datatransfer.LabelSelector{"job": "a"}.Matches(state))
+
+		err = channelList.UpdateLabels(datatransfer.ChannelID{Initiator: peers[1], Responder: peers[0], ID: tid1}, map[string]string{"job": "a"})
+		require.True(t, xerrors.As(err, new(*channels.ErrNotFound)))
+	})
+
 	t.Run("test finality", func(t *testing.T) {
 		state, err := channelList.GetByID(ctx, datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: tid1})
 		require.NoError(t, err)
@@ -265,10 +307,12 @@ func TestChannels(t *testing.T) {
 		state = checkEvent(ctx, t, received, datatransfer.Error)
 		require.Equal(t, datatransfer.Failing, state.Status())
 		require.Equal(t, "something went wrong", state.Message())
+		require.Equal(t, datatransfer.ErrorUnknown, state.ErrorCode())
+		require.False(t, state.Retryable())
 		state = checkEvent(ctx, t, received, datatransfer.CleanupComplete)
 		require.Equal(t, datatransfer.Failed, state.Status())
 
-		chid, err := channelList.CreateNew(peers[0], tid2, cids[1], selector, fv2, peers[2], peers[1], peers[2])
+		chid, err := channelList.CreateNew(peers[0], tid2, cids[1], selector, fv2, peers[2], peers[1], peers[2], datatransfer.ChannelOptions{})
 		require.NoError(t, err)
 		require.Equal(t, peers[2], chid.Initiator)
 		require.Equal(t, tid2, chid.ID)
@@ -285,7 +329,7 @@ func TestChannels(t *testing.T) {
 
 	t.Run("test self peer and other peer", func(t *testing.T) {
 		// sender is self peer
-		chid, err := channelList.CreateNew(peers[1], tid1, cids[0], selector, fv1, peers[1], peers[1], peers[2])
+		chid, err := channelList.CreateNew(peers[1], tid1, cids[0], selector, fv1, peers[1], peers[1], peers[2], datatransfer.ChannelOptions{})
 		require.NoError(t, err)
 		ch, err := channelList.GetByID(context.Background(), chid)
 		require.NoError(t, err)
@@ -293,7 +337,7 @@ func TestChannels(t *testing.T) {
 		require.Equal(t, peers[2], ch.OtherPeer())
 
 		// recipient is self peer
-		chid, err = channelList.CreateNew(peers[2], datatransfer.TransferID(1001), cids[0], selector, fv1, peers[1], peers[2], peers[1])
+		chid, err = channelList.CreateNew(peers[2], datatransfer.TransferID(1001), cids[0], selector, fv1, peers[1], peers[2], peers[1], datatransfer.ChannelOptions{})
 		require.NoError(t, err)
 		ch, err = channelList.GetByID(context.Background(), chid)
 		require.NoError(t, err)
@@ -315,7 +359,7 @@ func TestChannels(t *testing.T) {
 		err = channelList.Start(ctx)
 		require.NoError(t, err)
 
-		chid, err := channelList.CreateNew(peers[3], tid1, cids[0], selector, fv1, peers[3], peers[0], peers[3])
+		chid, err := channelList.CreateNew(peers[3], tid1, cids[0], selector, fv1, peers[3], peers[0], peers[3], datatransfer.ChannelOptions{})
 		require.NoError(t, err)
 		state := checkEvent(ctx, t, received, datatransfer.Open)
 		require.Equal(t, datatransfer.Requested, state.Status())
@@ -324,12 +368,161 @@ func TestChannels(t *testing.T) {
 		require.NoError(t, err)
 		state = checkEvent(ctx, t, received, datatransfer.Disconnected)
 		require.Equal(t, datatransfer.ErrDisconnected.Error(), state.Message())
+		require.Equal(t, datatransfer.ErrorDisconnected, state.ErrorCode())
+		require.True(t, state.Retryable())
+
+		err = channelList.Restart(chid)
+		require.NoError(t, err)
+		state = checkEvent(ctx, t, received, datatransfer.Restart)
+		require.Equal(t, "", state.Message())
+		require.Equal(t, datatransfer.NoError, state.ErrorCode())
+		require.False(t, state.Retryable())
+	})
+
+	t.Run("test reasons", func(t *testing.T) {
+		ds := datastore.NewMapDatastore()
+		received := make(chan event)
+		notifier := func(evt datatransfer.Event, chst datatransfer.ChannelState) {
+			received <- event{evt, chst}
+		}
+		cidLists, err := cidlists.NewCIDLists(os.TempDir())
+		require.NoError(t, err)
+		channelList, err := channels.New(ds, cidLists, notifier, decoderByType, decoderByType, &fakeEnv{}, peers[0])
+		require.NoError(t, err)
+		err = channelList.Start(ctx)
+		require.NoError(t, err)
+
+		chid1, err := channelList.CreateNew(peers[0], tid1, cids[0], selector, fv1, peers[0], peers[0], peers[1], datatransfer.ChannelOptions{})
+		require.NoError(t, err)
+		state := checkEvent(ctx, t, received, datatransfer.Open)
+		require.True(t, state.Reason().IsEmpty())
+		chid2, err := channelList.CreateNew(peers[0], tid2, cids[0], selector, fv1, peers[0], peers[0], peers[1], datatransfer.ChannelOptions{})
+		require.NoError(t, err)
+		checkEvent(ctx, t, received, datatransfer.Open)
+
+		rejected := datatransfer.Reason{Code: 1, Text: "deal not found"}
+		err = channelList.ErrorWithReason(chid1, datatransfer.ErrRejected, rejected)
+		require.NoError(t, err)
+		state = checkEvent(ctx, t, received, datatransfer.Error)
+		require.Equal(t, datatransfer.ErrRejected.Error(), state.Message())
+		require.Equal(t, rejected, state.Reason())
+		checkEvent(ctx, t, received, datatransfer.CleanupComplete)
+
+		cancelled := datatransfer.Reason{Code: 2, Text: "no longer needed"}
+		err = channelList.CancelWithReason(chid2, cancelled)
+		require.NoError(t, err)
+		state = checkEvent(ctx, t, received, datatransfer.Cancel)
+		require.Equal(t, cancelled, state.Reason())
+		state = checkEvent(ctx, t, received, datatransfer.CleanupComplete)
+		require.Equal(t, cancelled, state.Reason())
+
+		// a reason reads as its text, or its code if it has no text
+		require.Equal(t, "no longer needed", cancelled.Error())
+		require.Equal(t, "reason code 3", datatransfer.Reason{Code: 3}.Error())
+	})
+
+	t.Run("test restart attempts", func(t *testing.T) {
+		ds := datastore.NewMapDatastore()
+		received := make(chan event)
+		notifier := func(evt datatransfer.Event, chst datatransfer.ChannelState) {
+			received <- event{evt, chst}
+		}
+		cidLists, err := cidlists.NewCIDLists(os.TempDir())
+		require.NoError(t, err)
+		channelList, err := channels.New(ds, cidLists, notifier, decoderByType, decoderByType, &fakeEnv{}, peers[0])
+		require.NoError(t, err)
+		err = channelList.Start(ctx)
+		require.NoError(t, err)
+
+		chid, err := channelList.CreateNew(peers[0], tid1, cids[0], selector, fv1, peers[0], peers[0], peers[1], datatransfer.ChannelOptions{})
+		require.NoError(t, err)
+		state := checkEvent(ctx, t, received, datatransfer.Open)
+		require.Zero(t, state.RestartAttempts())
+		require.Equal(t, "", state.LastRestartError())
+
+		err = channelList.RestartAttempted(chid, xerrors.New("peer unreachable"))
+		require.NoError(t, err)
+		state = checkEvent(ctx, t, received, datatransfer.RestartAttempted)
+		require.Equal(t, uint64(1), state.RestartAttempts())
+		require.Equal(t, "peer unreachable", state.LastRestartError())
+
+		err = channelList.RestartAttempted(chid, nil)
+		require.NoError(t, err)
+		state = checkEvent(ctx, t, received, datatransfer.RestartAttempted)
+		require.Equal(t, uint64(2), state.RestartAttempts())
+		require.Equal(t, "", state.LastRestartError())
+		require.Equal(t, datatransfer.Requested, state.Status())
+
+		// data moving on the channel resets the count
+		err = channelList.DataReceived(chid, cids[0], 50)
+		require.NoError(t, err)
+		state = checkEvent(ctx, t, received, datatransfer.DataReceivedProgress)
+		require.Zero(t, state.RestartAttempts())
+		checkEvent(ctx, t, received, datatransfer.DataReceived)
+	})
+
+	t.Run("test error codes", func(t *testing.T) {
+		testCases := map[string]struct {
+			err               error
+			expectedCode      datatransfer.ErrorCode
+			expectedRetryable bool
+		}{
+			"rejected": {
+				err:          datatransfer.ErrRejected,
+				expectedCode: datatransfer.ErrorRejected,
+			},
+			"removed": {
+				err:               datatransfer.ErrRemoved,
+				expectedCode:      datatransfer.ErrorRemoved,
+				expectedRetryable: true,
+			},
+			"wrapped transport error": {
+				err:               xerrors.Errorf("failed to transfer data: %w", datatransfer.NewTransportError(errors.New("stream reset"))),
+				expectedCode:      datatransfer.ErrorTransport,
+				expectedRetryable: true,
+			},
+			"transport error wrapping a sentinel": {
+				err:          datatransfer.NewTransportError(datatransfer.ErrRejected),
+				expectedCode: datatransfer.ErrorRejected,
+			},
+			"unknown": {
+				err:          errors.New("something went wrong"),
+				expectedCode: datatransfer.ErrorUnknown,
+			},
+		}
+		for testCase, data := range testCases {
+			t.Run(testCase, func(t *testing.T) {
+				ds := datastore.NewMapDatastore()
+				received := make(chan event)
+				notifier := func(evt datatransfer.Event, chst datatransfer.ChannelState) {
+					received <- event{evt, chst}
+				}
+				cidLists, err := cidlists.NewCIDLists(os.TempDir())
+				require.NoError(t, err)
+				channelList, err := channels.New(ds, cidLists, notifier, decoderByType, decoderByType, &fakeEnv{}, peers[0])
+				require.NoError(t, err)
+				err = channelList.Start(ctx)
+				require.NoError(t, err)
+
+				chid, err := channelList.CreateNew(peers[0], tid1, cids[0], selector, fv1, peers[0], peers[0], peers[1], datatransfer.ChannelOptions{})
+				require.NoError(t, err)
+				checkEvent(ctx, t, received, datatransfer.Open)
+
+				err = channelList.Error(chid, data.err)
+				require.NoError(t, err)
+				state := checkEvent(ctx, t, received, datatransfer.Error)
+				require.Equal(t, data.err.Error(), state.Message())
+				require.Equal(t, data.expectedCode, state.ErrorCode())
+				require.Equal(t, data.expectedRetryable, state.Retryable())
+				checkEvent(ctx, t, received, datatransfer.CleanupComplete)
+			})
+		}
 	})
 
 	t.Run("test self peer and other peer", func(t *testing.T) {
 		peers := testutil.GeneratePeers(3)
 		// sender is self peer
-		chid, err := channelList.CreateNew(peers[1], tid1, cids[0], selector, fv1, peers[1], peers[1], peers[2])
+		chid, err := channelList.CreateNew(peers[1], tid1, cids[0], selector, fv1, peers[1], peers[1], peers[2], datatransfer.ChannelOptions{})
 		require.NoError(t, err)
 		ch, err := channelList.GetByID(context.Background(), chid)
 		require.NoError(t, err)
@@ -337,7 +530,7 @@ func TestChannels(t *testing.T) {
 		require.Equal(t, peers[2], ch.OtherPeer())
 
 		// recipient is self peer
-		chid, err = channelList.CreateNew(peers[2], datatransfer.TransferID(1001), cids[0], selector, fv1, peers[1], peers[2], peers[1])
+		chid, err = channelList.CreateNew(peers[2], datatransfer.TransferID(1001), cids[0], selector, fv1, peers[1], peers[2], peers[1], datatransfer.ChannelOptions{})
 		require.NoError(t, err)
 		ch, err = channelList.GetByID(context.Background(), chid)
 		require.NoError(t, err)
@@ -346,6 +539,330 @@ func TestChannels(t *testing.T) {
 	})
 }
 
+func TestProgressBatching(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	received := make(chan event)
+	notifier := func(evt datatransfer.Event, chst datatransfer.ChannelState) {
+		received <- event{evt, chst}
+	}
+
+	tid := datatransfer.TransferID(0)
+	fv := &testutil.FakeDTType{}
+	cids := testutil.GenerateCids(5)
+	selector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+	peers := testutil.GeneratePeers(2)
+	chid := datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: tid}
+
+	setup := func(t *testing.T, options ...channels.Option) *channels.Channels {
+		ds := datastore.NewMapDatastore()
+		cidLists, err := cidlists.NewCIDLists(tempDir(t))
+		require.NoError(t, err)
+		channelList, err := channels.New(ds, cidLists, notifier, decoderByType, decoderByType, &fakeEnv{}, peers[0], options...)
+		require.NoError(t, err)
+		require.NoError(t, channelList.Start(ctx))
+
+		_, err = channelList.CreateNew(peers[0], tid, cids[0], selector, fv, peers[0], peers[0], peers[1], datatransfer.ChannelOptions{})
+		require.NoError(t, err)
+		checkEvent(ctx, t, received, datatransfer.Open)
+		require.NoError(t, channelList.Accept(chid))
+		checkEvent(ctx, t, received, datatransfer.Accept)
+		return channelList
+	}
+
+	t.Run("applies progress once the byte threshold is reached", func(t *testing.T) {
+		channelList := setup(t, channels.ProgressBatching(0, 100))
+
+		for _, c := range cids[:3] {
+			require.NoError(t, channelList.DataReceived(chid, c, 30))
+		}
+		// a resent block adds no progress
+		require.NoError(t, channelList.DataReceived(chid, cids[0], 30))
+		require.NoError(t, channelList.DataReceived(chid, cids[3], 30))
+		state := checkEvent(ctx, t, received, datatransfer.DataReceivedProgress)
+		require.Equal(t, uint64(120), state.Received())
+		require.Equal(t, 5, state.ReceivedCidsLen())
+
+		// pending progress is applied before the channel pauses
+		require.NoError(t, channelList.DataReceived(chid, cids[4], 30))
+		require.NoError(t, channelList.PauseInitiator(chid))
+		state = checkEvent(ctx, t, received, datatransfer.DataReceivedProgress)
+		require.Equal(t, uint64(150), state.Received())
+		state = checkEvent(ctx, t, received, datatransfer.PauseInitiator)
+		require.Equal(t, uint64(150), state.Received())
+	})
+
+	t.Run("applies progress once the interval passes", func(t *testing.T) {
+		channelList := setup(t, channels.ProgressBatching(10*time.Millisecond, 0))
+
+		require.NoError(t, channelList.DataSent(chid, cids[0], 50))
+		require.NoError(t, channelList.DataSent(chid, cids[1], 50))
+		state := checkEvent(ctx, t, received, datatransfer.DataSentProgress)
+		require.Equal(t, uint64(100), state.Sent())
+
+		// only resent blocks in the interval fires the plain event
+		require.NoError(t, channelList.DataSent(chid, cids[0], 50))
+		state = checkEvent(ctx, t, received, datatransfer.DataSent)
+		require.Equal(t, uint64(100), state.Sent())
+	})
+}
+
+func TestProgressSurvivesCrash(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	received := make(chan event)
+	notifier := func(evt datatransfer.Event, chst datatransfer.ChannelState) {
+		received <- event{evt, chst}
+	}
+
+	tid := datatransfer.TransferID(0)
+	fv := &testutil.FakeDTType{}
+	cids := testutil.GenerateCids(4)
+	selector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+	peers := testutil.GeneratePeers(2)
+	chid := datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: tid}
+
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	dir := tempDir(t)
+	start := func() *channels.Channels {
+		cidLists, err := cidlists.NewCIDLists(dir)
+		require.NoError(t, err)
+		channelList, err := channels.New(ds, cidLists, notifier, decoderByType, decoderByType, &fakeEnv{}, peers[0])
+		require.NoError(t, err)
+		require.NoError(t, channelList.Start(ctx))
+		return channelList
+	}
+
+	channelList := start()
+	_, err := channelList.CreateNew(peers[0], tid, cids[0], selector, fv, peers[0], peers[0], peers[1], datatransfer.ChannelOptions{})
+	require.NoError(t, err)
+	checkEvent(ctx, t, received, datatransfer.Open)
+	for _, c := range cids[:3] {
+		require.NoError(t, channelList.DataReceived(chid, c, 50))
+		checkEvent(ctx, t, received, datatransfer.DataReceivedProgress)
+		checkEvent(ctx, t, received, datatransfer.DataReceived)
+		require.NoError(t, channelList.DataSent(chid, c, 50))
+		checkEvent(ctx, t, received, datatransfer.DataSentProgress)
+		checkEvent(ctx, t, received, datatransfer.DataSent)
+	}
+
+	// start over on the same datastore without stopping the channel list,
+	// as if the process crashed, and go through the blocks again
+	channelList = start()
+	for _, c := range cids[:3] {
+		require.NoError(t, channelList.DataReceived(chid, c, 50))
+		state := checkEvent(ctx, t, received, datatransfer.DataReceived)
+		require.Equal(t, uint64(150), state.Received())
+		require.NoError(t, channelList.DataSent(chid, c, 50))
+		state = checkEvent(ctx, t, received, datatransfer.DataSent)
+		require.Equal(t, uint64(150), state.Sent())
+	}
+	require.NoError(t, channelList.DataReceived(chid, cids[3], 50))
+	state := checkEvent(ctx, t, received, datatransfer.DataReceivedProgress)
+	require.Equal(t, uint64(200), state.Received())
+	checkEvent(ctx, t, received, datatransfer.DataReceived)
+}
+
+func TestChannelStateCache(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	received := make(chan event)
+	notifier := func(evt datatransfer.Event, chst datatransfer.ChannelState) {
+		received <- event{evt, chst}
+	}
+
+	tid := datatransfer.TransferID(0)
+	fv := &testutil.FakeDTType{}
+	cids := testutil.GenerateCids(2)
+	selector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+	peers := testutil.GeneratePeers(2)
+	chid := datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: tid}
+
+	ds := &countingDatastore{Batching: dss.MutexWrap(datastore.NewMapDatastore())}
+	cidLists, err := cidlists.NewCIDLists(tempDir(t))
+	require.NoError(t, err)
+	store := &countingStore{ChannelStore: channels.NewChannelStore(ds)}
+	channelList, err := channels.New(ds, cidLists, notifier, decoderByType, decoderByType, &fakeEnv{}, peers[0], channels.WithChannelStore(store))
+	require.NoError(t, err)
+	require.NoError(t, channelList.Start(ctx))
+
+	_, err = channelList.CreateNew(peers[0], tid, cids[0], selector, fv, peers[0], peers[0], peers[1], datatransfer.ChannelOptions{})
+	require.NoError(t, err)
+	checkEvent(ctx, t, received, datatransfer.Open)
+
+	// a lookup right after an event sees the event's changes
+	require.NoError(t, channelList.Accept(chid))
+	state, err := channelList.GetByID(ctx, chid)
+	require.NoError(t, err)
+	require.Equal(t, datatransfer.Ongoing, state.Status())
+	checkEvent(ctx, t, received, datatransfer.Accept)
+
+	// the cache relies on each event, including a synchronous read, being
+	// written exactly once
+	writes := store.writes()
+	require.NoError(t, channelList.NewVoucher(chid, fv))
+	checkEvent(ctx, t, received, datatransfer.NewVoucher)
+	require.Equal(t, writes+1, store.writes())
+	require.NoError(t, channelList.PauseInitiator(chid))
+	_, err = channelList.GetByID(ctx, chid)
+	require.NoError(t, err)
+	checkEvent(ctx, t, received, datatransfer.PauseInitiator)
+	require.Equal(t, writes+3, store.writes())
+	require.NoError(t, channelList.ResumeInitiator(chid))
+	checkEvent(ctx, t, received, datatransfer.ResumeInitiator)
+
+	// once all events are processed, lookups do not read the datastore
+	reads := ds.reads()
+	state, err = channelList.GetByID(ctx, chid)
+	require.NoError(t, err)
+	require.Equal(t, datatransfer.Ongoing, state.Status())
+	has, err := channelList.HasChannel(chid)
+	require.NoError(t, err)
+	require.True(t, has)
+	require.Equal(t, reads, ds.reads())
+
+	require.NoError(t, channelList.DataReceived(chid, cids[1], 50))
+	state, err = channelList.GetByID(ctx, chid)
+	require.NoError(t, err)
+	require.Equal(t, uint64(50), state.Received())
+	checkEvent(ctx, t, received, datatransfer.DataReceivedProgress)
+	checkEvent(ctx, t, received, datatransfer.DataReceived)
+
+	// terminated channels are still found
+	require.NoError(t, channelList.Cancel(chid))
+	checkEvent(ctx, t, received, datatransfer.Cancel)
+	checkEvent(ctx, t, received, datatransfer.CleanupComplete)
+	state, err = channelList.GetByID(ctx, chid)
+	require.NoError(t, err)
+	require.Equal(t, datatransfer.Cancelled, state.Status())
+
+	// the state machine of a terminated channel has stopped, so reads are not
+	// written
+	writes = store.writes()
+	state, err = channelList.GetByID(ctx, chid)
+	require.NoError(t, err)
+	require.Equal(t, datatransfer.Cancelled, state.Status())
+	require.Equal(t, writes, store.writes())
+}
+
+func TestChannelIndexes(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	received := make(chan event)
+	notifier := func(evt datatransfer.Event, chst datatransfer.ChannelState) {
+		received <- event{evt, chst}
+	}
+
+	fv := &testutil.FakeDTType{}
+	cids := testutil.GenerateCids(2)
+	selector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+	peers := testutil.GeneratePeers(3)
+
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	cidLists, err := cidlists.NewCIDLists(tempDir(t))
+	require.NoError(t, err)
+	store := channels.NewChannelStore(ds)
+	channelList, err := channels.New(ds, cidLists, notifier, decoderByType, decoderByType, &fakeEnv{}, peers[0], channels.WithChannelStore(store))
+	require.NoError(t, err)
+	require.NoError(t, channelList.Start(ctx))
+
+	// GetByID waits for the channel's state to be written, and with it the
+	// channel's index entries
+	requireWritten := func(t *testing.T, chid datatransfer.ChannelID) {
+		_, err := channelList.GetByID(ctx, chid)
+		require.NoError(t, err)
+	}
+
+	chid1, err := channelList.CreateNew(peers[0], 1, cids[0], selector, fv, peers[0], peers[0], peers[1], datatransfer.ChannelOptions{})
+	require.NoError(t, err)
+	checkEvent(ctx, t, received, datatransfer.Open)
+	chid2, err := channelList.CreateNew(peers[0], 2, cids[1], selector, fv, peers[0], peers[0], peers[2], datatransfer.ChannelOptions{})
+	require.NoError(t, err)
+	checkEvent(ctx, t, received, datatransfer.Open)
+	chid3, err := channelList.CreateNew(peers[0], 3, cids[0], selector, fv, peers[1], peers[1], peers[2], datatransfer.ChannelOptions{})
+	require.NoError(t, err)
+	checkEvent(ctx, t, received, datatransfer.Open)
+	requireWritten(t, chid1)
+	requireWritten(t, chid2)
+	requireWritten(t, chid3)
+
+	requireChannels := func(t *testing.T, chids []datatransfer.ChannelID, states map[datatransfer.ChannelID]datatransfer.ChannelState) {
+		require.Len(t, states, len(chids))
+		for _, chid := range chids {
+			require.Contains(t, states, chid)
+			require.Equal(t, chid, states[chid].ChannelID())
+		}
+	}
+	requireIndexes := func(t *testing.T, channelList *channels.Channels) {
+		states, err := channelList.ChannelsByPeer(peers[0])
+		require.NoError(t, err)
+		requireChannels(t, []datatransfer.ChannelID{chid1, chid2}, states)
+		states, err = channelList.ChannelsByPeer(peers[2])
+		require.NoError(t, err)
+		requireChannels(t, []datatransfer.ChannelID{chid2, chid3}, states)
+		states, err = channelList.ChannelsByBaseCID(cids[0])
+		require.NoError(t, err)
+		requireChannels(t, []datatransfer.ChannelID{chid1, chid3}, states)
+	}
+	requireIndexes(t, channelList)
+
+	byVoucherType, err := store.ChannelsByVoucherType(fv.Type())
+	require.NoError(t, err)
+	require.ElementsMatch(t, []datatransfer.ChannelID{chid1, chid2, chid3}, byVoucherType)
+
+	// the status index follows state transitions
+	require.NoError(t, channelList.Accept(chid1))
+	checkEvent(ctx, t, received, datatransfer.Accept)
+	requireWritten(t, chid1)
+	byStatus, err := store.ChannelsByStatus(datatransfer.Ongoing)
+	require.NoError(t, err)
+	require.Equal(t, []datatransfer.ChannelID{chid1}, byStatus)
+	byStatus, err = store.ChannelsByStatus(datatransfer.Requested)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []datatransfer.ChannelID{chid2, chid3}, byStatus)
+
+	// the label index follows label updates
+	require.NoError(t, channelList.UpdateLabels(chid2, map[string]string{"deal": "1", "job": "a"}))
+	checkEvent(ctx, t, received, datatransfer.LabelsUpdated)
+	require.NoError(t, channelList.UpdateLabels(chid3, map[string]string{"deal": "1"}))
+	checkEvent(ctx, t, received, datatransfer.LabelsUpdated)
+	requireWritten(t, chid2)
+	requireWritten(t, chid3)
+	states, err := channelList.ChannelsWithLabels(datatransfer.LabelSelector{"deal": "1"})
+	require.NoError(t, err)
+	requireChannels(t, []datatransfer.ChannelID{chid2, chid3}, states)
+	states, err = channelList.ChannelsWithLabels(datatransfer.LabelSelector{"deal": "1", "job": "a"})
+	require.NoError(t, err)
+	requireChannels(t, []datatransfer.ChannelID{chid2}, states)
+	require.NoError(t, channelList.UpdateLabels(chid3, map[string]string{"deal": ""}))
+	checkEvent(ctx, t, received, datatransfer.LabelsUpdated)
+	requireWritten(t, chid3)
+	states, err = channelList.ChannelsWithLabels(datatransfer.LabelSelector{"deal": "1"})
+	require.NoError(t, err)
+	requireChannels(t, []datatransfer.ChannelID{chid2}, states)
+
+	// indexes are rebuilt on start if they are missing
+	res, err := ds.Query(query.Query{Prefix: "/indexes", KeysOnly: true})
+	require.NoError(t, err)
+	entries, err := res.Rest()
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	for _, entry := range entries {
+		require.NoError(t, ds.Delete(datastore.NewKey(entry.Key)))
+	}
+	channelList, err = channels.New(ds, cidLists, notifier, decoderByType, decoderByType, &fakeEnv{}, peers[0])
+	require.NoError(t, err)
+	require.NoError(t, channelList.Start(ctx))
+	requireIndexes(t, channelList)
+	states, err = channelList.ChannelsWithLabels(datatransfer.LabelSelector{"job": "a"})
+	require.NoError(t, err)
+	requireChannels(t, []datatransfer.ChannelID{chid2}, states)
+}
+
 func TestIsChannelTerminated(t *testing.T) {
 	require.True(t, channels.IsChannelTerminated(datatransfer.Cancelled))
 	require.True(t, channels.IsChannelTerminated(datatransfer.Failed))
@@ -608,11 +1125,116 @@ func TestMigrationsV1(t *testing.T) {
 	}
 }
 
+func TestMigrationsV2(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	ds := datastore.NewMapDatastore()
+	received := make(chan event)
+	notifier := func(evt datatransfer.Event, chst datatransfer.ChannelState) {
+		received <- event{evt, chst}
+	}
+	numChannels := 5
+	chids := make([]datatransfer.ChannelID, numChannels)
+	totalSizes := make([]uint64, numChannels)
+	queueds := make([]uint64, numChannels)
+	// channels that had already failed get an error code from their message
+	statuses := []datatransfer.Status{datatransfer.Ongoing, datatransfer.Failed, datatransfer.Ongoing, datatransfer.Failed, datatransfer.Failing}
+	messages := []string{"", datatransfer.ErrRejected.Error(), "", datatransfer.ErrRemoved.Error(), "something went wrong"}
+	errorCodes := []datatransfer.ErrorCode{datatransfer.NoError, datatransfer.ErrorRejected, datatransfer.NoError, datatransfer.ErrorRemoved, datatransfer.ErrorUnknown}
+	vouchers := make([]datatransfer.Voucher, numChannels)
+	allSelector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+	allSelectorBuf := new(bytes.Buffer)
+	err := dagcbor.Encoder(allSelector, allSelectorBuf)
+	require.NoError(t, err)
+	selfPeer := testutil.GeneratePeers(1)[0]
+	cidLists, err := cidlists.NewCIDLists(os.TempDir())
+	require.NoError(t, err)
+
+	list, err := migrations.GetChannelStateMigrations(selfPeer, cidLists)
+	require.NoError(t, err)
+	vds, up := versionedds.NewVersionedDatastore(ds, list, versioning.VersionKey("2"))
+	require.NoError(t, up(ctx))
+
+	for i := 0; i < numChannels; i++ {
+		peers := testutil.GeneratePeers(2)
+		chids[i] = datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: datatransfer.TransferID(rand.Uint64())}
+		totalSizes[i] = rand.Uint64()
+		queueds[i] = rand.Uint64()
+		vouchers[i] = testutil.NewFakeDTType()
+		vBytes, err := encoding.Encode(vouchers[i])
+		require.NoError(t, err)
+		channel := v2.ChannelState{
+			SelfPeer:   selfPeer,
+			TransferID: chids[i].ID,
+			Initiator:  chids[i].Initiator,
+			Responder:  chids[i].Responder,
+			BaseCid:    testutil.GenerateCids(1)[0],
+			Selector: &cbg.Deferred{
+				Raw: allSelectorBuf.Bytes(),
+			},
+			Sender:    chids[i].Initiator,
+			Recipient: chids[i].Responder,
+			TotalSize: totalSizes[i],
+			Status:    statuses[i],
+			Message:   messages[i],
+			Queued:    queueds[i],
+			Vouchers: []internal.EncodedVoucher{
+				{
+					Type: vouchers[i].Type(),
+					Voucher: &cbg.Deferred{
+						Raw: vBytes,
+					},
+				},
+			},
+		}
+		buf := new(bytes.Buffer)
+		err = channel.MarshalCBOR(buf)
+		require.NoError(t, err)
+		err = vds.Put(datastore.NewKey(chids[i].String()), buf.Bytes())
+		require.NoError(t, err)
+		require.NoError(t, cidLists.CreateList(chids[i], nil))
+	}
+
+	channelList, err := channels.New(ds, cidLists, notifier, decoderByType, decoderByType, &fakeEnv{}, selfPeer)
+	require.NoError(t, err)
+	err = channelList.Start(ctx)
+	require.NoError(t, err)
+
+	for i := 0; i < numChannels; i++ {
+		channel, err := channelList.GetByID(ctx, chids[i])
+		require.NoError(t, err)
+		require.Equal(t, chids[i], channel.ChannelID())
+		require.Equal(t, totalSizes[i], channel.TotalSize())
+		require.Equal(t, queueds[i], channel.Queued())
+		require.Equal(t, vouchers[i], channel.Voucher())
+		require.Equal(t, time.Duration(0), channel.RemoveTimeout())
+		require.Empty(t, channel.Labels())
+		require.Equal(t, errorCodes[i], channel.ErrorCode())
+		require.Equal(t, errorCodes[i].Retryable(), channel.Retryable())
+		require.True(t, channel.Reason().IsEmpty())
+		require.Zero(t, channel.RestartAttempts())
+		require.Equal(t, "", channel.LastRestartError())
+	}
+}
+
 type event struct {
 	event datatransfer.Event
 	state datatransfer.ChannelState
 }
 
+// tempDir returns a new temporary directory that is removed when the test
+// finishes
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "dtchannels")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return dir
+}
+
 func checkEvent(ctx context.Context, t *testing.T, received chan event, code datatransfer.EventCode) datatransfer.ChannelState {
 	var evt event
 	select {
@@ -624,6 +1246,53 @@ func checkEvent(ctx context.Context, t *testing.T, received chan event, code dat
 	return evt.state
 }
 
+// countingDatastore counts the reads made from the datastore
+type countingDatastore struct {
+	datastore.Batching
+	lk    sync.Mutex
+	count int
+}
+
+func (cds *countingDatastore) Get(key datastore.Key) ([]byte, error) {
+	cds.lk.Lock()
+	cds.count++
+	cds.lk.Unlock()
+	return cds.Batching.Get(key)
+}
+
+func (cds *countingDatastore) Has(key datastore.Key) (bool, error) {
+	cds.lk.Lock()
+	cds.count++
+	cds.lk.Unlock()
+	return cds.Batching.Has(key)
+}
+
+func (cds *countingDatastore) reads() int {
+	cds.lk.Lock()
+	defer cds.lk.Unlock()
+	return cds.count
+}
+
+// countingStore counts the channel states written to the channel store
+type countingStore struct {
+	channels.ChannelStore
+	lk    sync.Mutex
+	count int
+}
+
+func (cs *countingStore) PutChannel(key datastore.Key, value []byte, chid datatransfer.ChannelID, index channels.ChannelIndex) error {
+	cs.lk.Lock()
+	cs.count++
+	cs.lk.Unlock()
+	return cs.ChannelStore.PutChannel(key, value, chid, index)
+}
+
+func (cs *countingStore) writes() int {
+	cs.lk.Lock()
+	defer cs.lk.Unlock()
+	return cs.count
+}
+
 type fakeEnv struct {
 }
 
@@ -0,0 +1,392 @@
+package channels
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dss "github.com/ipfs/go-datastore/sync"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	versioning "github.com/filecoin-project/go-ds-versioning/pkg"
+	versionedds "github.com/filecoin-project/go-ds-versioning/pkg/datastore"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/channels/internal"
+	"github.com/filecoin-project/go-data-transfer/channels/internal/migrations"
+	"github.com/filecoin-project/go-data-transfer/cidlists"
+	"github.com/filecoin-project/go-data-transfer/cidsets"
+)
+
+// seenCIDEvents are the events that seen CID sets are kept for
+var seenCIDEvents = []datatransfer.EventCode{
+	datatransfer.DataQueued,
+	datatransfer.DataSent,
+	datatransfer.DataReceived,
+}
+
+func seenCIDSetID(chid datatransfer.ChannelID, evt datatransfer.EventCode) cidsets.SetID {
+	return cidsets.SetID(chid.String() + "/" + datatransfer.Events[evt])
+}
+
+// ExportChannels writes every channel to w: its state, including vouchers and
+// voucher results, its list of received CIDs, and its sets of seen CIDs.
+//
+// The stream starts with a header recording the version of the channel state,
+// so it can be imported by later versions. Channels are read and written one
+// at a time, so a channel that is transferring data may be exported with CIDs
+// it had not counted yet. For an exact copy, export from a stopped node with
+// Inspector.ExportChannels.
+func (c *Channels) ExportChannels(ctx context.Context, w io.Writer) error {
+	if err := c.flushAllProgress(ctx); err != nil {
+		return err
+	}
+
+	return exportChannels(ctx, w, c.store, c.cidLists, c.seenCIDs, c.version)
+}
+
+// exportChannels writes the channel states at the given version in ds, with
+// their received and seen CIDs, as they are read
+func exportChannels(ctx context.Context, w io.Writer, ds datastore.Datastore, cidLists cidlists.CIDLists, seenCIDs *cidsets.CIDSetManager, version versioning.VersionKey) error {
+	bw := bufio.NewWriter(w)
+	header := internal.ExportHeader{
+		Magic:         internal.ExportMagic,
+		FormatVersion: internal.ExportFormatVersion,
+		StateVersion:  string(version),
+	}
+	if err := header.MarshalCBOR(bw); err != nil {
+		return err
+	}
+
+	res, err := ds.Query(query.Query{Prefix: datastore.NewKey(string(version)).String()})
+	if err != nil {
+		return err
+	}
+	defer res.Close() //nolint:errcheck
+
+	var count uint64
+	for result := range res.Next() {
+		if result.Error != nil {
+			return result.Error
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := exportChannel(bw, result.Value, cidLists, seenCIDs); err != nil {
+			return xerrors.Errorf("exporting channel at %s: %w", result.Key, err)
+		}
+		count++
+	}
+
+	end := internal.ExportRecord{End: &internal.ExportEnd{Channels: count}}
+	if err := end.MarshalCBOR(bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func exportChannel(w io.Writer, stateBytes []byte, cidLists cidlists.CIDLists, seenCIDs *cidsets.CIDSetManager) error {
+	var state internal.ChannelState
+	if err := state.UnmarshalCBOR(bytes.NewReader(stateBytes)); err != nil {
+		return err
+	}
+	chid := datatransfer.ChannelID{Initiator: state.Initiator, Responder: state.Responder, ID: state.TransferID}
+
+	record := internal.ExportRecord{Channel: &internal.ExportedChannel{State: &cbg.Deferred{Raw: stateBytes}}}
+	if err := record.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	err := exportCids(w, "", func(f func(cid.Cid) error) error {
+		return cidLists.IterateList(chid, f)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return xerrors.Errorf("reading received cids: %w", err)
+	}
+
+	for _, evt := range seenCIDEvents {
+		sid := seenCIDSetID(chid, evt)
+		err := exportCids(w, datatransfer.Events[evt], func(f func(cid.Cid) error) error {
+			return seenCIDs.IterateSetCIDs(sid, f)
+		})
+		if err != nil {
+			return xerrors.Errorf("reading seen cids: %w", err)
+		}
+	}
+	return nil
+}
+
+// exportCids writes the CIDs that iterate reads as they are read, a record
+// at a time
+func exportCids(w io.Writer, event string, iterate func(func(cid.Cid) error) error) error {
+	cids := make([]cid.Cid, 0, internal.MaxExportedCids)
+	err := iterate(func(k cid.Cid) error {
+		cids = append(cids, k)
+		if len(cids) < internal.MaxExportedCids {
+			return nil
+		}
+		err := writeExportedCids(w, event, cids)
+		cids = cids[:0]
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return writeExportedCids(w, event, cids)
+}
+
+// writeExportedCids writes the CIDs as ReceivedCids records, or as SeenCids
+// records if event is set, splitting them so no record is too long
+func writeExportedCids(w io.Writer, event string, cids []cid.Cid) error {
+	for len(cids) > 0 {
+		n := len(cids)
+		if n > internal.MaxExportedCids {
+			n = internal.MaxExportedCids
+		}
+		exported := &internal.ExportedCids{Event: event, Cids: cids[:n]}
+		record := internal.ExportRecord{ReceivedCids: exported}
+		if event != "" {
+			record = internal.ExportRecord{SeenCids: exported}
+		}
+		if err := record.MarshalCBOR(w); err != nil {
+			return err
+		}
+		cids = cids[n:]
+	}
+	return nil
+}
+
+// importingChannel is a channel being read from an export stream. Its CIDs
+// are written as they are read, and its state once they have all been read,
+// so that the channel only shows up once it is complete.
+type importingChannel struct {
+	chid  datatransfer.ChannelID
+	state internal.ChannelState
+}
+
+// ImportChannels reads channels written by ExportChannels and adds them.
+//
+// Channel states from an older version are migrated to the current version
+// with the same migrations that are run on the datastore at startup. It is an
+// error to import a channel that already exists. Channels are added as they
+// are read, so if the import fails, the channels before the failure remain.
+func (c *Channels) ImportChannels(ctx context.Context, r io.Reader) error {
+	br := bufio.NewReader(r)
+	var header internal.ExportHeader
+	if err := header.UnmarshalCBOR(br); err != nil {
+		return xerrors.Errorf("reading export header: %w", err)
+	}
+	if header.Magic != internal.ExportMagic {
+		return xerrors.New("not a channel export stream")
+	}
+	if header.FormatVersion != internal.ExportFormatVersion {
+		return xerrors.Errorf("unsupported channel export format version %d", header.FormatVersion)
+	}
+	stateVersion := versioning.VersionKey(header.StateVersion)
+
+	var current *importingChannel
+	var count uint64
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		if err := c.finishImport(current); err != nil {
+			return err
+		}
+		current = nil
+		count++
+		return nil
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var record internal.ExportRecord
+		if err := record.UnmarshalCBOR(br); err != nil {
+			if err == io.EOF {
+				return xerrors.New("channel export stream ended early")
+			}
+			return xerrors.Errorf("reading channel export record: %w", err)
+		}
+
+		switch {
+		case record.Channel != nil:
+			if err := finish(); err != nil {
+				return err
+			}
+			if record.Channel.State == nil {
+				return xerrors.New("channel record has no state")
+			}
+			var err error
+			current, err = c.startImport(ctx, stateVersion, record.Channel.State.Raw)
+			if err != nil {
+				return err
+			}
+		case record.ReceivedCids != nil:
+			if current == nil {
+				return xerrors.New("received cids record before any channel record")
+			}
+			for _, k := range record.ReceivedCids.Cids {
+				if err := c.cidLists.AppendList(current.chid, k); err != nil {
+					return xerrors.Errorf("writing received cids of imported channel %s: %w", current.chid, err)
+				}
+			}
+		case record.SeenCids != nil:
+			if current == nil {
+				return xerrors.New("seen cids record before any channel record")
+			}
+			evt, ok := seenCIDEvent(record.SeenCids.Event)
+			if !ok {
+				return xerrors.Errorf("seen cids record for unknown event %q", record.SeenCids.Event)
+			}
+			sid := seenCIDSetID(current.chid, evt)
+			for _, k := range record.SeenCids.Cids {
+				if _, err := c.seenCIDs.InsertSetCID(sid, k); err != nil {
+					return xerrors.Errorf("writing seen cids of imported channel %s: %w", current.chid, err)
+				}
+			}
+		case record.End != nil:
+			if err := finish(); err != nil {
+				return err
+			}
+			if record.End.Channels != count {
+				return xerrors.Errorf("channel export stream has %d channels but its end record says %d", count, record.End.Channels)
+			}
+			return nil
+		default:
+			return xerrors.New("empty channel export record")
+		}
+	}
+}
+
+func seenCIDEvent(name string) (datatransfer.EventCode, bool) {
+	for _, evt := range seenCIDEvents {
+		if datatransfer.Events[evt] == name {
+			return evt, true
+		}
+	}
+	return 0, false
+}
+
+// startImport migrates an imported channel's state to the current version,
+// checks the channel does not exist, and starts its list of received CIDs
+func (c *Channels) startImport(ctx context.Context, stateVersion versioning.VersionKey, stateBytes []byte) (*importingChannel, error) {
+	var received []cid.Cid
+	if stateVersion != c.version {
+		var err error
+		stateBytes, received, err = c.migrateImportedState(ctx, stateVersion, stateBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	imported := &importingChannel{}
+	if err := imported.state.UnmarshalCBOR(bytes.NewReader(stateBytes)); err != nil {
+		return nil, xerrors.Errorf("decoding imported channel state: %w", err)
+	}
+	imported.chid = datatransfer.ChannelID{Initiator: imported.state.Initiator, Responder: imported.state.Responder, ID: imported.state.TransferID}
+	has, err := c.HasChannel(imported.chid)
+	if err != nil {
+		return nil, err
+	}
+	if has {
+		return nil, xerrors.Errorf("cannot import channel %s: it already exists", imported.chid)
+	}
+	if err := c.cidLists.CreateList(imported.chid, received); err != nil {
+		return nil, xerrors.Errorf("writing received cids of imported channel %s: %w", imported.chid, err)
+	}
+	return imported, nil
+}
+
+// finishImport writes an imported channel's state, once all its CIDs have
+// been written
+func (c *Channels) finishImport(imported *importingChannel) error {
+	if err := c.seenCIDs.Flush(); err != nil {
+		return xerrors.Errorf("writing seen cids of imported channel %s: %w", imported.chid, err)
+	}
+	c.cache.sending(imported.chid)
+	if err := c.stateMachines.Begin(imported.chid, &imported.state); err != nil {
+		c.cache.sendFailed(imported.chid)
+		return xerrors.Errorf("writing imported channel %s: %w", imported.chid, err)
+	}
+	return nil
+}
+
+// importMigrationKey is the key an imported channel state is migrated under
+var importMigrationKey = datastore.NewKey("import")
+
+// migrateImportedState runs the channel state migrations on a single state
+// from an older version, in a scratch datastore. Old versions of the state
+// kept received CIDs in the state itself, so they are returned separately.
+func (c *Channels) migrateImportedState(ctx context.Context, stateVersion versioning.VersionKey, stateBytes []byte) ([]byte, []cid.Cid, error) {
+	scratch := dss.MutexWrap(datastore.NewMapDatastore())
+	if stateVersion != "" {
+		if err := scratch.Put(datastore.NewKey("/versions/current"), []byte(stateVersion)); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := scratch.Put(datastore.NewKey(string(stateVersion)).Child(importMigrationKey), stateBytes); err != nil {
+		return nil, nil, err
+	}
+
+	received := &migratedCIDLists{}
+	channelMigrations, err := migrations.GetChannelStateMigrations(c.selfPeer, received)
+	if err != nil {
+		return nil, nil, err
+	}
+	migrated, migrate := versionedds.NewVersionedDatastore(scratch, channelMigrations, c.version)
+	if err := migrate(ctx); err != nil {
+		return nil, nil, xerrors.Errorf("migrating imported channel state from version %q: %w", stateVersion, err)
+	}
+	migratedBytes, err := migrated.Get(importMigrationKey)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("reading migrated channel state: %w", err)
+	}
+	return migratedBytes, received.cids, nil
+}
+
+// migratedCIDLists captures the received CIDs that the migration from a
+// version that kept them in the channel state writes to a CID list, so
+// nothing is written until the migrated channel is known not to exist
+type migratedCIDLists struct {
+	cids []cid.Cid
+}
+
+func (m *migratedCIDLists) CreateList(chid datatransfer.ChannelID, initialCids []cid.Cid) error {
+	m.cids = append(m.cids, initialCids...)
+	return nil
+}
+
+func (m *migratedCIDLists) AppendList(chid datatransfer.ChannelID, c cid.Cid) error {
+	m.cids = append(m.cids, c)
+	return nil
+}
+
+func (m *migratedCIDLists) ReadList(chid datatransfer.ChannelID) ([]cid.Cid, error) {
+	return m.cids, nil
+}
+
+func (m *migratedCIDLists) IterateList(chid datatransfer.ChannelID, f func(cid.Cid) error) error {
+	for _, c := range m.cids {
+		if err := f(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *migratedCIDLists) ListLen(chid datatransfer.ChannelID) (int, error) {
+	return len(m.cids), nil
+}
+
+func (m *migratedCIDLists) DeleteList(chid datatransfer.ChannelID) error {
+	m.cids = nil
+	return nil
+}
@@ -0,0 +1,250 @@
+package channels_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/channels"
+	"github.com/filecoin-project/go-data-transfer/channels/internal"
+	v0 "github.com/filecoin-project/go-data-transfer/channels/internal/migrations/v0"
+	v1 "github.com/filecoin-project/go-data-transfer/channels/internal/migrations/v1"
+	"github.com/filecoin-project/go-data-transfer/cidlists"
+	"github.com/filecoin-project/go-data-transfer/encoding"
+	"github.com/filecoin-project/go-data-transfer/testutil"
+)
+
+func TestExportImportChannels(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	notifier := func(evt datatransfer.Event, chst datatransfer.ChannelState) {}
+	fv := testutil.NewFakeDTType()
+	cids := testutil.GenerateCids(2)
+	selector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+	peers := testutil.GeneratePeers(3)
+
+	newChannels := func(t *testing.T) *channels.Channels {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		cidLists, err := cidlists.NewCIDLists(tempDir(t))
+		require.NoError(t, err)
+		channelList, err := channels.New(ds, cidLists, notifier, decoderByType, decoderByType, &fakeEnv{}, peers[0])
+		require.NoError(t, err)
+		require.NoError(t, channelList.Start(ctx))
+		return channelList
+	}
+
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	cidLists, err := cidlists.NewCIDLists(tempDir(t))
+	require.NoError(t, err)
+	source, err := channels.New(ds, cidLists, notifier, decoderByType, decoderByType, &fakeEnv{}, peers[0])
+	require.NoError(t, err)
+	require.NoError(t, source.Start(ctx))
+
+	chid1, err := source.CreateNew(peers[0], 1, cids[0], selector, fv, peers[0], peers[1], peers[0], datatransfer.ChannelOptions{})
+	require.NoError(t, err)
+	require.NoError(t, source.Accept(chid1))
+	blocks := testutil.GenerateCids(3)
+	for _, k := range blocks {
+		require.NoError(t, source.DataReceived(chid1, k, 100))
+	}
+	require.NoError(t, source.NewVoucherResult(chid1, fv))
+
+	// enough received cids that they are split across several records
+	chid2, err := source.CreateNew(peers[0], 2, cids[1], selector, fv, peers[0], peers[2], peers[0], datatransfer.ChannelOptions{})
+	require.NoError(t, err)
+	manyCids := testutil.GenerateCids(internal.MaxExportedCids + 100)
+	require.NoError(t, cidLists.CreateList(chid2, manyCids))
+
+	exported := make(map[datatransfer.ChannelID]datatransfer.ChannelState)
+	for _, chid := range []datatransfer.ChannelID{chid1, chid2} {
+		exported[chid], err = source.GetByID(ctx, chid)
+		require.NoError(t, err)
+	}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, source.ExportChannels(ctx, buf))
+	stream := buf.Bytes()
+
+	t.Run("round trip", func(t *testing.T) {
+		dest := newChannels(t)
+		require.NoError(t, dest.ImportChannels(ctx, bytes.NewReader(stream)))
+
+		for chid, expected := range exported {
+			imported, err := dest.GetByID(ctx, chid)
+			require.NoError(t, err)
+			require.Equal(t, expected.Status(), imported.Status())
+			require.Equal(t, expected.Received(), imported.Received())
+			require.Equal(t, expected.BaseCID(), imported.BaseCID())
+			require.Equal(t, expected.Vouchers(), imported.Vouchers())
+			require.Equal(t, expected.VoucherResults(), imported.VoucherResults())
+			require.Equal(t, expected.ReceivedCids(), imported.ReceivedCids())
+		}
+		imported, err := dest.GetByID(ctx, chid2)
+		require.NoError(t, err)
+		require.Equal(t, manyCids, imported.ReceivedCids())
+
+		// blocks that were seen before the export are not counted again
+		require.NoError(t, dest.DataReceived(chid1, blocks[0], 100))
+		imported, err = dest.GetByID(ctx, chid1)
+		require.NoError(t, err)
+		require.Equal(t, exported[chid1].Received(), imported.Received())
+		require.NoError(t, dest.DataReceived(chid1, cids[1], 100))
+		imported, err = dest.GetByID(ctx, chid1)
+		require.NoError(t, err)
+		require.Equal(t, exported[chid1].Received()+100, imported.Received())
+	})
+
+	t.Run("existing channel", func(t *testing.T) {
+		dest := newChannels(t)
+		require.NoError(t, dest.ImportChannels(ctx, bytes.NewReader(stream)))
+		err := dest.ImportChannels(ctx, bytes.NewReader(stream))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "already exists")
+	})
+
+	t.Run("truncated stream", func(t *testing.T) {
+		dest := newChannels(t)
+		err := dest.ImportChannels(ctx, bytes.NewReader(stream[:len(stream)-2]))
+		require.Error(t, err)
+	})
+
+	t.Run("not an export stream", func(t *testing.T) {
+		dest := newChannels(t)
+		err := dest.ImportChannels(ctx, bytes.NewReader([]byte("not an export")))
+		require.Error(t, err)
+	})
+}
+
+func TestImportOlderChannelVersion(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	selfPeer := testutil.GeneratePeers(1)[0]
+	peers := testutil.GeneratePeers(2)
+	baseCid := testutil.GenerateCids(1)[0]
+	allSelector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+	allSelectorBuf := new(bytes.Buffer)
+	require.NoError(t, dagcbor.Encoder(allSelector, allSelectorBuf))
+	voucher := testutil.NewFakeDTType()
+	vBytes, err := encoding.Encode(voucher)
+	require.NoError(t, err)
+	chid := datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: 7}
+
+	testCases := map[string]struct {
+		stateVersion string
+		state        func(receivedCids []cid.Cid) cbg.CBORMarshaler
+		// inState is true if the version keeps received cids in the state
+		// rather than in separate records
+		inState bool
+	}{
+		"unversioned": {
+			stateVersion: "",
+			state: func([]cid.Cid) cbg.CBORMarshaler {
+				return &v0.ChannelState{
+					TransferID: chid.ID,
+					Initiator:  peers[0],
+					Responder:  peers[1],
+					BaseCid:    baseCid,
+					Selector:   &cbg.Deferred{Raw: allSelectorBuf.Bytes()},
+					Sender:     peers[0],
+					Recipient:  peers[1],
+					TotalSize:  1000,
+					Status:     datatransfer.Ongoing,
+					Received:   500,
+					Vouchers: []v0.EncodedVoucher{
+						{Type: voucher.Type(), Voucher: &cbg.Deferred{Raw: vBytes}},
+					},
+				}
+			},
+		},
+		"version 1": {
+			stateVersion: "1",
+			state: func(receivedCids []cid.Cid) cbg.CBORMarshaler {
+				return &v1.ChannelState{
+					TransferID: chid.ID,
+					Initiator:  peers[0],
+					Responder:  peers[1],
+					BaseCid:    baseCid,
+					Selector:   &cbg.Deferred{Raw: allSelectorBuf.Bytes()},
+					Sender:     peers[0],
+					Recipient:  peers[1],
+					TotalSize:  1000,
+					Status:     datatransfer.Ongoing,
+					Received:   500,
+					Vouchers: []internal.EncodedVoucher{
+						{Type: voucher.Type(), Voucher: &cbg.Deferred{Raw: vBytes}},
+					},
+					SelfPeer:     selfPeer,
+					ReceivedCids: receivedCids,
+				}
+			},
+			inState: true,
+		},
+	}
+	for testCase, data := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			receivedCids := testutil.GenerateCids(10)
+			stateBuf := new(bytes.Buffer)
+			require.NoError(t, data.state(receivedCids).MarshalCBOR(stateBuf))
+
+			// an export written when the channel state was at an older version
+			stream := new(bytes.Buffer)
+			header := internal.ExportHeader{
+				Magic:         internal.ExportMagic,
+				FormatVersion: internal.ExportFormatVersion,
+				StateVersion:  data.stateVersion,
+			}
+			require.NoError(t, header.MarshalCBOR(stream))
+			records := []internal.ExportRecord{
+				{Channel: &internal.ExportedChannel{State: &cbg.Deferred{Raw: stateBuf.Bytes()}}},
+			}
+			if !data.inState {
+				records = append(records, internal.ExportRecord{ReceivedCids: &internal.ExportedCids{Cids: receivedCids}})
+			}
+			records = append(records,
+				internal.ExportRecord{SeenCids: &internal.ExportedCids{Event: datatransfer.Events[datatransfer.DataReceived], Cids: receivedCids}},
+				internal.ExportRecord{End: &internal.ExportEnd{Channels: 1}})
+			for _, record := range records {
+				require.NoError(t, record.MarshalCBOR(stream))
+			}
+
+			ds := dss.MutexWrap(datastore.NewMapDatastore())
+			cidLists, err := cidlists.NewCIDLists(tempDir(t))
+			require.NoError(t, err)
+			notifier := func(evt datatransfer.Event, chst datatransfer.ChannelState) {}
+			channelList, err := channels.New(ds, cidLists, notifier, decoderByType, decoderByType, &fakeEnv{}, selfPeer)
+			require.NoError(t, err)
+			require.NoError(t, channelList.Start(ctx))
+			require.NoError(t, channelList.ImportChannels(ctx, stream))
+
+			channel, err := channelList.GetByID(ctx, chid)
+			require.NoError(t, err)
+			require.Equal(t, selfPeer, channel.SelfPeer())
+			require.Equal(t, baseCid, channel.BaseCID())
+			require.Equal(t, allSelector, channel.Selector())
+			require.Equal(t, uint64(500), channel.Received())
+			require.Equal(t, voucher, channel.LastVoucher())
+			require.Equal(t, receivedCids, channel.ReceivedCids())
+
+			// the migrated channel keeps its seen cids
+			require.NoError(t, channelList.DataReceived(chid, receivedCids[0], 100))
+			channel, err = channelList.GetByID(ctx, chid)
+			require.NoError(t, err)
+			require.Equal(t, uint64(500), channel.Received())
+		})
+	}
+}
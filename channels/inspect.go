@@ -0,0 +1,224 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"golang.org/x/xerrors"
+
+	versioning "github.com/filecoin-project/go-ds-versioning/pkg"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/channels/internal"
+	"github.com/filecoin-project/go-data-transfer/cidlists"
+	"github.com/filecoin-project/go-data-transfer/cidsets"
+)
+
+// versionKey is where the versioned datastore records the version of the
+// channel state
+var versionKey = datastore.NewKey("/versions/current")
+
+// EncodedValue is a voucher or voucher result as it is stored in the channel
+// state
+type EncodedValue struct {
+	Type datatransfer.TypeIdentifier
+	Raw  []byte
+}
+
+// ChannelRecord is the state of a channel as read by an Inspector. It has the
+// channel's vouchers and voucher results as they are stored, so they can be
+// shown even if their types are not registered. The Vouchers and
+// VoucherResults methods of the channel state must only be used if they are.
+type ChannelRecord struct {
+	datatransfer.ChannelState
+	EncodedVouchers       []EncodedValue
+	EncodedVoucherResults []EncodedValue
+}
+
+// Inspector reads and repairs the channels in the datastore of a data
+// transfer module that is not running. Unlike Channels, it never migrates the
+// datastore, and it reads and writes channel states directly rather than
+// through the channel state machines.
+type Inspector struct {
+	ds                   datastore.Batching
+	store                ChannelStore
+	cidLists             cidlists.CIDLists
+	seenCIDs             *cidsets.CIDSetManager
+	voucherDecoder       DecoderByTypeFunc
+	voucherResultDecoder DecoderByTypeFunc
+}
+
+// NewInspector returns an inspector for the channels in the given datastore
+// and cid lists, which are the ones that were passed to New
+func NewInspector(ds datastore.Batching, cidLists cidlists.CIDLists, voucherDecoder DecoderByTypeFunc, voucherResultDecoder DecoderByTypeFunc) *Inspector {
+	return &Inspector{
+		ds:                   ds,
+		store:                NewChannelStore(ds),
+		cidLists:             cidLists,
+		seenCIDs:             cidsets.NewCIDSetManager(namespace.Wrap(ds, datastore.NewKey("seencids"))),
+		voucherDecoder:       voucherDecoder,
+		voucherResultDecoder: voucherResultDecoder,
+	}
+}
+
+// Version returns the version of the channel state in the datastore. It is
+// empty if the datastore has never been migrated.
+func (i *Inspector) Version() (string, error) {
+	version, err := i.ds.Get(versionKey)
+	if err == datastore.ErrNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(version), nil
+}
+
+// checkVersion returns an error unless the channel state in the datastore is
+// at the current version
+func (i *Inspector) checkVersion() error {
+	version, err := i.Version()
+	if err != nil {
+		return xerrors.Errorf("reading channel state version: %w", err)
+	}
+	if version != CurrentVersion {
+		return xerrors.Errorf("channel state is at version %q rather than %q: start the data transfer module to migrate it", version, CurrentVersion)
+	}
+	return nil
+}
+
+// Channels returns every channel in the datastore
+func (i *Inspector) Channels() ([]ChannelRecord, error) {
+	if err := i.checkVersion(); err != nil {
+		return nil, err
+	}
+	res, err := i.ds.Query(query.Query{Prefix: datastore.NewKey(CurrentVersion).String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var records []ChannelRecord
+	for entry := range res.Next() {
+		if entry.Error != nil {
+			return nil, entry.Error
+		}
+		var state internal.ChannelState
+		if err := state.UnmarshalCBOR(bytes.NewReader(entry.Value)); err != nil {
+			return nil, xerrors.Errorf("decoding channel state at %s: %w", entry.Key, err)
+		}
+		records = append(records, i.channelRecord(state))
+	}
+	return records, nil
+}
+
+// Channel returns the channel with the given ID
+func (i *Inspector) Channel(chid datatransfer.ChannelID) (ChannelRecord, error) {
+	if err := i.checkVersion(); err != nil {
+		return ChannelRecord{}, err
+	}
+	state, err := i.channelState(chid)
+	if err != nil {
+		return ChannelRecord{}, err
+	}
+	return i.channelRecord(state), nil
+}
+
+// SeenCIDs returns the CIDs in the channel's set of seen CIDs for the given
+// event, which is one of DataQueued, DataSent or DataReceived
+func (i *Inspector) SeenCIDs(chid datatransfer.ChannelID, evt datatransfer.EventCode) ([]cid.Cid, error) {
+	if _, ok := seenCIDEvent(datatransfer.Events[evt]); !ok {
+		return nil, xerrors.Errorf("no seen cids are kept for %s events", datatransfer.Events[evt])
+	}
+	return i.seenCIDs.SetCIDs(seenCIDSetID(chid, evt))
+}
+
+// ForceFail moves a channel to the Failed status with the given message,
+// without running any of the channel's state transitions
+func (i *Inspector) ForceFail(chid datatransfer.ChannelID, message string) error {
+	if err := i.checkVersion(); err != nil {
+		return err
+	}
+	state, err := i.channelState(chid)
+	if err != nil {
+		return err
+	}
+	state.Status = datatransfer.Failed
+	state.Message = message
+	buf := new(bytes.Buffer)
+	if err := state.MarshalCBOR(buf); err != nil {
+		return err
+	}
+	return i.store.PutChannel(channelKey(chid), buf.Bytes(), chid, channelIndex(state))
+}
+
+// Purge deletes a channel, with its index entries, its received CIDs and its
+// seen CIDs
+func (i *Inspector) Purge(chid datatransfer.ChannelID) error {
+	if err := i.checkVersion(); err != nil {
+		return err
+	}
+	if _, err := i.channelState(chid); err != nil {
+		return err
+	}
+	if err := i.store.DeleteChannel(channelKey(chid), chid); err != nil {
+		return err
+	}
+	if err := i.cidLists.DeleteList(chid); err != nil && !os.IsNotExist(err) {
+		return xerrors.Errorf("deleting received cids: %w", err)
+	}
+	for _, evt := range seenCIDEvents {
+		if err := i.seenCIDs.DeleteSet(seenCIDSetID(chid, evt)); err != nil {
+			return xerrors.Errorf("deleting seen cids: %w", err)
+		}
+	}
+	return nil
+}
+
+// ExportChannels writes every channel to w, in the same format as
+// Channels.ExportChannels
+func (i *Inspector) ExportChannels(ctx context.Context, w io.Writer) error {
+	if err := i.checkVersion(); err != nil {
+		return err
+	}
+	return exportChannels(ctx, w, i.ds, i.cidLists, i.seenCIDs, versioning.VersionKey(CurrentVersion))
+}
+
+func (i *Inspector) channelState(chid datatransfer.ChannelID) (internal.ChannelState, error) {
+	value, err := i.ds.Get(channelKey(chid))
+	if err == datastore.ErrNotFound {
+		return internal.ChannelState{}, NewErrNotFound(chid)
+	}
+	if err != nil {
+		return internal.ChannelState{}, err
+	}
+	var state internal.ChannelState
+	if err := state.UnmarshalCBOR(bytes.NewReader(value)); err != nil {
+		return internal.ChannelState{}, xerrors.Errorf("decoding channel state: %w", err)
+	}
+	return state, nil
+}
+
+func (i *Inspector) channelRecord(state internal.ChannelState) ChannelRecord {
+	record := ChannelRecord{
+		ChannelState: fromInternalChannelState(state, i.voucherDecoder, i.voucherResultDecoder, nil, nil, i.cidLists),
+	}
+	for _, voucher := range state.Vouchers {
+		record.EncodedVouchers = append(record.EncodedVouchers, EncodedValue{Type: voucher.Type, Raw: voucher.Voucher.Raw})
+	}
+	for _, result := range state.VoucherResults {
+		record.EncodedVoucherResults = append(record.EncodedVoucherResults, EncodedValue{Type: result.Type, Raw: result.VoucherResult.Raw})
+	}
+	return record
+}
+
+// channelKey is the key the channel state machines keep a channel's state at
+func channelKey(chid datatransfer.ChannelID) datastore.Key {
+	return datastore.NewKey(CurrentVersion).ChildString(chid.String())
+}
@@ -0,0 +1,125 @@
+package channels_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/channels"
+	"github.com/filecoin-project/go-data-transfer/cidlists"
+	"github.com/filecoin-project/go-data-transfer/encoding"
+	"github.com/filecoin-project/go-data-transfer/testutil"
+)
+
+func TestInspector(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	notifier := func(evt datatransfer.Event, chst datatransfer.ChannelState) {}
+	fv := testutil.NewFakeDTType()
+	cids := testutil.GenerateCids(2)
+	selector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+	peers := testutil.GeneratePeers(3)
+
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	cidLists, err := cidlists.NewCIDLists(tempDir(t))
+	require.NoError(t, err)
+
+	inspector := channels.NewInspector(ds, cidLists, decoderByType, decoderByType)
+	version, err := inspector.Version()
+	require.NoError(t, err)
+	require.Equal(t, "", version)
+	_, err = inspector.Channels()
+	require.Error(t, err)
+
+	channelList, err := channels.New(ds, cidLists, notifier, decoderByType, decoderByType, &fakeEnv{}, peers[0])
+	require.NoError(t, err)
+	require.NoError(t, channelList.Start(ctx))
+	chid1, err := channelList.CreateNew(peers[0], 1, cids[0], selector, fv, peers[0], peers[1], peers[0], datatransfer.ChannelOptions{})
+	require.NoError(t, err)
+	require.NoError(t, channelList.Accept(chid1))
+	block := testutil.GenerateCids(1)[0]
+	require.NoError(t, channelList.DataReceived(chid1, block, 100))
+	chid2, err := channelList.CreateNew(peers[0], 2, cids[1], selector, fv, peers[0], peers[2], peers[0], datatransfer.ChannelOptions{})
+	require.NoError(t, err)
+	for _, chid := range []datatransfer.ChannelID{chid1, chid2} {
+		_, err := channelList.GetByID(ctx, chid)
+		require.NoError(t, err)
+	}
+	require.NoError(t, channelList.Stop(ctx))
+
+	version, err = inspector.Version()
+	require.NoError(t, err)
+	require.Equal(t, channels.CurrentVersion, version)
+
+	records, err := inspector.Channels()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	record, err := inspector.Channel(chid1)
+	require.NoError(t, err)
+	require.Equal(t, chid1, record.ChannelID())
+	require.Equal(t, datatransfer.Ongoing, record.Status())
+	require.Equal(t, uint64(100), record.Received())
+	require.Len(t, record.EncodedVouchers, 1)
+	require.Equal(t, fv.Type(), record.EncodedVouchers[0].Type)
+	fvBytes, err := encoding.Encode(fv)
+	require.NoError(t, err)
+	require.Equal(t, fvBytes, record.EncodedVouchers[0].Raw)
+
+	seen, err := inspector.SeenCIDs(chid1, datatransfer.DataReceived)
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{block}, seen)
+	_, err = inspector.SeenCIDs(chid1, datatransfer.Accept)
+	require.Error(t, err)
+
+	_, err = inspector.Channel(datatransfer.ChannelID{Initiator: peers[1], Responder: peers[2], ID: 3})
+	var notFound *channels.ErrNotFound
+	require.True(t, errors.As(err, &notFound))
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, inspector.ExportChannels(ctx, buf))
+	importDS := dss.MutexWrap(datastore.NewMapDatastore())
+	importCidLists, err := cidlists.NewCIDLists(tempDir(t))
+	require.NoError(t, err)
+	imported, err := channels.New(importDS, importCidLists, notifier, decoderByType, decoderByType, &fakeEnv{}, peers[0])
+	require.NoError(t, err)
+	require.NoError(t, imported.Start(ctx))
+	require.NoError(t, imported.ImportChannels(ctx, buf))
+	for _, chid := range []datatransfer.ChannelID{chid1, chid2} {
+		_, err := imported.GetByID(ctx, chid)
+		require.NoError(t, err)
+	}
+
+	// the inspector writes to the datastore of a module that is not running
+	require.NoError(t, inspector.ForceFail(chid1, "stuck"))
+	require.NoError(t, inspector.Purge(chid2))
+
+	restarted, err := channels.New(ds, cidLists, notifier, decoderByType, decoderByType, &fakeEnv{}, peers[0])
+	require.NoError(t, err)
+	require.NoError(t, restarted.Start(ctx))
+	state, err := restarted.GetByID(ctx, chid1)
+	require.NoError(t, err)
+	require.Equal(t, datatransfer.Failed, state.Status())
+	require.Equal(t, "stuck", state.Message())
+
+	_, err = restarted.GetByID(ctx, chid2)
+	require.True(t, errors.As(err, &notFound))
+	byPeer, err := restarted.ChannelsByPeer(peers[2])
+	require.NoError(t, err)
+	require.Empty(t, byPeer)
+	_, err = cidLists.ReadList(chid2)
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"github.com/ipfs/go-cid"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+//go:generate cbor-gen-for --map-encoding ExportHeader ExportRecord ExportedChannel ExportedCids ExportEnd
+
+// ExportMagic identifies a channel export stream
+const ExportMagic = "go-data-transfer channels"
+
+// ExportFormatVersion is the version of the layout of the export stream,
+// independent of the version of the channel states in it
+const ExportFormatVersion = 1
+
+// MaxExportedCids is the most CIDs in a single ExportedCids record. Longer
+// lists are split over several records, as CBOR arrays are limited in length.
+const MaxExportedCids = 4096
+
+// ExportHeader is the first value in an export stream
+type ExportHeader struct {
+	Magic         string
+	FormatVersion uint64
+	// StateVersion is the version of the encoded channel states in the stream
+	StateVersion string
+}
+
+// ExportRecord is a value following the header in an export stream. Exactly
+// one of its fields is set.
+//
+// Each channel starts with a Channel record, followed by any number of
+// ReceivedCids and SeenCids records for that channel. The stream ends with an
+// End record.
+type ExportRecord struct {
+	Channel      *ExportedChannel
+	ReceivedCids *ExportedCids
+	SeenCids     *ExportedCids
+	End          *ExportEnd
+}
+
+// ExportedChannel is the state of a channel
+type ExportedChannel struct {
+	// State is the encoded channel state, including its vouchers and voucher
+	// results
+	State *cbg.Deferred
+}
+
+// ExportedCids is part of a list of CIDs for the current channel
+type ExportedCids struct {
+	// Event is the event a seen CID set is for. It is empty for received
+	// CIDs.
+	Event string
+	Cids  []cid.Cid
+}
+
+// ExportEnd is the last record in an export stream, so that a truncated
+// stream can be told apart from a complete one
+type ExportEnd struct {
+	Channels uint64
+}
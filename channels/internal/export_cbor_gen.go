@@ -0,0 +1,674 @@
+// Code generated by github.com/whyrusleeping/cbor-gen. DO NOT EDIT.
+
+package internal
+
+import (
+	"fmt"
+	"io"
+
+	cid "github.com/ipfs/go-cid"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	xerrors "golang.org/x/xerrors"
+)
+
+var _ = xerrors.Errorf
+
+func (t *ExportHeader) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{163}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Magic (string) (string)
+	if len("Magic") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Magic\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Magic"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Magic")); err != nil {
+		return err
+	}
+
+	if len(t.Magic) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Magic was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Magic))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Magic)); err != nil {
+		return err
+	}
+
+	// t.FormatVersion (uint64) (uint64)
+	if len("FormatVersion") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"FormatVersion\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("FormatVersion"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("FormatVersion")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.FormatVersion)); err != nil {
+		return err
+	}
+
+	// t.StateVersion (string) (string)
+	if len("StateVersion") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"StateVersion\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("StateVersion"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("StateVersion")); err != nil {
+		return err
+	}
+
+	if len(t.StateVersion) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.StateVersion was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.StateVersion))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.StateVersion)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *ExportHeader) UnmarshalCBOR(r io.Reader) error {
+	*t = ExportHeader{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("ExportHeader: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Magic (string) (string)
+		case "Magic":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.Magic = string(sval)
+			}
+			// t.FormatVersion (uint64) (uint64)
+		case "FormatVersion":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.FormatVersion = uint64(extra)
+
+			}
+			// t.StateVersion (string) (string)
+		case "StateVersion":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.StateVersion = string(sval)
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *ExportRecord) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{164}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Channel (internal.ExportedChannel) (struct)
+	if len("Channel") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Channel\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Channel"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Channel")); err != nil {
+		return err
+	}
+
+	if err := t.Channel.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.ReceivedCids (internal.ExportedCids) (struct)
+	if len("ReceivedCids") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ReceivedCids\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("ReceivedCids"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ReceivedCids")); err != nil {
+		return err
+	}
+
+	if err := t.ReceivedCids.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.SeenCids (internal.ExportedCids) (struct)
+	if len("SeenCids") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"SeenCids\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("SeenCids"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("SeenCids")); err != nil {
+		return err
+	}
+
+	if err := t.SeenCids.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.End (internal.ExportEnd) (struct)
+	if len("End") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"End\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("End"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("End")); err != nil {
+		return err
+	}
+
+	if err := t.End.MarshalCBOR(w); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *ExportRecord) UnmarshalCBOR(r io.Reader) error {
+	*t = ExportRecord{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("ExportRecord: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Channel (internal.ExportedChannel) (struct)
+		case "Channel":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					t.Channel = new(ExportedChannel)
+					if err := t.Channel.UnmarshalCBOR(br); err != nil {
+						return xerrors.Errorf("unmarshaling t.Channel pointer: %w", err)
+					}
+				}
+
+			}
+			// t.ReceivedCids (internal.ExportedCids) (struct)
+		case "ReceivedCids":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					t.ReceivedCids = new(ExportedCids)
+					if err := t.ReceivedCids.UnmarshalCBOR(br); err != nil {
+						return xerrors.Errorf("unmarshaling t.ReceivedCids pointer: %w", err)
+					}
+				}
+
+			}
+			// t.SeenCids (internal.ExportedCids) (struct)
+		case "SeenCids":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					t.SeenCids = new(ExportedCids)
+					if err := t.SeenCids.UnmarshalCBOR(br); err != nil {
+						return xerrors.Errorf("unmarshaling t.SeenCids pointer: %w", err)
+					}
+				}
+
+			}
+			// t.End (internal.ExportEnd) (struct)
+		case "End":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					t.End = new(ExportEnd)
+					if err := t.End.UnmarshalCBOR(br); err != nil {
+						return xerrors.Errorf("unmarshaling t.End pointer: %w", err)
+					}
+				}
+
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *ExportedChannel) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{161}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.State (typegen.Deferred) (struct)
+	if len("State") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"State\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("State"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("State")); err != nil {
+		return err
+	}
+
+	if err := t.State.MarshalCBOR(w); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *ExportedChannel) UnmarshalCBOR(r io.Reader) error {
+	*t = ExportedChannel{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("ExportedChannel: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.State (typegen.Deferred) (struct)
+		case "State":
+
+			{
+
+				t.State = new(cbg.Deferred)
+
+				if err := t.State.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("failed to read deferred field: %w", err)
+				}
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *ExportedCids) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{162}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Event (string) (string)
+	if len("Event") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Event\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Event"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Event")); err != nil {
+		return err
+	}
+
+	if len(t.Event) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Event was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Event))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Event)); err != nil {
+		return err
+	}
+
+	// t.Cids ([]cid.Cid) (slice)
+	if len("Cids") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Cids\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Cids"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Cids")); err != nil {
+		return err
+	}
+
+	if len(t.Cids) > cbg.MaxLength {
+		return xerrors.Errorf("Slice value in field t.Cids was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajArray, uint64(len(t.Cids))); err != nil {
+		return err
+	}
+	for _, v := range t.Cids {
+		if err := cbg.WriteCidBuf(scratch, w, v); err != nil {
+			return xerrors.Errorf("failed writing cid field t.Cids: %w", err)
+		}
+	}
+	return nil
+}
+
+func (t *ExportedCids) UnmarshalCBOR(r io.Reader) error {
+	*t = ExportedCids{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("ExportedCids: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Event (string) (string)
+		case "Event":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.Event = string(sval)
+			}
+			// t.Cids ([]cid.Cid) (slice)
+		case "Cids":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.MaxLength {
+				return fmt.Errorf("t.Cids: array too large (%d)", extra)
+			}
+
+			if maj != cbg.MajArray {
+				return fmt.Errorf("expected cbor array")
+			}
+
+			if extra > 0 {
+				t.Cids = make([]cid.Cid, extra)
+			}
+
+			for i := 0; i < int(extra); i++ {
+
+				c, err := cbg.ReadCid(br)
+				if err != nil {
+					return xerrors.Errorf("reading cid field t.Cids failed: %w", err)
+				}
+				t.Cids[i] = c
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *ExportEnd) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{161}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Channels (uint64) (uint64)
+	if len("Channels") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Channels\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Channels"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Channels")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Channels)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *ExportEnd) UnmarshalCBOR(r io.Reader) error {
+	*t = ExportEnd{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("ExportEnd: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Channels (uint64) (uint64)
+		case "Channels":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Channels = uint64(extra)
+
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package internal
 
 import (
+	"time"
+
 	"github.com/ipfs/go-cid"
 	peer "github.com/libp2p/go-libp2p-core/peer"
 	cbg "github.com/whyrusleeping/cbor-gen"
@@ -8,7 +10,7 @@ import (
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 )
 
-//go:generate cbor-gen-for --map-encoding ChannelState EncodedVoucher EncodedVoucherResult
+//go:generate cbor-gen-for --map-encoding ChannelState EncodedVoucher EncodedVoucherResult Label
 
 // EncodedVoucher is how the voucher is stored on disk
 type EncodedVoucher struct {
@@ -26,6 +28,12 @@ type EncodedVoucherResult struct {
 	VoucherResult *cbg.Deferred
 }
 
+// Label is how a single user defined label is stored on disk
+type Label struct {
+	Key   string
+	Value string
+}
+
 // ChannelState is the internal representation on disk for the channel fsm
 type ChannelState struct {
 	// PeerId of the manager peer
@@ -58,4 +66,24 @@ type ChannelState struct {
 	Message        string
 	Vouchers       []EncodedVoucher
 	VoucherResults []EncodedVoucherResult
+	// how long the channel may stay stalled or disconnected before it is
+	// failed (zero means the manager default)
+	RemoveTimeout time.Duration
+	// user defined labels attached to this channel, sorted by key
+	Labels []Label
+	// the kind of error the channel failed with
+	ErrorCode datatransfer.ErrorCode
+	// whether restarting the channel may recover from the error
+	Retryable bool
+	// the reason given for rejecting or cancelling the transfer
+	ReasonCode uint64
+	ReasonText string
+	// the number of times the channel has been restarted automatically
+	RestartAttempts uint64
+	// the error from the last automatic restart attempt, if it failed
+	LastRestartError string
+	// the priority of the channel against other channels with the same peer
+	Priority int64
+	// addresses the other peer is known to be reachable at, in binary form
+	PeerAddrs [][]byte
 }
@@ -10,6 +10,7 @@ import (
 	peer "github.com/libp2p/go-libp2p-core/peer"
 	cbg "github.com/whyrusleeping/cbor-gen"
 	xerrors "golang.org/x/xerrors"
+	time "time"
 )
 
 var _ = xerrors.Errorf
@@ -19,7 +20,7 @@ func (t *ChannelState) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
-	if _, err := w.Write([]byte{176}); err != nil {
+	if _, err := w.Write([]byte{184, 26}); err != nil {
 		return err
 	}
 
@@ -340,6 +341,218 @@ func (t *ChannelState) MarshalCBOR(w io.Writer) error {
 			return err
 		}
 	}
+
+	// t.RemoveTimeout (time.Duration) (int64)
+	if len("RemoveTimeout") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"RemoveTimeout\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("RemoveTimeout"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("RemoveTimeout")); err != nil {
+		return err
+	}
+
+	if t.RemoveTimeout >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.RemoveTimeout)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.RemoveTimeout-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.Labels ([]internal.Label) (slice)
+	if len("Labels") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Labels\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Labels"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Labels")); err != nil {
+		return err
+	}
+
+	if len(t.Labels) > cbg.MaxLength {
+		return xerrors.Errorf("Slice value in field t.Labels was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajArray, uint64(len(t.Labels))); err != nil {
+		return err
+	}
+	for _, v := range t.Labels {
+		if err := v.MarshalCBOR(w); err != nil {
+			return err
+		}
+	}
+
+	// t.ErrorCode (datatransfer.ErrorCode) (uint64)
+	if len("ErrorCode") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ErrorCode\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("ErrorCode"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ErrorCode")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.ErrorCode)); err != nil {
+		return err
+	}
+
+	// t.Retryable (bool) (bool)
+	if len("Retryable") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Retryable\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Retryable"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Retryable")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteBool(w, t.Retryable); err != nil {
+		return err
+	}
+
+	// t.ReasonCode (uint64) (uint64)
+	if len("ReasonCode") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ReasonCode\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("ReasonCode"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ReasonCode")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.ReasonCode)); err != nil {
+		return err
+	}
+
+	// t.ReasonText (string) (string)
+	if len("ReasonText") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ReasonText\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("ReasonText"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ReasonText")); err != nil {
+		return err
+	}
+
+	if len(t.ReasonText) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.ReasonText was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.ReasonText))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.ReasonText)); err != nil {
+		return err
+	}
+
+	// t.RestartAttempts (uint64) (uint64)
+	if len("RestartAttempts") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"RestartAttempts\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("RestartAttempts"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("RestartAttempts")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.RestartAttempts)); err != nil {
+		return err
+	}
+
+	// t.LastRestartError (string) (string)
+	if len("LastRestartError") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"LastRestartError\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("LastRestartError"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("LastRestartError")); err != nil {
+		return err
+	}
+
+	if len(t.LastRestartError) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.LastRestartError was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.LastRestartError))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.LastRestartError)); err != nil {
+		return err
+	}
+
+	// t.Priority (int64) (int64)
+	if len("Priority") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Priority\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Priority"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Priority")); err != nil {
+		return err
+	}
+
+	if t.Priority >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Priority)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.Priority-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.PeerAddrs ([][]uint8) (slice)
+	if len("PeerAddrs") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PeerAddrs\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("PeerAddrs"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PeerAddrs")); err != nil {
+		return err
+	}
+
+	if len(t.PeerAddrs) > cbg.MaxLength {
+		return xerrors.Errorf("Slice value in field t.PeerAddrs was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajArray, uint64(len(t.PeerAddrs))); err != nil {
+		return err
+	}
+	for _, v := range t.PeerAddrs {
+		if len(v) > cbg.ByteArrayMaxLen {
+			return xerrors.Errorf("Byte array in field v was too long")
+		}
+
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajByteString, uint64(len(v))); err != nil {
+			return err
+		}
+
+		if _, err := w.Write(v[:]); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -616,6 +829,221 @@ func (t *ChannelState) UnmarshalCBOR(r io.Reader) error {
 				t.VoucherResults[i] = v
 			}
 
+			// t.RemoveTimeout (time.Duration) (int64)
+		case "RemoveTimeout":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.RemoveTimeout = time.Duration(extraI)
+			}
+			// t.Labels ([]internal.Label) (slice)
+		case "Labels":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.MaxLength {
+				return fmt.Errorf("t.Labels: array too large (%d)", extra)
+			}
+
+			if maj != cbg.MajArray {
+				return fmt.Errorf("expected cbor array")
+			}
+
+			if extra > 0 {
+				t.Labels = make([]Label, extra)
+			}
+
+			for i := 0; i < int(extra); i++ {
+
+				var v Label
+				if err := v.UnmarshalCBOR(br); err != nil {
+					return err
+				}
+
+				t.Labels[i] = v
+			}
+
+			// t.ErrorCode (datatransfer.ErrorCode) (uint64)
+		case "ErrorCode":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.ErrorCode = datatransfer.ErrorCode(extra)
+
+			}
+			// t.Retryable (bool) (bool)
+		case "Retryable":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.Retryable = false
+			case 21:
+				t.Retryable = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
+			// t.ReasonCode (uint64) (uint64)
+		case "ReasonCode":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.ReasonCode = uint64(extra)
+
+			}
+			// t.ReasonText (string) (string)
+		case "ReasonText":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.ReasonText = string(sval)
+			}
+			// t.RestartAttempts (uint64) (uint64)
+		case "RestartAttempts":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.RestartAttempts = uint64(extra)
+
+			}
+			// t.LastRestartError (string) (string)
+		case "LastRestartError":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.LastRestartError = string(sval)
+			}
+			// t.Priority (int64) (int64)
+		case "Priority":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.Priority = int64(extraI)
+			}
+			// t.PeerAddrs ([][]uint8) (slice)
+		case "PeerAddrs":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.MaxLength {
+				return fmt.Errorf("t.PeerAddrs: array too large (%d)", extra)
+			}
+
+			if maj != cbg.MajArray {
+				return fmt.Errorf("expected cbor array")
+			}
+
+			if extra > 0 {
+				t.PeerAddrs = make([][]uint8, extra)
+			}
+
+			for i := 0; i < int(extra); i++ {
+				{
+					var maj byte
+					var extra uint64
+					var err error
+
+					maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+					if err != nil {
+						return err
+					}
+
+					if extra > cbg.ByteArrayMaxLen {
+						return fmt.Errorf("t.PeerAddrs[i]: byte array too large (%d)", extra)
+					}
+					if maj != cbg.MajByteString {
+						return fmt.Errorf("expected byte array")
+					}
+
+					if extra > 0 {
+						t.PeerAddrs[i] = make([]uint8, extra)
+					}
+
+					if _, err := io.ReadFull(br, t.PeerAddrs[i][:]); err != nil {
+						return err
+					}
+				}
+			}
+
 		default:
 			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
 		}
@@ -853,3 +1281,125 @@ func (t *EncodedVoucherResult) UnmarshalCBOR(r io.Reader) error {
 
 	return nil
 }
+func (t *Label) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{162}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Key (string) (string)
+	if len("Key") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Key\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Key"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Key")); err != nil {
+		return err
+	}
+
+	if len(t.Key) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Key was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Key))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Key)); err != nil {
+		return err
+	}
+
+	// t.Value (string) (string)
+	if len("Value") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Value\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Value"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Value")); err != nil {
+		return err
+	}
+
+	if len(t.Value) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Value was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Value))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Value)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *Label) UnmarshalCBOR(r io.Reader) error {
+	*t = Label{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("Label: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Key (string) (string)
+		case "Key":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.Key = string(sval)
+			}
+			// t.Value (string) (string)
+		case "Value":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.Value = string(sval)
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/filecoin-project/go-data-transfer/channels/internal"
 	v0 "github.com/filecoin-project/go-data-transfer/channels/internal/migrations/v0"
 	v1 "github.com/filecoin-project/go-data-transfer/channels/internal/migrations/v1"
+	v2 "github.com/filecoin-project/go-data-transfer/channels/internal/migrations/v2"
 	"github.com/filecoin-project/go-data-transfer/cidlists"
 )
 
@@ -62,13 +63,13 @@ func GetMigrateChannelState0To1(selfPeer peer.ID) func(*v0.ChannelState) (*v1.Ch
 }
 
 // GetMigrateChannelState1To2 returns a conversion function for migrating v1 channel state to v2 channel state
-func GetMigrateChannelState1To2(cidLists cidlists.CIDLists) func(*v1.ChannelState) (*internal.ChannelState, error) {
-	return func(oldCs *v1.ChannelState) (*internal.ChannelState, error) {
+func GetMigrateChannelState1To2(cidLists cidlists.CIDLists) func(*v1.ChannelState) (*v2.ChannelState, error) {
+	return func(oldCs *v1.ChannelState) (*v2.ChannelState, error) {
 		err := cidLists.CreateList(datatransfer.ChannelID{ID: oldCs.TransferID, Initiator: oldCs.Initiator, Responder: oldCs.Responder}, oldCs.ReceivedCids)
 		if err != nil {
 			return nil, err
 		}
-		return &internal.ChannelState{
+		return &v2.ChannelState{
 			SelfPeer:       oldCs.SelfPeer,
 			TransferID:     oldCs.TransferID,
 			Initiator:      oldCs.Initiator,
@@ -88,6 +89,50 @@ func GetMigrateChannelState1To2(cidLists cidlists.CIDLists) func(*v1.ChannelStat
 	}
 }
 
+// MigrateChannelState2To3 migrates v2 channel state to v3 channel state,
+// which adds the channel remove timeout, user defined labels, the error code
+// and retryability of failed channels, the reason a transfer was rejected or
+// cancelled, and automatic restart attempts
+func MigrateChannelState2To3(oldCs *v2.ChannelState) (*internal.ChannelState, error) {
+	errorCode := datatransfer.NoError
+	if oldCs.Status == datatransfer.Failing || oldCs.Status == datatransfer.Failed {
+		errorCode = errorCodeForMessage(oldCs.Message)
+	}
+	return &internal.ChannelState{
+		SelfPeer:       oldCs.SelfPeer,
+		TransferID:     oldCs.TransferID,
+		Initiator:      oldCs.Initiator,
+		Responder:      oldCs.Responder,
+		BaseCid:        oldCs.BaseCid,
+		Selector:       oldCs.Selector,
+		Sender:         oldCs.Sender,
+		Recipient:      oldCs.Recipient,
+		TotalSize:      oldCs.TotalSize,
+		Status:         oldCs.Status,
+		Queued:         oldCs.Queued,
+		Sent:           oldCs.Sent,
+		Received:       oldCs.Received,
+		Message:        oldCs.Message,
+		Vouchers:       oldCs.Vouchers,
+		VoucherResults: oldCs.VoucherResults,
+		ErrorCode:      errorCode,
+		Retryable:      errorCode.Retryable(),
+	}, nil
+}
+
+// errorCodeForMessage recovers the error code of a channel that failed before
+// error codes were recorded, from the error message
+func errorCodeForMessage(message string) datatransfer.ErrorCode {
+	switch message {
+	case datatransfer.ErrRejected.Error():
+		return datatransfer.ErrorRejected
+	case datatransfer.ErrRemoved.Error():
+		return datatransfer.ErrorRemoved
+	default:
+		return datatransfer.ErrorUnknown
+	}
+}
+
 // GetChannelStateMigrations returns a migration list for the channel states
 func GetChannelStateMigrations(selfPeer peer.ID, cidLists cidlists.CIDLists) (versioning.VersionedMigrationList, error) {
 	channelStateMigration0To1 := GetMigrateChannelState0To1(selfPeer)
@@ -95,5 +140,6 @@ func GetChannelStateMigrations(selfPeer peer.ID, cidLists cidlists.CIDLists) (ve
 	return versioned.BuilderList{
 		versioned.NewVersionedBuilder(channelStateMigration0To1, versioning.VersionKey("1")),
 		versioned.NewVersionedBuilder(channelStateMigration1To2, versioning.VersionKey("2")).OldVersion("1"),
+		versioned.NewVersionedBuilder(MigrateChannelState2To3, versioning.VersionKey("3")).OldVersion("2"),
 	}.Build()
 }
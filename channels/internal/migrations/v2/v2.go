@@ -0,0 +1,46 @@
+package v2
+
+import (
+	"github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/channels/internal"
+)
+
+//go:generate cbor-gen-for --map-encoding ChannelState
+
+// ChannelState is version 2 of ChannelState
+type ChannelState struct {
+	// PeerId of the manager peer
+	SelfPeer peer.ID
+	// an identifier for this channel shared by request and responder, set by requester through protocol
+	TransferID datatransfer.TransferID
+	// Initiator is the person who intiated this datatransfer request
+	Initiator peer.ID
+	// Responder is the person who is responding to this datatransfer request
+	Responder peer.ID
+	// base CID for the piece being transferred
+	BaseCid cid.Cid
+	// portion of Piece to return, specified by an IPLD selector
+	Selector *cbg.Deferred
+	// the party that is sending the data (not who initiated the request)
+	Sender peer.ID
+	// the party that is receiving the data (not who initiated the request)
+	Recipient peer.ID
+	// expected amount of data to be transferred
+	TotalSize uint64
+	// current status of this deal
+	Status datatransfer.Status
+	// total bytes read from this node and queued for sending (0 if receiver)
+	Queued uint64
+	// total bytes sent from this node (0 if receiver)
+	Sent uint64
+	// total bytes received by this node (0 if sender)
+	Received uint64
+	// more informative status on a channel
+	Message        string
+	Vouchers       []internal.EncodedVoucher
+	VoucherResults []internal.EncodedVoucherResult
+}
@@ -0,0 +1,151 @@
+package channels
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+)
+
+// jsonChannelState is the JSON schema of a channel state
+type jsonChannelState struct {
+	ChannelID        datatransfer.JSONChannelID `json:"channelID"`
+	Status           string                     `json:"status"`
+	IsPull           bool                       `json:"isPull"`
+	BaseCid          cid.Cid                    `json:"baseCid"`
+	Selector         json.RawMessage            `json:"selector,omitempty"`
+	Sender           peer.ID                    `json:"sender"`
+	Recipient        peer.ID                    `json:"recipient"`
+	SelfPeer         peer.ID                    `json:"selfPeer"`
+	TotalSize        uint64                     `json:"totalSize"`
+	Queued           uint64                     `json:"queued"`
+	Sent             uint64                     `json:"sent"`
+	Received         uint64                     `json:"received"`
+	ReceivedCidsLen  int                        `json:"receivedCidsLen"`
+	Message          string                     `json:"message"`
+	RemoveTimeout    string                     `json:"removeTimeout"`
+	Labels           map[string]string          `json:"labels"`
+	Priority         int64                      `json:"priority"`
+	PeerAddrs        []string                   `json:"peerAddrs"`
+	ErrorCode        string                     `json:"errorCode"`
+	Retryable        bool                       `json:"retryable"`
+	Reason           jsonReason                 `json:"reason"`
+	RestartAttempts  uint64                     `json:"restartAttempts"`
+	LastRestartError string                     `json:"lastRestartError"`
+	Vouchers         []jsonEncodedValue         `json:"vouchers"`
+	VoucherResults   []jsonEncodedValue         `json:"voucherResults"`
+}
+
+// jsonReason is the JSON schema of the reason a channel was closed
+type jsonReason struct {
+	Code uint64 `json:"code"`
+	Text string `json:"text"`
+}
+
+// jsonEncodedValue is a voucher or voucher result. It has JSON if its type
+// has a JSON encoder, and base64 CBOR otherwise.
+type jsonEncodedValue struct {
+	Type datatransfer.TypeIdentifier `json:"type"`
+	JSON json.RawMessage             `json:"json,omitempty"`
+	CBOR []byte                      `json:"cbor,omitempty"`
+}
+
+// MarshalJSON encodes the channel state with a stable schema, for APIs that
+// expose transfers to a UI
+func (c channelState) MarshalJSON() ([]byte, error) {
+	state := jsonChannelState{
+		ChannelID:        datatransfer.NewJSONChannelID(c.ChannelID()),
+		Status:           datatransfer.StatusName(c.status),
+		IsPull:           c.isPull,
+		BaseCid:          c.baseCid,
+		Sender:           c.sender,
+		Recipient:        c.recipient,
+		SelfPeer:         c.selfPeer,
+		TotalSize:        c.totalSize,
+		Queued:           c.queued,
+		Sent:             c.sent,
+		Received:         c.received,
+		ReceivedCidsLen:  c.ReceivedCidsLen(),
+		Message:          c.message,
+		RemoveTimeout:    c.removeTimeout.String(),
+		Labels:           c.Labels(),
+		Priority:         c.priority,
+		PeerAddrs:        make([]string, 0, len(c.peerAddrs)),
+		ErrorCode:        datatransfer.ErrorCodeName(c.errorCode),
+		Retryable:        c.retryable,
+		Reason:           jsonReason{Code: c.reason.Code, Text: c.reason.Text},
+		RestartAttempts:  c.restartAttempts,
+		LastRestartError: c.lastRestartError,
+		Vouchers:         make([]jsonEncodedValue, 0, len(c.vouchers)),
+		VoucherResults:   make([]jsonEncodedValue, 0, len(c.voucherResults)),
+	}
+	for _, addr := range c.PeerAddrs() {
+		state.PeerAddrs = append(state.PeerAddrs, addr.String())
+	}
+	if c.selector != nil {
+		state.Selector = selectorJSON(c.selector.Raw)
+	}
+	for _, voucher := range c.vouchers {
+		state.Vouchers = append(state.Vouchers, encodeValueJSON(voucher.Type, voucher.Voucher, c.voucherDecoder, c.voucherJSON))
+	}
+	for _, result := range c.voucherResults {
+		state.VoucherResults = append(state.VoucherResults, encodeValueJSON(result.Type, result.VoucherResult, c.voucherResultDecoder, c.voucherResultJSON))
+	}
+	return json.Marshal(state)
+}
+
+// selectorJSON converts a dag-cbor selector to dag-json, or returns nil if
+// it can't be converted
+func selectorJSON(raw []byte) json.RawMessage {
+	builder := basicnode.Prototype.Any.NewBuilder()
+	if err := dagcbor.Decoder(builder, bytes.NewReader(raw)); err != nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := dagjson.Encoder(builder.Build(), &buf); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// encodeValueJSON renders a voucher or voucher result with the JSON encoder
+// for its type, falling back to its raw CBOR if it has no encoder or can't
+// be decoded
+func encodeValueJSON(identifier datatransfer.TypeIdentifier, encoded *cbg.Deferred, decoderByType DecoderByTypeFunc, jsonByType JSONEncoderByTypeFunc) jsonEncodedValue {
+	value := jsonEncodedValue{Type: identifier}
+	if encoded == nil {
+		return value
+	}
+	value.CBOR = encoded.Raw
+	if decoderByType == nil || jsonByType == nil {
+		return value
+	}
+	encoder, ok := jsonByType(identifier)
+	if !ok {
+		return value
+	}
+	decoder, ok := decoderByType(identifier)
+	if !ok {
+		return value
+	}
+	decoded, err := decoder.DecodeFromCbor(encoded.Raw)
+	if err != nil {
+		log.Warnf("decoding %s for json: %s", identifier, err)
+		return value
+	}
+	encodedJSON, err := encoder(decoded)
+	if err != nil || !json.Valid(encodedJSON) {
+		log.Warnf("encoding %s as json: %v", identifier, err)
+		return value
+	}
+	value.JSON = encodedJSON
+	value.CBOR = nil
+	return value
+}
@@ -0,0 +1,123 @@
+package channels_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/channels"
+	"github.com/filecoin-project/go-data-transfer/cidlists"
+	"github.com/filecoin-project/go-data-transfer/encoding"
+	"github.com/filecoin-project/go-data-transfer/testutil"
+)
+
+func TestChannelStateJSON(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	cidLists, err := cidlists.NewCIDLists(tempDir(t))
+	require.NoError(t, err)
+	peers := testutil.GenerateValidPeers(t, 2)
+	baseCid := testutil.GenerateCids(1)[0]
+	selector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+
+	// vouchers have a JSON encoder, voucher results don't
+	voucherJSON := func(identifier datatransfer.TypeIdentifier) (datatransfer.JSONEncoder, bool) {
+		return func(encodable encoding.Encodable) ([]byte, error) {
+			return json.Marshal(map[string]string{"data": encodable.(*testutil.FakeDTType).Data})
+		}, true
+	}
+	noJSON := func(identifier datatransfer.TypeIdentifier) (datatransfer.JSONEncoder, bool) {
+		return nil, false
+	}
+	channelList, err := channels.New(ds, cidLists, func(datatransfer.Event, datatransfer.ChannelState) {}, decoderByType, decoderByType, &fakeEnv{}, peers[0],
+		channels.JSONEncoders(voucherJSON, noJSON))
+	require.NoError(t, err)
+	require.NoError(t, channelList.Start(ctx))
+
+	chid, err := channelList.CreateNew(peers[0], 7, baseCid, selector, &testutil.FakeDTType{Data: "voucher"}, peers[0], peers[0], peers[1], datatransfer.ChannelOptions{
+		Labels: map[string]string{"deal": "1"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, channelList.NewVoucherResult(chid, &testutil.FakeDTType{Data: "result"}))
+	chst, err := channelList.GetByID(ctx, chid)
+	require.NoError(t, err)
+
+	encoded, err := json.Marshal(chst)
+	require.NoError(t, err)
+
+	var decoded struct {
+		ChannelID datatransfer.JSONChannelID
+		Status    string
+		ErrorCode string
+		BaseCid   map[string]string
+		Selector  map[string]interface{}
+		Labels    map[string]string
+		Vouchers  []struct {
+			Type datatransfer.TypeIdentifier
+			JSON map[string]string
+			CBOR []byte
+		}
+		VoucherResults []struct {
+			Type datatransfer.TypeIdentifier
+			JSON json.RawMessage
+			CBOR []byte
+		}
+	}
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	require.Equal(t, chid, decoded.ChannelID.ChannelID())
+	require.Equal(t, "Requested", decoded.Status)
+	require.Equal(t, "NoError", decoded.ErrorCode)
+	require.Equal(t, baseCid.String(), decoded.BaseCid["/"])
+	require.NotEmpty(t, decoded.Selector)
+	require.Equal(t, map[string]string{"deal": "1"}, decoded.Labels)
+	require.Len(t, decoded.Vouchers, 1)
+	require.Equal(t, map[string]string{"data": "voucher"}, decoded.Vouchers[0].JSON)
+	require.Nil(t, decoded.Vouchers[0].CBOR)
+	require.Len(t, decoded.VoucherResults, 1)
+	require.Nil(t, decoded.VoucherResults[0].JSON)
+	require.Equal(t, testutil.NewFakeDTType().Type(), decoded.VoucherResults[0].Type)
+	resultDecoder, _ := decoderByType(decoded.VoucherResults[0].Type)
+	decodedResult, err := resultDecoder.DecodeFromCbor(decoded.VoucherResults[0].CBOR)
+	require.NoError(t, err)
+	require.Equal(t, "result", decodedResult.(*testutil.FakeDTType).Data)
+
+	// the raw field names and values are the documented schema
+	var raw map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(encoded, &raw))
+	require.JSONEq(t, `"Requested"`, string(raw["status"]))
+	require.JSONEq(t, `"NoError"`, string(raw["errorCode"]))
+	require.JSONEq(t, `{"initiator":"`+peers[0].String()+`","responder":"`+peers[1].String()+`","id":7}`, string(raw["channelID"]))
+
+	t.Run("events", func(t *testing.T) {
+		evt := datatransfer.Event{Code: datatransfer.DataReceived, Message: "hello", Timestamp: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+		encoded, err := json.Marshal(datatransfer.NewJSONEvent(evt))
+		require.NoError(t, err)
+		require.JSONEq(t, `{"code":"DataReceived","message":"hello","timestamp":"2020-01-02T03:04:05Z"}`, string(encoded))
+
+		// unknown codes are written by number rather than failing the encoding
+		encoded, err = json.Marshal(datatransfer.NewJSONEvent(datatransfer.Event{Code: datatransfer.EventCode(-1), Timestamp: evt.Timestamp}))
+		require.NoError(t, err)
+		require.Contains(t, string(encoded), `"code":"EventCode(-1)"`)
+		require.Equal(t, "Status(1000)", datatransfer.StatusName(datatransfer.Status(1000)))
+	})
+
+	t.Run("existing encodings are unchanged", func(t *testing.T) {
+		encoded, err := json.Marshal(chid)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"Initiator":"`+peers[0].String()+`","Responder":"`+peers[1].String()+`","ID":7}`, string(encoded))
+		encoded, err = json.Marshal(datatransfer.Ongoing)
+		require.NoError(t, err)
+		require.JSONEq(t, fmt.Sprint(uint64(datatransfer.Ongoing)), string(encoded))
+	})
+}
@@ -0,0 +1,207 @@
+package channels
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/go-cid"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+)
+
+// Option configures the channel list
+type Option func(*Channels)
+
+// WithChannelStore sets the store that channel states and their indexes are
+// kept in. By default they are kept in the datastore passed to New.
+func WithChannelStore(store ChannelStore) Option {
+	return func(c *Channels) {
+		c.store = store
+	}
+}
+
+// JSONEncoders sets the JSON encoders that vouchers and voucher results are
+// rendered with when a channel state is encoded as JSON. Types without one
+// are rendered as base64 CBOR.
+func JSONEncoders(voucherJSON JSONEncoderByTypeFunc, voucherResultJSON JSONEncoderByTypeFunc) Option {
+	return func(c *Channels) {
+		c.voucherJSON = voucherJSON
+		c.voucherResultJSON = voucherResultJSON
+	}
+}
+
+// ProgressBatching makes the channel list accumulate progress in memory, and
+// update the channel state with it once per interval, or once maxBytes of
+// new data has been queued, sent or received, whichever comes first. Pending
+// progress is also applied before any other event on the channel, such as
+// completing or pausing it.
+//
+// Each update fires a single progress event (eg DataReceivedProgress) for all
+// the new blocks since the last update. If only blocks that were already seen
+// went by, it fires the plain event instead (eg DataReceived).
+//
+// Zero for both values (the default) updates the channel state for every new
+// block. Either way the blocks are written out as seen before the progress
+// that counts them, so a block is never counted twice, even after a crash.
+// With batching, progress that has not been applied yet is lost if the
+// process exits without stopping the channel list.
+func ProgressBatching(interval time.Duration, maxBytes uint64) Option {
+	return func(c *Channels) {
+		c.progressInterval = interval
+		c.progressMaxBytes = maxBytes
+	}
+}
+
+type progressKey struct {
+	chid datatransfer.ChannelID
+	evt  datatransfer.EventCode
+}
+
+// pendingProgress is progress that has not been applied to the channel state
+// yet
+type pendingProgress struct {
+	progressEvt datatransfer.EventCode
+	delta       uint64
+	timer       *time.Timer
+}
+
+func (c *Channels) batchingProgress() bool {
+	return c.progressInterval > 0 || c.progressMaxBytes > 0
+}
+
+// fireProgressEvent fires an event indicating progress has been made in
+// queuing / sending / receiving blocks.
+// Progress events are fired only for new blocks (not for example if
+// a block is resent)
+func (c *Channels) fireProgressEvent(chid datatransfer.ChannelID, evt datatransfer.EventCode, progressEvt datatransfer.EventCode, k cid.Cid, delta uint64) error {
+	if err := c.checkChannelExists(chid, evt); err != nil {
+		return err
+	}
+
+	// Check if the block has already been seen
+	seen, err := c.seenCIDs.InsertSetCID(seenCIDSetID(chid, evt), k)
+	if err != nil {
+		return err
+	}
+
+	if c.batchingProgress() {
+		if seen {
+			delta = 0
+		}
+		c.addProgress(chid, evt, progressEvt, delta)
+		return nil
+	}
+
+	// If the block has not been seen before, fire the progress event. The
+	// block is written out as seen first, so that after a restart it is
+	// never counted twice.
+	if !seen {
+		if err := c.seenCIDs.Flush(); err != nil {
+			return err
+		}
+		if err := c.sendEvent(chid, progressEvt, delta); err != nil {
+			return err
+		}
+	}
+
+	// Fire the regular event
+	return c.sendEvent(chid, evt)
+}
+
+// addProgress adds a block to the pending progress for the channel, and
+// applies it if enough new data has built up
+func (c *Channels) addProgress(chid datatransfer.ChannelID, evt datatransfer.EventCode, progressEvt datatransfer.EventCode, delta uint64) {
+	key := progressKey{chid, evt}
+
+	c.progressLk.Lock()
+	pending, ok := c.progress[key]
+	if !ok {
+		pending = &pendingProgress{progressEvt: progressEvt}
+		c.progress[key] = pending
+		if c.progressInterval > 0 {
+			pending.timer = time.AfterFunc(c.progressInterval, func() {
+				if err := c.flushProgressKey(key); err != nil {
+					log.Errorf("channel %s: applying %s: %s", chid, datatransfer.Events[progressEvt], err)
+				}
+			})
+		}
+	}
+	pending.delta += delta
+	full := c.progressMaxBytes > 0 && pending.delta >= c.progressMaxBytes
+	c.progressLk.Unlock()
+
+	if full {
+		if err := c.flushProgressKey(key); err != nil {
+			log.Errorf("channel %s: applying %s: %s", chid, datatransfer.Events[progressEvt], err)
+		}
+	}
+}
+
+// flushProgress applies all the pending progress for the channel
+func (c *Channels) flushProgress(chid datatransfer.ChannelID) error {
+	if !c.batchingProgress() {
+		return nil
+	}
+	for _, evt := range seenCIDEvents {
+		if err := c.flushProgressKey(progressKey{chid, evt}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushProgressKey applies the pending progress for one kind of event on a
+// channel, as a single state update
+func (c *Channels) flushProgressKey(key progressKey) error {
+	c.progressLk.Lock()
+	pending, ok := c.progress[key]
+	if ok {
+		delete(c.progress, key)
+		if pending.timer != nil {
+			pending.timer.Stop()
+		}
+	}
+	c.progressLk.Unlock()
+	if !ok {
+		return nil
+	}
+
+	// the channel may have been removed since the progress was added
+	if err := c.checkChannelExists(key.chid, pending.progressEvt); err != nil {
+		return err
+	}
+	if pending.delta > 0 {
+		// the seen blocks are written to the datastore before the progress,
+		// so that after a restart the blocks are never counted twice
+		if err := c.seenCIDs.Flush(); err != nil {
+			return err
+		}
+		return c.sendEvent(key.chid, pending.progressEvt, pending.delta)
+	}
+	return c.sendEvent(key.chid, key.evt)
+}
+
+// flushAllProgress applies the pending progress for every channel, and waits
+// for the updates to be written
+func (c *Channels) flushAllProgress(ctx context.Context) error {
+	c.progressLk.Lock()
+	keys := make([]progressKey, 0, len(c.progress))
+	for key := range c.progress {
+		keys = append(keys, key)
+	}
+	c.progressLk.Unlock()
+
+	flushed := make(map[datatransfer.ChannelID]struct{}, len(keys))
+	for _, key := range keys {
+		if err := c.flushProgressKey(key); err != nil {
+			return err
+		}
+		flushed[key.chid] = struct{}{}
+	}
+	for chid := range flushed {
+		if _, err := c.GetByID(ctx, chid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,427 @@
+package channels
+
+import (
+	"bytes"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/channels/internal"
+)
+
+// ChannelIndex is the set of values a channel is indexed by
+type ChannelIndex struct {
+	// Peers are the initiator and the responder of the channel
+	Peers        []peer.ID
+	Status       datatransfer.Status
+	BaseCid      cid.Cid
+	VoucherTypes []datatransfer.TypeIdentifier
+	Labels       map[string]string
+}
+
+// ChannelStore stores channel states, and keeps secondary indexes on them.
+//
+// The channel state machines read and write channel states through the store
+// as a datastore. Each time a channel state is written, PutChannel is called
+// instead of Put, so the store can update the channel's index entries in the
+// same atomic update as the state.
+type ChannelStore interface {
+	datastore.Batching
+
+	// PutChannel writes the state of a channel at the given key, and indexes
+	// the channel by the given values in place of the ones it was indexed by
+	// before. The state and the index entries must be updated atomically.
+	PutChannel(key datastore.Key, value []byte, chid datatransfer.ChannelID, index ChannelIndex) error
+	// DeleteChannel deletes the state of a channel at the given key, and all
+	// of the channel's index entries, atomically
+	DeleteChannel(key datastore.Key, chid datatransfer.ChannelID) error
+
+	// IndexesBuilt returns true if BuildIndexes has been called with the
+	// given channel state version
+	IndexesBuilt(version string) (bool, error)
+	// BuildIndexes replaces all index entries with entries for the given
+	// channels, and records that the indexes are built for the given version
+	BuildIndexes(version string, channels map[datatransfer.ChannelID]ChannelIndex) error
+
+	// ChannelsByPeer returns the channels whose initiator or responder is the
+	// given peer
+	ChannelsByPeer(p peer.ID) ([]datatransfer.ChannelID, error)
+	// ChannelsByStatus returns the channels with the given status
+	ChannelsByStatus(status datatransfer.Status) ([]datatransfer.ChannelID, error)
+	// ChannelsByBaseCID returns the channels for the given base CID
+	ChannelsByBaseCID(baseCid cid.Cid) ([]datatransfer.ChannelID, error)
+	// ChannelsByVoucherType returns the channels with a voucher of the given
+	// type
+	ChannelsByVoucherType(voucherType datatransfer.TypeIdentifier) ([]datatransfer.ChannelID, error)
+	// ChannelsByLabel returns the channels with the given label set to the
+	// given value
+	ChannelsByLabel(key string, value string) ([]datatransfer.ChannelID, error)
+}
+
+// Index entries are kept under the indexes namespace of the datastore:
+//
+//	/indexes/<field>/<value>/<channel id> -> CBOR encoded channel id
+//
+// The keys of a channel's entries are also recorded under
+//
+//	/indexes/channels/<channel id>
+//
+// so they can be removed when the channel's index values change.
+var (
+	indexesKey        = datastore.NewKey("indexes")
+	indexesBuiltKey   = indexesKey.ChildString("built")
+	channelEntriesKey = indexesKey.ChildString("channels")
+)
+
+const (
+	peerIndex        = "peer"
+	statusIndex      = "status"
+	baseCidIndex     = "basecid"
+	voucherTypeIndex = "vouchertype"
+	labelIndex       = "label"
+)
+
+// indexesVersion changes whenever an index is added, so that indexes built
+// for an earlier set of indexes are rebuilt on start
+const indexesVersion = "2"
+
+type channelStore struct {
+	datastore.Batching
+
+	// entries holds the index entry keys of channels that have not
+	// terminated, so most updates do not read them from the datastore
+	lk      sync.Mutex
+	entries map[datatransfer.ChannelID][]string
+}
+
+// NewChannelStore returns a channel store that keeps channel states and their
+// index entries in the given datastore. Updates are only atomic if the
+// datastore's batches are atomic.
+func NewChannelStore(ds datastore.Batching) ChannelStore {
+	return &channelStore{
+		Batching: ds,
+		entries:  make(map[datatransfer.ChannelID][]string),
+	}
+}
+
+// PutChannel writes the channel state and its index entries in one batch
+func (cs *channelStore) PutChannel(key datastore.Key, value []byte, chid datatransfer.ChannelID, index ChannelIndex) error {
+	cs.lk.Lock()
+	defer cs.lk.Unlock()
+
+	oldEntries, err := cs.channelEntries(chid)
+	if err != nil {
+		return err
+	}
+	newEntries := indexEntries(chid, index)
+
+	if equalEntries(oldEntries, newEntries) {
+		if err := cs.Batching.Put(key, value); err != nil {
+			return err
+		}
+	} else {
+		chidBytes, err := encodeChannelID(chid)
+		if err != nil {
+			return err
+		}
+		batch, err := cs.Batching.Batch()
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(key, value); err != nil {
+			return err
+		}
+		if err := writeEntries(batch, chid, chidBytes, oldEntries, newEntries); err != nil {
+			return err
+		}
+		if err := batch.Commit(); err != nil {
+			return err
+		}
+	}
+
+	if IsChannelTerminated(index.Status) {
+		delete(cs.entries, chid)
+	} else {
+		cs.entries[chid] = newEntries
+	}
+	return nil
+}
+
+// DeleteChannel deletes the channel state and its index entries in one batch
+func (cs *channelStore) DeleteChannel(key datastore.Key, chid datatransfer.ChannelID) error {
+	cs.lk.Lock()
+	defer cs.lk.Unlock()
+
+	entries, err := cs.channelEntries(chid)
+	if err != nil {
+		return err
+	}
+	batch, err := cs.Batching.Batch()
+	if err != nil {
+		return err
+	}
+	if err := batch.Delete(key); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := batch.Delete(datastore.NewKey(entry)); err != nil {
+			return err
+		}
+	}
+	if err := batch.Delete(channelEntriesKey.ChildString(chid.String())); err != nil {
+		return err
+	}
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	delete(cs.entries, chid)
+	return nil
+}
+
+// IndexesBuilt returns true if the indexes were built for the given version
+func (cs *channelStore) IndexesBuilt(version string) (bool, error) {
+	built, err := cs.Batching.Get(indexesBuiltKey)
+	if err == datastore.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return string(built) == version, nil
+}
+
+// BuildIndexes deletes all index entries and writes entries for the given
+// channels
+func (cs *channelStore) BuildIndexes(version string, channels map[datatransfer.ChannelID]ChannelIndex) error {
+	cs.lk.Lock()
+	defer cs.lk.Unlock()
+
+	res, err := cs.Batching.Query(query.Query{Prefix: indexesKey.String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	existing, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	batch, err := cs.Batching.Batch()
+	if err != nil {
+		return err
+	}
+	for _, entry := range existing {
+		if err := batch.Delete(datastore.NewKey(entry.Key)); err != nil {
+			return err
+		}
+	}
+	cs.entries = make(map[datatransfer.ChannelID][]string)
+	for chid, index := range channels {
+		chidBytes, err := encodeChannelID(chid)
+		if err != nil {
+			return err
+		}
+		entries := indexEntries(chid, index)
+		if err := writeEntries(batch, chid, chidBytes, nil, entries); err != nil {
+			return err
+		}
+	}
+	if err := batch.Put(indexesBuiltKey, []byte(version)); err != nil {
+		return err
+	}
+	return batch.Commit()
+}
+
+func (cs *channelStore) ChannelsByPeer(p peer.ID) ([]datatransfer.ChannelID, error) {
+	return cs.lookup(peerIndex, p.String())
+}
+
+func (cs *channelStore) ChannelsByStatus(status datatransfer.Status) ([]datatransfer.ChannelID, error) {
+	return cs.lookup(statusIndex, strconv.FormatUint(uint64(status), 10))
+}
+
+func (cs *channelStore) ChannelsByBaseCID(baseCid cid.Cid) ([]datatransfer.ChannelID, error) {
+	return cs.lookup(baseCidIndex, baseCid.String())
+}
+
+func (cs *channelStore) ChannelsByVoucherType(voucherType datatransfer.TypeIdentifier) ([]datatransfer.ChannelID, error) {
+	return cs.lookup(voucherTypeIndex, string(voucherType))
+}
+
+func (cs *channelStore) ChannelsByLabel(key string, value string) ([]datatransfer.ChannelID, error) {
+	return cs.lookup(labelIndex, labelIndexValue(key, value))
+}
+
+// lookup returns the channels with an index entry for the given value
+func (cs *channelStore) lookup(field string, value string) ([]datatransfer.ChannelID, error) {
+	res, err := cs.Batching.Query(query.Query{Prefix: indexValueKey(field, value).String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var chids []datatransfer.ChannelID
+	for entry := range res.Next() {
+		if entry.Error != nil {
+			return nil, entry.Error
+		}
+		var chid datatransfer.ChannelID
+		if err := chid.UnmarshalCBOR(bytes.NewReader(entry.Value)); err != nil {
+			return nil, err
+		}
+		chids = append(chids, chid)
+	}
+	return chids, nil
+}
+
+// channelEntries returns the keys of the channel's current index entries. It
+// must be called with the lock held.
+func (cs *channelStore) channelEntries(chid datatransfer.ChannelID) ([]string, error) {
+	if entries, ok := cs.entries[chid]; ok {
+		return entries, nil
+	}
+	recorded, err := cs.Batching.Get(channelEntriesKey.ChildString(chid.String()))
+	if err == datastore.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(recorded), "\n"), nil
+}
+
+// writeEntries adds the puts and deletes to the batch that replace a
+// channel's old index entries with its new ones
+func writeEntries(batch datastore.Batch, chid datatransfer.ChannelID, chidBytes []byte, oldEntries []string, newEntries []string) error {
+	keep := make(map[string]struct{}, len(newEntries))
+	for _, entry := range newEntries {
+		keep[entry] = struct{}{}
+	}
+	for _, entry := range oldEntries {
+		if _, ok := keep[entry]; !ok {
+			if err := batch.Delete(datastore.NewKey(entry)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, entry := range newEntries {
+		if err := batch.Put(datastore.NewKey(entry), chidBytes); err != nil {
+			return err
+		}
+	}
+	return batch.Put(channelEntriesKey.ChildString(chid.String()), []byte(strings.Join(newEntries, "\n")))
+}
+
+// indexEntries returns the sorted keys of the index entries for a channel
+func indexEntries(chid datatransfer.ChannelID, index ChannelIndex) []string {
+	name := chid.String()
+	var entries []string
+	add := func(field string, value string) {
+		entries = append(entries, indexValueKey(field, value).ChildString(name).String())
+	}
+	for _, p := range index.Peers {
+		add(peerIndex, p.String())
+	}
+	add(statusIndex, strconv.FormatUint(uint64(index.Status), 10))
+	if index.BaseCid.Defined() {
+		add(baseCidIndex, index.BaseCid.String())
+	}
+	for _, voucherType := range index.VoucherTypes {
+		add(voucherTypeIndex, string(voucherType))
+	}
+	for key, value := range index.Labels {
+		add(labelIndex, labelIndexValue(key, value))
+	}
+	sort.Strings(entries)
+	// drop duplicates, such as a voucher type used more than once
+	deduped := entries[:0]
+	for i, entry := range entries {
+		if i == 0 || entry != entries[i-1] {
+			deduped = append(deduped, entry)
+		}
+	}
+	return deduped
+}
+
+// indexValueKey is the prefix of the index entries for a value. The value is
+// escaped, as voucher types may contain slashes.
+func indexValueKey(field string, value string) datastore.Key {
+	return indexesKey.ChildString(field).ChildString(url.PathEscape(value))
+}
+
+// labelIndexValue is the value a label is indexed by. The key is escaped so
+// that it cannot contain the separator.
+func labelIndexValue(key string, value string) string {
+	return url.QueryEscape(key) + "=" + value
+}
+
+func equalEntries(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func encodeChannelID(chid datatransfer.ChannelID) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := chid.MarshalCBOR(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// channelIndex returns the values a channel state is indexed by
+func channelIndex(state internal.ChannelState) ChannelIndex {
+	voucherTypes := make([]datatransfer.TypeIdentifier, 0, len(state.Vouchers))
+	for _, voucher := range state.Vouchers {
+		voucherTypes = append(voucherTypes, voucher.Type)
+	}
+	labels := make(map[string]string, len(state.Labels))
+	for _, label := range state.Labels {
+		labels[label.Key] = label.Value
+	}
+	return ChannelIndex{
+		Peers:        []peer.ID{state.Initiator, state.Responder},
+		Status:       state.Status,
+		BaseCid:      state.BaseCid,
+		VoucherTypes: voucherTypes,
+		Labels:       labels,
+	}
+}
+
+// stateDatastore is the datastore the channel state machines use. Channel
+// states written under prefix are indexed in the channel store and passed to
+// the state cache.
+type stateDatastore struct {
+	ChannelStore
+	prefix datastore.Key
+	cache  *stateCache
+}
+
+func (sds *stateDatastore) Put(key datastore.Key, value []byte) error {
+	if !sds.prefix.IsAncestorOf(key) {
+		return sds.ChannelStore.Put(key, value)
+	}
+	var state internal.ChannelState
+	if err := state.UnmarshalCBOR(bytes.NewReader(value)); err != nil {
+		log.Warnf("decoding channel state written to %s: %s", key, err)
+		return sds.ChannelStore.Put(key, value)
+	}
+	chid := datatransfer.ChannelID{Initiator: state.Initiator, Responder: state.Responder, ID: state.TransferID}
+	if err := sds.ChannelStore.PutChannel(key, value, chid, channelIndex(state)); err != nil {
+		return err
+	}
+	sds.cache.written(state)
+	return nil
+}
@@ -0,0 +1,91 @@
+package cidlists
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/testutil"
+)
+
+// failingFile writes half of what it is given and then fails, like a disk
+// filling up part way through a write
+type failingFile struct {
+	*os.File
+	truncateErr error
+}
+
+func (f failingFile) Write(p []byte) (int, error) {
+	n, _ := f.File.Write(p[:len(p)/2])
+	return n, errors.New("no space left on device")
+}
+
+func (f failingFile) Truncate(size int64) error {
+	if f.truncateErr != nil {
+		return f.truncateErr
+	}
+	return f.File.Truncate(size)
+}
+
+func TestAppendListFailedWrite(t *testing.T) {
+	testCases := map[string]struct {
+		truncateErr error
+	}{
+		"partial record is cut off": {},
+		"partial record is recovered when it can't be cut off": {
+			truncateErr: errors.New("read-only file system"),
+		},
+	}
+	for testCase, data := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			baseDir, err := ioutil.TempDir("", "cidlisttest")
+			require.NoError(t, err)
+			defer os.RemoveAll(baseDir)
+
+			chid := datatransfer.ChannelID{ID: datatransfer.TransferID(rand.Uint64()), Initiator: testutil.GeneratePeers(1)[0], Responder: testutil.GeneratePeers(1)[0]}
+			cids := testutil.GenerateCids(4)
+
+			cl, err := NewCIDLists(baseDir)
+			require.NoError(t, err)
+			require.NoError(t, cl.CreateList(chid, cids[:2]))
+
+			impl := cl.(*cidLists)
+			impl.openAppend = func(path string) (appendFile, error) {
+				f, err := openAppend(path)
+				if err != nil {
+					return nil, err
+				}
+				return failingFile{File: f.(*os.File), truncateErr: data.truncateErr}, nil
+			}
+			require.Error(t, cl.AppendList(chid, cids[2]))
+
+			// the failed append is not counted
+			length, err := cl.ListLen(chid)
+			require.NoError(t, err)
+			require.Equal(t, 2, length)
+			received, err := cl.ReadList(chid)
+			require.NoError(t, err)
+			require.Equal(t, cids[:2], received)
+
+			// later appends follow the last whole record
+			impl.openAppend = openAppend
+			require.NoError(t, cl.AppendList(chid, cids[3]))
+			received, err = cl.ReadList(chid)
+			require.NoError(t, err)
+			require.Equal(t, []cid.Cid{cids[0], cids[1], cids[3]}, received)
+
+			// and the list reads back the same when it is reopened
+			reopened, err := NewCIDLists(baseDir)
+			require.NoError(t, err)
+			received, err = reopened.ReadList(chid)
+			require.NoError(t, err)
+			require.Equal(t, []cid.Cid{cids[0], cids[1], cids[3]}, received)
+		})
+	}
+}
@@ -1,31 +1,117 @@
 package cidlists
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
 	cbg "github.com/whyrusleeping/cbor-gen"
 
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 )
 
+var log = logging.Logger("dt-cidlists")
+
 // CIDLists maintains files that contain a list of CIDs received for different data transfers
 type CIDLists interface {
 	CreateList(chid datatransfer.ChannelID, initalCids []cid.Cid) error
 	AppendList(chid datatransfer.ChannelID, c cid.Cid) error
 	ReadList(chid datatransfer.ChannelID) ([]cid.Cid, error)
+	// IterateList calls f with each CID in the list in order, without loading
+	// the whole list into memory. It stops at the first error f returns.
+	IterateList(chid datatransfer.ChannelID, f func(cid.Cid) error) error
+	// ListLen returns the number of CIDs in the list
+	ListLen(chid datatransfer.ChannelID) (int, error)
 	DeleteList(chid datatransfer.ChannelID) error
 }
 
+// Each list is an append-only log that starts with a header, followed by one
+// record per CID:
+//
+//	uvarint length | CID bytes | CRC-32C of the CID bytes (big endian)
+//
+// A crash in the middle of an append can leave a partial record at the end
+// of the log. It is cut off the first time the list is opened. A bad record
+// anywhere else in the log means the list is corrupt.
+// Lists written by earlier versions are plain concatenated CIDs with no
+// header; they are converted to the log format when first opened.
+var logHeader = []byte("dtcids\x00\x01")
+
+// maxCidLength bounds the length read from a record, so a corrupt length
+// does not cause a huge allocation
+const maxCidLength = 4096
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errPartialRecord means the last record in the log is incomplete or fails
+// its checksum, as a torn write at the end of the log would leave it
+var errPartialRecord = errors.New("partial record")
+
+// ErrCorrupt is returned when a record that is not at the end of a list is
+// bad, so the list cannot be recovered by cutting off its end
+var ErrCorrupt = errors.New("cid list is corrupt")
+
+// ErrReadOnly is returned when writing to lists that were opened read only
+var ErrReadOnly = errors.New("cid lists are read only")
+
+// Option configures CID lists
+type Option func(*cidLists)
+
+// ReadOnly opens the lists without ever writing to them. A partial record at
+// the end of a list is skipped rather than cut off, and lists written by
+// earlier versions cannot be read, as they must be converted first.
+func ReadOnly() Option {
+	return func(cl *cidLists) {
+		cl.readOnly = true
+	}
+}
+
 type cidLists struct {
-	baseDir string
+	baseDir  string
+	readOnly bool
+	// openAppend opens a list file for appending
+	openAppend func(path string) (appendFile, error)
+
+	lk    sync.Mutex
+	lists map[datatransfer.ChannelID]*cidList
+}
+
+// cidList tracks an open list. Appends to a list are serialized by its lock.
+type cidList struct {
+	lk    sync.Mutex
+	count int
+	// needsRecovery is set when a failed append could not be rolled back, so
+	// the partial record it left is cut off before the next append
+	needsRecovery bool
+}
+
+// appendFile is a list file opened for appending
+type appendFile interface {
+	io.Writer
+	Stat() (os.FileInfo, error)
+	Truncate(size int64) error
+	Close() error
+}
+
+func openAppend(path string) (appendFile, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
 // NewCIDLists initializes a new set of cid lists in a given directory
-func NewCIDLists(baseDir string) (CIDLists, error) {
+func NewCIDLists(baseDir string, options ...Option) (CIDLists, error) {
 	base := filepath.Clean(string(baseDir))
 	info, err := os.Stat(string(base))
 	if err != nil {
@@ -34,14 +120,58 @@ func NewCIDLists(baseDir string) (CIDLists, error) {
 	if !info.IsDir() {
 		return nil, fmt.Errorf("%s is not a directory", base)
 	}
-	return &cidLists{
-		baseDir: base,
-	}, nil
+	cl := &cidLists{
+		baseDir:    base,
+		openAppend: openAppend,
+		lists:      make(map[datatransfer.ChannelID]*cidList),
+	}
+	for _, option := range options {
+		option(cl)
+	}
+	return cl, nil
 }
 
 // CreateList initializes a new CID list with the given initial cids (or can be empty) for a data transfer channel
-func (cl *cidLists) CreateList(chid datatransfer.ChannelID, initialCids []cid.Cid) (err error) {
-	f, err := os.Create(transferFilename(cl.baseDir, chid))
+func (cl *cidLists) CreateList(chid datatransfer.ChannelID, initialCids []cid.Cid) error {
+	if cl.readOnly {
+		return ErrReadOnly
+	}
+	cl.lk.Lock()
+	defer cl.lk.Unlock()
+
+	if err := writeLog(transferFilename(cl.baseDir, chid), initialCids); err != nil {
+		return err
+	}
+	cl.lists[chid] = &cidList{count: len(initialCids)}
+	return nil
+}
+
+// AppendList appends a single CID to the list for a given data transfer channel
+func (cl *cidLists) AppendList(chid datatransfer.ChannelID, c cid.Cid) (err error) {
+	if cl.readOnly {
+		return ErrReadOnly
+	}
+	l, created, err := cl.openOrCreate(chid, c)
+	if err != nil {
+		return err
+	}
+	if created {
+		return nil
+	}
+
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	path := transferFilename(cl.baseDir, chid)
+	if l.needsRecovery {
+		count, err := recoverLog(path, false)
+		if err != nil {
+			return err
+		}
+		l.count = count
+		l.needsRecovery = false
+	}
+	f, err := cl.openAppend(path)
 	if err != nil {
 		return err
 	}
@@ -51,58 +181,378 @@ func (cl *cidLists) CreateList(chid datatransfer.ChannelID, initialCids []cid.Ci
 			err = closeErr
 		}
 	}()
-	for _, c := range initialCids {
-		err := cbg.WriteCid(f, c)
-		if err != nil {
-			return err
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// write the whole record at once, so a crash leaves at most one partial
+	// record at the end of the log
+	record := encodeRecord(c)
+	n, err := f.Write(record)
+	if err == nil && n < len(record) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		// cut off what was written of the record, so that later appends
+		// don't follow a partial record
+		if truncateErr := f.Truncate(info.Size()); truncateErr != nil {
+			log.Warnf("failed to cut off partial record at offset %d of cid list %s: %s", info.Size(), path, truncateErr)
+			l.needsRecovery = true
 		}
+		return err
 	}
+	l.count++
 	return nil
 }
 
-// AppendList appends a single CID to the list for a given data transfer channel
-func (cl *cidLists) AppendList(chid datatransfer.ChannelID, c cid.Cid) (err error) {
-	f, err := os.OpenFile(transferFilename(cl.baseDir, chid), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
+// ReadList reads an on disk list of cids for the given data transfer channel
+func (cl *cidLists) ReadList(chid datatransfer.ChannelID) ([]cid.Cid, error) {
+	l, err := cl.open(chid)
+	if err != nil {
+		return nil, err
+	}
+	l.lk.Lock()
+	count := l.count
+	l.lk.Unlock()
+	if count == 0 {
+		return nil, nil
+	}
+
+	receivedCids := make([]cid.Cid, 0, count)
+	err = cl.iterate(chid, count, func(c cid.Cid) error {
+		receivedCids = append(receivedCids, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return receivedCids, nil
+}
+
+// IterateList calls f with each CID in the list for the given data transfer
+// channel
+func (cl *cidLists) IterateList(chid datatransfer.ChannelID, f func(cid.Cid) error) error {
+	l, err := cl.open(chid)
+	if err != nil {
+		return err
+	}
+	l.lk.Lock()
+	count := l.count
+	l.lk.Unlock()
+	return cl.iterate(chid, count, f)
+}
+
+// ListLen returns the number of CIDs in the list for the given data transfer
+// channel
+func (cl *cidLists) ListLen(chid datatransfer.ChannelID) (int, error) {
+	l, err := cl.open(chid)
+	if err != nil {
+		return 0, err
+	}
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	return l.count, nil
+}
+
+// DeleteList deletes the list for the given data transfer channel
+func (cl *cidLists) DeleteList(chid datatransfer.ChannelID) error {
+	if cl.readOnly {
+		return ErrReadOnly
+	}
+	cl.lk.Lock()
+	defer cl.lk.Unlock()
+
+	delete(cl.lists, chid)
+	return os.Remove(transferFilename(cl.baseDir, chid))
+}
+
+// open returns the list for the channel, checking and recovering it the first
+// time it is opened
+func (cl *cidLists) open(chid datatransfer.ChannelID) (*cidList, error) {
+	cl.lk.Lock()
+	defer cl.lk.Unlock()
+
+	if l, ok := cl.lists[chid]; ok {
+		return l, nil
+	}
+	count, err := recoverLog(transferFilename(cl.baseDir, chid), cl.readOnly)
+	if err != nil {
+		return nil, err
+	}
+	l := &cidList{count: count}
+	cl.lists[chid] = l
+	return l, nil
+}
+
+// openOrCreate returns the list for the channel like open, but creates the
+// list with the given CID if it does not exist yet. The list is created
+// under the lock, so that concurrent appends to a new list do not overwrite
+// each other.
+func (cl *cidLists) openOrCreate(chid datatransfer.ChannelID, c cid.Cid) (*cidList, bool, error) {
+	cl.lk.Lock()
+	defer cl.lk.Unlock()
+
+	if l, ok := cl.lists[chid]; ok {
+		return l, false, nil
+	}
+	path := transferFilename(cl.baseDir, chid)
+	count, err := recoverLog(path, cl.readOnly)
+	if os.IsNotExist(err) {
+		if err := writeLog(path, []cid.Cid{c}); err != nil {
+			return nil, false, err
+		}
+		cl.lists[chid] = &cidList{count: 1}
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	l := &cidList{count: count}
+	cl.lists[chid] = l
+	return l, false, nil
+}
+
+// iterate reads the first count records of the list. Records appended after
+// the count was taken are not read.
+func (cl *cidLists) iterate(chid datatransfer.ChannelID, count int, f func(cid.Cid) error) (err error) {
+	file, err := os.Open(transferFilename(cl.baseDir, chid))
 	if err != nil {
 		return err
 	}
 	defer func() {
-		closeErr := f.Close()
+		closeErr := file.Close()
 		if err == nil {
 			err = closeErr
 		}
 	}()
-	return cbg.WriteCid(f, c)
+	r := bufio.NewReader(file)
+	if _, err := r.Discard(len(logHeader)); err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		c, _, err := readRecord(r)
+		if err != nil {
+			return fmt.Errorf("reading cid %d of list for channel %s: %w", i, chid, err)
+		}
+		if err := f(c); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// ReadList reads an on disk list of cids for the given data transfer channel
-func (cl *cidLists) ReadList(chid datatransfer.ChannelID) (cids []cid.Cid, err error) {
-	f, err := os.Open(transferFilename(cl.baseDir, chid))
+// recoverLog checks the log at the given path and returns the number of CIDs
+// in it. A partial record at the end of the log is cut off, and a bad record
+// anywhere else is an ErrCorrupt error. A list in the old format is converted
+// to a log. If readOnly is set, the partial record is left in place, and a
+// list in the old format is an error.
+func recoverLog(path string, readOnly bool) (int, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	r := bufio.NewReader(file)
+	header := make([]byte, len(logHeader))
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		_ = file.Close()
+		return 0, err
+	}
+	if n < len(header) || !bytes.Equal(header, logHeader) {
+		_ = file.Close()
+		if readOnly {
+			return 0, fmt.Errorf("cid list %s is in an old format and must be converted: %w", path, ErrReadOnly)
+		}
+		return convertLegacyList(path)
+	}
+
+	count := 0
+	valid := int64(len(logHeader))
+	for {
+		_, size, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err == errPartialRecord && readOnly {
+			log.Warnf("skipping partial record at offset %d of cid list %s", valid, path)
+			return count, file.Close()
+		}
+		if err == errPartialRecord {
+			log.Warnf("cutting off partial record at offset %d of cid list %s", valid, path)
+			if err := file.Close(); err != nil {
+				return 0, err
+			}
+			if err := os.Truncate(path, valid); err != nil {
+				return 0, err
+			}
+			return count, nil
+		}
+		if err == ErrCorrupt {
+			_ = file.Close()
+			return 0, fmt.Errorf("bad record at offset %d of cid list %s: %w", valid, path, err)
+		}
+		if err != nil {
+			_ = file.Close()
+			return 0, err
+		}
+		valid += int64(size)
+		count++
+	}
+	return count, file.Close()
+}
+
+// convertLegacyList rewrites a list of concatenated CIDs as a log. A partial
+// CID at the end of the old list is dropped.
+func convertLegacyList(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	var cids []cid.Cid
+	r := bufio.NewReader(file)
+	for {
+		c, err := cbg.ReadCid(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Warnf("dropping unreadable cids at the end of cid list %s: %s", path, err)
+			}
+			break
+		}
+		cids = append(cids, c)
+	}
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+	if err := writeLog(path, cids); err != nil {
+		return 0, err
+	}
+	return len(cids), nil
+}
+
+// writeLog atomically replaces the file at path with a log of the given CIDs
+func writeLog(path string, cids []cid.Cid) (err error) {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
 	}
 	defer func() {
-		closeErr := f.Close()
-		if err == nil {
-			err = closeErr
+		if err != nil {
+			_ = os.Remove(tmpPath)
 		}
 	}()
-	var receivedCids []cid.Cid
+	w := bufio.NewWriter(f)
+	if _, err := w.Write(logHeader); err != nil {
+		_ = f.Close()
+		return err
+	}
+	for _, c := range cids {
+		if _, err := w.Write(encodeRecord(c)); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func encodeRecord(c cid.Cid) []byte {
+	cidBytes := c.Bytes()
+	record := make([]byte, 0, binary.MaxVarintLen64+len(cidBytes)+crc32.Size)
+	record = appendUvarint(record, uint64(len(cidBytes)))
+	record = append(record, cidBytes...)
+	var sum [crc32.Size]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(cidBytes, crcTable))
+	return append(record, sum[:]...)
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+// readRecord reads a single record, returning the CID and the size of the
+// record. It returns io.EOF if there are no more records. A bad record is
+// errPartialRecord if it is the last thing in the log, and ErrCorrupt if
+// more of the log follows it.
+func readRecord(r *bufio.Reader) (cid.Cid, int, error) {
+	if _, err := r.Peek(1); err == io.EOF {
+		return cid.Undef, 0, io.EOF
+	}
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return cid.Undef, 0, partialOr(err)
+	}
+	if length == 0 || length > maxCidLength {
+		// the length is bad, so where the record ends is unknown. A torn
+		// write can leave zeroes at the end of the log, anything else is
+		// corrupt.
+		return cid.Undef, 0, badRecordIfZeroes(r, length)
+	}
+	buf := make([]byte, int(length)+crc32.Size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return cid.Undef, 0, partialOr(err)
+	}
+	cidBytes := buf[:length]
+	if binary.BigEndian.Uint32(buf[length:]) != crc32.Checksum(cidBytes, crcTable) {
+		return cid.Undef, 0, badRecord(r)
+	}
+	c, err := cid.Cast(cidBytes)
+	if err != nil {
+		return cid.Undef, 0, badRecord(r)
+	}
+	return c, uvarintSize(length) + len(buf), nil
+}
+
+func partialOr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errPartialRecord
+	}
+	return err
+}
+
+// badRecord returns the error for a bad record that has just been read
+func badRecord(r *bufio.Reader) error {
+	if _, err := r.Peek(1); err == io.EOF {
+		return errPartialRecord
+	}
+	return ErrCorrupt
+}
+
+// badRecordIfZeroes returns the error for a record with a bad length, which
+// is only partial if the rest of the log is zeroes
+func badRecordIfZeroes(r *bufio.Reader, length uint64) error {
+	if length != 0 {
+		return ErrCorrupt
+	}
 	for {
-		c, err := cbg.ReadCid(f)
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return errPartialRecord
+		}
 		if err != nil {
-			if err == io.EOF {
-				return receivedCids, nil
-			}
-			return nil, err
+			return err
+		}
+		if b != 0 {
+			return ErrCorrupt
 		}
-		receivedCids = append(receivedCids, c)
 	}
 }
 
-// DeleteList deletes the list for the given data transfer channel
-func (cl *cidLists) DeleteList(chid datatransfer.ChannelID) error {
-	return os.Remove(transferFilename(cl.baseDir, chid))
+func uvarintSize(v uint64) int {
+	var tmp [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(tmp[:], v)
 }
 
 func transferFilename(baseDir string, chid datatransfer.ChannelID) string {
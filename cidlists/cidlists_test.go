@@ -1,15 +1,19 @@
 package cidlists_test
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/ipfs/go-cid"
 	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
 
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-data-transfer/cidlists"
@@ -82,3 +86,272 @@ func TestCIDLists(t *testing.T) {
 		require.Error(t, err)
 	})
 }
+
+func TestCIDListsStreaming(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "cidlisttest")
+	require.NoError(t, err)
+
+	chid := datatransfer.ChannelID{ID: datatransfer.TransferID(rand.Uint64()), Initiator: testutil.GeneratePeers(1)[0], Responder: testutil.GeneratePeers(1)[0]}
+	initialCids := testutil.GenerateCids(10)
+	cidLists, err := cidlists.NewCIDLists(baseDir)
+	require.NoError(t, err)
+	require.NoError(t, cidLists.CreateList(chid, initialCids))
+	newCid := testutil.GenerateCids(1)[0]
+	require.NoError(t, cidLists.AppendList(chid, newCid))
+
+	count, err := cidLists.ListLen(chid)
+	require.NoError(t, err)
+	require.Equal(t, 11, count)
+
+	var iterated []cid.Cid
+	err = cidLists.IterateList(chid, func(c cid.Cid) error {
+		iterated = append(iterated, c)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, append(initialCids, newCid), iterated)
+
+	// iteration stops at the first error
+	stop := errors.New("stop")
+	iterated = nil
+	err = cidLists.IterateList(chid, func(c cid.Cid) error {
+		iterated = append(iterated, c)
+		if len(iterated) == 3 {
+			return stop
+		}
+		return nil
+	})
+	require.Equal(t, stop, err)
+	require.Len(t, iterated, 3)
+
+	// a new instance reads the count from disk
+	reopened, err := cidlists.NewCIDLists(baseDir)
+	require.NoError(t, err)
+	count, err = reopened.ListLen(chid)
+	require.NoError(t, err)
+	require.Equal(t, 11, count)
+}
+
+func TestCIDListsRecovery(t *testing.T) {
+	chid := datatransfer.ChannelID{ID: datatransfer.TransferID(rand.Uint64()), Initiator: testutil.GeneratePeers(1)[0], Responder: testutil.GeneratePeers(1)[0]}
+	filename := fmt.Sprintf("%d-%s-%s", chid.ID, chid.Initiator, chid.Responder)
+	initialCids := testutil.GenerateCids(5)
+
+	testCases := map[string]struct {
+		damage       func(t *testing.T, path string)
+		expectedCids []cid.Cid
+	}{
+		"partial trailing record": {
+			damage: func(t *testing.T, path string) {
+				info, err := os.Stat(path)
+				require.NoError(t, err)
+				require.NoError(t, os.Truncate(path, info.Size()-3))
+			},
+			expectedCids: initialCids[:4],
+		},
+		"corrupt trailing record": {
+			damage: func(t *testing.T, path string) {
+				f, err := os.OpenFile(path, os.O_RDWR, 0)
+				require.NoError(t, err)
+				info, err := f.Stat()
+				require.NoError(t, err)
+				// flip a byte in the checksum of the last record
+				_, err = f.WriteAt([]byte{0xff}, info.Size()-1)
+				require.NoError(t, err)
+				require.NoError(t, f.Close())
+			},
+			expectedCids: initialCids[:4],
+		},
+		"zeroes appended": {
+			damage: func(t *testing.T, path string) {
+				f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+				require.NoError(t, err)
+				_, err = f.Write(make([]byte, 16))
+				require.NoError(t, err)
+				require.NoError(t, f.Close())
+			},
+			expectedCids: initialCids,
+		},
+		"garbage appended": {
+			damage: func(t *testing.T, path string) {
+				f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+				require.NoError(t, err)
+				_, err = f.Write([]byte{0x80})
+				require.NoError(t, err)
+				require.NoError(t, f.Close())
+			},
+			expectedCids: initialCids,
+		},
+	}
+	for testCase, data := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			baseDir, err := ioutil.TempDir("", "cidlisttest")
+			require.NoError(t, err)
+			cidLists, err := cidlists.NewCIDLists(baseDir)
+			require.NoError(t, err)
+			require.NoError(t, cidLists.CreateList(chid, initialCids))
+
+			data.damage(t, filepath.Join(baseDir, filename))
+
+			recovered, err := cidlists.NewCIDLists(baseDir)
+			require.NoError(t, err)
+			savedCids, err := recovered.ReadList(chid)
+			require.NoError(t, err)
+			require.Equal(t, data.expectedCids, savedCids)
+
+			// appends after recovery are readable
+			newCid := testutil.GenerateCids(1)[0]
+			require.NoError(t, recovered.AppendList(chid, newCid))
+			reopened, err := cidlists.NewCIDLists(baseDir)
+			require.NoError(t, err)
+			savedCids, err = reopened.ReadList(chid)
+			require.NoError(t, err)
+			require.Equal(t, append(append([]cid.Cid{}, data.expectedCids...), newCid), savedCids)
+		})
+	}
+}
+
+func TestCIDListsCorruption(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "cidlisttest")
+	require.NoError(t, err)
+
+	chid := datatransfer.ChannelID{ID: datatransfer.TransferID(rand.Uint64()), Initiator: testutil.GeneratePeers(1)[0], Responder: testutil.GeneratePeers(1)[0]}
+	filename := fmt.Sprintf("%d-%s-%s", chid.ID, chid.Initiator, chid.Responder)
+	initialCids := testutil.GenerateCids(5)
+
+	cidLists, err := cidlists.NewCIDLists(baseDir)
+	require.NoError(t, err)
+	require.NoError(t, cidLists.CreateList(chid, initialCids))
+
+	// flip a byte in the checksum of the first record
+	path := filepath.Join(baseDir, filename)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	require.NoError(t, err)
+	recordSize := (info.Size() - int64(len("dtcids\x00\x01"))) / int64(len(initialCids))
+	_, err = f.WriteAt([]byte{0xff}, int64(len("dtcids\x00\x01"))+recordSize-1)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	reopened, err := cidlists.NewCIDLists(baseDir)
+	require.NoError(t, err)
+	_, err = reopened.ReadList(chid)
+	require.True(t, errors.Is(err, cidlists.ErrCorrupt))
+	require.True(t, errors.Is(reopened.AppendList(chid, initialCids[0]), cidlists.ErrCorrupt))
+
+	// the list is left as it was
+	after, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, info.Size(), after.Size())
+}
+
+func TestCIDListsConcurrentAppends(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "cidlisttest")
+	require.NoError(t, err)
+
+	chid := datatransfer.ChannelID{ID: datatransfer.TransferID(rand.Uint64()), Initiator: testutil.GeneratePeers(1)[0], Responder: testutil.GeneratePeers(1)[0]}
+	cids := testutil.GenerateCids(20)
+
+	cidLists, err := cidlists.NewCIDLists(baseDir)
+	require.NoError(t, err)
+
+	// appends to a list that does not exist yet all end up in it
+	var wg sync.WaitGroup
+	errs := make(chan error, len(cids))
+	for _, c := range cids {
+		wg.Add(1)
+		go func(c cid.Cid) {
+			defer wg.Done()
+			errs <- cidLists.AppendList(chid, c)
+		}(c)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	savedCids, err := cidLists.ReadList(chid)
+	require.NoError(t, err)
+	require.ElementsMatch(t, cids, savedCids)
+}
+
+func TestCIDListsLegacyFormat(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "cidlisttest")
+	require.NoError(t, err)
+
+	chid := datatransfer.ChannelID{ID: datatransfer.TransferID(rand.Uint64()), Initiator: testutil.GeneratePeers(1)[0], Responder: testutil.GeneratePeers(1)[0]}
+	filename := fmt.Sprintf("%d-%s-%s", chid.ID, chid.Initiator, chid.Responder)
+	legacyCids := testutil.GenerateCids(3)
+
+	// write a list in the old format, with a torn write at the end
+	f, err := os.Create(filepath.Join(baseDir, filename))
+	require.NoError(t, err)
+	for _, c := range legacyCids {
+		require.NoError(t, cbg.WriteCid(f, c))
+	}
+	buf := new(bytes.Buffer)
+	require.NoError(t, cbg.WriteCid(buf, testutil.GenerateCids(1)[0]))
+	_, err = f.Write(buf.Bytes()[:buf.Len()/2])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	cidLists, err := cidlists.NewCIDLists(baseDir)
+	require.NoError(t, err)
+	count, err := cidLists.ListLen(chid)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+	savedCids, err := cidLists.ReadList(chid)
+	require.NoError(t, err)
+	require.Equal(t, legacyCids, savedCids)
+
+	newCid := testutil.GenerateCids(1)[0]
+	require.NoError(t, cidLists.AppendList(chid, newCid))
+	savedCids, err = cidLists.ReadList(chid)
+	require.NoError(t, err)
+	require.Equal(t, append(legacyCids, newCid), savedCids)
+}
+
+func TestCIDListsReadOnly(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "cidlisttest")
+	require.NoError(t, err)
+
+	chid := datatransfer.ChannelID{ID: datatransfer.TransferID(rand.Uint64()), Initiator: testutil.GeneratePeers(1)[0], Responder: testutil.GeneratePeers(1)[0]}
+	filename := fmt.Sprintf("%d-%s-%s", chid.ID, chid.Initiator, chid.Responder)
+	initialCids := testutil.GenerateCids(5)
+
+	cidLists, err := cidlists.NewCIDLists(baseDir)
+	require.NoError(t, err)
+	require.NoError(t, cidLists.CreateList(chid, initialCids))
+	path := filepath.Join(baseDir, filename)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-3))
+
+	readOnly, err := cidlists.NewCIDLists(baseDir, cidlists.ReadOnly())
+	require.NoError(t, err)
+	savedCids, err := readOnly.ReadList(chid)
+	require.NoError(t, err)
+	require.Equal(t, initialCids[:4], savedCids)
+
+	// the partial record is left in place
+	after, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, info.Size()-3, after.Size())
+
+	require.True(t, errors.Is(readOnly.AppendList(chid, initialCids[0]), cidlists.ErrReadOnly))
+	require.True(t, errors.Is(readOnly.CreateList(chid, nil), cidlists.ErrReadOnly))
+	require.True(t, errors.Is(readOnly.DeleteList(chid), cidlists.ErrReadOnly))
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+
+	// lists in the old format are not converted
+	legacy := datatransfer.ChannelID{ID: datatransfer.TransferID(rand.Uint64()), Initiator: testutil.GeneratePeers(1)[0], Responder: testutil.GeneratePeers(1)[0]}
+	f, err := os.Create(filepath.Join(baseDir, fmt.Sprintf("%d-%s-%s", legacy.ID, legacy.Initiator, legacy.Responder)))
+	require.NoError(t, err)
+	require.NoError(t, cbg.WriteCid(f, initialCids[0]))
+	require.NoError(t, f.Close())
+	_, err = readOnly.ReadList(legacy)
+	require.True(t, errors.Is(err, cidlists.ErrReadOnly))
+}
@@ -1,111 +1,354 @@
 package cidsets
 
 import (
+	"container/list"
+	"path"
 	"sync"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
-	"github.com/ipfs/go-datastore/namespace"
 	"github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log/v2"
 )
 
+var log = logging.Logger("dt-cidsets")
+
 // SetID is a unique ID for a CID set
 type SetID string
 
-// CIDSetManager keeps track of several CID sets, by SetID
-type CIDSetManager struct {
-	ds   datastore.Datastore
-	lk   sync.Mutex
-	sets map[SetID]*cidSet
+// defaultCacheSize is the default maximum number of CIDs held in memory across
+// all sets
+const defaultCacheSize = 1 << 18
+
+// maxPendingWrites is the number of inserts after which pending writes are
+// committed even if Flush has not been called
+const maxPendingWrites = 1024
+
+// defaultFlushInterval is how long pending writes wait to be committed by
+// default if Flush is not called
+const defaultFlushInterval = time.Second
+
+// Option configures a CIDSetManager
+type Option func(*CIDSetManager)
+
+// CacheSize sets the maximum number of CIDs held in memory across all sets.
+// When it is exceeded, the least recently used sets are dropped from memory
+// and loaded from the datastore again when next used.
+func CacheSize(size int) Option {
+	return func(mgr *CIDSetManager) {
+		mgr.cacheSize = size
+	}
 }
 
-func NewCIDSetManager(ds datastore.Datastore) *CIDSetManager {
-	return &CIDSetManager{ds: ds, sets: make(map[SetID]*cidSet)}
+// FlushInterval sets the longest that new members wait to be written to the
+// datastore if Flush is not called. Zero leaves them until maxPendingWrites
+// new members have built up.
+func FlushInterval(interval time.Duration) Option {
+	return func(mgr *CIDSetManager) {
+		mgr.flushInterval = interval
+	}
 }
 
-// InsertSetCID inserts a CID into a CID set.
-// Returns true if the set already contained the CID.
-func (mgr *CIDSetManager) InsertSetCID(sid SetID, c cid.Cid) (exists bool, err error) {
-	return mgr.getSet(sid).Insert(c)
+// CIDSetManager keeps track of several CID sets, by SetID.
+// The members of recently used sets are cached in memory, so most inserts do
+// not touch the datastore, and new members are written to the datastore in
+// batches, once enough have built up or the flush interval has passed.
+// Writes are only guaranteed to be in the datastore after Flush returns.
+type CIDSetManager struct {
+	ds            datastore.Batching
+	cacheSize     int
+	flushInterval time.Duration
+
+	lk     sync.Mutex
+	sets   map[SetID]*cidSet
+	lru    *list.List
+	cached int
+
+	pending     datastore.Batch
+	pendingKeys map[datastore.Key]struct{}
+	flushTimer  *time.Timer
 }
 
-// DeleteSet deletes a CID set
-func (mgr *CIDSetManager) DeleteSet(sid SetID) error {
-	return mgr.getSet(sid).Truncate()
+// cidSet is a set whose members are either all cached in memory, or, if the
+// set is too big for the cache, looked up in the datastore
+type cidSet struct {
+	id SetID
+	// members is nil if the set is too big to cache
+	members map[string]struct{}
+	elem    *list.Element
 }
 
-// getSet gets the cidSet for the given SetID
-func (mgr *CIDSetManager) getSet(sid SetID) *cidSet {
+// NewCIDSetManager returns a manager for sets stored in the given datastore
+func NewCIDSetManager(ds datastore.Batching, options ...Option) *CIDSetManager {
+	mgr := &CIDSetManager{
+		ds:            ds,
+		cacheSize:     defaultCacheSize,
+		flushInterval: defaultFlushInterval,
+		sets:          make(map[SetID]*cidSet),
+		lru:           list.New(),
+		pendingKeys:   make(map[datastore.Key]struct{}),
+	}
+	for _, option := range options {
+		option(mgr)
+	}
+	return mgr
+}
+
+// InsertSetCID inserts a CID into a CID set.
+// Returns true if the set already contained the CID.
+func (mgr *CIDSetManager) InsertSetCID(sid SetID, c cid.Cid) (exists bool, err error) {
 	mgr.lk.Lock()
 	defer mgr.lk.Unlock()
 
-	s, ok := mgr.sets[sid]
-	if !ok {
-		s = NewCIDSet(mgr.getSetDS(sid))
-		mgr.sets[sid] = s
+	s, err := mgr.getSet(sid)
+	if err != nil {
+		return false, err
 	}
-	return s
-}
 
-// getSetDS gets the wrapped datastore for the given SetID
-func (mgr *CIDSetManager) getSetDS(sid SetID) datastore.Batching {
-	setDSKey := datastore.NewKey(string(sid) + "/cids")
-	return namespace.Wrap(mgr.ds, setDSKey)
+	cidStr := c.String()
+	k := setKey(sid).ChildString(cidStr)
+	if s.members != nil {
+		if _, ok := s.members[cidStr]; ok {
+			return true, nil
+		}
+	} else {
+		if _, ok := mgr.pendingKeys[k]; ok {
+			return true, nil
+		}
+		has, err := mgr.ds.Has(k)
+		if err != nil {
+			return false, err
+		}
+		if has {
+			return true, nil
+		}
+	}
+
+	if err := mgr.write(k); err != nil {
+		return false, err
+	}
+	if s.members != nil {
+		s.members[cidStr] = struct{}{}
+		mgr.cached++
+		if err := mgr.evict(s); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
 }
 
-// cidSet persists a set of CIDs
-type cidSet struct {
-	lk sync.Mutex
-	ds datastore.Batching
+// Flush commits all pending writes to the datastore
+func (mgr *CIDSetManager) Flush() error {
+	mgr.lk.Lock()
+	defer mgr.lk.Unlock()
+
+	return mgr.flush()
 }
 
-func NewCIDSet(ds datastore.Batching) *cidSet {
-	return &cidSet{ds: ds}
+// SetCIDs returns all the CIDs in a CID set
+func (mgr *CIDSetManager) SetCIDs(sid SetID) ([]cid.Cid, error) {
+	var cids []cid.Cid
+	err := mgr.IterateSetCIDs(sid, func(c cid.Cid) error {
+		cids = append(cids, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cids, nil
 }
 
-// Insert a CID into the set.
-// Returns true if the the CID was already in the set.
-func (s *cidSet) Insert(c cid.Cid) (exists bool, err error) {
-	s.lk.Lock()
-	defer s.lk.Unlock()
+// IterateSetCIDs calls f with each CID in a CID set, reading them from the
+// datastore as it goes rather than loading the whole set into memory. It
+// stops at the first error f returns. CIDs inserted while the set is being
+// iterated may or may not be included.
+func (mgr *CIDSetManager) IterateSetCIDs(sid SetID, f func(cid.Cid) error) error {
+	mgr.lk.Lock()
+	err := mgr.flush()
+	mgr.lk.Unlock()
+	if err != nil {
+		return err
+	}
 
-	k := datastore.NewKey(c.String())
-	has, err := s.ds.Has(k)
+	res, err := mgr.ds.Query(query.Query{Prefix: setKey(sid).String(), KeysOnly: true})
 	if err != nil {
-		return false, err
+		return err
 	}
-	if has {
-		return true, nil
+	defer res.Close()
+	for entry := range res.Next() {
+		if entry.Error != nil {
+			return entry.Error
+		}
+		c, err := cid.Decode(path.Base(entry.Key))
+		if err != nil {
+			return err
+		}
+		if err := f(c); err != nil {
+			return err
+		}
 	}
-	return false, s.ds.Put(k, nil)
+	return nil
 }
 
-// Truncate removes all CIDs in the set
-func (s *cidSet) Truncate() error {
-	s.lk.Lock()
-	defer s.lk.Unlock()
+// DeleteSet deletes a CID set
+func (mgr *CIDSetManager) DeleteSet(sid SetID) error {
+	mgr.lk.Lock()
+	defer mgr.lk.Unlock()
+
+	if err := mgr.flush(); err != nil {
+		return err
+	}
+	if s, ok := mgr.sets[sid]; ok {
+		mgr.drop(s)
+	}
 
-	res, err := s.ds.Query(query.Query{KeysOnly: true})
+	res, err := mgr.ds.Query(query.Query{Prefix: setKey(sid).String(), KeysOnly: true})
 	if err != nil {
 		return err
 	}
-
 	entries, err := res.Rest()
 	if err != nil {
 		return err
 	}
-
-	batched, err := s.ds.Batch()
+	batched, err := mgr.ds.Batch()
 	if err != nil {
 		return err
 	}
-
 	for _, entry := range entries {
 		err := batched.Delete(datastore.NewKey(entry.Key))
 		if err != nil {
 			return err
 		}
 	}
-
 	return batched.Commit()
 }
+
+// getSet returns the set with the given ID, loading it from the datastore if
+// it is not cached. It must be called with the lock held.
+func (mgr *CIDSetManager) getSet(sid SetID) (*cidSet, error) {
+	if s, ok := mgr.sets[sid]; ok {
+		mgr.lru.MoveToFront(s.elem)
+		return s, nil
+	}
+
+	// the datastore must have all the set's members before it is loaded
+	if err := mgr.flush(); err != nil {
+		return nil, err
+	}
+	res, err := mgr.ds.Query(query.Query{Prefix: setKey(sid).String(), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	s := &cidSet{id: sid, members: make(map[string]struct{})}
+	for entry := range res.Next() {
+		if entry.Error != nil {
+			return nil, entry.Error
+		}
+		if len(s.members) >= mgr.cacheSize {
+			// too big to cache, so look members up in the datastore instead
+			s.members = nil
+			break
+		}
+		s.members[path.Base(entry.Key)] = struct{}{}
+	}
+	s.elem = mgr.lru.PushFront(s)
+	mgr.sets[sid] = s
+	mgr.cached += len(s.members)
+	if err := mgr.evict(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// evict drops the least recently used sets, other than the given set, from
+// memory until the cache is within its size. It must be called with the lock
+// held.
+func (mgr *CIDSetManager) evict(keep *cidSet) error {
+	for mgr.cached > mgr.cacheSize {
+		elem := mgr.lru.Back()
+		for elem != nil && elem.Value.(*cidSet) == keep {
+			elem = elem.Prev()
+		}
+		if elem == nil {
+			// the set being used is too big to cache on its own
+			mgr.cached -= len(keep.members)
+			keep.members = nil
+			return nil
+		}
+		// evicted sets are loaded from the datastore when next used, so it
+		// must have all their members
+		if err := mgr.flush(); err != nil {
+			return err
+		}
+		mgr.drop(elem.Value.(*cidSet))
+	}
+	return nil
+}
+
+func (mgr *CIDSetManager) drop(s *cidSet) {
+	mgr.lru.Remove(s.elem)
+	delete(mgr.sets, s.id)
+	mgr.cached -= len(s.members)
+}
+
+// write adds a new member to the pending batch. It must be called with the
+// lock held.
+func (mgr *CIDSetManager) write(k datastore.Key) error {
+	if mgr.pending == nil {
+		batch, err := mgr.ds.Batch()
+		if err != nil {
+			return err
+		}
+		mgr.pending = batch
+		if mgr.flushInterval > 0 {
+			mgr.flushTimer = time.AfterFunc(mgr.flushInterval, mgr.flushPending)
+		}
+	}
+	if err := mgr.pending.Put(k, nil); err != nil {
+		return err
+	}
+	mgr.pendingKeys[k] = struct{}{}
+	if len(mgr.pendingKeys) >= maxPendingWrites {
+		return mgr.flush()
+	}
+	return nil
+}
+
+// flush commits the pending batch. It must be called with the lock held.
+func (mgr *CIDSetManager) flush() error {
+	if mgr.pending == nil {
+		return nil
+	}
+	if err := mgr.pending.Commit(); err != nil {
+		return err
+	}
+	mgr.pending = nil
+	mgr.pendingKeys = make(map[datastore.Key]struct{})
+	if mgr.flushTimer != nil {
+		mgr.flushTimer.Stop()
+		mgr.flushTimer = nil
+	}
+	return nil
+}
+
+// flushPending commits the pending batch once the flush interval has passed,
+// trying again after another interval if it fails
+func (mgr *CIDSetManager) flushPending() {
+	mgr.lk.Lock()
+	defer mgr.lk.Unlock()
+
+	if err := mgr.flush(); err != nil {
+		log.Errorf("writing cid sets, trying again in %s: %s", mgr.flushInterval, err)
+		if mgr.flushTimer != nil {
+			mgr.flushTimer.Reset(mgr.flushInterval)
+		}
+	}
+}
+
+// setKey is the prefix of the keys of a set's members
+func setKey(sid SetID) datastore.Key {
+	return datastore.NewKey(string(sid) + "/cids")
+}
@@ -1,8 +1,11 @@
 package cidsets
 
 import (
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/ipfs/go-cid"
 	ds "github.com/ipfs/go-datastore"
 	ds_sync "github.com/ipfs/go-datastore/sync"
 	"github.com/stretchr/testify/require"
@@ -45,3 +48,162 @@ func TestCIDSetManager(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, exists)
 }
+
+func TestCIDSetManagerReload(t *testing.T) {
+	cids := testutil.GenerateCids(3)
+
+	dstore := ds_sync.MutexWrap(ds.NewMapDatastore())
+	mgr := NewCIDSetManager(dstore)
+	setID := SetID("set")
+
+	for _, c := range cids {
+		exists, err := mgr.InsertSetCID(setID, c)
+		require.NoError(t, err)
+		require.False(t, exists)
+	}
+	require.NoError(t, mgr.Flush())
+
+	// a new manager on the same datastore sees the flushed members
+	mgr = NewCIDSetManager(dstore)
+	for _, c := range cids {
+		exists, err := mgr.InsertSetCID(setID, c)
+		require.NoError(t, err)
+		require.True(t, exists)
+	}
+}
+
+func TestCIDSetManagerEviction(t *testing.T) {
+	cids := testutil.GenerateCids(4)
+
+	dstore := ds_sync.MutexWrap(ds.NewMapDatastore())
+	mgr := NewCIDSetManager(dstore, CacheSize(2))
+	setID1 := SetID("set1")
+	setID2 := SetID("set2")
+
+	for _, c := range cids[:2] {
+		exists, err := mgr.InsertSetCID(setID1, c)
+		require.NoError(t, err)
+		require.False(t, exists)
+	}
+	require.Len(t, mgr.sets, 1)
+
+	// inserting into a second set evicts the first from memory
+	exists, err := mgr.InsertSetCID(setID2, cids[2])
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.Len(t, mgr.sets, 1)
+	require.LessOrEqual(t, mgr.cached, 2)
+
+	// the evicted set is loaded again from the datastore
+	for _, c := range cids[:2] {
+		exists, err := mgr.InsertSetCID(setID1, c)
+		require.NoError(t, err)
+		require.True(t, exists)
+	}
+	exists, err = mgr.InsertSetCID(setID2, cids[2])
+	require.NoError(t, err)
+	require.True(t, exists)
+}
+
+func TestCIDSetManagerUncachedSet(t *testing.T) {
+	cids := testutil.GenerateCids(5)
+
+	dstore := ds_sync.MutexWrap(ds.NewMapDatastore())
+	mgr := NewCIDSetManager(dstore, CacheSize(2))
+	setID := SetID("set")
+
+	// the set grows bigger than the cache, so members are looked up in the
+	// datastore and in pending writes
+	for _, c := range cids {
+		exists, err := mgr.InsertSetCID(setID, c)
+		require.NoError(t, err)
+		require.False(t, exists)
+	}
+	require.Nil(t, mgr.sets[setID].members)
+	require.Equal(t, 0, mgr.cached)
+	for _, c := range cids {
+		exists, err := mgr.InsertSetCID(setID, c)
+		require.NoError(t, err)
+		require.True(t, exists)
+	}
+
+	// once flushed, a new manager loads it as too big to cache
+	require.NoError(t, mgr.Flush())
+	mgr = NewCIDSetManager(dstore, CacheSize(2))
+	exists, err := mgr.InsertSetCID(setID, cids[0])
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Nil(t, mgr.sets[setID].members)
+}
+
+func TestCIDSetManagerDeletePending(t *testing.T) {
+	cid1 := testutil.GenerateCids(1)[0]
+
+	dstore := ds_sync.MutexWrap(ds.NewMapDatastore())
+	mgr := NewCIDSetManager(dstore)
+	setID := SetID("set")
+
+	exists, err := mgr.InsertSetCID(setID, cid1)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// deleting the set removes writes that have not been flushed yet
+	require.NoError(t, mgr.DeleteSet(setID))
+	require.NoError(t, mgr.Flush())
+	mgr = NewCIDSetManager(dstore)
+	exists, err = mgr.InsertSetCID(setID, cid1)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestCIDSetManagerFlushInterval(t *testing.T) {
+	cid1 := testutil.GenerateCids(1)[0]
+
+	dstore := ds_sync.MutexWrap(ds.NewMapDatastore())
+	mgr := NewCIDSetManager(dstore, FlushInterval(10*time.Millisecond))
+	setID := SetID("set")
+
+	exists, err := mgr.InsertSetCID(setID, cid1)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// pending writes are committed once the interval has passed, without
+	// calling Flush
+	require.Eventually(t, func() bool {
+		has, err := dstore.Has(setKey(setID).ChildString(cid1.String()))
+		return err == nil && has
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCIDSetManagerSetCIDs(t *testing.T) {
+	cids := testutil.GenerateCids(3)
+
+	dstore := ds_sync.MutexWrap(ds.NewMapDatastore())
+	mgr := NewCIDSetManager(dstore)
+	setID := SetID("set")
+
+	members, err := mgr.SetCIDs(setID)
+	require.NoError(t, err)
+	require.Empty(t, members)
+
+	for _, c := range cids {
+		_, err := mgr.InsertSetCID(setID, c)
+		require.NoError(t, err)
+	}
+	members, err = mgr.SetCIDs(setID)
+	require.NoError(t, err)
+	require.ElementsMatch(t, cids, members)
+
+	// iterating stops at the first error
+	var iterated []cid.Cid
+	stop := errors.New("stop")
+	err = mgr.IterateSetCIDs(setID, func(c cid.Cid) error {
+		iterated = append(iterated, c)
+		if len(iterated) == 2 {
+			return stop
+		}
+		return nil
+	})
+	require.Equal(t, stop, err)
+	require.Len(t, iterated, 2)
+}
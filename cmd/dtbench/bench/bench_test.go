@@ -0,0 +1,143 @@
+package bench_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-data-transfer/cmd/dtbench/bench"
+)
+
+func TestLoadScenarios(t *testing.T) {
+	scenarios, err := bench.LoadScenarios(strings.NewReader(`[
+		{"name": "defaults", "dag": {"size": 1024}},
+		{"name": "custom", "dag": {"size": 1024, "chunkSize": 256}, "peers": 3, "link": {"latency": "10ms", "bandwidth": 1e6}, "timeout": "30s"}
+	]`))
+	require.NoError(t, err)
+	require.Len(t, scenarios, 2)
+	require.Equal(t, int64(1<<20), scenarios[0].DAG.ChunkSize)
+	require.Equal(t, 1, scenarios[0].Peers)
+	require.Equal(t, 1, scenarios[0].Transfers)
+	require.Equal(t, 1, scenarios[0].Iterations)
+	require.Equal(t, int64(256), scenarios[1].DAG.ChunkSize)
+	require.Equal(t, 3, scenarios[1].Peers)
+	require.Equal(t, bench.Duration(10*time.Millisecond), scenarios[1].Link.Latency)
+	require.Equal(t, bench.Duration(30*time.Second), scenarios[1].Timeout)
+
+	invalid := map[string]string{
+		"no name":          `[{"dag": {"size": 1024}}]`,
+		"no size":          `[{"name": "a"}]`,
+		"unknown network":  `[{"name": "a", "dag": {"size": 1024}, "network": "dialup"}]`,
+		"unknown field":    `[{"name": "a", "dag": {"size": 1024}, "peer": 2}]`,
+		"duplicate names":  `[{"name": "a", "dag": {"size": 1024}}, {"name": "a", "dag": {"size": 1024}}]`,
+		"invalid duration": `[{"name": "a", "dag": {"size": 1024}, "timeout": "soon"}]`,
+	}
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := bench.LoadScenarios(strings.NewReader(data))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestRunScenario(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	testCases := map[string]bench.Scenario{
+		"push to two peers": {
+			DAG:         bench.DAGShape{Size: 256 << 10, ChunkSize: 16 << 10, LinksPerLevel: 1024, RawLeaves: true},
+			Peers:       2,
+			Transfers:   2,
+			Concurrency: 2,
+		},
+		"pull over an emulated link": {
+			DAG:       bench.DAGShape{Size: 64 << 10, ChunkSize: 16 << 10, LinksPerLevel: 1024},
+			Pull:      true,
+			Transfers: 2,
+			Network:   "lan",
+		},
+		"restarts": {
+			DAG:       bench.DAGShape{Size: 1 << 20, ChunkSize: 16 << 10, LinksPerLevel: 1024, RawLeaves: true},
+			Restarts:  1,
+			Downtime:  bench.Duration(50 * time.Millisecond),
+			Link:      &bench.Link{Bandwidth: 8 << 20},
+			Transfers: 1,
+		},
+	}
+	for name, s := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s.Name = name
+			s.Iterations = 2
+			s.Timeout = bench.Duration(10 * time.Second)
+			result := bench.RunScenario(ctx, s)
+			require.Empty(t, result.Error)
+			require.Equal(t, 2, result.Iterations)
+			require.Zero(t, result.Failed)
+			transfers := 2 * s.Transfers * s.Peers
+			if s.Peers == 0 {
+				transfers = 2 * s.Transfers
+			}
+			require.Equal(t, transfers, result.Transfers)
+			require.Equal(t, uint64(transfers)*s.DAG.Size, result.Bytes)
+			require.Greater(t, result.Throughput, float64(0))
+			require.Greater(t, int64(result.Latency.P50), int64(0))
+			require.LessOrEqual(t, int64(result.Latency.P50), int64(result.Latency.Max))
+			require.Greater(t, result.Allocs, uint64(0))
+			require.Greater(t, result.DatastoreOps.Puts, uint64(0))
+			if s.Restarts > 0 {
+				require.Greater(t, result.Restarts, 0)
+			}
+		})
+	}
+}
+
+func TestRunAndCompare(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "dtbench")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	scenarioFile := filepath.Join(dir, "scenarios.json")
+	require.NoError(t, ioutil.WriteFile(scenarioFile, []byte(`[
+		{"name": "small", "dag": {"size": 65536, "chunkSize": 16384}},
+		{"name": "skipped", "dag": {"size": 65536}}
+	]`), 0644))
+
+	reports := []string{filepath.Join(dir, "old.json"), filepath.Join(dir, "new.json")}
+	for i, report := range reports {
+		var stdout, stderr bytes.Buffer
+		require.NoError(t, bench.Run(ctx, []string{"run", "-run", "^small$", "-label", []string{"v1", "v2"}[i], "-out", report, scenarioFile}, &stdout, &stderr))
+		require.Contains(t, stdout.String(), "small")
+		require.NotContains(t, stdout.String(), "skipped")
+	}
+
+	f, err := os.Open(reports[0])
+	require.NoError(t, err)
+	report, err := bench.ReadReport(f)
+	require.NoError(t, f.Close())
+	require.NoError(t, err)
+	require.Equal(t, "v1", report.Label)
+	require.Len(t, report.Results, 1)
+	require.Equal(t, 1, report.Results[0].Transfers)
+
+	var stdout, stderr bytes.Buffer
+	require.NoError(t, bench.Run(ctx, append([]string{"compare"}, reports...), &stdout, &stderr))
+	require.Contains(t, stdout.String(), "v1")
+	require.Contains(t, stdout.String(), "v2")
+	require.Contains(t, stdout.String(), "ds ops/transfer")
+
+	require.Equal(t, bench.ErrUsage, bench.Run(ctx, nil, &stdout, &stderr))
+	require.Equal(t, bench.ErrUsage, bench.Run(ctx, []string{"compare", reports[0]}, &stdout, &stderr))
+	require.Error(t, bench.Run(ctx, []string{"run", "-run", "nothing", scenarioFile}, &stdout, &stderr))
+}
@@ -0,0 +1,143 @@
+package bench
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"golang.org/x/xerrors"
+)
+
+const usage = `usage: dtbench <command> [flags] [args]
+
+Benchmarks data transfer managers running in this process.
+
+commands:
+  run [flags] <scenario file>...    run the scenarios in the files
+  compare <old report> <new report>  compare two JSON reports written by run
+
+Scenario files are JSON lists of scenarios; see cmd/dtbench/scenarios.json.
+`
+
+// ErrUsage is returned when dtbench is run with invalid arguments
+var ErrUsage = xerrors.New("invalid arguments")
+
+// Run runs dtbench with the given command line arguments, not including the
+// program name
+func Run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return ErrUsage
+	}
+	switch args[0] {
+	case "run":
+		return runScenarios(ctx, args[1:], stdout, stderr)
+	case "compare":
+		return runCompare(args[1:], stdout, stderr)
+	default:
+		fmt.Fprint(stderr, usage)
+		return ErrUsage
+	}
+}
+
+func runScenarios(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) error {
+	fs := flag.NewFlagSet("dtbench run", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	filter := fs.String("run", "", "only run scenarios whose names match this regular expression")
+	out := fs.String("out", "", "also write the report as JSON to this file")
+	label := fs.String("label", "", "name for this run in the report, such as the version benchmarked")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, "usage: dtbench run [flags] <scenario file>...\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return ErrUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return ErrUsage
+	}
+	var match *regexp.Regexp
+	if *filter != "" {
+		var err error
+		match, err = regexp.Compile(*filter)
+		if err != nil {
+			return xerrors.Errorf("invalid -run: %w", err)
+		}
+	}
+
+	var scenarios []Scenario
+	for _, path := range fs.Args() {
+		loaded, err := loadScenarioFile(path)
+		if err != nil {
+			return err
+		}
+		for _, s := range loaded {
+			if match == nil || match.MatchString(s.Name) {
+				scenarios = append(scenarios, s)
+			}
+		}
+	}
+	if len(scenarios) == 0 {
+		return xerrors.New("no scenarios to run")
+	}
+
+	report := NewReport(*label)
+	for _, s := range scenarios {
+		fmt.Fprintf(stderr, "running %s\n", s.Name)
+		report.Results = append(report.Results, RunScenario(ctx, s))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		err = report.WriteJSON(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return xerrors.Errorf("writing report: %w", err)
+		}
+	}
+	return report.WriteText(stdout)
+}
+
+func loadScenarioFile(path string) ([]Scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scenarios, err := LoadScenarios(f)
+	if err != nil {
+		return nil, xerrors.Errorf("%s: %w", path, err)
+	}
+	return scenarios, nil
+}
+
+func runCompare(args []string, stdout io.Writer, stderr io.Writer) error {
+	if len(args) != 2 {
+		fmt.Fprint(stderr, "usage: dtbench compare <old report> <new report>\n")
+		return ErrUsage
+	}
+	var reports [2]*Report
+	for i, path := range args {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		reports[i], err = ReadReport(f)
+		f.Close()
+		if err != nil {
+			return xerrors.Errorf("%s: %w", path, err)
+		}
+	}
+	return Compare(stdout, reports[0], reports[1])
+}
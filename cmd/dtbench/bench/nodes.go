@@ -0,0 +1,317 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	dss "github.com/ipfs/go-datastore/sync"
+	badgerds "github.com/ipfs/go-ds-badger"
+	gsimpl "github.com/ipfs/go-graphsync/impl"
+	"github.com/ipfs/go-graphsync/storeutil"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	files "github.com/ipfs/go-ipfs-files"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	ihelper "github.com/ipfs/go-unixfs/importer/helpers"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-storedcounter"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	tn "github.com/filecoin-project/go-data-transfer/benchmarks/testnet"
+	"github.com/filecoin-project/go-data-transfer/dttest"
+	dtimpl "github.com/filecoin-project/go-data-transfer/impl"
+	dtnet "github.com/filecoin-project/go-data-transfer/network"
+	"github.com/filecoin-project/go-data-transfer/testutil"
+	gstransport "github.com/filecoin-project/go-data-transfer/transport/graphsync"
+)
+
+// node is one data transfer manager in a scenario
+type node struct {
+	peer       peer.ID
+	manager    datatransfer.Manager
+	network    dtnet.DataTransferNetwork
+	dagService ipldformat.DAGService
+	// dtDatastore is the datastore the manager keeps its channels in
+	dtDatastore *countingDatastore
+	ds          datastore.Batching
+	tempDir     string
+}
+
+func newNode(ctx context.Context, net tn.Network, disk bool, options ...dtimpl.DataTransferOption) (*node, error) {
+	p, gsNet, dtNet := net.Adapter()
+	tempDir, err := ioutil.TempDir("", "dtbench")
+	if err != nil {
+		return nil, err
+	}
+	n := &node{peer: p, network: dtNet, tempDir: tempDir}
+	// badger and the received CID lists each get their own directory, as
+	// badger expects to own the files in its directory
+	dsDir := filepath.Join(tempDir, "datastore")
+	cidListsDir := filepath.Join(tempDir, "cidlists")
+	for _, dir := range []string{dsDir, cidListsDir} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			_ = os.RemoveAll(tempDir)
+			return nil, err
+		}
+	}
+	if disk {
+		opts := badgerds.DefaultOptions
+		opts.SyncWrites = false
+		opts.Truncate = true
+		n.ds, err = badgerds.NewDatastore(dsDir, &opts)
+		if err != nil {
+			_ = os.RemoveAll(tempDir)
+			return nil, xerrors.Errorf("opening datastore: %w", err)
+		}
+	} else {
+		n.ds = dss.MutexWrap(datastore.NewMapDatastore())
+	}
+
+	bs := bstore.NewBlockstore(namespace.Wrap(n.ds, datastore.NewKey("blockstore")))
+	n.dagService = merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	gs := gsimpl.New(ctx, gsNet, storeutil.LoaderForBlockstore(bs), storeutil.StorerForBlockstore(bs))
+	transport := gstransport.NewTransport(p, gs)
+	n.dtDatastore = &countingDatastore{Batching: namespace.Wrap(n.ds, datastore.NewKey("datatransfer"))}
+	counter := storedcounter.New(n.ds, datastore.NewKey("counter"))
+	n.manager, err = dttest.StartManager(ctx, n.dtDatastore, cidListsDir, dtNet, transport, counter, options...)
+	if err != nil {
+		n.close()
+		return nil, err
+	}
+
+	if err := n.manager.RegisterVoucherType(testutil.NewFakeDTType(), acceptAll{}); err != nil {
+		n.close()
+		return nil, err
+	}
+	return n, nil
+}
+
+// acceptAll is a validator that accepts every request. Unlike
+// testutil.StubbedValidator, it keeps no record of the requests, so it is safe
+// for concurrent transfers and doesn't grow as the benchmark runs.
+type acceptAll struct{}
+
+func (acceptAll) ValidatePush(peer.ID, datatransfer.Voucher, cid.Cid, ipld.Node) (datatransfer.VoucherResult, error) {
+	return nil, nil
+}
+
+func (acceptAll) ValidatePull(peer.ID, datatransfer.Voucher, cid.Cid, ipld.Node) (datatransfer.VoucherResult, error) {
+	return nil, nil
+}
+
+// addDAG adds a UnixFS DAG of random data with the given shape
+func (n *node) addDAG(ctx context.Context, shape DAGShape) (cid.Cid, error) {
+	data := make([]byte, shape.Size)
+	rand.Read(data)
+
+	bufferedDS := ipldformat.NewBufferedDAG(ctx, n.dagService)
+	params := ihelper.DagBuilderParams{
+		Maxlinks:  shape.LinksPerLevel,
+		RawLeaves: shape.RawLeaves,
+		Dagserv:   bufferedDS,
+	}
+	db, err := params.New(chunker.NewSizeSplitter(files.NewReaderFile(bytes.NewReader(data)), shape.ChunkSize))
+	if err != nil {
+		return cid.Undef, err
+	}
+	nd, err := balanced.Layout(db)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := bufferedDS.Commit(); err != nil {
+		return cid.Undef, err
+	}
+	return nd.Cid(), nil
+}
+
+func (n *node) close() {
+	if n.manager != nil {
+		_ = n.manager.Stop(context.Background())
+	}
+	if n.dtDatastore != nil {
+		n.dtDatastore.shutdown()
+	}
+	_ = n.ds.Close()
+	_ = os.RemoveAll(n.tempDir)
+}
+
+// DatastoreOps counts the operations on the data transfer datastores of all
+// the nodes in a scenario. Puts and deletes in batches count as puts and
+// deletes, and each batch commit as a batch.
+type DatastoreOps struct {
+	Gets    uint64 `json:"gets"`
+	Has     uint64 `json:"has"`
+	Puts    uint64 `json:"puts"`
+	Deletes uint64 `json:"deletes"`
+	Queries uint64 `json:"queries"`
+	Batches uint64 `json:"batches"`
+}
+
+// Total is the number of operations, not counting batch commits
+func (o DatastoreOps) Total() uint64 {
+	return o.Gets + o.Has + o.Puts + o.Deletes + o.Queries
+}
+
+func (o *DatastoreOps) add(other DatastoreOps) {
+	o.Gets += other.Gets
+	o.Has += other.Has
+	o.Puts += other.Puts
+	o.Deletes += other.Deletes
+	o.Queries += other.Queries
+	o.Batches += other.Batches
+}
+
+// since returns the operations counted since earlier
+func (o DatastoreOps) since(earlier DatastoreOps) DatastoreOps {
+	return DatastoreOps{
+		Gets:    o.Gets - earlier.Gets,
+		Has:     o.Has - earlier.Has,
+		Puts:    o.Puts - earlier.Puts,
+		Deletes: o.Deletes - earlier.Deletes,
+		Queries: o.Queries - earlier.Queries,
+		Batches: o.Batches - earlier.Batches,
+	}
+}
+
+// errClosed is returned by operations on a node's data transfer datastore
+// after the node is closed
+var errClosed = xerrors.New("datastore closed")
+
+// countingDatastore counts the operations on a datastore. It also fails
+// operations once closed: the manager's channel state machines can still
+// write for a moment after it stops, and go-ds-badger hands out nil batches
+// once it is closed.
+type countingDatastore struct {
+	datastore.Batching
+	gets, has, puts, deletes, queries, batches uint64
+
+	closeLk sync.RWMutex
+	closed  bool
+}
+
+func (c *countingDatastore) ops() DatastoreOps {
+	return DatastoreOps{
+		Gets:    atomic.LoadUint64(&c.gets),
+		Has:     atomic.LoadUint64(&c.has),
+		Puts:    atomic.LoadUint64(&c.puts),
+		Deletes: atomic.LoadUint64(&c.deletes),
+		Queries: atomic.LoadUint64(&c.queries),
+		Batches: atomic.LoadUint64(&c.batches),
+	}
+}
+
+// shutdown waits for operations in progress, then fails any more. It leaves
+// the underlying datastore open.
+func (c *countingDatastore) shutdown() {
+	c.closeLk.Lock()
+	c.closed = true
+	c.closeLk.Unlock()
+}
+
+// do runs an operation on the underlying datastore, unless it is closed
+func (c *countingDatastore) do(count *uint64, op func() error) error {
+	c.closeLk.RLock()
+	defer c.closeLk.RUnlock()
+	if c.closed {
+		return errClosed
+	}
+	atomic.AddUint64(count, 1)
+	return op()
+}
+
+func (c *countingDatastore) Get(key datastore.Key) (value []byte, err error) {
+	err = c.do(&c.gets, func() error {
+		value, err = c.Batching.Get(key)
+		return err
+	})
+	return value, err
+}
+
+func (c *countingDatastore) Has(key datastore.Key) (exists bool, err error) {
+	err = c.do(&c.has, func() error {
+		exists, err = c.Batching.Has(key)
+		return err
+	})
+	return exists, err
+}
+
+func (c *countingDatastore) GetSize(key datastore.Key) (size int, err error) {
+	err = c.do(&c.has, func() error {
+		size, err = c.Batching.GetSize(key)
+		return err
+	})
+	return size, err
+}
+
+func (c *countingDatastore) Put(key datastore.Key, value []byte) error {
+	return c.do(&c.puts, func() error {
+		return c.Batching.Put(key, value)
+	})
+}
+
+func (c *countingDatastore) Delete(key datastore.Key) error {
+	return c.do(&c.deletes, func() error {
+		return c.Batching.Delete(key)
+	})
+}
+
+func (c *countingDatastore) Query(q query.Query) (results query.Results, err error) {
+	err = c.do(&c.queries, func() error {
+		results, err = c.Batching.Query(q)
+		return err
+	})
+	return results, err
+}
+
+func (c *countingDatastore) Batch() (datastore.Batch, error) {
+	c.closeLk.RLock()
+	defer c.closeLk.RUnlock()
+	if c.closed {
+		return nil, errClosed
+	}
+	batch, err := c.Batching.Batch()
+	if err != nil {
+		return nil, err
+	}
+	return &countingBatch{Batch: batch, counts: c}, nil
+}
+
+type countingBatch struct {
+	datastore.Batch
+	counts *countingDatastore
+}
+
+func (b *countingBatch) Put(key datastore.Key, value []byte) error {
+	return b.counts.do(&b.counts.puts, func() error {
+		return b.Batch.Put(key, value)
+	})
+}
+
+func (b *countingBatch) Delete(key datastore.Key) error {
+	return b.counts.do(&b.counts.deletes, func() error {
+		return b.Batch.Delete(key)
+	})
+}
+
+func (b *countingBatch) Commit() error {
+	return b.counts.do(&b.counts.batches, func() error {
+		return b.Batch.Commit()
+	})
+}
@@ -0,0 +1,137 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// Report is the results of a run of dtbench
+type Report struct {
+	// Label names the run, such as the version being benchmarked
+	Label string `json:"label"`
+	// GoVersion is the Go version dtbench was built with
+	GoVersion string `json:"goVersion"`
+	// Started is when the run started
+	Started time.Time `json:"started"`
+	// Results are the results of each scenario, in the order they ran
+	Results []Result `json:"results"`
+}
+
+// NewReport returns an empty report for a run starting now
+func NewReport(label string) *Report {
+	return &Report{Label: label, GoVersion: runtime.Version(), Started: time.Now()}
+}
+
+// ReadReport reads a report written as JSON
+func ReadReport(r io.Reader) (*Report, error) {
+	var report Report
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return nil, xerrors.Errorf("reading report: %w", err)
+	}
+	return &report, nil
+}
+
+// WriteJSON writes the report as JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteText writes the report as a table
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCENARIO\tTRANSFERS\tFAILED\tRESTARTS\tMB/S\tP50\tP90\tP99\tMAX\tALLOCS\tALLOC MB\tDS OPS")
+	for _, result := range r.Results {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.2f\t%s\t%s\t%s\t%s\t%d\t%.1f\t%d\n",
+			result.Scenario, result.Transfers, result.Failed, result.Restarts, result.Throughput/(1<<20),
+			round(result.Latency.P50), round(result.Latency.P90), round(result.Latency.P99), round(result.Latency.Max),
+			result.Allocs, float64(result.AllocBytes)/(1<<20), result.DatastoreOps.Total())
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, result := range r.Results {
+		if result.Error != "" {
+			fmt.Fprintf(w, "%s: %s\n", result.Scenario, result.Error)
+		}
+	}
+	return nil
+}
+
+func round(d Duration) time.Duration {
+	return time.Duration(d).Round(time.Millisecond)
+}
+
+// Compare writes how each scenario in both reports changed from the old one
+// to the new one
+func Compare(w io.Writer, old, new *Report) error {
+	oldResults := make(map[string]Result, len(old.Results))
+	for _, result := range old.Results {
+		oldResults[result.Scenario] = result
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "SCENARIO\tMETRIC\t%s\t%s\tCHANGE\n", labelOr(old.Label, "OLD"), labelOr(new.Label, "NEW"))
+	for _, result := range new.Results {
+		oldResult, ok := oldResults[result.Scenario]
+		if !ok {
+			continue
+		}
+		metrics := []struct {
+			name     string
+			old, new float64
+			format   func(float64) string
+		}{
+			{"MB/s", oldResult.Throughput / (1 << 20), result.Throughput / (1 << 20), formatFloat},
+			{"p50", float64(oldResult.Latency.P50), float64(result.Latency.P50), formatDuration},
+			{"p99", float64(oldResult.Latency.P99), float64(result.Latency.P99), formatDuration},
+			{"allocs/transfer", perTransfer(oldResult.Allocs, oldResult), perTransfer(result.Allocs, result), formatFloat},
+			{"ds ops/transfer", perTransfer(oldResult.DatastoreOps.Total(), oldResult), perTransfer(result.DatastoreOps.Total(), result), formatFloat},
+			{"failed", float64(oldResult.Failed), float64(result.Failed), formatCount},
+		}
+		for _, metric := range metrics {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", result.Scenario, metric.name,
+				metric.format(metric.old), metric.format(metric.new), change(metric.old, metric.new))
+		}
+	}
+	return tw.Flush()
+}
+
+func labelOr(label, fallback string) string {
+	if label == "" {
+		return fallback
+	}
+	return label
+}
+
+func perTransfer(total uint64, result Result) float64 {
+	if result.Transfers == 0 {
+		return 0
+	}
+	return float64(total) / float64(result.Transfers)
+}
+
+func formatFloat(f float64) string {
+	return fmt.Sprintf("%.2f", f)
+}
+
+func formatCount(f float64) string {
+	return fmt.Sprintf("%.0f", f)
+}
+
+func formatDuration(f float64) string {
+	return round(Duration(f)).String()
+}
+
+func change(old, new float64) string {
+	if old == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", (new-old)/old*100)
+}
@@ -0,0 +1,423 @@
+package bench
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	tn "github.com/filecoin-project/go-data-transfer/benchmarks/testnet"
+	"github.com/filecoin-project/go-data-transfer/testutil"
+)
+
+var log = logging.Logger("dtbench")
+
+// Latency is the spread of how long transfers took, from opening the channel
+// to both sides completing it
+type Latency struct {
+	P50 Duration `json:"p50"`
+	P90 Duration `json:"p90"`
+	P99 Duration `json:"p99"`
+	Max Duration `json:"max"`
+}
+
+// Result is the outcome of running a scenario
+type Result struct {
+	// Scenario is the name of the scenario
+	Scenario string `json:"scenario"`
+	// Iterations is how many iterations ran
+	Iterations int `json:"iterations"`
+	// Transfers is how many transfers completed
+	Transfers int `json:"transfers"`
+	// Failed is how many transfers failed
+	Failed int `json:"failed"`
+	// Restarts is how many Restart events either side of a channel saw
+	Restarts int `json:"restarts"`
+	// Bytes is the size of the files transferred
+	Bytes uint64 `json:"bytes"`
+	// Duration is how long the transfers took, not counting setup
+	Duration Duration `json:"duration"`
+	// Throughput is the bytes transferred per second
+	Throughput float64 `json:"throughput"`
+	// Latency is the spread of how long transfers took
+	Latency Latency `json:"latency"`
+	// Allocs is how many heap allocations there were during the transfers
+	Allocs uint64 `json:"allocs"`
+	// AllocBytes is how many bytes were allocated during the transfers
+	AllocBytes uint64 `json:"allocBytes"`
+	// DatastoreOps are the operations on the managers' datastores during the
+	// transfers
+	DatastoreOps DatastoreOps `json:"datastoreOps"`
+	// Error is why the scenario stopped early, if it did
+	Error string `json:"error,omitempty"`
+}
+
+// RunScenario runs every iteration of a scenario, each on new nodes, and
+// returns the totals. Unset fields take the same defaults as in a scenario
+// file. An iteration that fails to set up or times out stops the scenario,
+// with the error in the result.
+func RunScenario(ctx context.Context, s Scenario) Result {
+	s.setDefaults()
+	result := Result{Scenario: s.Name}
+	var latencies []time.Duration
+	for i := 0; i < s.Iterations; i++ {
+		it, err := runIteration(ctx, s)
+		result.Transfers += it.transfers
+		result.Failed += it.failed
+		result.Restarts += it.restarts
+		result.Bytes += it.bytes
+		result.Duration += Duration(it.duration)
+		result.Allocs += it.allocs
+		result.AllocBytes += it.allocBytes
+		result.DatastoreOps.add(it.datastoreOps)
+		latencies = append(latencies, it.latencies...)
+		if err != nil {
+			result.Error = err.Error()
+			break
+		}
+		result.Iterations++
+	}
+	if result.Duration > 0 {
+		result.Throughput = float64(result.Bytes) / time.Duration(result.Duration).Seconds()
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	result.Latency = Latency{
+		P50: percentile(latencies, 0.5),
+		P90: percentile(latencies, 0.9),
+		P99: percentile(latencies, 0.99),
+		Max: percentile(latencies, 1),
+	}
+	return result
+}
+
+// percentile returns the pth percentile of sorted durations
+func percentile(sorted []time.Duration, p float64) Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return Duration(sorted[i])
+}
+
+type iteration struct {
+	transfers    int
+	failed       int
+	restarts     int
+	bytes        uint64
+	duration     time.Duration
+	latencies    []time.Duration
+	allocs       uint64
+	allocBytes   uint64
+	datastoreOps DatastoreOps
+}
+
+type transfer struct {
+	to      *node
+	root    cid.Cid
+	voucher datatransfer.Voucher
+}
+
+func runIteration(ctx context.Context, s Scenario) (iteration, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.Timeout))
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	var net tn.Network
+	if link := s.linkConfig(); link != nil {
+		net = tn.EmulatedNet(ctx, mn, *link)
+	} else {
+		net = tn.StreamNet(ctx, mn)
+	}
+
+	// the provider joins the network first, so it is the first peer of
+	// every link
+	provider, err := newNode(ctx, net, s.DiskDatastore)
+	if err != nil {
+		return iteration{}, xerrors.Errorf("starting provider: %w", err)
+	}
+	defer provider.close()
+	nodes := []*node{provider}
+	var transfers []transfer
+	for i := 0; i < s.Peers; i++ {
+		n, err := newNode(ctx, net, s.DiskDatastore)
+		if err != nil {
+			return iteration{}, xerrors.Errorf("starting peer: %w", err)
+		}
+		defer n.close()
+		nodes = append(nodes, n)
+		if err := provider.network.ConnectTo(ctx, n.peer); err != nil {
+			return iteration{}, xerrors.Errorf("connecting peer: %w", err)
+		}
+		for j := 0; j < s.Transfers; j++ {
+			root, err := provider.addDAG(ctx, s.DAG)
+			if err != nil {
+				return iteration{}, xerrors.Errorf("adding DAG: %w", err)
+			}
+			// vouchers are made here rather than as transfers start, as
+			// testutil's random bytes are not safe for concurrent use
+			transfers = append(transfers, transfer{to: n, root: root, voucher: testutil.NewFakeDTType()})
+		}
+	}
+
+	tr := newTracker(ctx, s, mn, provider.peer)
+	for _, n := range nodes {
+		tr.managers[n.peer] = n.manager
+		n.manager.SubscribeToEvents(tr.subscriber)
+	}
+	datastoreOps := func() DatastoreOps {
+		var ops DatastoreOps
+		for _, n := range nodes {
+			ops.add(n.dtDatastore.ops())
+		}
+		return ops
+	}
+
+	concurrency := s.Concurrency
+	if concurrency == 0 {
+		concurrency = len(transfers)
+	}
+	var it iteration
+	var lk sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	opsBefore := datastoreOps()
+	runtime.GC()
+	var memBefore runtime.MemStats
+	runtime.ReadMemStats(&memBefore)
+	start := time.Now()
+	for _, t := range transfers {
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(t transfer) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			latency, err := tr.run(ctx, provider, t)
+			lk.Lock()
+			defer lk.Unlock()
+			if err != nil {
+				log.Warnf("transfer to %s failed: %s", t.to.peer, err)
+				return
+			}
+			it.transfers++
+			it.bytes += s.DAG.Size
+			it.latencies = append(it.latencies, latency)
+		}(t)
+	}
+	wg.Wait()
+	it.duration = time.Since(start)
+	var memAfter runtime.MemStats
+	runtime.ReadMemStats(&memAfter)
+	it.allocs = memAfter.Mallocs - memBefore.Mallocs
+	it.allocBytes = memAfter.TotalAlloc - memBefore.TotalAlloc
+	it.datastoreOps = datastoreOps().since(opsBefore)
+	it.restarts = tr.restartCount()
+	// transfers that never started failed too
+	it.failed = len(transfers) - it.transfers
+	if ctx.Err() != nil {
+		return it, xerrors.Errorf("timed out after %s", time.Duration(s.Timeout))
+	}
+	return it, nil
+}
+
+// tracker follows the channels of an iteration to completion, and takes the
+// links down for restarts as data is received
+type tracker struct {
+	ctx      context.Context
+	scenario Scenario
+	mn       mocknet.Mocknet
+	provider peer.ID
+	managers map[peer.ID]datatransfer.Manager
+
+	lk        sync.Mutex
+	channels  map[datatransfer.ChannelID]*channelProgress
+	restarts  int
+	received  map[peer.ID]uint64
+	linkDrops map[peer.ID]int
+}
+
+type channelProgress struct {
+	completed map[peer.ID]struct{}
+	received  uint64
+	err       error
+	done      chan struct{}
+}
+
+func newTracker(ctx context.Context, s Scenario, mn mocknet.Mocknet, provider peer.ID) *tracker {
+	return &tracker{
+		ctx:       ctx,
+		scenario:  s,
+		mn:        mn,
+		provider:  provider,
+		managers:  make(map[peer.ID]datatransfer.Manager),
+		channels:  make(map[datatransfer.ChannelID]*channelProgress),
+		received:  make(map[peer.ID]uint64),
+		linkDrops: make(map[peer.ID]int),
+	}
+}
+
+// channel returns the progress of a channel, which may be seen by the
+// subscriber before the channel is opened. The lock must be held.
+func (t *tracker) channel(chid datatransfer.ChannelID) *channelProgress {
+	ch, ok := t.channels[chid]
+	if !ok {
+		ch = &channelProgress{completed: make(map[peer.ID]struct{}), done: make(chan struct{})}
+		t.channels[chid] = ch
+	}
+	return ch
+}
+
+func (t *tracker) restartCount() int {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	return t.restarts
+}
+
+func (t *tracker) subscriber(event datatransfer.Event, state datatransfer.ChannelState) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	ch := t.channel(state.ChannelID())
+	select {
+	case <-ch.done:
+		return
+	default:
+	}
+	switch event.Code {
+	case datatransfer.Restart:
+		t.restarts++
+	case datatransfer.DataReceived:
+		if state.Received() > ch.received {
+			t.received[state.SelfPeer()] += state.Received() - ch.received
+			ch.received = state.Received()
+			t.maybeDropLink(state.SelfPeer())
+		}
+	}
+	switch state.Status() {
+	case datatransfer.Completed:
+		ch.completed[state.SelfPeer()] = struct{}{}
+		if len(ch.completed) == 2 {
+			close(ch.done)
+		}
+	case datatransfer.Failed, datatransfer.Cancelled:
+		// a restart sent just as the other side completed is rejected, but the
+		// data all arrived
+		if len(ch.completed) == 0 {
+			ch.err = xerrors.Errorf("channel %s: %s", datatransfer.Statuses[state.Status()], state.Message())
+		}
+		close(ch.done)
+	}
+}
+
+// maybeDropLink takes the link between the provider and a peer down if the
+// peer has received enough for its next restart. The lock must be held.
+func (t *tracker) maybeDropLink(p peer.ID) {
+	if p == t.provider || t.linkDrops[p] >= t.scenario.Restarts {
+		return
+	}
+	total := t.scenario.DAG.Size * uint64(t.scenario.Transfers)
+	threshold := total * uint64(t.linkDrops[p]+1) / uint64(t.scenario.Restarts+1)
+	if t.received[p] < threshold {
+		return
+	}
+	t.linkDrops[p]++
+	go t.dropLink(p)
+}
+
+func (t *tracker) dropLink(p peer.ID) {
+	if err := t.mn.UnlinkPeers(t.provider, p); err != nil {
+		log.Warnf("unlinking %s: %s", p, err)
+		return
+	}
+	if err := t.mn.DisconnectPeers(t.provider, p); err != nil {
+		log.Warnf("disconnecting %s: %s", p, err)
+	}
+	select {
+	case <-t.ctx.Done():
+		return
+	case <-time.After(time.Duration(t.scenario.Downtime)):
+	}
+	if _, err := t.mn.LinkPeers(t.provider, p); err != nil {
+		log.Warnf("linking %s: %s", p, err)
+		return
+	}
+	if _, err := t.mn.ConnectPeers(t.provider, p); err != nil {
+		log.Warnf("connecting %s: %s", p, err)
+		return
+	}
+	t.restartChannels(p)
+}
+
+// restartChannels restarts the unfinished channels between the provider and
+// a peer from the side that opened them, as a client would after
+// reconnecting. A channel whose data was all sent before the link went down
+// never sees a network error, so it would otherwise wait forever for the
+// rest of its blocks.
+// A channel that one side completed before the link went down is finished
+// instead: all its data arrived, the other side just never heard so, and
+// the completed side would reject a restart.
+func (t *tracker) restartChannels(p peer.ID) {
+	t.lk.Lock()
+	var chids []datatransfer.ChannelID
+	for chid, ch := range t.channels {
+		if chid.Initiator != p && chid.Responder != p {
+			continue
+		}
+		select {
+		case <-ch.done:
+			continue
+		default:
+		}
+		if len(ch.completed) > 0 {
+			close(ch.done)
+			continue
+		}
+		chids = append(chids, chid)
+	}
+	t.lk.Unlock()
+	for _, chid := range chids {
+		if err := t.managers[chid.Initiator].RestartDataTransferChannel(t.ctx, chid); err != nil {
+			log.Warnf("restarting channel %s: %s", chid, err)
+		}
+	}
+}
+
+// run runs one transfer, returning how long it took
+func (t *tracker) run(ctx context.Context, provider *node, tr transfer) (time.Duration, error) {
+	start := time.Now()
+	var chid datatransfer.ChannelID
+	var err error
+	if t.scenario.Pull {
+		chid, err = tr.to.manager.OpenPullDataChannel(ctx, provider.peer, tr.voucher, tr.root, testutil.AllSelector())
+	} else {
+		chid, err = provider.manager.OpenPushDataChannel(ctx, tr.to.peer, tr.voucher, tr.root, testutil.AllSelector())
+	}
+	if err != nil {
+		return 0, err
+	}
+	t.lk.Lock()
+	ch := t.channel(chid)
+	t.lk.Unlock()
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-ch.done:
+	}
+	return time.Since(start), ch.err
+}
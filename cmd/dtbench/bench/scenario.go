@@ -0,0 +1,185 @@
+package bench
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	tn "github.com/filecoin-project/go-data-transfer/benchmarks/testnet"
+)
+
+// Duration is a time.Duration written as a string, such as "1.5s", in
+// scenarios and reports
+type Duration time.Duration
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON reads a duration written as a string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// DAGShape is how the DAG of each transfer is built
+type DAGShape struct {
+	// Size is the size in bytes of the file the DAG holds
+	Size uint64 `json:"size"`
+	// ChunkSize is the size in bytes of the leaf blocks
+	ChunkSize int64 `json:"chunkSize"`
+	// LinksPerLevel is the most links an intermediate block has
+	LinksPerLevel int `json:"linksPerLevel"`
+	// RawLeaves stores the leaves as raw blocks rather than UnixFS nodes
+	RawLeaves bool `json:"rawLeaves"`
+}
+
+// Link is a custom network link, the same in both directions
+type Link struct {
+	// Latency is the one way delay
+	Latency Duration `json:"latency"`
+	// Jitter is the most random delay added to the latency
+	Jitter Duration `json:"jitter"`
+	// Bandwidth is in bytes per second, or unlimited if zero
+	Bandwidth float64 `json:"bandwidth"`
+	// PacketLoss is the fraction of packets lost, from 0 to 1
+	PacketLoss float64 `json:"packetLoss"`
+}
+
+// Scenario is one benchmark. A provider node holds a DAG for every transfer,
+// and each of the other peers receives Transfers of them, pushed by the
+// provider or pulled from it.
+type Scenario struct {
+	// Name identifies the scenario in reports
+	Name string `json:"name"`
+	// DAG is the shape of each transfer's DAG
+	DAG DAGShape `json:"dag"`
+	// Peers is how many peers receive data from the provider
+	Peers int `json:"peers"`
+	// Transfers is how many transfers each peer receives
+	Transfers int `json:"transfers"`
+	// Concurrency is the most transfers in progress at once, or all of them
+	// if zero
+	Concurrency int `json:"concurrency"`
+	// Pull has the peers pull data rather than the provider push it
+	Pull bool `json:"pull"`
+	// Restarts is how many times the link between the provider and each peer
+	// goes down, spread evenly over the data the peer receives. Initiators
+	// restart their channels when the peer reconnects.
+	Restarts int `json:"restarts"`
+	// Downtime is how long the link stays down for each restart
+	Downtime Duration `json:"downtime"`
+	// Network is the network profile: "" for an unlimited mocknet, or "lan",
+	// "transatlantic" or "flaky-mobile"
+	Network string `json:"network"`
+	// Link is a custom network link, used instead of Network if it is set
+	Link *Link `json:"link,omitempty"`
+	// DiskDatastore keeps each node's datastore in badger on disk rather
+	// than in memory
+	DiskDatastore bool `json:"diskDatastore"`
+	// Iterations is how many times to run the scenario, each on new nodes
+	Iterations int `json:"iterations"`
+	// Timeout is the longest one iteration may take
+	Timeout Duration `json:"timeout"`
+}
+
+var networkProfiles = map[string]func() tn.LinkConfig{
+	"lan":           tn.LAN,
+	"transatlantic": tn.Transatlantic,
+	"flaky-mobile":  tn.FlakyMobile,
+}
+
+// LoadScenarios reads a JSON list of scenarios, filling in defaults
+func LoadScenarios(r io.Reader) ([]Scenario, error) {
+	var scenarios []Scenario
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&scenarios); err != nil {
+		return nil, xerrors.Errorf("reading scenarios: %w", err)
+	}
+	names := make(map[string]struct{}, len(scenarios))
+	for i := range scenarios {
+		s := &scenarios[i]
+		s.setDefaults()
+		if err := s.validate(); err != nil {
+			return nil, xerrors.Errorf("scenario %d (%s): %w", i, s.Name, err)
+		}
+		if _, ok := names[s.Name]; ok {
+			return nil, xerrors.Errorf("scenario %d: duplicate name %s", i, s.Name)
+		}
+		names[s.Name] = struct{}{}
+	}
+	return scenarios, nil
+}
+
+func (s *Scenario) setDefaults() {
+	if s.DAG.ChunkSize == 0 {
+		s.DAG.ChunkSize = 1 << 20
+	}
+	if s.DAG.LinksPerLevel == 0 {
+		s.DAG.LinksPerLevel = 1024
+	}
+	if s.Peers == 0 {
+		s.Peers = 1
+	}
+	if s.Transfers == 0 {
+		s.Transfers = 1
+	}
+	if s.Downtime == 0 {
+		s.Downtime = Duration(100 * time.Millisecond)
+	}
+	if s.Iterations == 0 {
+		s.Iterations = 1
+	}
+	if s.Timeout == 0 {
+		s.Timeout = Duration(5 * time.Minute)
+	}
+}
+
+func (s *Scenario) validate() error {
+	if s.Name == "" {
+		return xerrors.New("no name")
+	}
+	if s.DAG.Size == 0 {
+		return xerrors.New("no DAG size")
+	}
+	if s.Peers < 0 || s.Transfers < 0 || s.Concurrency < 0 || s.Restarts < 0 || s.Iterations < 0 {
+		return xerrors.New("negative count")
+	}
+	if s.Link == nil && s.Network != "" {
+		if _, ok := networkProfiles[s.Network]; !ok {
+			return xerrors.Errorf("unknown network %s", s.Network)
+		}
+	}
+	return nil
+}
+
+// linkConfig returns the emulated link between the provider and each peer,
+// or nil for an unlimited mocknet
+func (s *Scenario) linkConfig() *tn.LinkConfig {
+	if s.Link != nil {
+		config := tn.Symmetric(tn.LinkProfile{
+			Latency:    time.Duration(s.Link.Latency),
+			Jitter:     time.Duration(s.Link.Jitter),
+			Bandwidth:  s.Link.Bandwidth,
+			PacketLoss: s.Link.PacketLoss,
+		})
+		return &config
+	}
+	if profile, ok := networkProfiles[s.Network]; ok {
+		config := profile()
+		return &config
+	}
+	return nil
+}
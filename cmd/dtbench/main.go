@@ -0,0 +1,30 @@
+// dtbench runs benchmark scenarios against data transfer managers in this
+// process, and writes reports that can be compared across versions:
+//
+//	dtbench run -label v1.2.0 -out old.json cmd/dtbench/scenarios.json
+//	dtbench run -label main -out new.json cmd/dtbench/scenarios.json
+//	dtbench compare old.json new.json
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/filecoin-project/go-data-transfer/cmd/dtbench/bench"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		cancel()
+	}()
+	if err := bench.Run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "dtbench: %s\n", err)
+		os.Exit(1)
+	}
+}
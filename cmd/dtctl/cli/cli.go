@@ -0,0 +1,461 @@
+package cli
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	badgerds "github.com/ipfs/go-ds-badger"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/channels"
+	"github.com/filecoin-project/go-data-transfer/cidlists"
+	"github.com/filecoin-project/go-data-transfer/registry"
+)
+
+// Types are the voucher and voucher result types that dtctl decodes and shows
+// as JSON. Vouchers and voucher results of other types are shown as raw CBOR.
+type Types struct {
+	Vouchers       []datatransfer.Registerable
+	VoucherResults []datatransfer.Registerable
+}
+
+const usage = `usage: dtctl [flags] <command> [command flags] [args]
+
+Inspects the channels of a data transfer module that is not running.
+
+commands:
+  version                  print the version of the channel state
+  list                     list channels
+  show <channel id>        show the full state of a channel
+  cids <channel id>        list the CIDs a channel has received
+  seen <channel id>        list the CIDs a channel has seen
+  force-fail <channel id>  move a channel to the Failed status (needs -write)
+  purge <channel id>       delete a channel and its CIDs (needs -write)
+  export <file>            export all channels to a file (needs -write)
+
+Channel IDs are written <initiator>-<responder>-<transfer id>.
+
+flags:
+`
+
+// ErrUsage is returned when dtctl is run with invalid arguments
+var ErrUsage = xerrors.New("invalid arguments")
+
+// Run runs dtctl with the given command line arguments, not including the
+// program name
+func Run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer, types Types) error {
+	fs := flag.NewFlagSet("dtctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dsPath := fs.String("datastore", "", "path to the badger datastore of the node")
+	prefix := fs.String("prefix", "/", "key the data transfer module's datastore is namespaced under")
+	cidListsDir := fs.String("cidlists", "", "path to the cid lists directory of the node")
+	write := fs.Bool("write", false, "open the datastore and cid lists for writing")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return ErrUsage
+	}
+	if fs.NArg() == 0 || *dsPath == "" || *cidListsDir == "" {
+		fs.Usage()
+		return ErrUsage
+	}
+
+	command, commandArgs := fs.Arg(0), fs.Args()[1:]
+	handler, ok := commands[command]
+	if !ok {
+		fs.Usage()
+		return ErrUsage
+	}
+	if handler.write && !*write {
+		return xerrors.Errorf("%s changes the datastore, so it needs -write", command)
+	}
+
+	e, err := open(*dsPath, *prefix, *cidListsDir, *write, types)
+	if err != nil {
+		return err
+	}
+	e.ctx = ctx
+	e.out = stdout
+	e.errOut = stderr
+	runErr := handler.run(e, command, commandArgs)
+	if err := e.close(); err != nil && runErr == nil {
+		return err
+	}
+	return runErr
+}
+
+type command struct {
+	write bool
+	run   func(e *env, name string, args []string) error
+}
+
+var commands = map[string]command{
+	"version":    {run: runVersion},
+	"list":       {run: runList},
+	"show":       {run: runShow},
+	"cids":       {run: runCids},
+	"seen":       {run: runSeen},
+	"force-fail": {write: true, run: runForceFail},
+	"purge":      {write: true, run: runPurge},
+	"export":     {write: true, run: runExport},
+}
+
+// env is what commands run with
+type env struct {
+	ctx            context.Context
+	out            io.Writer
+	errOut         io.Writer
+	badger         *badgerds.Datastore
+	cidLists       cidlists.CIDLists
+	inspector      *channels.Inspector
+	vouchers       *registry.Registry
+	voucherResults *registry.Registry
+}
+
+func open(dsPath string, prefix string, cidListsDir string, write bool, types Types) (*env, error) {
+	e := &env{
+		vouchers:       registry.NewRegistry(),
+		voucherResults: registry.NewRegistry(),
+	}
+	for _, voucherType := range types.Vouchers {
+		if err := e.vouchers.Register(voucherType, nil); err != nil {
+			return nil, err
+		}
+	}
+	for _, resultType := range types.VoucherResults {
+		if err := e.voucherResults.Register(resultType, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	var cidListsOptions []cidlists.Option
+	if !write {
+		cidListsOptions = append(cidListsOptions, cidlists.ReadOnly())
+	}
+	cidLists, err := cidlists.NewCIDLists(cidListsDir, cidListsOptions...)
+	if err != nil {
+		return nil, err
+	}
+	e.cidLists = cidLists
+
+	opts := badgerds.DefaultOptions
+	opts.ReadOnly = !write
+	// truncating a corrupt value log is a write
+	opts.Truncate = write
+	e.badger, err = badgerds.NewDatastore(dsPath, &opts)
+	if err != nil {
+		return nil, xerrors.Errorf("opening datastore: %w", err)
+	}
+	var ds datastore.Batching = e.badger
+	if prefix != "" && prefix != "/" {
+		ds = namespace.Wrap(ds, datastore.NewKey(prefix))
+	}
+	e.inspector = channels.NewInspector(ds, cidLists, e.vouchers.Decoder, e.voucherResults.Decoder)
+	return e, nil
+}
+
+func (e *env) close() error {
+	return e.badger.Close()
+}
+
+func runVersion(e *env, name string, args []string) error {
+	if len(args) != 0 {
+		return ErrUsage
+	}
+	version, err := e.inspector.Version()
+	if err != nil {
+		return err
+	}
+	if version == "" {
+		version = "none"
+	}
+	fmt.Fprintf(e.out, "datastore version: %s\n", version)
+	fmt.Fprintf(e.out, "current version:   %s\n", channels.CurrentVersion)
+	return nil
+}
+
+func runList(e *env, name string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.errOut)
+	statusName := fs.String("status", "", "only list channels with this status, eg Ongoing")
+	peerStr := fs.String("peer", "", "only list channels with this peer as initiator or responder")
+	baseCidStr := fs.String("basecid", "", "only list channels for this base CID")
+	voucherType := fs.String("voucher-type", "", "only list channels with a voucher of this type")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return ErrUsage
+	}
+
+	var filters []func(channels.ChannelRecord) bool
+	if *statusName != "" {
+		status, err := parseStatus(*statusName)
+		if err != nil {
+			return err
+		}
+		filters = append(filters, func(record channels.ChannelRecord) bool {
+			return record.Status() == status
+		})
+	}
+	if *peerStr != "" {
+		p, err := peer.Decode(*peerStr)
+		if err != nil {
+			return xerrors.Errorf("parsing peer: %w", err)
+		}
+		filters = append(filters, func(record channels.ChannelRecord) bool {
+			chid := record.ChannelID()
+			return chid.Initiator == p || chid.Responder == p
+		})
+	}
+	if *baseCidStr != "" {
+		baseCid, err := cid.Decode(*baseCidStr)
+		if err != nil {
+			return xerrors.Errorf("parsing base cid: %w", err)
+		}
+		filters = append(filters, func(record channels.ChannelRecord) bool {
+			return record.BaseCID().Equals(baseCid)
+		})
+	}
+	if *voucherType != "" {
+		filters = append(filters, func(record channels.ChannelRecord) bool {
+			for _, voucher := range record.EncodedVouchers {
+				if string(voucher.Type) == *voucherType {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	records, err := e.inspector.Channels()
+	if err != nil {
+		return err
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ChannelID().String() < records[j].ChannelID().String()
+	})
+
+	tw := tabwriter.NewWriter(e.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHANNEL\tSTATUS\tBASE CID\tSENT\tRECEIVED\tVOUCHER TYPE")
+records:
+	for _, record := range records {
+		for _, filter := range filters {
+			if !filter(record) {
+				continue records
+			}
+		}
+		var voucherType datatransfer.TypeIdentifier
+		if len(record.EncodedVouchers) > 0 {
+			voucherType = record.EncodedVouchers[len(record.EncodedVouchers)-1].Type
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n", record.ChannelID(), datatransfer.Statuses[record.Status()],
+			record.BaseCID(), record.Sent(), record.Received(), voucherType)
+	}
+	return tw.Flush()
+}
+
+func runShow(e *env, name string, args []string) error {
+	chid, err := channelIDArg(args)
+	if err != nil {
+		return err
+	}
+	record, err := e.inspector.Channel(chid)
+	if err != nil {
+		return err
+	}
+	receivedCids, err := e.cidLists.ListLen(chid)
+	if err != nil && !os.IsNotExist(err) {
+		return xerrors.Errorf("reading received cids: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(e.out, 0, 4, 1, ' ', 0)
+	field := func(name string, value interface{}) {
+		fmt.Fprintf(tw, "%s:\t%v\n", name, value)
+	}
+	field("Channel", chid)
+	field("Self peer", record.SelfPeer())
+	field("Status", datatransfer.Statuses[record.Status()])
+	field("Message", record.Message())
+	field("Base CID", record.BaseCID())
+	field("Sender", record.Sender())
+	field("Recipient", record.Recipient())
+	field("Total size", record.TotalSize())
+	field("Queued", record.Queued())
+	field("Sent", record.Sent())
+	field("Received", record.Received())
+	field("Received CIDs", receivedCids)
+	field("Remove timeout", record.RemoveTimeout())
+	field("Priority", record.Priority())
+	field("Peer addresses", record.PeerAddrs())
+	field("Error code", record.ErrorCode())
+	field("Retryable", record.Retryable())
+	field("Reason", fmt.Sprintf("%d %s", record.Reason().Code, record.Reason().Text))
+	field("Restart attempts", record.RestartAttempts())
+	field("Last restart error", record.LastRestartError())
+	labels := record.Labels()
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field("Label "+key, labels[key])
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(e.out, "Vouchers:")
+	for _, voucher := range record.EncodedVouchers {
+		fmt.Fprintf(e.out, "  %s: %s\n", voucher.Type, showEncoded(e.vouchers, voucher))
+	}
+	fmt.Fprintln(e.out, "Voucher results:")
+	for _, result := range record.EncodedVoucherResults {
+		fmt.Fprintf(e.out, "  %s: %s\n", result.Type, showEncoded(e.voucherResults, result))
+	}
+	return nil
+}
+
+// showEncoded returns a voucher or voucher result as JSON if its type is
+// registered and it decodes, or as hex encoded CBOR otherwise
+func showEncoded(types *registry.Registry, encoded channels.EncodedValue) string {
+	raw := "cbor:" + hex.EncodeToString(encoded.Raw)
+	decoder, ok := types.Decoder(encoded.Type)
+	if !ok {
+		return raw
+	}
+	decoded, err := decoder.DecodeFromCbor(encoded.Raw)
+	if err != nil {
+		return raw
+	}
+	js, err := json.Marshal(decoded)
+	if err != nil {
+		return raw
+	}
+	return string(js)
+}
+
+func runCids(e *env, name string, args []string) error {
+	chid, err := channelIDArg(args)
+	if err != nil {
+		return err
+	}
+	err = e.cidLists.IterateList(chid, func(k cid.Cid) error {
+		_, err := fmt.Fprintln(e.out, k)
+		return err
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func runSeen(e *env, name string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.errOut)
+	eventName := fs.String("event", "", "only list the CIDs seen for this event: DataQueued, DataSent or DataReceived")
+	if err := fs.Parse(args); err != nil {
+		return ErrUsage
+	}
+	chid, err := channelIDArg(fs.Args())
+	if err != nil {
+		return err
+	}
+	events := []datatransfer.EventCode{datatransfer.DataQueued, datatransfer.DataSent, datatransfer.DataReceived}
+	if *eventName != "" {
+		evt, ok := parseEvent(*eventName)
+		if !ok {
+			return xerrors.Errorf("unknown event %q", *eventName)
+		}
+		events = []datatransfer.EventCode{evt}
+	}
+	for _, evt := range events {
+		seen, err := e.inspector.SeenCIDs(chid, evt)
+		if err != nil {
+			return err
+		}
+		for _, k := range seen {
+			fmt.Fprintf(e.out, "%s\t%s\n", datatransfer.Events[evt], k)
+		}
+	}
+	return nil
+}
+
+func runForceFail(e *env, name string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.errOut)
+	message := fs.String("message", "failed by dtctl", "message to record on the channel")
+	if err := fs.Parse(args); err != nil {
+		return ErrUsage
+	}
+	chid, err := channelIDArg(fs.Args())
+	if err != nil {
+		return err
+	}
+	return e.inspector.ForceFail(chid, *message)
+}
+
+func runPurge(e *env, name string, args []string) error {
+	chid, err := channelIDArg(args)
+	if err != nil {
+		return err
+	}
+	return e.inspector.Purge(chid)
+}
+
+func runExport(e *env, name string, args []string) (err error) {
+	if len(args) != 1 {
+		return ErrUsage
+	}
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	return e.inspector.ExportChannels(e.ctx, f)
+}
+
+// channelIDArg parses the only argument as a channel ID, in the form
+// ChannelID.String writes
+func channelIDArg(args []string) (datatransfer.ChannelID, error) {
+	if len(args) != 1 {
+		return datatransfer.ChannelID{}, ErrUsage
+	}
+	return datatransfer.ParseChannelID(args[0])
+}
+
+func parseStatus(name string) (datatransfer.Status, error) {
+	for status, statusName := range datatransfer.Statuses {
+		if strings.EqualFold(statusName, name) {
+			return status, nil
+		}
+	}
+	return 0, xerrors.Errorf("unknown status %q", name)
+}
+
+func parseEvent(name string) (datatransfer.EventCode, bool) {
+	for evt, eventName := range datatransfer.Events {
+		if strings.EqualFold(eventName, name) {
+			return evt, true
+		}
+	}
+	return 0, false
+}
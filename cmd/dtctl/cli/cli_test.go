@@ -0,0 +1,178 @@
+package cli_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	badgerds "github.com/ipfs/go-ds-badger"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/channels"
+	"github.com/filecoin-project/go-data-transfer/cidlists"
+	"github.com/filecoin-project/go-data-transfer/cmd/dtctl/cli"
+	"github.com/filecoin-project/go-data-transfer/encoding"
+	"github.com/filecoin-project/go-data-transfer/testutil"
+)
+
+func TestDtctl(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	dsDir := tempDir(t)
+	cidListsDir := tempDir(t)
+	peers := testutil.GenerateValidPeers(t, 3)
+	baseCids := testutil.GenerateCids(2)
+	blocks := testutil.GenerateCids(2)
+	fv := &testutil.FakeDTType{Data: "hello"}
+	selector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+
+	// set up the channels of a node, then stop it
+	badger, err := badgerds.NewDatastore(dsDir, &badgerds.DefaultOptions)
+	require.NoError(t, err)
+	ds := namespace.Wrap(badger, datastore.NewKey("/datatransfer"))
+	cidLists, err := cidlists.NewCIDLists(cidListsDir)
+	require.NoError(t, err)
+	channelList, err := channels.New(ds, cidLists, func(datatransfer.Event, datatransfer.ChannelState) {}, fakeDecoder, fakeDecoder, &fakeEnv{}, peers[0])
+	require.NoError(t, err)
+	require.NoError(t, channelList.Start(ctx))
+	chid1, err := channelList.CreateNew(peers[0], 1, baseCids[0], selector, fv, peers[0], peers[1], peers[0], datatransfer.ChannelOptions{})
+	require.NoError(t, err)
+	require.NoError(t, channelList.Accept(chid1))
+	for _, block := range blocks {
+		require.NoError(t, channelList.DataReceived(chid1, block, 10))
+	}
+	chid2, err := channelList.CreateNew(peers[0], 2, baseCids[1], selector, fv, peers[0], peers[2], peers[0], datatransfer.ChannelOptions{})
+	require.NoError(t, err)
+	for _, chid := range []datatransfer.ChannelID{chid1, chid2} {
+		_, err := channelList.GetByID(ctx, chid)
+		require.NoError(t, err)
+	}
+	require.NoError(t, channelList.Stop(ctx))
+	require.NoError(t, badger.Close())
+
+	run := func(t *testing.T, types cli.Types, args ...string) (string, error) {
+		stdout := new(bytes.Buffer)
+		stderr := new(bytes.Buffer)
+		args = append([]string{"-datastore", dsDir, "-prefix", "/datatransfer", "-cidlists", cidListsDir}, args...)
+		err := cli.Run(ctx, args, stdout, stderr, types)
+		return stdout.String(), err
+	}
+
+	t.Run("version", func(t *testing.T) {
+		out, err := run(t, cli.Types{}, "version")
+		require.NoError(t, err)
+		require.Contains(t, out, "datastore version: "+channels.CurrentVersion)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		out, err := run(t, cli.Types{}, "list")
+		require.NoError(t, err)
+		require.Contains(t, out, chid1.String())
+		require.Contains(t, out, chid2.String())
+
+		out, err = run(t, cli.Types{}, "list", "-status", "ongoing")
+		require.NoError(t, err)
+		require.Contains(t, out, chid1.String())
+		require.NotContains(t, out, chid2.String())
+
+		out, err = run(t, cli.Types{}, "list", "-peer", peers[2].String())
+		require.NoError(t, err)
+		require.NotContains(t, out, chid1.String())
+		require.Contains(t, out, chid2.String())
+
+		out, err = run(t, cli.Types{}, "list", "-basecid", baseCids[0].String(), "-voucher-type", string(fv.Type()))
+		require.NoError(t, err)
+		require.Contains(t, out, chid1.String())
+		require.NotContains(t, out, chid2.String())
+	})
+
+	t.Run("show", func(t *testing.T) {
+		out, err := run(t, cli.Types{}, "show", chid1.String())
+		require.NoError(t, err)
+		require.Contains(t, out, "Ongoing")
+		require.Contains(t, out, string(fv.Type())+": cbor:")
+
+		out, err = run(t, cli.Types{Vouchers: []datatransfer.Registerable{&testutil.FakeDTType{}}}, "show", chid1.String())
+		require.NoError(t, err)
+		require.Contains(t, out, `{"Data":"hello"}`)
+
+		_, err = run(t, cli.Types{}, "show", chid1.String()+"0")
+		require.Error(t, err)
+	})
+
+	t.Run("cids", func(t *testing.T) {
+		out, err := run(t, cli.Types{}, "cids", chid1.String())
+		require.NoError(t, err)
+		require.Equal(t, blocks[0].String()+"\n"+blocks[1].String()+"\n", out)
+
+		out, err = run(t, cli.Types{}, "seen", "-event", "DataReceived", chid1.String())
+		require.NoError(t, err)
+		require.Len(t, strings.Split(strings.TrimSpace(out), "\n"), 2)
+		require.Contains(t, out, blocks[0].String())
+	})
+
+	t.Run("writes need -write", func(t *testing.T) {
+		_, err := run(t, cli.Types{}, "purge", chid2.String())
+		require.Error(t, err)
+		out, err := run(t, cli.Types{}, "list")
+		require.NoError(t, err)
+		require.Contains(t, out, chid2.String())
+	})
+
+	t.Run("write mode", func(t *testing.T) {
+		exportPath := filepath.Join(tempDir(t), "channels.export")
+		_, err := run(t, cli.Types{}, "-write", "export", exportPath)
+		require.NoError(t, err)
+		info, err := os.Stat(exportPath)
+		require.NoError(t, err)
+		require.NotZero(t, info.Size())
+
+		_, err = run(t, cli.Types{}, "-write", "force-fail", "-message", "stuck", chid1.String())
+		require.NoError(t, err)
+		_, err = run(t, cli.Types{}, "-write", "purge", chid2.String())
+		require.NoError(t, err)
+
+		out, err := run(t, cli.Types{}, "list")
+		require.NoError(t, err)
+		require.Contains(t, out, chid1.String())
+		require.Contains(t, out, "Failed")
+		require.NotContains(t, out, chid2.String())
+	})
+}
+
+func fakeDecoder(identifier datatransfer.TypeIdentifier) (encoding.Decoder, bool) {
+	if identifier == testutil.NewFakeDTType().Type() {
+		decoder, _ := encoding.NewDecoder(testutil.NewFakeDTType())
+		return decoder, true
+	}
+	return nil, false
+}
+
+type fakeEnv struct{}
+
+func (fakeEnv) Protect(id peer.ID, tag string)             {}
+func (fakeEnv) Unprotect(id peer.ID, tag string) bool      { return false }
+func (fakeEnv) ID() peer.ID                                { return peer.ID("") }
+func (fakeEnv) CleanupChannel(chid datatransfer.ChannelID) {}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "dtctl")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return dir
+}
@@ -0,0 +1,22 @@
+// dtctl inspects and repairs the channels of a data transfer module that is
+// not running, by opening its datastore and cid lists directly.
+//
+// This build shows every voucher as raw CBOR. To show an application's
+// vouchers as JSON, build a copy of this command that passes the
+// application's voucher types to cli.Run.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/filecoin-project/go-data-transfer/cmd/dtctl/cli"
+)
+
+func main() {
+	if err := cli.Run(context.Background(), os.Args[1:], os.Stdout, os.Stderr, cli.Types{}); err != nil {
+		fmt.Fprintf(os.Stderr, "dtctl: %s\n", err)
+		os.Exit(1)
+	}
+}
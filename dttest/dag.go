@@ -0,0 +1,73 @@
+package dttest
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	files "github.com/ipfs/go-ipfs-files"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	ihelper "github.com/ipfs/go-unixfs/importer/helpers"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-data-transfer/testutil"
+)
+
+// DAG is a UnixFS DAG in a blockstore
+type DAG struct {
+	// Root is the link to the root of the DAG
+	Root ipld.Link
+	// Data is the file the DAG holds
+	Data []byte
+}
+
+// DAGParams is how a random DAG is built
+type DAGParams struct {
+	// ChunkSize is the size of the leaf blocks
+	ChunkSize int64
+	// LinksPerLevel is the most links an intermediate block has
+	LinksPerLevel int
+	// RawLeaves stores leaves as raw blocks rather than UnixFS nodes
+	RawLeaves bool
+}
+
+// DefaultDAGParams builds DAGs of raw 1KB leaves, with up to 1024 links per
+// intermediate block
+var DefaultDAGParams = DAGParams{ChunkSize: 1 << 10, LinksPerLevel: 1024, RawLeaves: true}
+
+// RandomDAG adds a UnixFS DAG of size random bytes to the DAG service
+func RandomDAG(ctx context.Context, t testing.TB, dagService ipldformat.DAGService, size uint64, params DAGParams) DAG {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	bufferedDS := ipldformat.NewBufferedDAG(ctx, dagService)
+	builderParams := ihelper.DagBuilderParams{
+		Maxlinks:  params.LinksPerLevel,
+		RawLeaves: params.RawLeaves,
+		Dagserv:   bufferedDS,
+	}
+	db, err := builderParams.New(chunker.NewSizeSplitter(files.NewReaderFile(bytes.NewReader(data)), params.ChunkSize))
+	require.NoError(t, err)
+	nd, err := balanced.Layout(db)
+	require.NoError(t, err)
+	require.NoError(t, bufferedDS.Commit())
+	return DAG{Root: cidlink.Link{Cid: nd.Cid()}, Data: data}
+}
+
+// RandomDAG adds a UnixFS DAG of size random bytes to the node's blockstore
+func (n *Node) RandomDAG(t testing.TB, size uint64) DAG {
+	dag := RandomDAG(n.ctx, t, n.DAGService, size, DefaultDAGParams)
+	n.DAGs = append(n.DAGs, dag)
+	return dag
+}
+
+// RequireDAG requires the node's blockstore to have all of the given DAG
+func (n *Node) RequireDAG(t testing.TB, dag DAG) {
+	testutil.VerifyHasFile(n.ctx, t, n.DAGService, dag.Root, dag.Data)
+}
@@ -0,0 +1,303 @@
+// Package dttest builds connected data transfer managers for integration
+// tests, in this module and in modules that use it.
+//
+// New starts N managers over graphsync, each with its own datastore and
+// blockstore, on a mocknet by default, and connects them to each other. With
+// the Loopback option, the managers move data in process instead.
+// Every manager accepts push and pull requests with a testutil.FakeDTType
+// voucher, and records the events on its channels:
+//
+//	h := dttest.New(ctx, t, 2, dttest.PreloadDAGs(1, 1<<20))
+//	sender, receiver := h.Nodes[0], h.Nodes[1]
+//	chid := h.Push(t, sender, receiver, sender.DAGs[0].Root)
+//	receiver.WaitForStatus(t, chid, datatransfer.Completed)
+//	receiver.RequireDAG(t, sender.DAGs[0])
+//	receiver.Events.RequireSequence(t, chid, datatransfer.Open, datatransfer.Accept, datatransfer.CleanupComplete)
+//
+// The harness stops the managers when the test finishes.
+package dttest
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-graphsync"
+	gsimpl "github.com/ipfs/go-graphsync/impl"
+	gsnet "github.com/ipfs/go-graphsync/network"
+	"github.com/ipfs/go-graphsync/storeutil"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-storedcounter"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	tn "github.com/filecoin-project/go-data-transfer/benchmarks/testnet"
+	"github.com/filecoin-project/go-data-transfer/impl"
+	"github.com/filecoin-project/go-data-transfer/network"
+	"github.com/filecoin-project/go-data-transfer/testutil"
+	gstransport "github.com/filecoin-project/go-data-transfer/transport/graphsync"
+	"github.com/filecoin-project/go-data-transfer/transport/loopback"
+)
+
+// Harness is a set of data transfer managers connected to each other
+type Harness struct {
+	// Ctx is the context the harness was created with
+	Ctx context.Context
+	// Net is the network the managers are on, nil for a loopback harness
+	Net tn.Network
+	// Nodes are the managers, in the order they were created
+	Nodes []*Node
+}
+
+// Node is one data transfer manager of a harness, with everything under it
+type Node struct {
+	ctx context.Context
+
+	// Peer is the node's peer ID
+	Peer peer.ID
+	// Manager is the node's data transfer manager
+	Manager datatransfer.Manager
+	// Network is the data transfer network the manager uses
+	Network network.DataTransferNetwork
+	// Transport is the transport the manager uses
+	Transport datatransfer.Transport
+	// Graphsync is the graphsync instance under the transport, nil for a
+	// loopback harness
+	Graphsync graphsync.GraphExchange
+	// Datastore is the datastore holding the node's blocks and channels
+	Datastore datastore.Batching
+	// Blockstore is the blockstore graphsync loads and stores blocks with
+	Blockstore bstore.Blockstore
+	// DAGService is a DAG service over the blockstore
+	DAGService ipldformat.DAGService
+	// Loader loads blocks from the blockstore
+	Loader ipld.Loader
+	// Storer stores blocks in the blockstore
+	Storer ipld.Storer
+	// Validator accepts all push and pull requests with a FakeDTType voucher
+	Validator *testutil.StubbedValidator
+	// Events records every event on the node's channels
+	Events *Recorder
+	// DAGs are the random DAGs preloaded into the blockstore
+	DAGs []DAG
+}
+
+type config struct {
+	net              tn.Network
+	router           *loopback.Router
+	managerOptions   []impl.DataTransferOption
+	transportOptions []gstransport.Option
+	wrapTransport    func(int, datatransfer.Transport) datatransfer.Transport
+	wrapNetwork      func(int, network.DataTransferNetwork) network.DataTransferNetwork
+	dagCount         int
+	dagSize          uint64
+	dagParams        DAGParams
+}
+
+// Option is an option for a harness
+type Option func(*config)
+
+// Network puts the managers on the given network instead of a new mocknet,
+// such as a testnet.EmulatedNet
+func Network(net tn.Network) Option {
+	return func(c *config) {
+		c.net = net
+	}
+}
+
+// Loopback runs the managers over the given loopback router instead of
+// graphsync on a network. Disconnecting nodes on the router stops the
+// channels between them.
+func Loopback(router *loopback.Router) Option {
+	return func(c *config) {
+		c.router = router
+	}
+}
+
+// ManagerOptions passes options to every data transfer manager
+func ManagerOptions(options ...impl.DataTransferOption) Option {
+	return func(c *config) {
+		c.managerOptions = append(c.managerOptions, options...)
+	}
+}
+
+// TransportOptions passes options to every graphsync transport
+func TransportOptions(options ...gstransport.Option) Option {
+	return func(c *config) {
+		c.transportOptions = append(c.transportOptions, options...)
+	}
+}
+
+// WrapTransport wraps the transport of each node, identified by its index,
+// before it is given to the manager, for example with faults.Transport
+func WrapTransport(wrap func(i int, transport datatransfer.Transport) datatransfer.Transport) Option {
+	return func(c *config) {
+		c.wrapTransport = wrap
+	}
+}
+
+// WrapNetwork wraps the data transfer network of each node, identified by its
+// index, before it is given to the manager, for example with faults.Network
+func WrapNetwork(wrap func(i int, net network.DataTransferNetwork) network.DataTransferNetwork) Option {
+	return func(c *config) {
+		c.wrapNetwork = wrap
+	}
+}
+
+// PreloadDAGs adds count random UnixFS DAGs of the given size to every node's
+// blockstore, listed in the node's DAGs
+func PreloadDAGs(count int, size uint64) Option {
+	return func(c *config) {
+		c.dagCount = count
+		c.dagSize = size
+	}
+}
+
+// PreloadDAGParams sets how preloaded DAGs are built
+func PreloadDAGParams(params DAGParams) Option {
+	return func(c *config) {
+		c.dagParams = params
+	}
+}
+
+// New starts n data transfer managers, connected to each other, and stops
+// them when the test finishes
+func New(ctx context.Context, t testing.TB, n int, options ...Option) *Harness {
+	cfg := config{dagParams: DefaultDAGParams}
+	for _, option := range options {
+		option(&cfg)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+	if cfg.net == nil && cfg.router == nil {
+		cfg.net = tn.StreamNet(ctx, mocknet.New(ctx))
+	}
+
+	h := &Harness{Ctx: ctx, Net: cfg.net}
+	for i := 0; i < n; i++ {
+		h.Nodes = append(h.Nodes, newNode(ctx, t, i, cfg))
+	}
+	for i, node := range h.Nodes {
+		for _, other := range h.Nodes[i+1:] {
+			require.NoError(t, node.Network.ConnectTo(ctx, other.Peer))
+		}
+	}
+	return h
+}
+
+func newNode(ctx context.Context, t testing.TB, i int, cfg config) *Node {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	bs := bstore.NewBlockstore(namespace.Wrap(ds, datastore.NewKey("blockstore")))
+	node := &Node{
+		ctx:        ctx,
+		Datastore:  ds,
+		Blockstore: bs,
+		DAGService: merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs))),
+		Loader:     storeutil.LoaderForBlockstore(bs),
+		Storer:     storeutil.StorerForBlockstore(bs),
+		Validator:  testutil.NewStubbedValidator(),
+		Events:     newRecorder(ctx),
+	}
+	for j := 0; j < cfg.dagCount; j++ {
+		node.DAGs = append(node.DAGs, RandomDAG(ctx, t, node.DAGService, cfg.dagSize, cfg.dagParams))
+	}
+
+	if cfg.router != nil {
+		node.Peer = testutil.GenerateValidPeers(t, 1)[0]
+		node.Network = loopback.NewNetwork(node.Peer, cfg.router)
+		node.Transport = loopback.NewTransport(node.Peer, cfg.router, node.Loader, node.Storer)
+	} else {
+		var gsNet gsnet.GraphSyncNetwork
+		node.Peer, gsNet, node.Network = cfg.net.Adapter()
+		node.Graphsync = gsimpl.New(ctx, gsNet, node.Loader, node.Storer)
+		node.Transport = gstransport.NewTransport(node.Peer, node.Graphsync, cfg.transportOptions...)
+	}
+	if cfg.wrapTransport != nil {
+		node.Transport = cfg.wrapTransport(i, node.Transport)
+	}
+	if cfg.wrapNetwork != nil {
+		node.Network = cfg.wrapNetwork(i, node.Network)
+	}
+
+	tempDir, err := ioutil.TempDir("", "dttest")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tempDir)
+	})
+	counter := storedcounter.New(ds, datastore.NewKey("counter"))
+	node.Manager, err = StartManager(ctx, namespace.Wrap(ds, datastore.NewKey("datatransfer")),
+		tempDir, node.Network, node.Transport, counter, cfg.managerOptions...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = node.Manager.Stop(context.Background())
+	})
+	node.Manager.SubscribeToEvents(node.Events.record)
+
+	node.Validator.StubSuccessPush()
+	node.Validator.StubSuccessPull()
+	require.NoError(t, node.Manager.RegisterVoucherType(testutil.NewFakeDTType(), node.Validator))
+	require.NoError(t, node.Manager.RegisterVoucherResultType(testutil.NewFakeDTType()))
+	return node
+}
+
+// StartManager creates a data transfer manager that keeps its channels in ds
+// and its received CID lists in cidListsDir, starts it and waits for it to be
+// ready. It is how New starts each node's manager, for callers without a
+// testing.TB, such as benchmarks.
+func StartManager(ctx context.Context, ds datastore.Batching, cidListsDir string, net network.DataTransferNetwork,
+	transport datatransfer.Transport, counter *storedcounter.StoredCounter, options ...impl.DataTransferOption) (datatransfer.Manager, error) {
+	manager, err := impl.NewDataTransfer(ds, cidListsDir, net, transport, counter, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	ready := make(chan error, 1)
+	manager.OnReady(func(err error) {
+		ready <- err
+	})
+	if err := manager.Start(ctx); err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		err = xerrors.Errorf("data transfer manager did not start: %w", ctx.Err())
+	case err = <-ready:
+	}
+	if err != nil {
+		_ = manager.Stop(context.Background())
+		return nil, err
+	}
+	return manager, nil
+}
+
+// Push opens a push channel from one node to another for the DAG under root,
+// with a FakeDTType voucher
+func (h *Harness) Push(t testing.TB, from, to *Node, root ipld.Link, options ...datatransfer.ChannelOption) datatransfer.ChannelID {
+	chid, err := from.Manager.OpenPushDataChannel(h.Ctx, to.Peer, testutil.NewFakeDTType(),
+		root.(cidlink.Link).Cid, testutil.AllSelector(), options...)
+	require.NoError(t, err)
+	return chid
+}
+
+// Pull opens a pull channel from one node to another for the DAG under root,
+// with a FakeDTType voucher
+func (h *Harness) Pull(t testing.TB, from, to *Node, root ipld.Link, options ...datatransfer.ChannelOption) datatransfer.ChannelID {
+	chid, err := from.Manager.OpenPullDataChannel(h.Ctx, to.Peer, testutil.NewFakeDTType(),
+		root.(cidlink.Link).Cid, testutil.AllSelector(), options...)
+	require.NoError(t, err)
+	return chid
+}
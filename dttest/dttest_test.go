@@ -0,0 +1,83 @@
+package dttest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	tn "github.com/filecoin-project/go-data-transfer/benchmarks/testnet"
+	"github.com/filecoin-project/go-data-transfer/dttest"
+	"github.com/filecoin-project/go-data-transfer/testutil/faults"
+)
+
+func TestHarness(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := dttest.New(ctx, t, 3, dttest.PreloadDAGs(2, 64<<10))
+	require.Len(t, h.Nodes, 3)
+	for _, node := range h.Nodes {
+		require.Len(t, node.DAGs, 2)
+		node.RequireDAG(t, node.DAGs[0])
+	}
+
+	t.Run("push", func(t *testing.T) {
+		sender, receiver := h.Nodes[0], h.Nodes[1]
+		chid := h.Push(t, sender, receiver, sender.DAGs[0].Root)
+		state := receiver.WaitForStatus(t, chid, datatransfer.Completed)
+		// received counts the intermediate blocks as well as the file
+		require.GreaterOrEqual(t, state.Received(), uint64(len(sender.DAGs[0].Data)))
+		sender.WaitForStatus(t, chid, datatransfer.Completed)
+		receiver.RequireDAG(t, sender.DAGs[0])
+
+		receiver.Events.RequireSequence(t, chid, datatransfer.Open, datatransfer.Accept, datatransfer.DataReceived, datatransfer.CleanupComplete)
+		sender.Events.RequireSequence(t, chid, datatransfer.Open, datatransfer.Accept, datatransfer.DataQueued, datatransfer.DataSent, datatransfer.CleanupComplete)
+		sender.Events.RequireSequence(t, chid, datatransfer.ResponderCompletes, datatransfer.CleanupComplete)
+		receiver.Events.RequireNoEvent(t, chid, datatransfer.Error)
+	})
+
+	t.Run("pull", func(t *testing.T) {
+		sender, receiver := h.Nodes[2], h.Nodes[0]
+		chid := h.Pull(t, receiver, sender, sender.DAGs[1].Root)
+		receiver.WaitForStatus(t, chid, datatransfer.Completed)
+		receiver.RequireDAG(t, sender.DAGs[1])
+		// the responder's completion message can arrive before the
+		// transport finishes
+		receiver.Events.RequireSequence(t, chid, datatransfer.Open, datatransfer.Accept, datatransfer.DataReceived, datatransfer.CleanupComplete)
+		receiver.Events.RequireSequence(t, chid, datatransfer.ResponderCompletes, datatransfer.CleanupComplete)
+	})
+
+	t.Run("already reached status", func(t *testing.T) {
+		sender, receiver := h.Nodes[1], h.Nodes[2]
+		dag := sender.RandomDAG(t, 1<<10)
+		chid := h.Push(t, sender, receiver, dag.Root)
+		receiver.Events.WaitForEvent(t, chid, datatransfer.CleanupComplete)
+		require.Equal(t, datatransfer.Completed, receiver.WaitForStatus(t, chid, datatransfer.Completed, datatransfer.Failed).Status())
+	})
+}
+
+func TestHarnessOptions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	script := faults.NewScript(faults.Fault{At: faults.ChannelCompleted, Action: faults.Error})
+	h := dttest.New(ctx, t, 2,
+		dttest.Network(tn.EmulatedNet(ctx, mocknet.New(ctx), tn.LAN())),
+		dttest.WrapTransport(func(i int, transport datatransfer.Transport) datatransfer.Transport {
+			if i == 1 {
+				return faults.Transport(transport, script)
+			}
+			return transport
+		}),
+	)
+	sender, receiver := h.Nodes[0], h.Nodes[1]
+	dag := sender.RandomDAG(t, 16<<10)
+	chid := h.Push(t, sender, receiver, dag.Root)
+	receiver.WaitForStatus(t, chid, datatransfer.Failed)
+	receiver.Events.RequireSequence(t, chid, datatransfer.Open, datatransfer.Error)
+	require.Equal(t, 1, script.Fired(0))
+}
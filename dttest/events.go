@@ -0,0 +1,146 @@
+package dttest
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+)
+
+// RecordedEvent is an event on a channel with the channel's state after it
+type RecordedEvent struct {
+	Event datatransfer.Event
+	State datatransfer.ChannelState
+}
+
+// Recorder records the events on a manager's channels, for waiting on and
+// asserting against
+type Recorder struct {
+	ctx    context.Context
+	lk     sync.Mutex
+	events map[datatransfer.ChannelID][]RecordedEvent
+	// changed is closed and replaced whenever an event is recorded
+	changed chan struct{}
+}
+
+func newRecorder(ctx context.Context) *Recorder {
+	return &Recorder{
+		ctx:     ctx,
+		events:  make(map[datatransfer.ChannelID][]RecordedEvent),
+		changed: make(chan struct{}),
+	}
+}
+
+func (r *Recorder) record(event datatransfer.Event, state datatransfer.ChannelState) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	chid := state.ChannelID()
+	r.events[chid] = append(r.events[chid], RecordedEvent{Event: event, State: state})
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// Events returns the events recorded on a channel so far
+func (r *Recorder) Events(chid datatransfer.ChannelID) []RecordedEvent {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return append([]RecordedEvent(nil), r.events[chid]...)
+}
+
+// Codes returns the codes of the events recorded on a channel so far
+func (r *Recorder) Codes(chid datatransfer.ChannelID) []datatransfer.EventCode {
+	events := r.Events(chid)
+	codes := make([]datatransfer.EventCode, 0, len(events))
+	for _, event := range events {
+		codes = append(codes, event.Event.Code)
+	}
+	return codes
+}
+
+// WaitFor waits until an event on the channel matches, returning the first
+// one that does, and fails the test if the harness context ends first
+func (r *Recorder) WaitFor(t testing.TB, chid datatransfer.ChannelID, match func(RecordedEvent) bool) RecordedEvent {
+	checked := 0
+	for {
+		r.lk.Lock()
+		events := r.events[chid]
+		changed := r.changed
+		r.lk.Unlock()
+		for ; checked < len(events); checked++ {
+			if match(events[checked]) {
+				return events[checked]
+			}
+		}
+		select {
+		case <-r.ctx.Done():
+			require.FailNowf(t, "event did not happen", "channel %s, events so far: %s", chid, formatCodes(r.Codes(chid)))
+		case <-changed:
+		}
+	}
+}
+
+// WaitForEvent waits for an event with the given code on the channel
+func (r *Recorder) WaitForEvent(t testing.TB, chid datatransfer.ChannelID, code datatransfer.EventCode) RecordedEvent {
+	return r.WaitFor(t, chid, func(event RecordedEvent) bool {
+		return event.Event.Code == code
+	})
+}
+
+// RequireSequence requires the given events to have happened on the channel
+// in the given order, with any other events between them
+func (r *Recorder) RequireSequence(t testing.TB, chid datatransfer.ChannelID, codes ...datatransfer.EventCode) {
+	recorded := r.Codes(chid)
+	next := 0
+	for _, code := range recorded {
+		if next < len(codes) && code == codes[next] {
+			next++
+		}
+	}
+	require.Equalf(t, len(codes), next, "channel %s: expected %s in order, got %s", chid, formatCodes(codes), formatCodes(recorded))
+}
+
+// RequireExactly requires exactly the given events, in order, to have
+// happened on the channel
+func (r *Recorder) RequireExactly(t testing.TB, chid datatransfer.ChannelID, codes ...datatransfer.EventCode) {
+	recorded := r.Codes(chid)
+	require.Equalf(t, formatCodes(codes), formatCodes(recorded), "channel %s", chid)
+}
+
+// RequireNoEvent requires no event with the given code to have happened on
+// the channel
+func (r *Recorder) RequireNoEvent(t testing.TB, chid datatransfer.ChannelID, code datatransfer.EventCode) {
+	recorded := r.Codes(chid)
+	require.NotContainsf(t, recorded, code, "channel %s: unexpected %s in %s", chid, datatransfer.Events[code], formatCodes(recorded))
+}
+
+func formatCodes(codes []datatransfer.EventCode) string {
+	names := make([]string, 0, len(codes))
+	for _, code := range codes {
+		names = append(names, datatransfer.Events[code])
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+// WaitForStatus waits until the channel reaches one of the given statuses on
+// the node, returning its state then
+func (n *Node) WaitForStatus(t testing.TB, chid datatransfer.ChannelID, statuses ...datatransfer.Status) datatransfer.ChannelState {
+	hasStatus := func(state datatransfer.ChannelState) bool {
+		for _, status := range statuses {
+			if state.Status() == status {
+				return true
+			}
+		}
+		return false
+	}
+	// the channel may have been imported rather than had events
+	if state, err := n.Manager.ChannelState(n.ctx, chid); err == nil && hasStatus(state) {
+		return state
+	}
+	return n.Events.WaitFor(t, chid, func(event RecordedEvent) bool {
+		return hasStatus(event.State)
+	}).State
+}
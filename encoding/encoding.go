@@ -77,6 +77,9 @@ type ipldDecoder struct {
 }
 
 func (decoder *ipldDecoder) DecodeFromCbor(encoded []byte) (Encodable, error) {
+	if err := CheckDepth(encoded); err != nil {
+		return nil, err
+	}
 	builder := decoder.style.NewBuilder()
 	buf := bytes.NewReader(encoded)
 	err := dagcbor.Decoder(builder, buf)
@@ -109,6 +112,9 @@ type defaultDecoder struct {
 }
 
 func (decoder *defaultDecoder) DecodeFromCbor(encoded []byte) (Encodable, error) {
+	if err := CheckDepth(encoded); err != nil {
+		return nil, err
+	}
 	decodedValue := reflect.New(decoder.ptrType.Elem())
 	decoded, ok := decodedValue.Interface().(Encodable)
 	if !ok || reflect.ValueOf(decoded).IsNil() {
@@ -1,6 +1,8 @@
 package encoding_test
 
 import (
+	"bytes"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -35,3 +37,53 @@ func TestRoundTrip(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckDepth(t *testing.T) {
+	nested := func(depth int) []byte {
+		return append(bytes.Repeat([]byte{0x81}, depth), 0xf6)
+	}
+	testCases := map[string]struct {
+		encoded []byte
+		err     error
+	}{
+		"scalar":                        {encoded: []byte{0x18, 0x64}},
+		"float":                         {encoded: []byte{0xf9, 0x00, 0x00}},
+		"map of strings":                {encoded: []byte{0xa1, 0x61, 'a', 0x42, 0x01, 0x02}},
+		"tagged":                        {encoded: []byte{0xd8, 0x2a, 0x41, 0x00}},
+		"nested to the limit":           {encoded: nested(encoding.MaxDepth)},
+		"nested past the limit":         {encoded: nested(encoding.MaxDepth + 1), err: encoding.ErrTooDeep},
+		"tags nested past the limit":    {encoded: append(bytes.Repeat([]byte{0xc6}, encoding.MaxDepth+1), 0xf6), err: encoding.ErrTooDeep},
+		"empty":                         {encoded: nil, err: errAny},
+		"truncated string":              {encoded: []byte{0x45, 0x01}, err: errAny},
+		"array longer than the data":    {encoded: []byte{0x9a, 0xff, 0xff, 0xff, 0xff}, err: errAny},
+		"map longer than the data":      {encoded: []byte{0xbb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, err: errAny},
+		"indefinite length":             {encoded: []byte{0x9f, 0xff}, err: errAny},
+		"trailing data":                 {encoded: []byte{0x01, 0x02}, err: errAny},
+		"truncated header":              {encoded: []byte{0x19, 0x01}, err: errAny},
+		"string longer than max uint32": {encoded: []byte{0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, err: errAny},
+	}
+	for testCase, data := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			err := encoding.CheckDepth(data.encoded)
+			switch data.err {
+			case nil:
+				require.NoError(t, err)
+			case errAny:
+				require.Error(t, err)
+			default:
+				require.Equal(t, data.err, err)
+			}
+		})
+	}
+
+	encoded, err := encoding.Encode(testdata.Prime)
+	require.NoError(t, err)
+	require.NoError(t, encoding.CheckDepth(encoded))
+
+	decoder, err := encoding.NewDecoder(testdata.Prime)
+	require.NoError(t, err)
+	_, err = decoder.DecodeFromCbor(nested(encoding.MaxDepth + 1))
+	require.Equal(t, encoding.ErrTooDeep, err)
+}
+
+var errAny = errors.New("any error")
@@ -0,0 +1,105 @@
+package encoding
+
+import (
+	"encoding/binary"
+
+	cborgen "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+)
+
+// MaxDepth is the deepest nesting of CBOR arrays, maps and tags that will be
+// decoded into IPLD nodes or old style ipld-format objects. Those decoders
+// recurse once per level, so without a limit a small, deeply nested value
+// from a peer can exhaust the stack.
+const MaxDepth = 1024
+
+// ErrTooDeep is returned when CBOR is nested deeper than MaxDepth
+var ErrTooDeep = xerrors.Errorf("cbor nested more than %d levels deep", MaxDepth)
+
+// CheckDepth returns ErrTooDeep if the encoded CBOR value nests arrays, maps
+// and tags more than MaxDepth levels deep, or an error if it is not a single
+// well formed value. It does not recurse, so it is safe to call on untrusted
+// data before decoding it.
+func CheckDepth(encoded []byte) error {
+	// remaining holds, for each open array, map or tag, how many more items
+	// it contains. It starts with the single top level value.
+	remaining := []uint64{1}
+	offset := 0
+	for len(remaining) > 0 {
+		if remaining[len(remaining)-1] == 0 {
+			remaining = remaining[:len(remaining)-1]
+			continue
+		}
+		remaining[len(remaining)-1]--
+
+		maj, extra, n, err := readHeader(encoded[offset:])
+		if err != nil {
+			return err
+		}
+		offset += n
+		left := uint64(len(encoded) - offset)
+		var items uint64
+		switch maj {
+		case cborgen.MajByteString, cborgen.MajTextString:
+			if extra > left {
+				return xerrors.New("cbor string is longer than the data")
+			}
+			offset += int(extra)
+			continue
+		case cborgen.MajArray, cborgen.MajMap:
+			// every item takes at least a byte, which also keeps the count
+			// of map items from overflowing
+			if extra > left {
+				return xerrors.New("cbor array or map has more items than the data")
+			}
+			items = extra
+			if maj == cborgen.MajMap {
+				items *= 2
+			}
+		case cborgen.MajTag:
+			items = 1
+		default:
+			continue
+		}
+		if len(remaining) > MaxDepth {
+			return ErrTooDeep
+		}
+		remaining = append(remaining, items)
+	}
+	if offset != len(encoded) {
+		return xerrors.New("unexpected data after cbor value")
+	}
+	return nil
+}
+
+// readHeader reads the major type and argument of the CBOR item at the start
+// of data, returning the number of bytes read
+func readHeader(data []byte) (byte, uint64, int, error) {
+	if len(data) == 0 {
+		return 0, 0, 0, xerrors.New("unexpected end of cbor")
+	}
+	maj := data[0] >> 5
+	low := data[0] & 0x1f
+	var size int
+	switch {
+	case low < 24:
+		return maj, uint64(low), 1, nil
+	case low == 24:
+		size = 1
+	case low == 25:
+		size = 2
+	case low == 26:
+		size = 4
+	case low == 27:
+		size = 8
+	default:
+		// DAG-CBOR does not allow indefinite lengths
+		return 0, 0, 0, xerrors.Errorf("unsupported cbor header %x", data[0])
+	}
+	if len(data) < 1+size {
+		return 0, 0, 0, xerrors.New("unexpected end of cbor")
+	}
+	var buf [8]byte
+	copy(buf[8-size:], data[1:1+size])
+	return maj, binary.BigEndian.Uint64(buf[:]), 1 + size, nil
+}
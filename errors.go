@@ -1,5 +1,7 @@
 package datatransfer
 
+import "golang.org/x/xerrors"
+
 type errorType string
 
 func (e errorType) Error() string {
@@ -36,3 +38,113 @@ const ErrDisconnected = errorType("other peer appears to have hung up. restart C
 
 // ErrRemoved indicates the channel was inactive long enough that it was put in a permaneant error state
 const ErrRemoved = errorType("channel removed due to inactivity")
+
+// ErrTransport indicates the transport failed to move data on the channel
+const ErrTransport = errorType("transport failed to transfer data")
+
+// transportError marks an error returned from the transport or network as a
+// transport failure, without changing its message
+type transportError struct {
+	err error
+}
+
+// NewTransportError wraps an error returned from the transport or network so
+// that it is recorded as a transport failure on the channel
+func NewTransportError(err error) error {
+	return transportError{err}
+}
+
+func (e transportError) Error() string {
+	return e.err.Error()
+}
+
+func (e transportError) Unwrap() error {
+	return e.err
+}
+
+func (e transportError) Is(target error) bool {
+	return target == ErrTransport
+}
+
+// ErrorCode identifies the kind of error a channel failed with, so that
+// clients don't need to match on error messages
+type ErrorCode uint64
+
+const (
+	// NoError means the channel has not encountered an error
+	NoError ErrorCode = iota
+
+	// ErrorUnknown is any error not covered by another error code
+	ErrorUnknown
+
+	// ErrorRejected means the other party rejected the request
+	ErrorRejected
+
+	// ErrorDisconnected means the other party appears to have hung up
+	ErrorDisconnected
+
+	// ErrorRemoved means the channel was inactive for too long
+	ErrorRemoved
+
+	// ErrorUnsupported means an operation is not supported by the transport
+	ErrorUnsupported
+
+	// ErrorChannelNotFound means the channel could not be found
+	ErrorChannelNotFound
+
+	// ErrorTransport means the transport or network failed to move data
+	ErrorTransport
+)
+
+// ErrorCodes are human readable names for error codes
+var ErrorCodes = map[ErrorCode]string{
+	NoError:              "NoError",
+	ErrorUnknown:         "ErrorUnknown",
+	ErrorRejected:        "ErrorRejected",
+	ErrorDisconnected:    "ErrorDisconnected",
+	ErrorRemoved:         "ErrorRemoved",
+	ErrorUnsupported:     "ErrorUnsupported",
+	ErrorChannelNotFound: "ErrorChannelNotFound",
+	ErrorTransport:       "ErrorTransport",
+}
+
+func (c ErrorCode) String() string {
+	return ErrorCodes[c]
+}
+
+// Retryable indicates whether restarting the channel may succeed after a
+// failure with this error code
+func (c ErrorCode) Retryable() bool {
+	switch c {
+	case ErrorDisconnected, ErrorRemoved, ErrorTransport:
+		return true
+	default:
+		return false
+	}
+}
+
+// sentinelErrorCodes maps the sentinel errors to their error codes
+var sentinelErrorCodes = []struct {
+	err  error
+	code ErrorCode
+}{
+	{ErrRejected, ErrorRejected},
+	{ErrDisconnected, ErrorDisconnected},
+	{ErrRemoved, ErrorRemoved},
+	{ErrUnsupported, ErrorUnsupported},
+	{ErrChannelNotFound, ErrorChannelNotFound},
+	{ErrTransport, ErrorTransport},
+}
+
+// ErrorCodeFor classifies an error by the sentinel error it wraps
+func ErrorCodeFor(err error) ErrorCode {
+	if err == nil {
+		return NoError
+	}
+	for _, sentinel := range sentinelErrorCodes {
+		if xerrors.Is(err, sentinel.err) {
+			return sentinel.code
+		}
+	}
+	return ErrorUnknown
+}
@@ -77,6 +77,8 @@ const (
 	// DataQueuedProgress is emitted the first time a block is queued for
 	// sending to the remote peer. It is used to measure progress of how much
 	// of the total data has been queued.
+	// When progress batching is enabled, each of the progress events covers
+	// all the new blocks since the last one.
 	DataQueuedProgress
 
 	// DataSentProgress is emitted the first time a block is sent to the remote
@@ -88,6 +90,13 @@ const (
 	// the remote peer. It is used to measure progress of how much of the total
 	// data has been received.
 	DataReceivedProgress
+
+	// LabelsUpdated is emitted when the user defined labels on a channel change
+	LabelsUpdated
+
+	// RestartAttempted is emitted when the manager tries to restart a channel
+	// automatically, according to its restart policy
+	RestartAttempted
 )
 
 // Events are human readable names for data transfer events
@@ -115,6 +124,8 @@ var Events = map[EventCode]string{
 	DataQueuedProgress:          "DataQueuedProgress",
 	DataSentProgress:            "DataSentProgress",
 	DataReceivedProgress:        "DataReceivedProgress",
+	LabelsUpdated:               "LabelsUpdated",
+	RestartAttempted:            "RestartAttempted",
 }
 
 // Event is a struct containing information about a data transfer event
@@ -0,0 +1,185 @@
+package impl
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/channels"
+	"github.com/filecoin-project/go-data-transfer/encoding"
+	"github.com/filecoin-project/go-data-transfer/pushchannelmonitor"
+	"github.com/filecoin-project/go-data-transfer/registry"
+)
+
+// debugStater is implemented by transports that can report their internal
+// state for debugging, such as the graphsync transport
+type debugStater interface {
+	DebugState() interface{}
+}
+
+// NewDebugHandler returns an HTTP handler with a live view of a data transfer
+// manager created by NewDataTransfer. It is meant for a local debug or admin
+// server only, as it lets anyone who can reach it pause, resume, restart and
+// close channels. Mount it under a prefix with http.StripPrefix:
+//
+//	mux.Handle("/debug/datatransfer/", http.StripPrefix("/debug/datatransfer", handler))
+//
+// It serves:
+//
+//	GET  /channels                     in progress channels, encoded as JSON by the channel state
+//	GET  /transport                    transport internals, if the transport reports them
+//	GET  /pushmonitor                  push channel monitor state per channel
+//	GET  /types                        registered voucher, voucher result and revalidator types
+//	POST /channels/<channel id>/pause  pause a channel (also resume, restart and close)
+func NewDebugHandler(dtm datatransfer.Manager) (http.Handler, error) {
+	m, ok := dtm.(*manager)
+	if !ok {
+		return nil, xerrors.Errorf("debug handler needs a manager created by NewDataTransfer, not %T", dtm)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/channels", m.debugChannels)
+	mux.HandleFunc("/channels/", m.debugChannelAction)
+	mux.HandleFunc("/transport", m.debugTransport)
+	mux.HandleFunc("/pushmonitor", m.debugPushMonitor)
+	mux.HandleFunc("/types", m.debugTypes)
+	return mux, nil
+}
+
+func (m *manager) debugChannels(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	inProgress, err := m.InProgressChannels(r.Context())
+	if err != nil {
+		writeDebugError(w, http.StatusInternalServerError, err)
+		return
+	}
+	states := make([]datatransfer.ChannelState, 0, len(inProgress))
+	for _, chst := range inProgress {
+		states = append(states, chst)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].ChannelID().String() < states[j].ChannelID().String() })
+	writeDebugJSON(w, states)
+}
+
+var debugActions = map[string]func(m *manager, ctx context.Context, chid datatransfer.ChannelID) error{
+	"pause":   (*manager).PauseDataTransferChannel,
+	"resume":  (*manager).ResumeDataTransferChannel,
+	"restart": (*manager).RestartDataTransferChannel,
+	"close":   (*manager).CloseDataTransferChannel,
+}
+
+func (m *manager) debugChannelAction(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/channels/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	action, ok := debugActions[parts[1]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	chid, err := datatransfer.ParseChannelID(parts[0])
+	if err != nil {
+		writeDebugError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := action(m, r.Context(), chid); err != nil {
+		status := http.StatusInternalServerError
+		var notFound *channels.ErrNotFound
+		if xerrors.As(err, &notFound) {
+			status = http.StatusNotFound
+		}
+		writeDebugError(w, status, err)
+		return
+	}
+	writeDebugJSON(w, struct{}{})
+}
+
+func (m *manager) debugTransport(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	stater, ok := m.transport.(debugStater)
+	if !ok {
+		writeDebugError(w, http.StatusNotImplemented, xerrors.Errorf("transport %T does not report its state", m.transport))
+		return
+	}
+	writeDebugJSON(w, stater.DebugState())
+}
+
+func (m *manager) debugPushMonitor(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	states := m.pushChannelMonitor.ChannelStates()
+	sort.Slice(states, func(i, j int) bool { return states[i].ChannelID.String() < states[j].ChannelID.String() })
+	writeDebugJSON(w, struct {
+		Enabled  bool                              `json:"enabled"`
+		Channels []pushchannelmonitor.ChannelState `json:"channels"`
+	}{m.pushChannelMonitorCfg != nil, states})
+}
+
+func (m *manager) debugTypes(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeDebugJSON(w, struct {
+		Vouchers             []datatransfer.TypeIdentifier `json:"vouchers"`
+		VoucherResults       []datatransfer.TypeIdentifier `json:"voucherResults"`
+		Revalidators         []datatransfer.TypeIdentifier `json:"revalidators"`
+		TransportConfigurers []datatransfer.TypeIdentifier `json:"transportConfigurers"`
+	}{
+		Vouchers:             registeredTypes(m.validatedTypes),
+		VoucherResults:       registeredTypes(m.resultTypes),
+		Revalidators:         registeredTypes(m.revalidators),
+		TransportConfigurers: registeredTypes(m.transportConfigurers),
+	})
+}
+
+func registeredTypes(r *registry.Registry) []datatransfer.TypeIdentifier {
+	identifiers := make([]datatransfer.TypeIdentifier, 0)
+	_ = r.Each(func(identifier datatransfer.TypeIdentifier, _ encoding.Decoder, _ registry.Processor) error {
+		identifiers = append(identifiers, identifier)
+		return nil
+	})
+	sort.Slice(identifiers, func(i, j int) bool { return identifiers[i] < identifiers[j] })
+	return identifiers
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeDebugError(w, http.StatusMethodNotAllowed, xerrors.Errorf("method %s not allowed", r.Method))
+	return false
+}
+
+func writeDebugJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Warnf("writing debug response: %s", err)
+	}
+}
+
+func writeDebugError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{err.Error()}); err != nil {
+		log.Warnf("writing debug response: %s", err)
+	}
+}
@@ -0,0 +1,127 @@
+package impl_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-storedcounter"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/encoding"
+	. "github.com/filecoin-project/go-data-transfer/impl"
+	"github.com/filecoin-project/go-data-transfer/testutil"
+)
+
+func TestDebugHandler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// the handler parses channel IDs, so the peer IDs must be valid
+	peers := testutil.GenerateValidPeers(t, 2)
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	transport := testutil.NewFakeTransport()
+	dt, err := NewDataTransfer(ds, os.TempDir(), testutil.NewFakeNetwork(peers[0]), transport,
+		storedcounter.New(ds, datastore.NewKey("counter")),
+		VoucherJSONEncoder(testutil.NewFakeDTType().Type(), func(encodable encoding.Encodable) ([]byte, error) {
+			return json.Marshal(encodable.(*testutil.FakeDTType).Data)
+		}))
+	require.NoError(t, err)
+	testutil.StartAndWaitForReady(ctx, t, dt)
+	require.NoError(t, dt.RegisterVoucherType(testutil.NewFakeDTType(), testutil.NewStubbedValidator()))
+	require.NoError(t, dt.RegisterVoucherResultType(testutil.NewFakeDTType()))
+
+	voucher := &testutil.FakeDTType{Data: "hello"}
+	chid, err := dt.OpenPushDataChannel(ctx, peers[1], voucher, testutil.GenerateCids(1)[0], testutil.AllSelector())
+	require.NoError(t, err)
+
+	handler, err := NewDebugHandler(dt)
+	require.NoError(t, err)
+	mux := http.NewServeMux()
+	mux.Handle("/debug/datatransfer/", http.StripPrefix("/debug/datatransfer", handler))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	getJSON := func(t *testing.T, path string, v interface{}) {
+		resp, err := http.Get(server.URL + "/debug/datatransfer" + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+	post := func(t *testing.T, path string) int {
+		resp, err := http.Post(server.URL+"/debug/datatransfer"+path, "", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("channels", func(t *testing.T) {
+		var channels []struct {
+			ChannelID datatransfer.JSONChannelID
+			Status    string
+			Vouchers  []struct {
+				Type datatransfer.TypeIdentifier
+				JSON string
+			}
+		}
+		getJSON(t, "/channels", &channels)
+		require.Len(t, channels, 1)
+		require.Equal(t, chid, channels[0].ChannelID.ChannelID())
+		require.Equal(t, "Requested", channels[0].Status)
+		require.Len(t, channels[0].Vouchers, 1)
+		require.Equal(t, voucher.Type(), channels[0].Vouchers[0].Type)
+		require.Equal(t, "hello", channels[0].Vouchers[0].JSON)
+	})
+
+	t.Run("types", func(t *testing.T) {
+		var types struct {
+			Vouchers       []datatransfer.TypeIdentifier
+			VoucherResults []datatransfer.TypeIdentifier
+		}
+		getJSON(t, "/types", &types)
+		require.Equal(t, []datatransfer.TypeIdentifier{voucher.Type()}, types.Vouchers)
+		require.Equal(t, []datatransfer.TypeIdentifier{voucher.Type()}, types.VoucherResults)
+	})
+
+	t.Run("push monitor", func(t *testing.T) {
+		var monitor struct {
+			Enabled bool
+		}
+		getJSON(t, "/pushmonitor", &monitor)
+		require.False(t, monitor.Enabled)
+	})
+
+	t.Run("transport without debug state", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/debug/datatransfer/transport")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	})
+
+	t.Run("actions", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/debug/datatransfer/channels/" + chid.String() + "/pause")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+		require.Equal(t, http.StatusBadRequest, post(t, "/channels/not-a-channel/pause"))
+		require.Equal(t, http.StatusNotFound, post(t, "/channels/"+chid.String()+"/explode"))
+
+		require.Equal(t, http.StatusOK, post(t, "/channels/"+chid.String()+"/pause"))
+		require.Equal(t, []datatransfer.ChannelID{chid}, transport.PausedChannels)
+	})
+
+	t.Run("not a manager", func(t *testing.T) {
+		_, err := NewDebugHandler(nil)
+		require.Error(t, err)
+	})
+}
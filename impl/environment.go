@@ -23,8 +23,7 @@ func (ce *channelEnvironment) ID() peer.ID {
 }
 
 func (ce *channelEnvironment) CleanupChannel(chid datatransfer.ChannelID) {
-	ce.m.reconnectsLk.Lock()
-	delete(ce.m.reconnects, chid)
-	ce.m.reconnectsLk.Unlock()
+	ce.m.cancelRemoval(chid)
+	ce.m.clearTransportOptions(chid)
 	ce.m.transport.CleanupChannel(chid)
 }
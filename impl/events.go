@@ -3,7 +3,6 @@ package impl
 import (
 	"context"
 	"errors"
-	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
@@ -12,7 +11,6 @@ import (
 	"golang.org/x/xerrors"
 
 	datatransfer "github.com/filecoin-project/go-data-transfer"
-	"github.com/filecoin-project/go-data-transfer/channels"
 	"github.com/filecoin-project/go-data-transfer/encoding"
 	"github.com/filecoin-project/go-data-transfer/registry"
 )
@@ -36,18 +34,7 @@ func (m *manager) OnDataReceived(chid datatransfer.ChannelID, link ipld.Link, si
 		return err
 	}
 
-	m.reconnectsLk.RLock()
-	reconnect, ok := m.reconnects[chid]
-	var alreadyReconnected bool
-	select {
-	case <-reconnect:
-		alreadyReconnected = true
-	default:
-	}
-	if ok && !alreadyReconnected {
-		close(reconnect)
-	}
-	m.reconnectsLk.RUnlock()
+	m.cancelRemoval(chid)
 
 	if chid.Initiator != m.peerID {
 		var result datatransfer.VoucherResult
@@ -99,18 +86,7 @@ func (m *manager) OnDataQueued(chid datatransfer.ChannelID, link ipld.Link, size
 }
 
 func (m *manager) OnDataSent(chid datatransfer.ChannelID, link ipld.Link, size uint64) error {
-	m.reconnectsLk.RLock()
-	reconnect, ok := m.reconnects[chid]
-	var alreadyReconnected bool
-	select {
-	case <-reconnect:
-		alreadyReconnected = true
-	default:
-	}
-	if ok && !alreadyReconnected {
-		close(reconnect)
-	}
-	m.reconnectsLk.RUnlock()
+	m.cancelRemoval(chid)
 	return m.channels.DataSent(chid, link.(cidlink.Link).Cid, size)
 }
 
@@ -126,7 +102,7 @@ func (m *manager) OnRequestReceived(chid datatransfer.ChannelID, request datatra
 		log.Infof("channel %s: received cancel request, cleaning up channel", chid)
 
 		m.transport.CleanupChannel(chid)
-		return nil, m.channels.Cancel(chid)
+		return nil, m.channels.CancelWithReason(chid, request.Reason())
 	}
 	if request.IsVoucher() {
 		return m.processUpdateVoucher(chid, request)
@@ -152,7 +128,7 @@ func (m *manager) OnRequestReceived(chid datatransfer.ChannelID, request datatra
 func (m *manager) OnResponseReceived(chid datatransfer.ChannelID, response datatransfer.Response) error {
 	if response.IsCancel() {
 		log.Infof("channel %s: received cancel response, cancelling channel", chid)
-		return m.channels.Cancel(chid)
+		return m.channels.CancelWithReason(chid, response.Reason())
 	}
 	if response.IsVoucherResult() {
 		if !response.EmptyVoucherResult() {
@@ -167,7 +143,7 @@ func (m *manager) OnResponseReceived(chid datatransfer.ChannelID, response datat
 		}
 		if !response.Accepted() {
 			log.Infof("channel %s: received rejected response, erroring out channel", chid)
-			return m.channels.Error(chid, datatransfer.ErrRejected)
+			return m.channels.ErrorWithReason(chid, datatransfer.ErrRejected, response.Reason())
 		}
 		if response.IsNew() {
 			log.Infof("channel %s: received new response, accepting channel", chid)
@@ -204,40 +180,8 @@ func (m *manager) OnResponseReceived(chid datatransfer.ChannelID, response datat
 func (m *manager) OnRequestTimedOut(ctx context.Context, chid datatransfer.ChannelID) error {
 	log.Warnf("channel %+v has timed out", chid)
 
-	m.reconnectsLk.Lock()
-	reconnect, ok := m.reconnects[chid]
-	var alreadyReconnected bool
-	select {
-	case <-reconnect:
-		alreadyReconnected = true
-	default:
-	}
-	if !ok || alreadyReconnected {
-		reconnect = make(chan struct{})
-		m.reconnects[chid] = reconnect
-	}
-	m.reconnectsLk.Unlock()
-	timer := time.NewTimer(m.channelRemoveTimeout)
-
-	go func() {
-		select {
-		case <-ctx.Done():
-		case <-reconnect:
-		case <-timer.C:
-			channel, err := m.channels.GetByID(ctx, chid)
-			if err == nil {
-				if !(channels.IsChannelTerminated(channel.Status()) ||
-					channels.IsChannelCleaningUp(channel.Status())) {
-					if err := m.channels.Error(chid, datatransfer.ErrRemoved); err != nil {
-						log.Errorf("failed to cancel timed-out channel: %v", err)
-						return
-					}
-					log.Warnf("channel %+v has ben cancelled because of timeout", chid)
-				}
-			}
-		}
-	}()
-
+	m.scheduleRemoval(ctx, chid)
+	m.scheduleRestart(chid)
 	return nil
 }
 
@@ -250,39 +194,8 @@ func (m *manager) OnRequestDisconnected(ctx context.Context, chid datatransfer.C
 		return err
 	}
 
-	m.reconnectsLk.Lock()
-	reconnect, ok := m.reconnects[chid]
-	var alreadyReconnected bool
-	select {
-	case <-reconnect:
-		alreadyReconnected = true
-	default:
-	}
-	if !ok || alreadyReconnected {
-		reconnect = make(chan struct{})
-		m.reconnects[chid] = reconnect
-	}
-	m.reconnectsLk.Unlock()
-	timer := time.NewTimer(m.channelRemoveTimeout)
-	go func() {
-		select {
-		case <-ctx.Done():
-		case <-reconnect:
-		case <-timer.C:
-			channel, err := m.channels.GetByID(ctx, chid)
-			if err == nil {
-				if !(channels.IsChannelTerminated(channel.Status()) ||
-					channels.IsChannelCleaningUp(channel.Status())) {
-					if err := m.channels.Error(chid, datatransfer.ErrRemoved); err != nil {
-						log.Errorf("failed to cancel timed-out channel: %v", err)
-						return
-					}
-					log.Warnf("channel %+v has ben cancelled because of timeout", chid)
-				}
-			}
-		}
-	}()
-
+	m.scheduleRemoval(ctx, chid)
+	m.scheduleRestart(chid)
 	return nil
 }
 
@@ -315,12 +228,18 @@ func (m *manager) OnChannelCompleted(chid datatransfer.ChannelID, completeErr er
 		return err
 	}
 	// send an error, but only if we haven't already errored for some reason
-	if chst.Status() != datatransfer.Failing && chst.Status() != datatransfer.Failed {
-		err := xerrors.Errorf("data transfer channel %s failed to transfer data: %w", chid, completeErr)
-		log.Warnf(err.Error())
-		return m.channels.Error(chid, err)
+	if chst.Status() == datatransfer.Failing || chst.Status() == datatransfer.Failed {
+		return nil
 	}
-	return nil
+	err = xerrors.Errorf("data transfer channel %s failed to transfer data: %w", chid, datatransfer.NewTransportError(completeErr))
+	// restarting may recover from a transport error, so a channel with a
+	// restart policy is restarted like a disconnected channel instead of failed
+	if datatransfer.ErrorCodeFor(err).Retryable() && m.restartPolicyFor(chst) != nil {
+		log.Warnf("%s: restarting channel", err)
+		return m.OnRequestDisconnected(context.TODO(), chid)
+	}
+	log.Warnf(err.Error())
+	return m.channels.Error(chid, err)
 }
 
 func (m *manager) receiveRestartRequest(chid datatransfer.ChannelID, incoming datatransfer.Request) (datatransfer.Response, error) {
@@ -379,11 +298,11 @@ func (m *manager) restartRequest(chid datatransfer.ChannelID,
 	if err := m.channels.Restart(chid); err != nil {
 		return result, xerrors.Errorf("failed to restart channel %s: %w", chid, err)
 	}
-	processor, has := m.transportConfigurers.Processor(voucher.Type())
-	if has {
-		transportConfigurer := processor.(datatransfer.TransportConfigurer)
-		transportConfigurer(chid, voucher, m.transport)
+	channel, err := m.channels.GetByID(context.TODO(), chid)
+	if err != nil {
+		return result, err
 	}
+	m.configureTransport(chid, voucher, m.persistedOptions(channel))
 	m.dataTransferNetwork.Protect(initiator, chid.String())
 	if voucherErr == datatransfer.ErrPause {
 		err := m.channels.PauseResponder(chid)
@@ -418,7 +337,12 @@ func (m *manager) acceptRequest(
 		dataReceiver = m.peerID
 	}
 
-	chid, err := m.channels.CreateNew(m.peerID, incoming.TransferID(), incoming.BaseCid(), stor, voucher, initiator, dataSender, dataReceiver)
+	// the initiator may have sent the expected size and its labels for the channel
+	options := datatransfer.ChannelOptions{
+		TotalSize: incoming.TotalSize(),
+		Labels:    incoming.Labels(),
+	}
+	chid, err := m.channels.CreateNew(m.peerID, incoming.TransferID(), incoming.BaseCid(), stor, voucher, initiator, dataSender, dataReceiver, options)
 	if err != nil {
 		return result, err
 	}
@@ -431,11 +355,7 @@ func (m *manager) acceptRequest(
 	if err := m.channels.Accept(chid); err != nil {
 		return result, err
 	}
-	processor, has := m.transportConfigurers.Processor(voucher.Type())
-	if has {
-		transportConfigurer := processor.(datatransfer.TransportConfigurer)
-		transportConfigurer(chid, voucher, m.transport)
-	}
+	m.configureTransport(chid, voucher, options)
 	m.dataTransferNetwork.Protect(initiator, chid.String())
 	if voucherErr == datatransfer.ErrPause {
 		err := m.channels.PauseResponder(chid)
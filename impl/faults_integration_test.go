@@ -0,0 +1,139 @@
+package impl_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	. "github.com/filecoin-project/go-data-transfer/impl"
+	"github.com/filecoin-project/go-data-transfer/testutil"
+	"github.com/filecoin-project/go-data-transfer/testutil/faults"
+)
+
+// TestFaultInjection runs push transfers over graphsync with faults injected
+// into the receiving side
+func TestFaultInjection(t *testing.T) {
+	isCompletion := func(msg datatransfer.Message) bool {
+		response, ok := msg.(datatransfer.Response)
+		return ok && response.IsComplete()
+	}
+
+	testCases := map[string]struct {
+		transportFaults []faults.Fault
+		networkFaults   []faults.Fault
+		// receiverFails is whether the receiving side should fail
+		receiverFails bool
+		// senderCompletes is whether the sending side should complete
+		senderCompletes bool
+	}{
+		"no faults": {
+			senderCompletes: true,
+		},
+		"transport completes with an error": {
+			transportFaults: []faults.Fault{{At: faults.ChannelCompleted, Action: faults.Error}},
+			receiverFails:   true,
+		},
+		"duplicate block events": {
+			transportFaults: []faults.Fault{{At: faults.DataReceived, Action: faults.Duplicate, Nth: 2, Times: 3}},
+			senderCompletes: true,
+		},
+		"completion message dropped": {
+			networkFaults: []faults.Fault{{At: faults.SendMessage, Action: faults.Drop, Message: isCompletion}},
+		},
+	}
+	for testCase, data := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			gsData := testutil.NewGraphsyncTestingData(ctx, t, nil, nil)
+			host2 := gsData.Host2 // data recipient
+
+			tp1 := gsData.SetupGSTransportHost1()
+			tp2 := gsData.SetupGSTransportHost2()
+			transportScript := faults.NewScript(data.transportFaults...)
+			networkScript := faults.NewScript(data.networkFaults...)
+
+			dt1, err := NewDataTransfer(gsData.DtDs1, gsData.TempDir1, gsData.DtNet1, tp1, gsData.StoredCounter1)
+			require.NoError(t, err)
+			testutil.StartAndWaitForReady(ctx, t, dt1)
+			dt2, err := NewDataTransfer(gsData.DtDs2, gsData.TempDir2,
+				faults.Network(gsData.DtNet2, networkScript), faults.Transport(tp2, transportScript), gsData.StoredCounter2)
+			require.NoError(t, err)
+			testutil.StartAndWaitForReady(ctx, t, dt2)
+
+			senderCompleted := make(chan struct{}, 1)
+			receiverCompleted := make(chan struct{}, 1)
+			receiverFailed := make(chan struct{}, 1)
+			notify := func(ch chan struct{}) {
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+			dt1.SubscribeToEvents(func(event datatransfer.Event, channelState datatransfer.ChannelState) {
+				if channelState.Status() == datatransfer.Completed {
+					notify(senderCompleted)
+				}
+			})
+			dt2.SubscribeToEvents(func(event datatransfer.Event, channelState datatransfer.ChannelState) {
+				switch channelState.Status() {
+				case datatransfer.Completed:
+					notify(receiverCompleted)
+				case datatransfer.Failed:
+					notify(receiverFailed)
+				}
+			})
+
+			voucher := testutil.FakeDTType{Data: "applesauce"}
+			sv := testutil.NewStubbedValidator()
+			sv.ExpectSuccessPush()
+			require.NoError(t, dt2.RegisterVoucherType(&testutil.FakeDTType{}, sv))
+
+			root, origBytes := testutil.LoadUnixFSFile(ctx, t, gsData.DagService1, loremFile)
+			rootCid := root.(cidlink.Link).Cid
+			_, err = dt1.OpenPushDataChannel(ctx, host2.ID(), &voucher, rootCid, gsData.AllSelector)
+			require.NoError(t, err)
+
+			if data.receiverFails {
+				select {
+				case <-ctx.Done():
+					t.Fatal("receiver did not fail")
+				case <-receiverCompleted:
+					t.Fatal("receiver completed despite the fault")
+				case <-receiverFailed:
+				}
+				require.Equal(t, 1, transportScript.Fired(0))
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				t.Fatal("receiver did not complete")
+			case <-receiverFailed:
+				t.Fatal("receiver failed")
+			case <-receiverCompleted:
+			}
+			testutil.VerifyHasFile(ctx, t, gsData.DagService2, root, origBytes)
+
+			if data.senderCompletes {
+				select {
+				case <-ctx.Done():
+					t.Fatal("sender did not complete")
+				case <-senderCompleted:
+				}
+				return
+			}
+			select {
+			case <-time.After(500 * time.Millisecond):
+			case <-senderCompleted:
+				t.Fatal("sender completed without the completion message")
+			}
+			require.Equal(t, 1, networkScript.Fired(0))
+		})
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
 	"github.com/hannahhoward/go-pubsub"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
@@ -26,6 +28,7 @@ import (
 	"github.com/filecoin-project/go-data-transfer/network"
 	"github.com/filecoin-project/go-data-transfer/pushchannelmonitor"
 	"github.com/filecoin-project/go-data-transfer/registry"
+	"github.com/filecoin-project/go-data-transfer/scheduler"
 )
 
 var log = logging.Logger("dt-impl")
@@ -39,15 +42,27 @@ type manager struct {
 	pubSub                *pubsub.PubSub
 	readySub              *pubsub.PubSub
 	channels              *channels.Channels
+	channelsOptions       []channels.Option
 	peerID                peer.ID
 	transport             datatransfer.Transport
 	storedCounter         *storedcounter.StoredCounter
 	channelRemoveTimeout  time.Duration
-	reconnectsLk          sync.RWMutex
-	reconnects            map[datatransfer.ChannelID]chan struct{}
+	removals              *scheduler.Scheduler
+	clock                 scheduler.Clock
 	cidLists              cidlists.CIDLists
 	pushChannelMonitor    *pushchannelmonitor.Monitor
 	pushChannelMonitorCfg *pushchannelmonitor.Config
+	reconnectRestartCfg   *reconnectRestartConfig
+	restartPolicy         datatransfer.RestartPolicy
+	voucherRestartPolicy  map[datatransfer.TypeIdentifier]datatransfer.RestartPolicy
+	jsonEncoders          map[datatransfer.TypeIdentifier]datatransfer.JSONEncoder
+	restartsLk            sync.Mutex
+	restarts              map[datatransfer.ChannelID]struct{}
+	channelConfigurers    []channelConfigurer
+	transportOptionsLk    sync.Mutex
+	transportOptions      map[datatransfer.ChannelID][]datatransfer.TransportOption
+	ctx                   context.Context
+	cancel                context.CancelFunc
 }
 
 type internalEvent struct {
@@ -119,6 +134,86 @@ func PushChannelRestartConfig(
 	}
 }
 
+// RestartOnReconnect configures the manager to restart channels it opened
+// that have stalled or disconnected when the other peer connects again
+// - minBackoff is the time to wait after the first failed restart attempt
+// - maxBackoff is the most time to wait between restart attempts
+// - maxAttempts is the maximum number of restart attempts to make each time
+//   the peer connects
+func RestartOnReconnect(minBackoff time.Duration, maxBackoff time.Duration, maxAttempts uint32) DataTransferOption {
+	return func(m *manager) {
+		m.reconnectRestartCfg = &reconnectRestartConfig{
+			minBackoff:  minBackoff,
+			maxBackoff:  maxBackoff,
+			maxAttempts: maxAttempts,
+		}
+	}
+}
+
+// ChannelRestartPolicy sets the policy for automatically restarting channels
+// that time out, disconnect or hit a network error. By default channels are
+// not restarted automatically.
+func ChannelRestartPolicy(policy datatransfer.RestartPolicy) DataTransferOption {
+	return func(m *manager) {
+		m.restartPolicy = policy
+	}
+}
+
+// VoucherTypeRestartPolicy overrides the channel restart policy for channels
+// whose voucher has the given type
+func VoucherTypeRestartPolicy(voucherType datatransfer.TypeIdentifier, policy datatransfer.RestartPolicy) DataTransferOption {
+	return func(m *manager) {
+		m.voucherRestartPolicy[voucherType] = policy
+	}
+}
+
+// VoucherJSONEncoder sets the JSON encoder for the voucher or voucher result
+// type with the given identifier. Channel states encoded as JSON render
+// vouchers of that type with it once the type is registered, and other
+// vouchers as base64 CBOR.
+func VoucherJSONEncoder(identifier datatransfer.TypeIdentifier, encoder datatransfer.JSONEncoder) DataTransferOption {
+	return func(m *manager) {
+		m.jsonEncoders[identifier] = encoder
+	}
+}
+
+// ProgressBatching makes the manager record progress on a channel at most
+// once per interval, or once maxBytes of new data has been queued, sent or
+// received, rather than for every block. This cuts down datastore writes for
+// transfers made of many small blocks, at the cost of coarser progress
+// events. The interval should be well below the push channel monitor's check
+// interval, so the monitor sees data moving. See channels.ProgressBatching.
+func ProgressBatching(interval time.Duration, maxBytes uint64) DataTransferOption {
+	return func(m *manager) {
+		m.channelsOptions = append(m.channelsOptions, channels.ProgressBatching(interval, maxBytes))
+	}
+}
+
+// channelConfigurer is a channel transport configurer to register when the
+// manager is created
+type channelConfigurer struct {
+	voucherType datatransfer.Voucher
+	configurer  datatransfer.ChannelTransportConfigurer
+}
+
+// ChannelTransportConfigurer registers a transport configurer to be run on
+// requests with the given voucher type, which receives the options the
+// channel was opened with. It takes the place of RegisterTransportConfigurer
+// for the voucher type.
+func ChannelTransportConfigurer(voucherType datatransfer.Voucher, configurer datatransfer.ChannelTransportConfigurer) DataTransferOption {
+	return func(m *manager) {
+		m.channelConfigurers = append(m.channelConfigurers, channelConfigurer{voucherType, configurer})
+	}
+}
+
+// Clock sets the clock used to time channel removals and restarts. It is
+// mainly useful for tests that control the passing of time.
+func Clock(clock scheduler.Clock) DataTransferOption {
+	return func(m *manager) {
+		m.clock = clock
+	}
+}
+
 const defaultChannelRemoveTimeout = 1 * time.Hour
 
 // NewDataTransfer initializes a new instance of a data transfer manager
@@ -135,28 +230,46 @@ func NewDataTransfer(ds datastore.Batching, cidListsDir string, dataTransferNetw
 		transport:            transport,
 		storedCounter:        storedCounter,
 		channelRemoveTimeout: defaultChannelRemoveTimeout,
-		reconnects:           make(map[datatransfer.ChannelID]chan struct{}),
+		clock:                scheduler.RealClock(),
+		voucherRestartPolicy: make(map[datatransfer.TypeIdentifier]datatransfer.RestartPolicy),
+		jsonEncoders:         make(map[datatransfer.TypeIdentifier]datatransfer.JSONEncoder),
+		restarts:             make(map[datatransfer.ChannelID]struct{}),
+		transportOptions:     make(map[datatransfer.ChannelID][]datatransfer.TransportOption),
 	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
 
 	cidLists, err := cidlists.NewCIDLists(cidListsDir)
 	if err != nil {
 		return nil, err
 	}
 	m.cidLists = cidLists
-	channels, err := channels.New(ds, cidLists, m.notifier, m.voucherDecoder, m.resultTypes.Decoder, &channelEnvironment{m}, dataTransferNetwork.ID())
-	if err != nil {
-		return nil, err
-	}
-	m.channels = channels
 
 	// Apply config options
 	for _, option := range options {
 		option(m)
 	}
+	for _, cc := range m.channelConfigurers {
+		if err := m.transportConfigurers.Register(cc.voucherType, cc.configurer); err != nil {
+			return nil, xerrors.Errorf("error registering transport configurer: %w", err)
+		}
+	}
+
+	// Create the channel list after applying config options as the config
+	// options may apply to the channel list
+	channelsOptions := append([]channels.Option{channels.JSONEncoders(m.voucherJSONEncoder, m.resultTypes.JSONEncoder)}, m.channelsOptions...)
+	channels, err := channels.New(ds, cidLists, m.notifier, m.voucherDecoder, m.resultTypes.Decoder, &channelEnvironment{m}, dataTransferNetwork.ID(), channelsOptions...)
+	if err != nil {
+		return nil, err
+	}
+	m.channels = channels
+
+	// Create the removal scheduler after applying config options as the
+	// clock may be set by an option
+	m.removals = scheduler.New(namespace.Wrap(ds, datastore.NewKey("removals")), m.removeStalledChannel, scheduler.WithClock(m.clock))
 
 	// Start push channel monitor after applying config options as the config
 	// options may apply to the monitor
-	m.pushChannelMonitor = pushchannelmonitor.NewMonitor(m, m.pushChannelMonitorCfg)
+	m.pushChannelMonitor = pushchannelmonitor.NewMonitor(monitorRestarts{m}, m.pushChannelMonitorCfg)
 	m.pushChannelMonitor.Start()
 
 	return m, nil
@@ -170,6 +283,13 @@ func (m *manager) voucherDecoder(voucherType datatransfer.TypeIdentifier) (encod
 	return decoder, true
 }
 
+func (m *manager) voucherJSONEncoder(voucherType datatransfer.TypeIdentifier) (datatransfer.JSONEncoder, bool) {
+	if _, has := m.validatedTypes.Decoder(voucherType); has {
+		return m.validatedTypes.JSONEncoder(voucherType)
+	}
+	return m.revalidators.JSONEncoder(voucherType)
+}
+
 func (m *manager) notifier(evt datatransfer.Event, chst datatransfer.ChannelState) {
 	err := m.pubSub.Publish(internalEvent{evt, chst})
 	if err != nil {
@@ -185,6 +305,10 @@ func (m *manager) Start(ctx context.Context) error {
 		err := m.channels.Start(ctx)
 		if err != nil {
 			log.Errorf("Migrating data transfer state machines: %s", err.Error())
+		} else if err = m.removals.Start(ctx); err != nil {
+			log.Errorf("Loading channel removal deadlines: %s", err.Error())
+		} else {
+			m.protectOngoingChannels()
 		}
 		err = m.readySub.Publish(err)
 		if err != nil {
@@ -197,6 +321,23 @@ func (m *manager) Start(ctx context.Context) error {
 	return m.transport.SetEventHandler(m)
 }
 
+// protectOngoingChannels protects the connections to the other peers of the
+// channels that have not finished, as the network only keeps protections in
+// memory
+func (m *manager) protectOngoingChannels() {
+	chsts, err := m.channels.InProgress()
+	if err != nil {
+		log.Errorf("Listing channels to protect their connections: %s", err.Error())
+		return
+	}
+	for chid, chst := range chsts {
+		if channels.IsChannelTerminated(chst.Status()) {
+			continue
+		}
+		m.dataTransferNetwork.Protect(chst.OtherPeer(), chid.String())
+	}
+}
+
 // OnReady registers a listener for when the data transfer manager has finished starting up
 func (m *manager) OnReady(ready datatransfer.ReadyFunc) {
 	m.readySub.Subscribe(ready)
@@ -205,7 +346,12 @@ func (m *manager) OnReady(ready datatransfer.ReadyFunc) {
 // Stop terminates all data transfers and ends processing
 func (m *manager) Stop(ctx context.Context) error {
 	log.Info("stop data-transfer module")
+	m.cancel()
+	m.removals.Stop()
 	m.pushChannelMonitor.Shutdown()
+	if err := m.channels.Stop(ctx); err != nil {
+		log.Errorf("stopping channels: %s", err)
+	}
 	return m.transport.Shutdown(ctx)
 }
 
@@ -219,33 +365,36 @@ func (m *manager) RegisterVoucherType(voucherType datatransfer.Voucher, validato
 	if err != nil {
 		return xerrors.Errorf("error registering voucher type: %w", err)
 	}
-	return nil
+	return m.registerJSONEncoder(m.validatedTypes, voucherType.Type())
 }
 
 // OpenPushDataChannel opens a data transfer that will send data to the recipient peer and
 // transfer parts of the piece that match the selector
-func (m *manager) OpenPushDataChannel(ctx context.Context, requestTo peer.ID, voucher datatransfer.Voucher, baseCid cid.Cid, selector ipld.Node) (datatransfer.ChannelID, error) {
+func (m *manager) OpenPushDataChannel(ctx context.Context, requestTo peer.ID, voucher datatransfer.Voucher, baseCid cid.Cid, selector ipld.Node, options ...datatransfer.ChannelOption) (datatransfer.ChannelID, error) {
 	log.Infof("open push channel to %s with base cid %s", requestTo, baseCid)
 
-	req, err := m.newRequest(ctx, selector, false, voucher, baseCid, requestTo)
+	channelOptions := datatransfer.NewChannelOptions(options...)
+	req, err := m.newRequest(ctx, selector, false, voucher, baseCid, requestTo, channelOptions)
 	if err != nil {
 		return datatransfer.ChannelID{}, err
 	}
 
 	chid, err := m.channels.CreateNew(m.peerID, req.TransferID(), baseCid, selector, voucher,
-		m.peerID, m.peerID, requestTo) // initiator = us, sender = us, receiver = them
+		m.peerID, m.peerID, requestTo, channelOptions) // initiator = us, sender = us, receiver = them
 	if err != nil {
 		return chid, err
 	}
-	processor, has := m.transportConfigurers.Processor(voucher.Type())
-	if has {
-		transportConfigurer := processor.(datatransfer.TransportConfigurer)
-		transportConfigurer(chid, voucher, m.transport)
+	m.setTransportOptions(chid, channelOptions.TransportOptions)
+	if err := m.applyTransportOptions(chid, channelOptions.TransportOptions); err != nil {
+		_ = m.channels.Error(chid, err)
+		return chid, err
 	}
+	m.configureTransport(chid, voucher, channelOptions)
+	m.addPeerAddrs(requestTo, channelOptions.PeerAddrs)
 	m.dataTransferNetwork.Protect(requestTo, chid.String())
 	monitoredChan := m.pushChannelMonitor.AddChannel(chid)
 	if err := m.dataTransferNetwork.SendMessage(ctx, requestTo, req); err != nil {
-		err = fmt.Errorf("Unable to send request: %w", err)
+		err = fmt.Errorf("Unable to send request: %w", datatransfer.NewTransportError(err))
 		_ = m.channels.Error(chid, err)
 
 		// If push channel monitoring is enabled, shutdown the monitor as it
@@ -262,27 +411,30 @@ func (m *manager) OpenPushDataChannel(ctx context.Context, requestTo peer.ID, vo
 
 // OpenPullDataChannel opens a data transfer that will request data from the sending peer and
 // transfer parts of the piece that match the selector
-func (m *manager) OpenPullDataChannel(ctx context.Context, requestTo peer.ID, voucher datatransfer.Voucher, baseCid cid.Cid, selector ipld.Node) (datatransfer.ChannelID, error) {
+func (m *manager) OpenPullDataChannel(ctx context.Context, requestTo peer.ID, voucher datatransfer.Voucher, baseCid cid.Cid, selector ipld.Node, options ...datatransfer.ChannelOption) (datatransfer.ChannelID, error) {
 	log.Infof("open pull channel to %s with base cid %s", requestTo, baseCid)
 
-	req, err := m.newRequest(ctx, selector, true, voucher, baseCid, requestTo)
+	channelOptions := datatransfer.NewChannelOptions(options...)
+	req, err := m.newRequest(ctx, selector, true, voucher, baseCid, requestTo, channelOptions)
 	if err != nil {
 		return datatransfer.ChannelID{}, err
 	}
 	// initiator = us, sender = them, receiver = us
 	chid, err := m.channels.CreateNew(m.peerID, req.TransferID(), baseCid, selector, voucher,
-		m.peerID, requestTo, m.peerID)
+		m.peerID, requestTo, m.peerID, channelOptions)
 	if err != nil {
 		return chid, err
 	}
-	processor, has := m.transportConfigurers.Processor(voucher.Type())
-	if has {
-		transportConfigurer := processor.(datatransfer.TransportConfigurer)
-		transportConfigurer(chid, voucher, m.transport)
+	m.setTransportOptions(chid, channelOptions.TransportOptions)
+	if err := m.applyTransportOptions(chid, channelOptions.TransportOptions); err != nil {
+		_ = m.channels.Error(chid, err)
+		return chid, err
 	}
+	m.configureTransport(chid, voucher, channelOptions)
+	m.addPeerAddrs(requestTo, channelOptions.PeerAddrs)
 	m.dataTransferNetwork.Protect(requestTo, chid.String())
 	if err := m.transport.OpenChannel(ctx, requestTo, chid, cidlink.Link{Cid: baseCid}, selector, nil, req); err != nil {
-		err = fmt.Errorf("Unable to send request: %w", err)
+		err = fmt.Errorf("Unable to send request: %w", datatransfer.NewTransportError(err))
 		_ = m.channels.Error(chid, err)
 		return chid, err
 	}
@@ -312,26 +464,35 @@ func (m *manager) SendVoucher(ctx context.Context, channelID datatransfer.Channe
 
 // close an open channel (effectively a cancel)
 func (m *manager) CloseDataTransferChannel(ctx context.Context, chid datatransfer.ChannelID) error {
+	return m.CloseDataTransferChannelWithReason(ctx, chid, datatransfer.Reason{})
+}
+
+// close an open channel, sending the other party the reason for closing it
+func (m *manager) CloseDataTransferChannelWithReason(ctx context.Context, chid datatransfer.ChannelID, reason datatransfer.Reason) error {
 	log.Infof("close channel %s", chid)
 
 	chst, err := m.channels.GetByID(ctx, chid)
 	if err != nil {
 		return err
 	}
+	cancelMessage, err := m.cancelMessage(chid, reason)
+	if err != nil {
+		return xerrors.Errorf("unable to create cancel message: %w", err)
+	}
 	err = m.transport.CloseChannel(ctx, chid)
 	if err != nil {
 		log.Warnf("unable to close channel %s: %s", chid, err)
 	}
 
 	log.Infof("%s: sending close channel to %s for channel %s", m.peerID, chst.OtherPeer(), chid)
-	err = m.dataTransferNetwork.SendMessage(ctx, chst.OtherPeer(), m.cancelMessage(chid))
+	err = m.dataTransferNetwork.SendMessage(ctx, chst.OtherPeer(), cancelMessage)
 	if err != nil {
 		err = fmt.Errorf("Unable to send cancel message: %w", err)
 		_ = m.OnRequestDisconnected(ctx, chid)
 		log.Warn(err)
 	}
 
-	fsmerr := m.channels.Cancel(chid)
+	fsmerr := m.channels.CancelWithReason(chid, reason)
 	if err != nil {
 		return err
 	}
@@ -406,6 +567,38 @@ func (m *manager) InProgressChannels(ctx context.Context) (map[datatransfer.Chan
 	return m.channels.InProgress()
 }
 
+// ChannelsByPeer returns all the channels with the given peer as initiator
+// or responder, using the channel index rather than listing every channel
+func (m *manager) ChannelsByPeer(ctx context.Context, p peer.ID) (map[datatransfer.ChannelID]datatransfer.ChannelState, error) {
+	return m.channels.ChannelsByPeer(p)
+}
+
+// ChannelsByBaseCID returns all the channels for the given base CID, using
+// the channel index rather than listing every channel
+func (m *manager) ChannelsByBaseCID(ctx context.Context, baseCid cid.Cid) (map[datatransfer.ChannelID]datatransfer.ChannelState, error) {
+	return m.channels.ChannelsByBaseCID(baseCid)
+}
+
+// ExportChannels writes every channel to the given stream
+func (m *manager) ExportChannels(ctx context.Context, w io.Writer) error {
+	return m.channels.ExportChannels(ctx, w)
+}
+
+// ImportChannels adds the channels in a stream written by ExportChannels
+func (m *manager) ImportChannels(ctx context.Context, r io.Reader) error {
+	return m.channels.ImportChannels(ctx, r)
+}
+
+// get all transfers whose labels match the given selector
+func (m *manager) ChannelsWithLabels(ctx context.Context, selector datatransfer.LabelSelector) (map[datatransfer.ChannelID]datatransfer.ChannelState, error) {
+	return m.channels.ChannelsWithLabels(selector)
+}
+
+// update the labels on a channel -- labels with an empty value are removed
+func (m *manager) UpdateChannelLabels(ctx context.Context, chid datatransfer.ChannelID, labels map[string]string) error {
+	return m.channels.UpdateLabels(chid, labels)
+}
+
 // RegisterRevalidator registers a revalidator for the given voucher type
 // Note: this is the voucher type used to revalidate. It can share a name
 // with the initial validator type and CAN be the same type, or a different type.
@@ -416,7 +609,7 @@ func (m *manager) RegisterRevalidator(voucherType datatransfer.Voucher, revalida
 	if err != nil {
 		return xerrors.Errorf("error registering revalidator type: %w", err)
 	}
-	return nil
+	return m.registerJSONEncoder(m.revalidators, voucherType.Type())
 }
 
 // RegisterVoucherResultType allows deserialization of a voucher result,
@@ -426,7 +619,7 @@ func (m *manager) RegisterVoucherResultType(resultType datatransfer.VoucherResul
 	if err != nil {
 		return xerrors.Errorf("error registering voucher type: %w", err)
 	}
-	return nil
+	return m.registerJSONEncoder(m.resultTypes, resultType.Type())
 }
 
 // RegisterTransportConfigurer registers the given transport configurer to be run on requests with the given voucher
@@ -439,6 +632,19 @@ func (m *manager) RegisterTransportConfigurer(voucherType datatransfer.Voucher,
 	return nil
 }
 
+// registerJSONEncoder sets the JSON encoder given with VoucherJSONEncoder, if
+// any, on a type that was just registered
+func (m *manager) registerJSONEncoder(r *registry.Registry, identifier datatransfer.TypeIdentifier) error {
+	encoder, ok := m.jsonEncoders[identifier]
+	if !ok {
+		return nil
+	}
+	if err := r.RegisterJSONEncoder(identifier, encoder); err != nil {
+		return xerrors.Errorf("error registering json encoder: %w", err)
+	}
+	return nil
+}
+
 // RestartDataTransferChannel restarts data transfer on the channel with the given channelId
 func (m *manager) RestartDataTransferChannel(ctx context.Context, chid datatransfer.ChannelID) error {
 	log.Infof("restart channel %s", chid)
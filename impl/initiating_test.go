@@ -13,6 +13,7 @@ import (
 	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/xerrors"
 
@@ -22,12 +23,17 @@ import (
 	"github.com/filecoin-project/go-data-transfer/channels"
 	. "github.com/filecoin-project/go-data-transfer/impl"
 	"github.com/filecoin-project/go-data-transfer/message"
+	"github.com/filecoin-project/go-data-transfer/network"
 	"github.com/filecoin-project/go-data-transfer/testutil"
 )
 
 func TestDataTransferInitiating(t *testing.T) {
 	// create network
 	ctx := context.Background()
+	var configuredOptions []datatransfer.ChannelOptions
+	recordOptions := ChannelTransportConfigurer(testutil.NewFakeDTType(), func(channelID datatransfer.ChannelID, voucher datatransfer.Voucher, transport datatransfer.Transport, options datatransfer.ChannelOptions) {
+		configuredOptions = append(configuredOptions, options)
+	})
 	testCases := map[string]struct {
 		expectedEvents []datatransfer.EventCode
 		options        []DataTransferOption
@@ -386,6 +392,336 @@ func TestDataTransferInitiating(t *testing.T) {
 				require.Equal(t, h.voucher, customizedTransfer.Voucher)
 			},
 		},
+		"opening with channel options": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open},
+			options:        []DataTransferOption{recordOptions},
+			verify: func(t *testing.T, h *harness) {
+				var transportOptionChannels []datatransfer.ChannelID
+				transportOption := func(chid datatransfer.ChannelID, transport datatransfer.Transport) error {
+					transportOptionChannels = append(transportOptionChannels, chid)
+					return nil
+				}
+				channelID, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor,
+					datatransfer.WithTotalSize(1000),
+					datatransfer.WithRemoveTimeout(time.Minute),
+					datatransfer.WithTransportOption(transportOption))
+				require.NoError(t, err)
+				require.Equal(t, []datatransfer.ChannelID{channelID}, transportOptionChannels)
+				require.Len(t, configuredOptions, 1)
+				require.Equal(t, uint64(1000), configuredOptions[0].TotalSize)
+				require.Equal(t, time.Minute, configuredOptions[0].RemoveTimeout)
+
+				chst, err := h.dt.ChannelState(h.ctx, channelID)
+				require.NoError(t, err)
+				require.Equal(t, uint64(1000), chst.TotalSize())
+				require.Equal(t, time.Minute, chst.RemoveTimeout())
+			},
+		},
+		"labels": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.LabelsUpdated, datatransfer.Open},
+			verify: func(t *testing.T, h *harness) {
+				labelledEvents := make(chan datatransfer.EventCode, 3)
+				unsub := h.dt.SubscribeToEvents(datatransfer.SubscriberWithLabels(datatransfer.LabelSelector{"deal": "1"},
+					func(event datatransfer.Event, channelState datatransfer.ChannelState) {
+						labelledEvents <- event.Code
+					}))
+				defer unsub()
+
+				labelled, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor,
+					datatransfer.WithLabels(map[string]string{"deal": "1"}))
+				require.NoError(t, err)
+				err = h.dt.UpdateChannelLabels(h.ctx, labelled, map[string]string{"job": "a"})
+				require.NoError(t, err)
+				chst, err := h.dt.ChannelState(h.ctx, labelled)
+				require.NoError(t, err)
+				require.Equal(t, map[string]string{"deal": "1", "job": "a"}, chst.Labels())
+
+				for _, code := range []datatransfer.EventCode{datatransfer.Open, datatransfer.LabelsUpdated} {
+					select {
+					case <-h.ctx.Done():
+						t.Fatal("did not receive labelled event")
+					case received := <-labelledEvents:
+						require.Equal(t, code, received)
+					}
+				}
+
+				// events on channels without the label are filtered out. Subscribers
+				// are called in the order they subscribed, so once a later subscriber
+				// sees the unlabelled channel open, the labelled subscriber has
+				// already been passed the event
+				unlabelledOpened := make(chan struct{}, 1)
+				unsubAll := h.dt.SubscribeToEvents(func(event datatransfer.Event, channelState datatransfer.ChannelState) {
+					if event.Code == datatransfer.Open && channelState.Labels()["deal"] == "" {
+						unlabelledOpened <- struct{}{}
+					}
+				})
+				defer unsubAll()
+				_, err = h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				select {
+				case <-h.ctx.Done():
+					t.Fatal("did not receive unlabelled channel open event")
+				case <-unlabelledOpened:
+				}
+				require.Empty(t, labelledEvents)
+
+				channels, err := h.dt.ChannelsWithLabels(h.ctx, datatransfer.LabelSelector{"deal": "1"})
+				require.NoError(t, err)
+				require.Len(t, channels, 1)
+				require.Contains(t, channels, labelled)
+			},
+		},
+		"channels by peer and base cid": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open},
+			verify: func(t *testing.T, h *harness) {
+				channelID, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				_, err = h.dt.ChannelState(h.ctx, channelID)
+				require.NoError(t, err)
+
+				channels, err := h.dt.ChannelsByPeer(h.ctx, h.peers[1])
+				require.NoError(t, err)
+				require.Len(t, channels, 1)
+				require.Contains(t, channels, channelID)
+
+				channels, err = h.dt.ChannelsByBaseCID(h.ctx, h.baseCid)
+				require.NoError(t, err)
+				require.Len(t, channels, 1)
+				require.Contains(t, channels, channelID)
+
+				channels, err = h.dt.ChannelsByBaseCID(h.ctx, testutil.GenerateCids(1)[0])
+				require.NoError(t, err)
+				require.Empty(t, channels)
+			},
+		},
+		"restarts disconnected pull request when peer reconnects": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Disconnected, datatransfer.RestartAttempted},
+			options:        []DataTransferOption{RestartOnReconnect(10*time.Millisecond, 50*time.Millisecond, 3)},
+			verify: func(t *testing.T, h *harness) {
+				require.NoError(t, h.dt.RegisterVoucherType(h.voucher, testutil.NewStubbedValidator()))
+				channelID, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				require.NoError(t, h.transport.EventHandler.OnRequestDisconnected(ctx, channelID))
+				h.peerConnected(t, h.peers[1])
+				// need time for the restart to take place
+				time.Sleep(100 * time.Millisecond)
+				require.Len(t, h.transport.OpenedChannels, 2)
+				restart := h.transport.OpenedChannels[1]
+				require.Equal(t, channelID, restart.ChannelID)
+				require.True(t, restart.Message.IsRestart())
+			},
+		},
+		"restarts disconnected push request when peer reconnects": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Accept, datatransfer.ResumeResponder, datatransfer.Disconnected, datatransfer.RestartAttempted},
+			options:        []DataTransferOption{RestartOnReconnect(10*time.Millisecond, 50*time.Millisecond, 3)},
+			verify: func(t *testing.T, h *harness) {
+				require.NoError(t, h.dt.RegisterVoucherType(h.voucher, testutil.NewStubbedValidator()))
+				channelID, err := h.dt.OpenPushDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				response, err := message.NewResponse(channelID.ID, true, false, datatransfer.EmptyTypeIdentifier, nil)
+				require.NoError(t, err)
+				err = h.transport.EventHandler.OnResponseReceived(channelID, response)
+				require.NoError(t, err)
+				require.NoError(t, h.transport.EventHandler.OnRequestDisconnected(ctx, channelID))
+				h.peerConnected(t, h.peers[1])
+				// need time for the restart to take place
+				time.Sleep(100 * time.Millisecond)
+				require.Len(t, h.network.SentMessages, 2)
+				restart := h.network.SentMessages[1]
+				require.Equal(t, h.peers[1], restart.PeerID)
+				require.True(t, restart.Message.IsRestart())
+				require.Equal(t, channelID.ID, restart.Message.TransferID())
+			},
+		},
+		"restarts higher priority channels first when peer reconnects": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Open, datatransfer.Disconnected, datatransfer.Disconnected,
+				datatransfer.RestartAttempted, datatransfer.RestartAttempted},
+			options: []DataTransferOption{RestartOnReconnect(10*time.Millisecond, 50*time.Millisecond, 3)},
+			verify: func(t *testing.T, h *harness) {
+				require.NoError(t, h.dt.RegisterVoucherType(h.voucher, testutil.NewStubbedValidator()))
+				// events for different channels are not ordered, so wait for
+				// both channels to open before disconnecting them
+				opened := make(chan struct{}, 2)
+				unsub := h.dt.SubscribeToEvents(func(event datatransfer.Event, channelState datatransfer.ChannelState) {
+					if event.Code == datatransfer.Open {
+						opened <- struct{}{}
+					}
+				})
+				defer unsub()
+				lowID, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor, datatransfer.WithPriority(1))
+				require.NoError(t, err)
+				highID, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor, datatransfer.WithPriority(5))
+				require.NoError(t, err)
+				for i := 0; i < 2; i++ {
+					select {
+					case <-h.ctx.Done():
+						t.Fatal("channels did not open")
+					case <-opened:
+					}
+				}
+				require.NoError(t, h.transport.EventHandler.OnRequestDisconnected(ctx, lowID))
+				require.NoError(t, h.transport.EventHandler.OnRequestDisconnected(ctx, highID))
+				h.peerConnected(t, h.peers[1])
+				require.Len(t, h.transport.OpenedChannels, 4)
+				require.Equal(t, highID, h.transport.OpenedChannels[2].ChannelID)
+				require.Equal(t, lowID, h.transport.OpenedChannels[3].ChannelID)
+			},
+		},
+		"gives up restarting after max attempts": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Disconnected,
+				datatransfer.RestartAttempted, datatransfer.RestartAttempted, datatransfer.RestartAttempted},
+			options: []DataTransferOption{RestartOnReconnect(10*time.Millisecond, 20*time.Millisecond, 3)},
+			verify: func(t *testing.T, h *harness) {
+				require.NoError(t, h.dt.RegisterVoucherType(h.voucher, testutil.NewStubbedValidator()))
+				channelID, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				require.NoError(t, h.transport.EventHandler.OnRequestDisconnected(ctx, channelID))
+				h.transport.OpenChannelErr = xerrors.New("still can't reach peer")
+				h.peerConnected(t, h.peers[1])
+				// need time for the restarts to take place
+				time.Sleep(200 * time.Millisecond)
+				// the original open plus three restart attempts
+				require.Len(t, h.transport.OpenedChannels, 4)
+			},
+		},
+		"does not restart when other peer connects": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Disconnected},
+			options:        []DataTransferOption{RestartOnReconnect(10*time.Millisecond, 50*time.Millisecond, 3)},
+			verify: func(t *testing.T, h *harness) {
+				channelID, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				require.NoError(t, h.transport.EventHandler.OnRequestDisconnected(ctx, channelID))
+				h.peerConnected(t, testutil.GeneratePeers(1)[0])
+				time.Sleep(100 * time.Millisecond)
+				require.Len(t, h.transport.OpenedChannels, 1)
+			},
+		},
+		"does not restart on reconnect unless configured": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Disconnected},
+			verify: func(t *testing.T, h *harness) {
+				channelID, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				require.NoError(t, h.transport.EventHandler.OnRequestDisconnected(ctx, channelID))
+				h.peerConnected(t, h.peers[1])
+				time.Sleep(100 * time.Millisecond)
+				require.Len(t, h.transport.OpenedChannels, 1)
+			},
+		},
+		"restarts disconnected pull request using restart policy": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Disconnected, datatransfer.RestartAttempted},
+			options: []DataTransferOption{ChannelRestartPolicy(datatransfer.BackoffRestartPolicy{
+				MinBackoff:  10 * time.Millisecond,
+				MaxBackoff:  50 * time.Millisecond,
+				MaxAttempts: 2,
+			})},
+			verify: func(t *testing.T, h *harness) {
+				require.NoError(t, h.dt.RegisterVoucherType(h.voucher, testutil.NewStubbedValidator()))
+				channelID, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				require.NoError(t, h.transport.EventHandler.OnRequestDisconnected(ctx, channelID))
+				// need time for the restart to take place
+				time.Sleep(100 * time.Millisecond)
+				require.Len(t, h.transport.OpenedChannels, 2)
+				restart := h.transport.OpenedChannels[1]
+				require.Equal(t, channelID, restart.ChannelID)
+				require.True(t, restart.Message.IsRestart())
+				chst, err := h.dt.ChannelState(h.ctx, channelID)
+				require.NoError(t, err)
+				require.Equal(t, uint64(1), chst.RestartAttempts())
+				require.Equal(t, "", chst.LastRestartError())
+			},
+		},
+		"restarts pull request that failed with a transport error using restart policy": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Disconnected, datatransfer.RestartAttempted},
+			options: []DataTransferOption{ChannelRestartPolicy(datatransfer.BackoffRestartPolicy{
+				MinBackoff:  10 * time.Millisecond,
+				MaxBackoff:  50 * time.Millisecond,
+				MaxAttempts: 2,
+			})},
+			verify: func(t *testing.T, h *harness) {
+				require.NoError(t, h.dt.RegisterVoucherType(h.voucher, testutil.NewStubbedValidator()))
+				channelID, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				require.NoError(t, h.transport.EventHandler.OnChannelCompleted(channelID, xerrors.New("stream reset")))
+				// need time for the restart to take place
+				time.Sleep(100 * time.Millisecond)
+				require.Len(t, h.transport.OpenedChannels, 2)
+				restart := h.transport.OpenedChannels[1]
+				require.Equal(t, channelID, restart.ChannelID)
+				require.True(t, restart.Message.IsRestart())
+				chst, err := h.dt.ChannelState(h.ctx, channelID)
+				require.NoError(t, err)
+				require.NotEqual(t, datatransfer.Failing, chst.Status())
+				require.Equal(t, uint64(1), chst.RestartAttempts())
+			},
+		},
+		"fails pull request with a transport error without a restart policy": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Error, datatransfer.CleanupComplete},
+			verify: func(t *testing.T, h *harness) {
+				channelID, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				require.NoError(t, h.transport.EventHandler.OnChannelCompleted(channelID, xerrors.New("stream reset")))
+				require.Len(t, h.transport.OpenedChannels, 1)
+				chst, err := h.dt.ChannelState(h.ctx, channelID)
+				require.NoError(t, err)
+				require.Equal(t, datatransfer.ErrorTransport, chst.ErrorCode())
+			},
+		},
+		"stops restarting when restart policy gives up": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.RestartAttempted, datatransfer.RestartAttempted},
+			options: []DataTransferOption{ChannelRestartPolicy(datatransfer.BackoffRestartPolicy{
+				MinBackoff:  10 * time.Millisecond,
+				MaxBackoff:  20 * time.Millisecond,
+				MaxAttempts: 2,
+			})},
+			verify: func(t *testing.T, h *harness) {
+				require.NoError(t, h.dt.RegisterVoucherType(h.voucher, testutil.NewStubbedValidator()))
+				channelID, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				h.transport.OpenChannelErr = xerrors.New("still can't reach peer")
+				require.NoError(t, h.transport.EventHandler.OnRequestTimedOut(ctx, channelID))
+				// need time for the restarts to take place
+				time.Sleep(200 * time.Millisecond)
+				// the original open plus two restart attempts
+				require.Len(t, h.transport.OpenedChannels, 3)
+				chst, err := h.dt.ChannelState(h.ctx, channelID)
+				require.NoError(t, err)
+				require.Equal(t, uint64(2), chst.RestartAttempts())
+				require.Contains(t, chst.LastRestartError(), "still can't reach peer")
+			},
+		},
+		"voucher type restart policy overrides default": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Disconnected},
+			options: []DataTransferOption{
+				ChannelRestartPolicy(datatransfer.BackoffRestartPolicy{
+					MinBackoff:  10 * time.Millisecond,
+					MaxAttempts: 2,
+				}),
+				VoucherTypeRestartPolicy(testutil.NewFakeDTType().Type(), datatransfer.BackoffRestartPolicy{
+					MaxAttempts: 0,
+				}),
+			},
+			verify: func(t *testing.T, h *harness) {
+				require.NoError(t, h.dt.RegisterVoucherType(h.voucher, testutil.NewStubbedValidator()))
+				channelID, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				require.NoError(t, h.transport.EventHandler.OnRequestDisconnected(ctx, channelID))
+				time.Sleep(100 * time.Millisecond)
+				require.Len(t, h.transport.OpenedChannels, 1)
+			},
+		},
+		"transport option fails": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Error, datatransfer.CleanupComplete},
+			verify: func(t *testing.T, h *harness) {
+				transportOption := func(chid datatransfer.ChannelID, transport datatransfer.Transport) error {
+					return xerrors.New("something went wrong")
+				}
+				_, err := h.dt.OpenPushDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor,
+					datatransfer.WithTransportOption(transportOption))
+				require.Error(t, err)
+				require.Len(t, h.network.SentMessages, 0)
+			},
+		},
 	}
 	for testCase, verify := range testCases {
 
@@ -421,11 +757,128 @@ func TestDataTransferInitiating(t *testing.T) {
 	}
 }
 
+func TestChannelRemovalSurvivesRestart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	peers := testutil.GeneratePeers(2)
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	clock := testutil.NewMockClock()
+	newManager := func() (datatransfer.Manager, *testutil.FakeTransport) {
+		transport := testutil.NewFakeTransport()
+		dt, err := NewDataTransfer(ds, os.TempDir(), testutil.NewFakeNetwork(peers[0]), transport,
+			storedcounter.New(ds, datastore.NewKey("counter")), ChannelRemoveTimeout(time.Hour), Clock(clock))
+		require.NoError(t, err)
+		return dt, transport
+	}
+
+	dt, transport := newManager()
+	testutil.StartAndWaitForReady(ctx, t, dt)
+	channelID, err := dt.OpenPullDataChannel(ctx, peers[1], testutil.NewFakeDTType(), testutil.GenerateCids(1)[0], testutil.AllSelector())
+	require.NoError(t, err)
+	require.NoError(t, transport.EventHandler.OnRequestTimedOut(ctx, channelID))
+	require.NoError(t, dt.Stop(ctx))
+
+	// the remove timeout passes while the manager is stopped
+	clock.Add(2 * time.Hour)
+
+	dt, _ = newManager()
+	ev := eventVerifier{
+		expectedEvents: []datatransfer.EventCode{datatransfer.Error, datatransfer.CleanupComplete},
+		events:         make(chan datatransfer.EventCode, 2),
+	}
+	ev.setup(t, dt)
+	testutil.StartAndWaitForReady(ctx, t, dt)
+	ev.verify(ctx, t)
+
+	chst, err := dt.ChannelState(ctx, channelID)
+	require.NoError(t, err)
+	require.Equal(t, datatransfer.ErrRemoved.Error(), chst.Message())
+}
+
+func TestChannelsProtectedAfterRestart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	peers := testutil.GeneratePeers(3)
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	newManager := func() (datatransfer.Manager, *testutil.FakeNetwork) {
+		net := testutil.NewFakeNetwork(peers[0])
+		dt, err := NewDataTransfer(ds, os.TempDir(), net, testutil.NewFakeTransport(),
+			storedcounter.New(ds, datastore.NewKey("counter")))
+		require.NoError(t, err)
+		return dt, net
+	}
+
+	dt, net := newManager()
+	testutil.StartAndWaitForReady(ctx, t, dt)
+	ongoing, err := dt.OpenPullDataChannel(ctx, peers[1], testutil.NewFakeDTType(), testutil.GenerateCids(1)[0], testutil.AllSelector())
+	require.NoError(t, err)
+	require.True(t, net.IsProtected(peers[1], ongoing.String()))
+	cancelled, err := dt.OpenPullDataChannel(ctx, peers[2], testutil.NewFakeDTType(), testutil.GenerateCids(1)[0], testutil.AllSelector())
+	require.NoError(t, err)
+	require.NoError(t, dt.CloseDataTransferChannel(ctx, cancelled))
+	require.Eventually(t, func() bool {
+		chst, err := dt.ChannelState(ctx, cancelled)
+		return err == nil && chst.Status() == datatransfer.Cancelled
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, dt.Stop(ctx))
+
+	// protections are not saved, so the restarted manager protects the
+	// connection for the channel that is still going again
+	dt, net = newManager()
+	testutil.StartAndWaitForReady(ctx, t, dt)
+	require.True(t, net.IsProtected(peers[1], ongoing.String()))
+	require.False(t, net.IsProtected(peers[2], cancelled.String()))
+}
+
+func TestChannelRemovalCancelledByData(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	peers := testutil.GeneratePeers(2)
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	clock := testutil.NewMockClock()
+	transport := testutil.NewFakeTransport()
+	dt, err := NewDataTransfer(ds, os.TempDir(), testutil.NewFakeNetwork(peers[0]), transport,
+		storedcounter.New(ds, datastore.NewKey("counter")), ChannelRemoveTimeout(time.Hour), Clock(clock))
+	require.NoError(t, err)
+	ev := eventVerifier{
+		expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Disconnected, datatransfer.DataReceivedProgress, datatransfer.DataReceived},
+		events:         make(chan datatransfer.EventCode, 4),
+	}
+	ev.setup(t, dt)
+	testutil.StartAndWaitForReady(ctx, t, dt)
+
+	channelID, err := dt.OpenPullDataChannel(ctx, peers[1], testutil.NewFakeDTType(), testutil.GenerateCids(1)[0], testutil.AllSelector())
+	require.NoError(t, err)
+	require.NoError(t, transport.EventHandler.OnRequestDisconnected(ctx, channelID))
+	clock.BlockUntil(1)
+	clock.Add(30 * time.Minute)
+	testCids := testutil.GenerateCids(1)
+	require.NoError(t, transport.EventHandler.OnDataReceived(channelID, cidlink.Link{Cid: testCids[0]}, uint64(12345)))
+
+	// the channel is not removed once the remove timeout has passed, because
+	// data was received in the meantime
+	clock.Add(time.Hour)
+	ev.verify(ctx, t)
+	time.Sleep(50 * time.Millisecond)
+	chst, err := dt.ChannelState(ctx, channelID)
+	require.NoError(t, err)
+	require.NotEqual(t, datatransfer.Failed, chst.Status())
+}
+
 func TestDataTransferRestartInitiating(t *testing.T) {
 	// create network
 	ctx := context.Background()
+	var configuredOptions []datatransfer.ChannelOptions
+	recordOptions := ChannelTransportConfigurer(testutil.NewFakeDTType(), func(channelID datatransfer.ChannelID, voucher datatransfer.Voucher, transport datatransfer.Transport, options datatransfer.ChannelOptions) {
+		configuredOptions = append(configuredOptions, options)
+	})
 	testCases := map[string]struct {
 		expectedEvents []datatransfer.EventCode
+		options        []DataTransferOption
+		maxDoNotSend   int
 		verify         func(t *testing.T, h *harness)
 	}{
 		"RestartDataTransferChannel: Manager Peer Create Pull Restart works": {
@@ -476,6 +929,24 @@ func TestDataTransferRestartInitiating(t *testing.T) {
 				testutil.AssertFakeDTVoucher(t, receivedRequest, h.voucher)
 			},
 		},
+		"RestartDataTransferChannel: only reads as many received cids as the transport uses": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.DataReceivedProgress, datatransfer.DataReceived, datatransfer.DataReceivedProgress, datatransfer.DataReceived},
+			maxDoNotSend:   1,
+			verify: func(t *testing.T, h *harness) {
+				channelID, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+
+				testCids := testutil.GenerateCids(2)
+				ev, ok := h.dt.(datatransfer.EventsHandler)
+				require.True(t, ok)
+				require.NoError(t, ev.OnDataReceived(channelID, cidlink.Link{Cid: testCids[0]}, 12345))
+				require.NoError(t, ev.OnDataReceived(channelID, cidlink.Link{Cid: testCids[1]}, 12345))
+
+				require.NoError(t, h.dt.RestartDataTransferChannel(ctx, channelID))
+				require.Len(t, h.transport.OpenedChannels, 2)
+				require.Equal(t, []cid.Cid{testCids[0]}, h.transport.OpenedChannels[1].DoNotSendCids)
+			},
+		},
 		"RestartDataTransferChannel: Manager Peer Create Push Restart works": {
 			expectedEvents: []datatransfer.EventCode{datatransfer.Open},
 			verify: func(t *testing.T, h *harness) {
@@ -512,6 +983,49 @@ func TestDataTransferRestartInitiating(t *testing.T) {
 				testutil.AssertFakeDTVoucher(t, receivedRequest, h.voucher)
 			},
 		},
+		"RestartDataTransferChannel: restart reuses persisted channel options": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open},
+			options:        []DataTransferOption{recordOptions},
+			verify: func(t *testing.T, h *harness) {
+				var transportOptionChannels []datatransfer.ChannelID
+				transportOption := func(chid datatransfer.ChannelID, transport datatransfer.Transport) error {
+					transportOptionChannels = append(transportOptionChannels, chid)
+					return nil
+				}
+
+				// open a push channel with options
+				addr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/1234")
+				require.NoError(t, err)
+				channelID, err := h.dt.OpenPushDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor,
+					datatransfer.WithTotalSize(500), datatransfer.WithRemoveTimeout(time.Minute),
+					datatransfer.WithPriority(3), datatransfer.WithPeerAddrs(addr),
+					datatransfer.WithTransportOption(transportOption))
+				require.NoError(t, err)
+
+				// restart that push channel
+				err = h.dt.RestartDataTransferChannel(ctx, channelID)
+				require.NoError(t, err)
+
+				// the transport configurer should get the same options on restart,
+				// and the transport options should be applied again
+				require.Len(t, configuredOptions, 2)
+				for _, options := range configuredOptions {
+					require.Equal(t, uint64(500), options.TotalSize)
+					require.Equal(t, time.Minute, options.RemoveTimeout)
+					require.Equal(t, int64(3), options.Priority)
+					require.Equal(t, []ma.Multiaddr{addr}, options.PeerAddrs)
+					require.Len(t, options.TransportOptions, 1)
+				}
+				require.Equal(t, []datatransfer.ChannelID{channelID, channelID}, transportOptionChannels)
+
+				// the peer addresses should be given to the network on open and
+				// again on restart
+				require.Equal(t, []testutil.FakePeerAddrs{
+					{PeerID: h.peers[1], Addrs: []ma.Multiaddr{addr}},
+					{PeerID: h.peers[1], Addrs: []ma.Multiaddr{addr}},
+				}, h.network.PeerAddrs)
+			},
+		},
 		"RestartDataTransferChannel: Manager Peer Receive Push Restart works ": {
 			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Accept},
 			verify: func(t *testing.T, h *harness) {
@@ -648,7 +1162,11 @@ func TestDataTransferRestartInitiating(t *testing.T) {
 			h.voucherValidator = testutil.NewStubbedValidator()
 
 			// setup data transfer``
-			dt, err := NewDataTransfer(h.ds, os.TempDir(), h.network, h.transport, h.storedCounter)
+			var transport datatransfer.Transport = h.transport
+			if verify.maxDoNotSend > 0 {
+				transport = &testutil.FakeLimitedTransport{FakeTransport: h.transport, MaxDoNotSend: verify.maxDoNotSend}
+			}
+			dt, err := NewDataTransfer(h.ds, os.TempDir(), h.network, transport, h.storedCounter, verify.options...)
 			require.NoError(t, err)
 			testutil.StartAndWaitForReady(ctx, t, dt)
 			h.dt = dt
@@ -702,6 +1220,13 @@ type harness struct {
 	pullRequest datatransfer.Request
 }
 
+// peerConnected tells the manager the peer connected, as the network would
+func (h *harness) peerConnected(t *testing.T, p peer.ID) {
+	receiver, ok := h.network.Delegate.(network.PeerConnectedReceiver)
+	require.True(t, ok)
+	receiver.ReceivePeerConnected(p)
+}
+
 type eventVerifier struct {
 	expectedEvents []datatransfer.EventCode
 	events         chan datatransfer.EventCode
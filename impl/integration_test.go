@@ -414,8 +414,7 @@ func TestManyReceiversAtOnce(t *testing.T) {
 
 				receiver, err := NewDataTransfer(dtDs, os.TempDir(), dtnet, gsTransport, storedCounter)
 				require.NoError(t, err)
-				err = receiver.Start(gsData.Ctx)
-				require.NoError(t, err)
+				testutil.StartAndWaitForReady(gsData.Ctx, t, receiver)
 
 				err = receiver.RegisterTransportConfigurer(&testutil.FakeDTType{}, func(channelID datatransfer.ChannelID, testVoucher datatransfer.Voucher, transport datatransfer.Transport) {
 					_, isFv := testVoucher.(*testutil.FakeDTType)
@@ -623,7 +622,7 @@ func TestRoundTripCancelledRequest(t *testing.T) {
 
 			finished := make(chan struct{}, 2)
 			errChan := make(chan string, 2)
-			cancelled := make(chan struct{}, 2)
+			cancelled := make(chan datatransfer.ChannelState, 2)
 			accepted := make(chan struct{}, 2)
 			opened := make(chan struct{}, 2)
 			var subscriber datatransfer.Subscriber = func(event datatransfer.Event, channelState datatransfer.ChannelState) {
@@ -637,7 +636,7 @@ func TestRoundTripCancelledRequest(t *testing.T) {
 					errChan <- event.Message
 				}
 				if event.Code == datatransfer.Cancel {
-					cancelled <- struct{}{}
+					cancelled <- channelState
 				}
 				if event.Code == datatransfer.Open {
 					opened <- struct{}{}
@@ -661,6 +660,7 @@ func TestRoundTripCancelledRequest(t *testing.T) {
 				chid, err = dt1.OpenPushDataChannel(ctx, host2.ID(), &voucher, rootCid, gsData.AllSelector)
 			}
 			require.NoError(t, err)
+			reason := datatransfer.Reason{Code: 7, Text: "no longer needed"}
 			opens := 0
 			cancels := 0
 			accepts := 0
@@ -672,7 +672,9 @@ func TestRoundTripCancelledRequest(t *testing.T) {
 					t.Fatal("request completed succussfully but should have been cancelled")
 				case <-opened:
 					opens++
-				case <-cancelled:
+				case chst := <-cancelled:
+					// both the party that cancelled and the other party see the reason
+					require.Equal(t, reason, chst.Reason())
 					cancels++
 				case <-accepted:
 					if accepts == 0 {
@@ -682,9 +684,9 @@ func TestRoundTripCancelledRequest(t *testing.T) {
 							case <-ctx.Done():
 							case <-timer.C:
 								if data.isPull {
-									_ = dt1.CloseDataTransferChannel(ctx, chid)
+									_ = dt1.CloseDataTransferChannelWithReason(ctx, chid, reason)
 								} else {
-									_ = dt2.CloseDataTransferChannel(ctx, chid)
+									_ = dt2.CloseDataTransferChannelWithReason(ctx, chid, reason)
 								}
 							}
 						}()
@@ -1031,14 +1033,14 @@ func TestUnrecognizedVoucherRoundTrip(t *testing.T) {
 			testutil.StartAndWaitForReady(ctx, t, dt2)
 
 			finished := make(chan struct{}, 2)
-			errChan := make(chan string, 2)
+			errChan := make(chan datatransfer.ChannelState, 2)
 			opened := make(chan struct{}, 2)
 			var subscriber datatransfer.Subscriber = func(event datatransfer.Event, channelState datatransfer.ChannelState) {
 				if channelState.Status() == datatransfer.Failed {
 					finished <- struct{}{}
 				}
 				if event.Code == datatransfer.Error {
-					errChan <- channelState.Message()
+					errChan <- channelState
 				}
 				if event.Code == datatransfer.Open {
 					opened <- struct{}{}
@@ -1068,9 +1070,11 @@ func TestUnrecognizedVoucherRoundTrip(t *testing.T) {
 					finishes++
 				case <-opened:
 					opens++
-				case errMessage := <-errChan:
-					require.Equal(t, errMessage, datatransfer.ErrRejected.Error())
-					errMessages = append(errMessages, errMessage)
+				case errState := <-errChan:
+					require.Equal(t, errState.Message(), datatransfer.ErrRejected.Error())
+					require.Equal(t, datatransfer.ErrorRejected, errState.ErrorCode())
+					require.False(t, errState.Retryable())
+					errMessages = append(errMessages, errState.Message())
 					if len(errMessages) > 1 {
 						t.Fatal("too many errors")
 					}
@@ -1080,6 +1084,163 @@ func TestUnrecognizedVoucherRoundTrip(t *testing.T) {
 	}
 }
 
+func TestRejectionReasonRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	testCases := map[string]struct {
+		isPull         bool
+		host1Protocols []protocol.ID
+		expectedReason datatransfer.Reason
+	}{
+		"push request": {
+			expectedReason: datatransfer.Reason{Code: 42, Text: "deal not found"},
+		},
+		"pull request": {
+			isPull:         true,
+			expectedReason: datatransfer.Reason{Code: 42, Text: "deal not found"},
+		},
+		"push request to 1.1 peer": {
+			host1Protocols: []protocol.ID{datatransfer.ProtocolDataTransfer1_1},
+		},
+	}
+	for testCase, data := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+
+			gsData := testutil.NewGraphsyncTestingData(ctx, t, data.host1Protocols, nil)
+			host1 := gsData.Host1 // data sender
+			host2 := gsData.Host2 // data recipient
+
+			tp1 := gsData.SetupGSTransportHost1()
+			tp2 := gsData.SetupGSTransportHost2()
+
+			dt1, err := NewDataTransfer(gsData.DtDs1, gsData.TempDir1, gsData.DtNet1, tp1, gsData.StoredCounter1)
+			require.NoError(t, err)
+			testutil.StartAndWaitForReady(ctx, t, dt1)
+			dt2, err := NewDataTransfer(gsData.DtDs2, gsData.TempDir2, gsData.DtNet2, tp2, gsData.StoredCounter2)
+			require.NoError(t, err)
+			testutil.StartAndWaitForReady(ctx, t, dt2)
+
+			errChan := make(chan datatransfer.ChannelState, 2)
+			var subscriber datatransfer.Subscriber = func(event datatransfer.Event, channelState datatransfer.ChannelState) {
+				if event.Code == datatransfer.Error {
+					errChan <- channelState
+				}
+			}
+			dt1.SubscribeToEvents(subscriber)
+			dt2.SubscribeToEvents(subscriber)
+			voucher := testutil.FakeDTType{Data: "applesauce"}
+			sv := testutil.NewStubbedValidator()
+			reason := datatransfer.Reason{Code: 42, Text: "deal not found"}
+
+			root, _ := testutil.LoadUnixFSFile(ctx, t, gsData.DagService1, loremFile)
+			rootCid := root.(cidlink.Link).Cid
+
+			if data.isPull {
+				sv.StubErrorPullWithReason(reason)
+				require.NoError(t, dt1.RegisterVoucherType(&testutil.FakeDTType{}, sv))
+				_, err = dt2.OpenPullDataChannel(ctx, host1.ID(), &voucher, rootCid, gsData.AllSelector)
+			} else {
+				sv.StubErrorPushWithReason(reason)
+				require.NoError(t, dt2.RegisterVoucherType(&testutil.FakeDTType{}, sv))
+				_, err = dt1.OpenPushDataChannel(ctx, host2.ID(), &voucher, rootCid, gsData.AllSelector)
+			}
+			require.NoError(t, err)
+
+			select {
+			case <-ctx.Done():
+				t.Fatal("did not receive rejection")
+			case chst := <-errChan:
+				require.Equal(t, datatransfer.ErrRejected.Error(), chst.Message())
+				require.Equal(t, datatransfer.ErrorRejected, chst.ErrorCode())
+				require.Equal(t, data.expectedReason, chst.Reason())
+			}
+		})
+	}
+}
+
+func TestRequestMetadataRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	labels := map[string]string{"deal": "1234"}
+	testCases := map[string]struct {
+		isPull            bool
+		host1Protocols    []protocol.ID
+		expectedTotalSize uint64
+		expectedLabels    map[string]string
+	}{
+		"push request": {
+			expectedTotalSize: 4096,
+			expectedLabels:    labels,
+		},
+		"pull request": {
+			isPull:            true,
+			expectedTotalSize: 4096,
+			expectedLabels:    labels,
+		},
+		"push request to 1.1 peer": {
+			host1Protocols: []protocol.ID{datatransfer.ProtocolDataTransfer1_1},
+		},
+	}
+	for testCase, data := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+
+			gsData := testutil.NewGraphsyncTestingData(ctx, t, data.host1Protocols, nil)
+			host1 := gsData.Host1 // data sender
+			host2 := gsData.Host2 // data recipient
+
+			tp1 := gsData.SetupGSTransportHost1()
+			tp2 := gsData.SetupGSTransportHost2()
+
+			dt1, err := NewDataTransfer(gsData.DtDs1, gsData.TempDir1, gsData.DtNet1, tp1, gsData.StoredCounter1)
+			require.NoError(t, err)
+			testutil.StartAndWaitForReady(ctx, t, dt1)
+			dt2, err := NewDataTransfer(gsData.DtDs2, gsData.TempDir2, gsData.DtNet2, tp2, gsData.StoredCounter2)
+			require.NoError(t, err)
+			testutil.StartAndWaitForReady(ctx, t, dt2)
+
+			accepted := make(chan datatransfer.ChannelState, 1)
+			var subscriber datatransfer.Subscriber = func(event datatransfer.Event, channelState datatransfer.ChannelState) {
+				if event.Code == datatransfer.Accept {
+					accepted <- channelState
+				}
+			}
+			voucher := testutil.FakeDTType{Data: "applesauce"}
+			sv := testutil.NewStubbedValidator()
+
+			root, _ := testutil.LoadUnixFSFile(ctx, t, gsData.DagService1, loremFile)
+			rootCid := root.(cidlink.Link).Cid
+			options := []datatransfer.ChannelOption{datatransfer.WithTotalSize(4096), datatransfer.WithLabels(labels)}
+
+			if data.isPull {
+				sv.StubSuccessPull()
+				require.NoError(t, dt1.RegisterVoucherType(&testutil.FakeDTType{}, sv))
+				dt1.SubscribeToEvents(subscriber)
+				_, err = dt2.OpenPullDataChannel(ctx, host1.ID(), &voucher, rootCid, gsData.AllSelector, options...)
+			} else {
+				sv.StubSuccessPush()
+				require.NoError(t, dt2.RegisterVoucherType(&testutil.FakeDTType{}, sv))
+				dt2.SubscribeToEvents(subscriber)
+				_, err = dt1.OpenPushDataChannel(ctx, host2.ID(), &voucher, rootCid, gsData.AllSelector, options...)
+			}
+			require.NoError(t, err)
+
+			select {
+			case <-ctx.Done():
+				t.Fatal("responder did not accept the request")
+			case chst := <-accepted:
+				require.Equal(t, data.expectedTotalSize, chst.TotalSize())
+				if data.expectedLabels == nil {
+					require.Empty(t, chst.Labels())
+				} else {
+					require.Equal(t, data.expectedLabels, chst.Labels())
+				}
+			}
+		})
+	}
+}
+
 func TestDataTransferSubscribing(t *testing.T) {
 	// create network
 	ctx := context.Background()
@@ -1259,7 +1420,7 @@ func TestRespondingToPushGraphsyncRequests(t *testing.T) {
 		extData := buf.Bytes()
 
 		request := gsmsg.NewRequest(graphsync.RequestID(rand.Int31()), link.(cidlink.Link).Cid, gsData.AllSelector, graphsync.Priority(rand.Int31()), graphsync.ExtensionData{
-			Name: extension.ExtensionDataTransfer1_1,
+			Name: extension.ExtensionDataTransfer1_2,
 			Data: extData,
 		})
 		builder := gsmsg.NewBuilder(0)
@@ -1281,7 +1442,7 @@ func TestRespondingToPushGraphsyncRequests(t *testing.T) {
 		extData := buf.Bytes()
 
 		request := gsmsg.NewRequest(graphsync.RequestID(rand.Int31()), link.(cidlink.Link).Cid, gsData.AllSelector, graphsync.Priority(rand.Int31()), graphsync.ExtensionData{
-			Name: extension.ExtensionDataTransfer1_1,
+			Name: extension.ExtensionDataTransfer1_2,
 			Data: extData,
 		})
 		builder := gsmsg.NewBuilder(0)
@@ -1377,7 +1538,7 @@ func TestRespondingToPullGraphsyncRequests(t *testing.T) {
 				extData := buf.Bytes()
 
 				gsRequest := gsmsg.NewRequest(graphsync.RequestID(rand.Int31()), link.(cidlink.Link).Cid, gsData.AllSelector, graphsync.Priority(rand.Int31()), graphsync.ExtensionData{
-					Name: extension.ExtensionDataTransfer1_1,
+					Name: extension.ExtensionDataTransfer1_2,
 					Data: extData,
 				})
 
@@ -1408,7 +1569,7 @@ func TestRespondingToPullGraphsyncRequests(t *testing.T) {
 				require.NoError(t, err)
 				extData := buf.Bytes()
 				request := gsmsg.NewRequest(graphsync.RequestID(rand.Int31()), link.(cidlink.Link).Cid, gsData.AllSelector, graphsync.Priority(rand.Int31()), graphsync.ExtensionData{
-					Name: extension.ExtensionDataTransfer1_1,
+					Name: extension.ExtensionDataTransfer1_2,
 					Data: extData,
 				})
 				builder := gsmsg.NewBuilder(0)
@@ -1484,6 +1645,9 @@ func (r *receiver) ReceiveResponse(
 func (r *receiver) ReceiveError(err error) {
 }
 
+func (r *receiver) ReceivePeerConnected(p peer.ID) {
+}
+
 func (r *receiver) ReceiveRestartExistingChannelRequest(ctx context.Context,
 	sender peer.ID,
 	incoming datatransfer.Request) {
@@ -9,12 +9,15 @@ import (
 
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-data-transfer/channels"
+	"github.com/filecoin-project/go-data-transfer/network"
 )
 
 type receiver struct {
 	manager *manager
 }
 
+var _ network.PeerConnectedReceiver = (*receiver)(nil)
+
 // ReceiveRequest takes an incoming data transfer request, validates the voucher and
 // processes the message.
 func (r *receiver) ReceiveRequest(
@@ -46,11 +49,11 @@ func (r *receiver) receiveRequest(ctx context.Context, initiator peer.ID, incomi
 		if (response.IsNew() || response.IsRestart()) && response.Accepted() && !incoming.IsPull() {
 			var doNotSendCids []cid.Cid
 			if response.IsRestart() {
-				channel, err := r.manager.channels.GetByID(ctx, chid)
+				var err error
+				doNotSendCids, err = r.manager.receivedCids(chid)
 				if err != nil {
 					return err
 				}
-				doNotSendCids = channel.ReceivedCids()
 			}
 
 			stor, _ := incoming.Selector()
@@ -110,6 +113,12 @@ func (r *receiver) ReceiveError(err error) {
 	log.Errorf("received error message on data transfer: %s", err.Error())
 }
 
+// ReceivePeerConnected restarts any stalled channels with the peer, if
+// restarting on reconnect is enabled
+func (r *receiver) ReceivePeerConnected(p peer.ID) {
+	r.manager.restartStalledChannels(p)
+}
+
 func (r *receiver) ReceiveRestartExistingChannelRequest(ctx context.Context,
 	sender peer.ID,
 	incoming datatransfer.Request) {
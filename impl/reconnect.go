@@ -0,0 +1,106 @@
+package impl
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jpillora/backoff"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+)
+
+// reconnectRestartConfig configures restarting stalled channels when the
+// other peer connects again
+type reconnectRestartConfig struct {
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	maxAttempts uint32
+}
+
+// restartStalledChannels restarts the channels we opened with the given peer
+// that have stalled or disconnected and are waiting to be removed, starting
+// with the channels with the highest priority.
+// Only the initiator restarts a channel, so that both sides of a channel don't
+// try to restart it at the same time when they reconnect.
+func (m *manager) restartStalledChannels(p peer.ID) {
+	if m.reconnectRestartCfg == nil {
+		return
+	}
+
+	var stalled []datatransfer.ChannelState
+	for _, chid := range m.removals.Pending() {
+		if chid.Initiator != m.peerID || chid.Responder != p {
+			continue
+		}
+		chst, err := m.channels.GetByID(m.ctx, chid)
+		if err != nil {
+			log.Warnf("channel %s: peer reconnected, failed to get channel: %s", chid, err)
+			continue
+		}
+		stalled = append(stalled, chst)
+	}
+	sort.SliceStable(stalled, func(i, j int) bool {
+		return stalled[i].Priority() > stalled[j].Priority()
+	})
+	for _, chst := range stalled {
+		m.restartOnReconnect(chst.ChannelID())
+	}
+}
+
+// restartOnReconnect tries to restart the channel, and if that fails keeps
+// trying in the background, backing off between attempts, until it succeeds,
+// the channel recovers or is removed, or it runs out of attempts. If another
+// automatic restart of the channel is in flight, the channel is left to it.
+func (m *manager) restartOnReconnect(chid datatransfer.ChannelID) {
+	if !m.beginRestart(chid) {
+		log.Debugf("channel %s: peer reconnected, already restarting channel", chid)
+		return
+	}
+	if m.reconnectRestartAttempt(chid, 1) {
+		m.endRestart(chid)
+		return
+	}
+	go func() {
+		defer m.endRestart(chid)
+
+		cfg := m.reconnectRestartCfg
+		b := &backoff.Backoff{
+			Min:    cfg.minBackoff,
+			Max:    cfg.maxBackoff,
+			Factor: 2,
+			Jitter: true,
+		}
+		for attempt := uint32(2); ; attempt++ {
+			d := b.Duration()
+			log.Warnf("channel %s: waiting %s to try restarting channel again", chid, d)
+			if !m.wait(d) {
+				return
+			}
+			if m.reconnectRestartAttempt(chid, attempt) {
+				return
+			}
+		}
+	}()
+}
+
+// reconnectRestartAttempt makes one attempt to restart the channel after its
+// peer reconnected, and returns whether there is no need to try again
+func (m *manager) reconnectRestartAttempt(chid datatransfer.ChannelID, attempt uint32) bool {
+	if !m.isStalled(chid) {
+		return true
+	}
+
+	cfg := m.reconnectRestartCfg
+	log.Infof("channel %s: peer reconnected, restarting channel (attempt %d of %d)", chid, attempt, cfg.maxAttempts)
+	err := m.attemptRestart(m.ctx, chid)
+	if err == nil {
+		return true
+	}
+	if attempt >= cfg.maxAttempts {
+		log.Warnf("channel %s: giving up restarting channel after %d attempts: %s", chid, attempt, err)
+		return true
+	}
+	log.Warnf("channel %s: failed to restart channel: %s", chid, err)
+	return false
+}
@@ -0,0 +1,68 @@
+package impl
+
+import (
+	"context"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/channels"
+)
+
+// scheduleRemoval marks a channel that has timed out or disconnected as
+// stalled, and schedules it to be removed once its remove timeout passes,
+// unless data flows on it again first. If the channel is already stalled, it
+// keeps its existing deadline.
+func (m *manager) scheduleRemoval(ctx context.Context, chid datatransfer.ChannelID) {
+	if m.isStalled(chid) {
+		return
+	}
+	deadline := m.clock.Now().Add(m.removeTimeout(ctx, chid))
+	if err := m.removals.Schedule(chid, deadline); err != nil {
+		log.Errorf("channel %s: scheduling removal: %s", chid, err)
+	}
+}
+
+// cancelRemoval clears the stalled mark on a channel, when data flows on it
+// again or it is cleaned up
+func (m *manager) cancelRemoval(chid datatransfer.ChannelID) {
+	if err := m.removals.Cancel(chid); err != nil {
+		log.Errorf("channel %s: cancelling removal: %s", chid, err)
+	}
+}
+
+// isStalled returns true if the channel has stalled or disconnected and has
+// not received or sent data since
+func (m *manager) isStalled(chid datatransfer.ChannelID) bool {
+	_, ok := m.removals.Deadline(chid)
+	return ok
+}
+
+// removeStalledChannel is called by the removal scheduler when a channel has
+// been stalled for longer than its remove timeout
+func (m *manager) removeStalledChannel(chid datatransfer.ChannelID) {
+	channel, err := m.channels.GetByID(m.ctx, chid)
+	if err != nil {
+		return
+	}
+	if channels.IsChannelTerminated(channel.Status()) || channels.IsChannelCleaningUp(channel.Status()) {
+		return
+	}
+	if err := m.channels.Error(chid, datatransfer.ErrRemoved); err != nil {
+		log.Errorf("failed to cancel timed-out channel: %v", err)
+		return
+	}
+	log.Warnf("channel %+v has been cancelled because of timeout", chid)
+}
+
+// wait waits for the given duration on the manager's clock. It returns false
+// if the manager is stopped first.
+func (m *manager) wait(d time.Duration) bool {
+	timer := m.clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-m.ctx.Done():
+		return false
+	case <-timer.C():
+		return true
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 
+	"github.com/ipfs/go-cid"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"golang.org/x/xerrors"
@@ -92,11 +93,12 @@ func (m *manager) openPushRestartChannel(ctx context.Context, channel datatransf
 		return err
 	}
 
-	processor, has := m.transportConfigurers.Processor(voucher.Type())
-	if has {
-		transportConfigurer := processor.(datatransfer.TransportConfigurer)
-		transportConfigurer(chid, voucher, m.transport)
+	options := m.persistedOptions(channel)
+	if err := m.applyTransportOptions(chid, options.TransportOptions); err != nil {
+		return err
 	}
+	m.configureTransport(chid, voucher, options)
+	m.addPeerAddrs(requestTo, options.PeerAddrs)
 	m.dataTransferNetwork.Protect(requestTo, chid.String())
 
 	log.Infof("sending push restart channel to %s for channel %s", requestTo, chid)
@@ -119,21 +121,53 @@ func (m *manager) openPullRestartChannel(ctx context.Context, channel datatransf
 		return err
 	}
 
-	processor, has := m.transportConfigurers.Processor(voucher.Type())
-	if has {
-		transportConfigurer := processor.(datatransfer.TransportConfigurer)
-		transportConfigurer(chid, voucher, m.transport)
+	options := m.persistedOptions(channel)
+	if err := m.applyTransportOptions(chid, options.TransportOptions); err != nil {
+		return err
 	}
+	m.configureTransport(chid, voucher, options)
+	m.addPeerAddrs(requestTo, options.PeerAddrs)
 	m.dataTransferNetwork.Protect(requestTo, chid.String())
 
+	doNotSendCids, err := m.receivedCids(chid)
+	if err != nil {
+		return xerrors.Errorf("Unable to read received cids: %w", err)
+	}
+
 	log.Infof("sending open channel to %s to restart channel %s", requestTo, chid)
-	if err := m.transport.OpenChannel(ctx, requestTo, chid, cidlink.Link{Cid: baseCid}, selector, channel.ReceivedCids(), req); err != nil {
+	if err := m.transport.OpenChannel(ctx, requestTo, chid, cidlink.Link{Cid: baseCid}, selector, doNotSendCids, req); err != nil {
 		return xerrors.Errorf("Unable to send open channel restart request: %w", err)
 	}
 
 	return nil
 }
 
+// errEnoughCids stops reading received cids once the transport has as many as
+// it uses
+var errEnoughCids = xerrors.New("read as many cids as the transport uses")
+
+// receivedCids streams the cids received so far on the channel from the
+// channel's cid list, for the transport not to send again on restart. If the
+// transport only uses a limited number of them, only that many are read.
+func (m *manager) receivedCids(chid datatransfer.ChannelID) ([]cid.Cid, error) {
+	max := -1
+	if limited, ok := m.transport.(datatransfer.DoNotSendLimitedTransport); ok {
+		max = limited.MaxDoNotSendCids()
+	}
+	var receivedCids []cid.Cid
+	err := m.channels.IterateReceivedCids(chid, func(c cid.Cid) error {
+		if len(receivedCids) == max {
+			return errEnoughCids
+		}
+		receivedCids = append(receivedCids, c)
+		return nil
+	})
+	if err != nil && err != errEnoughCids {
+		return nil, err
+	}
+	return receivedCids, nil
+}
+
 func (m *manager) validateRestartRequest(ctx context.Context, otherPeer peer.ID, chid datatransfer.ChannelID, req datatransfer.Request) error {
 	// channel should exist
 	channel, err := m.channels.GetByID(ctx, chid)
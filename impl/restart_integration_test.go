@@ -18,6 +18,7 @@ import (
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	. "github.com/filecoin-project/go-data-transfer/impl"
 	"github.com/filecoin-project/go-data-transfer/testutil"
+	gstransport "github.com/filecoin-project/go-data-transfer/transport/graphsync"
 )
 
 const totalIncrements = 204
@@ -439,6 +440,102 @@ func TestRestartPull(t *testing.T) {
 	}
 }
 
+// TestRestartPullAboveDoNotSendCap restarts a pull after more blocks have been
+// received than fit in a single graphsync request, and checks that the
+// blocks that were already received are not sent again
+func TestRestartPullAboveDoNotSendCap(t *testing.T) {
+	const maxDoNotSendCids = 10
+	const stopAt = 40
+
+	rh := newRestartHarness(t)
+	defer rh.cancel()
+	rh.sv.ExpectSuccessPull()
+	testutil.StartAndWaitForReady(rh.testCtx, t, rh.dt1)
+	testutil.StartAndWaitForReady(rh.testCtx, t, rh.dt2)
+
+	finished := make(chan peer.ID, 2)
+	errChan := make(chan *peerError, 2)
+	disConnChan := make(chan struct{}, 1)
+	receivedTillNow := atomic.NewInt32(0)
+	restarted := atomic.NewBool(false)
+	sentAfterRestart := atomic.NewInt32(0)
+	var subscriber datatransfer.Subscriber = func(event datatransfer.Event, channelState datatransfer.ChannelState) {
+		if event.Code == datatransfer.DataReceived && receivedTillNow.Inc() == stopAt {
+			require.NoError(t, rh.gsData.Mn.UnlinkPeers(rh.peer1, rh.peer2))
+			require.NoError(t, rh.gsData.Mn.DisconnectPeers(rh.peer1, rh.peer2))
+			disConnChan <- struct{}{}
+		}
+		// the sent event fires for every block that goes over the wire,
+		// even blocks that were sent before
+		if event.Code == datatransfer.DataSent && restarted.Load() {
+			sentAfterRestart.Inc()
+		}
+		if channelState.Status() == datatransfer.Completed {
+			finished <- channelState.SelfPeer()
+		}
+		if event.Code == datatransfer.Error {
+			errChan <- &peerError{channelState.SelfPeer(), xerrors.New(channelState.Message())}
+		}
+	}
+	rh.dt1.SubscribeToEvents(subscriber)
+	rh.dt2.SubscribeToEvents(subscriber)
+
+	voucher := testutil.FakeDTType{Data: "applesauce"}
+	chid, err := rh.dt2.OpenPullDataChannel(rh.testCtx, rh.peer1, &voucher, rh.rootCid, rh.gsData.AllSelector)
+	require.NoError(t, err)
+	select {
+	case <-time.After(10 * time.Second):
+		t.Fatal("did not hear a disconnection: test timed out")
+	case <-disConnChan:
+	}
+
+	// restart the receiver with a transport that only fits a few of the
+	// received cids in a request
+	require.NoError(t, rh.dt2.Stop(rh.testCtx))
+	time.Sleep(100 * time.Millisecond)
+	gs2 := rh.gsData.SetupGraphsyncHost2()
+	tp2 := gstransport.NewTransport(rh.peer2, gs2, gstransport.MaxDoNotSendCids(maxDoNotSendCids))
+	rh.dt2, err = NewDataTransfer(rh.gsData.DtDs2, rh.gsData.TempDir2, rh.gsData.DtNet2, tp2, rh.gsData.StoredCounter2)
+	require.NoError(t, err)
+	require.NoError(t, rh.dt2.RegisterVoucherType(&testutil.FakeDTType{}, rh.sv))
+	testutil.StartAndWaitForReady(rh.testCtx, t, rh.dt2)
+	rh.dt2.SubscribeToEvents(subscriber)
+
+	chst, err := rh.dt2.ChannelState(rh.testCtx, chid)
+	require.NoError(t, err)
+	received := cid.NewSet()
+	for _, c := range chst.ReceivedCids() {
+		received.Add(c)
+	}
+	require.Greater(t, received.Len(), maxDoNotSendCids)
+
+	require.NoError(t, rh.gsData.Mn.LinkAll())
+	_, err = rh.gsData.Mn.ConnectPeers(rh.peer1, rh.peer2)
+	require.NoError(t, err)
+	restarted.Store(true)
+	require.NoError(t, rh.dt2.RestartDataTransferChannel(rh.testCtx, chid))
+
+	waitCtx, cancel := context.WithTimeout(rh.testCtx, 10*time.Second)
+	defer cancel()
+	for completes := 0; completes < 2; {
+		select {
+		case <-waitCtx.Done():
+			t.Fatal("data transfer did not complete after restart")
+		case <-finished:
+			completes++
+		case perr := <-errChan:
+			t.Fatalf("received error on peer %s, err: %v", perr.p.Pretty(), perr.err)
+		}
+	}
+
+	testutil.VerifyHasFile(rh.testCtx, t, rh.destDagService, rh.root, rh.origBytes)
+	// only the blocks that had not been received were sent after the restart
+	require.EqualValues(t, totalIncrements-received.Len(), sentAfterRestart.Load())
+	recvChan, err := rh.dt2.ChannelState(context.Background(), chid)
+	require.NoError(t, err)
+	require.Equal(t, expectedTransferSize, int(recvChan.Received()))
+}
+
 type restartHarness struct {
 	t       *testing.T
 	testCtx context.Context
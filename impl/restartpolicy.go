@@ -0,0 +1,74 @@
+package impl
+
+import (
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/channels"
+)
+
+// restartPolicyFor returns the restart policy for the channel, or nil if it
+// should not be restarted automatically
+func (m *manager) restartPolicyFor(chst datatransfer.ChannelState) datatransfer.RestartPolicy {
+	if len(m.voucherRestartPolicy) > 0 {
+		if voucher := chst.Voucher(); voucher != nil {
+			if policy, ok := m.voucherRestartPolicy[voucher.Type()]; ok {
+				return policy
+			}
+		}
+	}
+	return m.restartPolicy
+}
+
+// scheduleRestart starts restarting a channel that has timed out or
+// disconnected according to its restart policy. Restarting stops when data
+// flows on the channel again.
+func (m *manager) scheduleRestart(chid datatransfer.ChannelID) {
+	chst, err := m.channels.GetByID(m.ctx, chid)
+	if err != nil {
+		return
+	}
+	policy := m.restartPolicyFor(chst)
+	if policy == nil {
+		return
+	}
+
+	if !m.beginRestart(chid) {
+		// already restarting this channel
+		return
+	}
+	go m.restartWithPolicy(chid, policy)
+}
+
+func (m *manager) restartWithPolicy(chid datatransfer.ChannelID, policy datatransfer.RestartPolicy) {
+	defer m.endRestart(chid)
+
+	for {
+		chst, err := m.channels.GetByID(m.ctx, chid)
+		if err != nil {
+			return
+		}
+		if channels.IsChannelTerminated(chst.Status()) || channels.IsChannelCleaningUp(chst.Status()) {
+			return
+		}
+		attempts := chst.RestartAttempts()
+		wait, ok := policy.NextRestart(chst, attempts)
+		if !ok {
+			log.Warnf("channel %s: restart policy allows no more restarts after %d attempts", chid, attempts)
+			return
+		}
+
+		if !m.wait(wait) {
+			return
+		}
+		if !m.isStalled(chid) {
+			// data is flowing again
+			return
+		}
+
+		log.Infof("channel %s: restarting channel (attempt %d)", chid, attempts+1)
+		restartErr := m.attemptRestart(m.ctx, chid)
+		if restartErr == nil {
+			return
+		}
+		log.Warnf("channel %s: failed to restart channel: %s", chid, restartErr)
+	}
+}
@@ -0,0 +1,57 @@
+package impl
+
+import (
+	"context"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+)
+
+// Channels are restarted automatically by the push channel monitor, when the
+// other peer connects again, and according to the restart policy. All of
+// these claim the channel with beginRestart first, so that only one automatic
+// restart of a channel is in flight at a time, and make each attempt with
+// attemptRestart, so that it is counted in the channel's restart attempts.
+
+// beginRestart claims the channel for an automatic restart. It returns false
+// if another automatic restart of the channel is in flight.
+func (m *manager) beginRestart(chid datatransfer.ChannelID) bool {
+	m.restartsLk.Lock()
+	defer m.restartsLk.Unlock()
+	if _, ok := m.restarts[chid]; ok {
+		return false
+	}
+	m.restarts[chid] = struct{}{}
+	return true
+}
+
+// endRestart releases a channel claimed with beginRestart
+func (m *manager) endRestart(chid datatransfer.ChannelID) {
+	m.restartsLk.Lock()
+	defer m.restartsLk.Unlock()
+	delete(m.restarts, chid)
+}
+
+// attemptRestart restarts the channel, and records the attempt on it
+func (m *manager) attemptRestart(ctx context.Context, chid datatransfer.ChannelID) error {
+	restartErr := m.RestartDataTransferChannel(ctx, chid)
+	if err := m.channels.RestartAttempted(chid, restartErr); err != nil {
+		log.Errorf("channel %s: recording restart attempt: %s", chid, err)
+	}
+	return restartErr
+}
+
+// monitorRestarts is the manager as the push channel monitor uses it. The
+// monitor's restarts go through the same in flight set as the manager's own,
+// and a restart the monitor asks for while another is in flight is skipped.
+type monitorRestarts struct {
+	*manager
+}
+
+func (mr monitorRestarts) RestartDataTransferChannel(ctx context.Context, chid datatransfer.ChannelID) error {
+	if !mr.beginRestart(chid) {
+		log.Debugf("channel %s: not restarting for push channel monitor, already restarting", chid)
+		return nil
+	}
+	defer mr.endRestart(chid)
+	return mr.attemptRestart(ctx, chid)
+}
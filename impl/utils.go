@@ -2,14 +2,17 @@ package impl
 
 import (
 	"context"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
 	"golang.org/x/xerrors"
 
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-data-transfer/message"
+	"github.com/filecoin-project/go-data-transfer/network"
 	"github.com/filecoin-project/go-data-transfer/registry"
 )
 
@@ -30,41 +33,155 @@ var resumeTransportStatesResponder = statusList{
 	datatransfer.InitiatorPaused,
 }
 
-// newRequest encapsulates message creation
-func (m *manager) newRequest(ctx context.Context, selector ipld.Node, isPull bool, voucher datatransfer.Voucher, baseCid cid.Cid, to peer.ID) (datatransfer.Request, error) {
+// newRequest encapsulates message creation. The total size and labels from
+// the channel options are sent along with the request, so the responder can
+// record them on its side of the channel.
+func (m *manager) newRequest(ctx context.Context, selector ipld.Node, isPull bool, voucher datatransfer.Voucher, baseCid cid.Cid, to peer.ID, options datatransfer.ChannelOptions) (datatransfer.Request, error) {
 	next, err := m.storedCounter.Next()
 	if err != nil {
 		return nil, err
 	}
 	tid := datatransfer.TransferID(next)
-	return message.NewRequest(tid, false, isPull, voucher.Type(), voucher, baseCid, selector)
+	req, err := message.NewRequest(tid, false, isPull, voucher.Type(), voucher, baseCid, selector)
+	if err != nil {
+		return nil, err
+	}
+	if options.TotalSize != 0 {
+		req, err = message.RequestWithTotalSize(req, options.TotalSize)
+		if err != nil {
+			return nil, xerrors.Errorf("adding total size to request: %w", err)
+		}
+	}
+	if len(options.Labels) != 0 {
+		req, err = message.RequestWithLabels(req, options.Labels)
+		if err != nil {
+			return nil, xerrors.Errorf("adding labels to request: %w", err)
+		}
+	}
+	return req, nil
+}
+
+// configureTransport runs the transport configurer registered for the
+// voucher's type, if there is one
+func (m *manager) configureTransport(chid datatransfer.ChannelID, voucher datatransfer.Voucher, options datatransfer.ChannelOptions) {
+	processor, has := m.transportConfigurers.Processor(voucher.Type())
+	if !has {
+		return
+	}
+	switch configurer := processor.(type) {
+	case datatransfer.TransportConfigurer:
+		configurer(chid, voucher, m.transport)
+	case datatransfer.ChannelTransportConfigurer:
+		configurer(chid, voucher, m.transport, options)
+	}
+}
+
+// applyTransportOptions applies the per channel transport options given when
+// the channel was opened
+func (m *manager) applyTransportOptions(chid datatransfer.ChannelID, transportOptions []datatransfer.TransportOption) error {
+	for _, transportOption := range transportOptions {
+		if err := transportOption(chid, m.transport); err != nil {
+			return xerrors.Errorf("applying transport option to channel %s: %w", chid, err)
+		}
+	}
+	return nil
+}
+
+// setTransportOptions keeps the transport options a channel was opened with,
+// so they can be applied again when it is restarted. Transport options cannot
+// be saved to the datastore, so a channel restarted after the manager itself
+// restarts is opened without them.
+func (m *manager) setTransportOptions(chid datatransfer.ChannelID, transportOptions []datatransfer.TransportOption) {
+	if len(transportOptions) == 0 {
+		return
+	}
+	m.transportOptionsLk.Lock()
+	defer m.transportOptionsLk.Unlock()
+	m.transportOptions[chid] = transportOptions
+}
+
+func (m *manager) channelTransportOptions(chid datatransfer.ChannelID) []datatransfer.TransportOption {
+	m.transportOptionsLk.Lock()
+	defer m.transportOptionsLk.Unlock()
+	return m.transportOptions[chid]
 }
 
-func (m *manager) response(isRestart bool, isNew bool, err error, tid datatransfer.TransferID, voucherResult datatransfer.VoucherResult) (datatransfer.Response, error) {
-	isAccepted := err == nil || err == datatransfer.ErrPause
-	isPaused := err == datatransfer.ErrPause
+func (m *manager) clearTransportOptions(chid datatransfer.ChannelID) {
+	m.transportOptionsLk.Lock()
+	defer m.transportOptionsLk.Unlock()
+	delete(m.transportOptions, chid)
+}
+
+// persistedOptions reconstructs the channel options the channel was opened
+// with, from those saved with the channel and the transport options kept in
+// memory
+func (m *manager) persistedOptions(chst datatransfer.ChannelState) datatransfer.ChannelOptions {
+	return datatransfer.ChannelOptions{
+		TotalSize:        chst.TotalSize(),
+		RemoveTimeout:    chst.RemoveTimeout(),
+		Labels:           chst.Labels(),
+		Priority:         chst.Priority(),
+		PeerAddrs:        chst.PeerAddrs(),
+		TransportOptions: m.channelTransportOptions(chst.ChannelID()),
+	}
+}
+
+// addPeerAddrs gives the network the addresses the other peer of a channel is
+// known to be reachable at, if the network keeps addresses
+func (m *manager) addPeerAddrs(p peer.ID, addrs []ma.Multiaddr) {
+	if len(addrs) == 0 {
+		return
+	}
+	if addrBook, ok := m.dataTransferNetwork.(network.PeerAddrBook); ok {
+		addrBook.AddPeerAddrs(p, addrs)
+	}
+}
+
+// removeTimeout returns how long the channel may stay stalled or disconnected
+// before it is failed
+func (m *manager) removeTimeout(ctx context.Context, chid datatransfer.ChannelID) time.Duration {
+	chst, err := m.channels.GetByID(ctx, chid)
+	if err != nil || chst.RemoveTimeout() == 0 {
+		return m.channelRemoveTimeout
+	}
+	return chst.RemoveTimeout()
+}
+
+func (m *manager) response(isRestart bool, isNew bool, resultErr error, tid datatransfer.TransferID, voucherResult datatransfer.VoucherResult) (datatransfer.Response, error) {
+	isAccepted := resultErr == nil || resultErr == datatransfer.ErrPause
+	isPaused := resultErr == datatransfer.ErrPause
 	resultType := datatransfer.EmptyTypeIdentifier
 	if voucherResult != nil {
 		resultType = voucherResult.Type()
 	}
-	if isRestart {
-		return message.RestartResponse(tid, isAccepted, isPaused, resultType, voucherResult)
+	var response datatransfer.Response
+	var err error
+	switch {
+	case isRestart:
+		response, err = message.RestartResponse(tid, isAccepted, isPaused, resultType, voucherResult)
+	case isNew:
+		response, err = message.NewResponse(tid, isAccepted, isPaused, resultType, voucherResult)
+	default:
+		response, err = message.VoucherResultResponse(tid, isAccepted, isPaused, resultType, voucherResult)
 	}
-
-	if isNew {
-		return message.NewResponse(tid, isAccepted, isPaused, resultType, voucherResult)
+	if err != nil {
+		return nil, err
 	}
-	return message.VoucherResultResponse(tid, isAccepted, isPaused, resultType, voucherResult)
+	return message.ResponseWithReason(response, rejectionReason(resultErr))
 }
 
-func (m *manager) completeResponse(err error, tid datatransfer.TransferID, voucherResult datatransfer.VoucherResult) (datatransfer.Response, error) {
-	isAccepted := err == nil || err == datatransfer.ErrPause
-	isPaused := err == datatransfer.ErrPause
+func (m *manager) completeResponse(resultErr error, tid datatransfer.TransferID, voucherResult datatransfer.VoucherResult) (datatransfer.Response, error) {
+	isAccepted := resultErr == nil || resultErr == datatransfer.ErrPause
+	isPaused := resultErr == datatransfer.ErrPause
 	resultType := datatransfer.EmptyTypeIdentifier
 	if voucherResult != nil {
 		resultType = voucherResult.Type()
 	}
-	return message.CompleteResponse(tid, isAccepted, isPaused, resultType, voucherResult)
+	response, err := message.CompleteResponse(tid, isAccepted, isPaused, resultType, voucherResult)
+	if err != nil {
+		return nil, err
+	}
+	return message.ResponseWithReason(response, rejectionReason(resultErr))
 }
 
 func (m *manager) resume(chid datatransfer.ChannelID) error {
@@ -109,11 +226,21 @@ func (m *manager) pauseMessage(chid datatransfer.ChannelID) datatransfer.Message
 	return message.UpdateResponse(chid.ID, true)
 }
 
-func (m *manager) cancelMessage(chid datatransfer.ChannelID) datatransfer.Message {
+func (m *manager) cancelMessage(chid datatransfer.ChannelID, reason datatransfer.Reason) (datatransfer.Message, error) {
 	if chid.Initiator == m.peerID {
-		return message.CancelRequest(chid.ID)
+		return message.RequestWithReason(message.CancelRequest(chid.ID), reason)
+	}
+	return message.ResponseWithReason(message.CancelResponse(chid.ID), reason)
+}
+
+// rejectionReason extracts the reason a validator gave for rejecting a
+// request, if it gave one
+func rejectionReason(err error) datatransfer.Reason {
+	var reason datatransfer.Reason
+	if err == nil || !xerrors.As(err, &reason) {
+		return datatransfer.Reason{}
 	}
-	return message.CancelResponse(chid.ID)
+	return reason
 }
 
 func (m *manager) decodeVoucherResult(response datatransfer.Response) (datatransfer.VoucherResult, error) {
@@ -0,0 +1,72 @@
+package datatransfer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/filecoin-project/go-data-transfer/encoding"
+)
+
+// JSONEncoder renders a decoded voucher or voucher result as JSON, for types
+// that don't have a useful encoding/json form of their own
+type JSONEncoder func(encoding.Encodable) ([]byte, error)
+
+// JSONChannelID is the stable JSON schema of a channel ID, for APIs that
+// expose transfers to a UI
+type JSONChannelID struct {
+	Initiator peer.ID    `json:"initiator"`
+	Responder peer.ID    `json:"responder"`
+	ID        TransferID `json:"id"`
+}
+
+// NewJSONChannelID converts a channel ID to its stable JSON schema
+func NewJSONChannelID(chid ChannelID) JSONChannelID {
+	return JSONChannelID{Initiator: chid.Initiator, Responder: chid.Responder, ID: chid.ID}
+}
+
+// ChannelID converts the JSON schema back to a channel ID
+func (c JSONChannelID) ChannelID() ChannelID {
+	return ChannelID{Initiator: c.Initiator, Responder: c.Responder, ID: c.ID}
+}
+
+// JSONEvent is the stable JSON schema of an event, with its code written by
+// its name in Events
+type JSONEvent struct {
+	Code      string    `json:"code"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// NewJSONEvent converts an event to its stable JSON schema
+func NewJSONEvent(evt Event) JSONEvent {
+	return JSONEvent{Code: EventName(evt.Code), Message: evt.Message, Timestamp: evt.Timestamp}
+}
+
+// StatusName returns the name of a status in Statuses, or its number if it
+// has no name
+func StatusName(s Status) string {
+	if name, ok := Statuses[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Status(%d)", s)
+}
+
+// EventName returns the name of an event code in Events, or its number if it
+// has no name
+func EventName(e EventCode) string {
+	if name, ok := Events[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("EventCode(%d)", e)
+}
+
+// ErrorCodeName returns the name of an error code in ErrorCodes, or its
+// number if it has no name
+func ErrorCodeName(c ErrorCode) string {
+	if name, ok := ErrorCodes[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("ErrorCode(%d)", c)
+}
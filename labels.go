@@ -0,0 +1,29 @@
+package datatransfer
+
+// LabelSelector matches channels that have all of the given labels set to
+// the given values. An empty selector matches every channel.
+type LabelSelector map[string]string
+
+// Matches returns true if the channel has every label in the selector
+func (ls LabelSelector) Matches(chst ChannelState) bool {
+	if len(ls) == 0 {
+		return true
+	}
+	labels := chst.Labels()
+	for key, value := range ls {
+		if labelValue, ok := labels[key]; !ok || labelValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// SubscriberWithLabels wraps a subscriber so that it is only called for
+// events on channels that match the given label selector
+func SubscriberWithLabels(selector LabelSelector, subscriber Subscriber) Subscriber {
+	return func(event Event, channelState ChannelState) {
+		if selector.Matches(channelState) {
+			subscriber(event, channelState)
+		}
+	}
+}
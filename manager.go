@@ -2,6 +2,7 @@ package datatransfer
 
 import (
 	"context"
+	"io"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
@@ -60,6 +61,10 @@ type Revalidator interface {
 // TransportConfigurer provides a mechanism to provide transport specific configuration for a given voucher type
 type TransportConfigurer func(chid ChannelID, voucher Voucher, transport Transport)
 
+// ChannelTransportConfigurer is a TransportConfigurer that also receives the
+// options the channel was opened with
+type ChannelTransportConfigurer func(chid ChannelID, voucher Voucher, transport Transport, options ChannelOptions)
+
 // ReadyFunc is function that gets called once when the data transfer module is ready
 type ReadyFunc func(error)
 
@@ -98,11 +103,11 @@ type Manager interface {
 
 	// open a data transfer that will send data to the recipient peer and
 	// transfer parts of the piece that match the selector
-	OpenPushDataChannel(ctx context.Context, to peer.ID, voucher Voucher, baseCid cid.Cid, selector ipld.Node) (ChannelID, error)
+	OpenPushDataChannel(ctx context.Context, to peer.ID, voucher Voucher, baseCid cid.Cid, selector ipld.Node, options ...ChannelOption) (ChannelID, error)
 
 	// open a data transfer that will request data from the sending peer and
 	// transfer parts of the piece that match the selector
-	OpenPullDataChannel(ctx context.Context, to peer.ID, voucher Voucher, baseCid cid.Cid, selector ipld.Node) (ChannelID, error)
+	OpenPullDataChannel(ctx context.Context, to peer.ID, voucher Voucher, baseCid cid.Cid, selector ipld.Node, options ...ChannelOption) (ChannelID, error)
 
 	// send an intermediate voucher as needed when the receiver sends a request for revalidation
 	SendVoucher(ctx context.Context, chid ChannelID, voucher Voucher) error
@@ -110,6 +115,9 @@ type Manager interface {
 	// close an open channel (effectively a cancel)
 	CloseDataTransferChannel(ctx context.Context, chid ChannelID) error
 
+	// close an open channel, sending the other party the reason for closing it
+	CloseDataTransferChannelWithReason(ctx context.Context, chid ChannelID, reason Reason) error
+
 	// pause a data transfer channel (only allowed if transport supports it)
 	PauseDataTransferChannel(ctx context.Context, chid ChannelID) error
 
@@ -128,6 +136,28 @@ type Manager interface {
 	// get all in progress transfers
 	InProgressChannels(ctx context.Context) (map[ChannelID]ChannelState, error)
 
+	// get all transfers whose labels match the given selector
+	ChannelsWithLabels(ctx context.Context, selector LabelSelector) (map[ChannelID]ChannelState, error)
+
+	// get all transfers with the given peer, as initiator or responder
+	ChannelsByPeer(ctx context.Context, p peer.ID) (map[ChannelID]ChannelState, error)
+
+	// get all transfers for the given base CID
+	ChannelsByBaseCID(ctx context.Context, baseCid cid.Cid) (map[ChannelID]ChannelState, error)
+
+	// write the state of every channel, with its received and seen CIDs, to
+	// a stream that can be imported later. Channels that are transferring
+	// data while they are exported may be exported with CIDs they had not
+	// counted yet.
+	ExportChannels(ctx context.Context, w io.Writer) error
+
+	// add the channels in a stream written by ExportChannels, migrating
+	// channel states written by older versions
+	ImportChannels(ctx context.Context, r io.Reader) error
+
+	// update the labels on a channel -- labels with an empty value are removed
+	UpdateChannelLabels(ctx context.Context, chid ChannelID, labels map[string]string) error
+
 	// RestartDataTransferChannel restarts an existing data transfer channel
 	RestartDataTransferChannel(ctx context.Context, chid ChannelID) error
 }
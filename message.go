@@ -1,6 +1,7 @@
 package datatransfer
 
 import (
+	"fmt"
 	"io"
 
 	"github.com/ipfs/go-cid"
@@ -12,6 +13,11 @@ import (
 )
 
 var (
+	// ProtocolDataTransfer1_2 is the protocol identifier for graphsync messages
+	// that carry optional metadata, such as the reason a transfer was rejected
+	// or cancelled
+	ProtocolDataTransfer1_2 protocol.ID = "/fil/datatransfer/1.2.0"
+
 	// ProtocolDataTransfer1_1 is the protocol identifier for graphsync messages
 	ProtocolDataTransfer1_1 protocol.ID = "/fil/datatransfer/1.1.0"
 
@@ -20,6 +26,30 @@ var (
 	ProtocolDataTransfer1_0 protocol.ID = "/fil/datatransfer/1.0.0"
 )
 
+// Reason explains why a transfer was rejected or cancelled. A validator can
+// return a Reason (or an error wrapping one) to reject a request with it, and
+// it is sent to the other party on protocols that support it (1.2 and up).
+type Reason struct {
+	// Code is an application defined, machine readable code
+	Code uint64
+	// Text is a human readable explanation
+	Text string
+}
+
+// Error returns the text of the reason, or its code if it has no text, so
+// that a Reason can be used as an error
+func (r Reason) Error() string {
+	if r.Text == "" {
+		return fmt.Sprintf("reason code %d", r.Code)
+	}
+	return r.Text
+}
+
+// IsEmpty returns true if no reason was given
+func (r Reason) IsEmpty() bool {
+	return r.Code == 0 && r.Text == ""
+}
+
 // Message is a message for the data transfer protocol
 // (either request or response) that can serialize to a protobuf
 type Message interface {
@@ -30,6 +60,7 @@ type Message interface {
 	IsPaused() bool
 	IsCancel() bool
 	TransferID() TransferID
+	Reason() Reason
 	cborgen.CBORMarshaler
 	cborgen.CBORUnmarshaler
 	ToNet(w io.Writer) error
@@ -47,6 +78,10 @@ type Request interface {
 	Selector() (ipld.Node, error)
 	IsRestartExistingChannelRequest() bool
 	RestartChannelId() (ChannelID, error)
+	// TotalSize and Labels are only sent on protocols that support metadata
+	// (1.2 and up), and are empty otherwise
+	TotalSize() uint64
+	Labels() map[string]string
 }
 
 // Response is a response message for the data transfer protocol
@@ -1,18 +1,22 @@
 package message
 
 import (
-	"github.com/filecoin-project/go-data-transfer/message/message1_1"
+	"github.com/filecoin-project/go-data-transfer/message/message1_2"
 )
 
-var NewRequest = message1_1.NewRequest
-var RestartExistingChannelRequest = message1_1.RestartExistingChannelRequest
-var UpdateRequest = message1_1.UpdateRequest
-var VoucherRequest = message1_1.VoucherRequest
-var RestartResponse = message1_1.RestartResponse
-var NewResponse = message1_1.NewResponse
-var VoucherResultResponse = message1_1.VoucherResultResponse
-var CancelResponse = message1_1.CancelResponse
-var UpdateResponse = message1_1.UpdateResponse
-var FromNet = message1_1.FromNet
-var CompleteResponse = message1_1.CompleteResponse
-var CancelRequest = message1_1.CancelRequest
+var NewRequest = message1_2.NewRequest
+var RestartExistingChannelRequest = message1_2.RestartExistingChannelRequest
+var UpdateRequest = message1_2.UpdateRequest
+var VoucherRequest = message1_2.VoucherRequest
+var RestartResponse = message1_2.RestartResponse
+var NewResponse = message1_2.NewResponse
+var VoucherResultResponse = message1_2.VoucherResultResponse
+var CancelResponse = message1_2.CancelResponse
+var UpdateResponse = message1_2.UpdateResponse
+var FromNet = message1_2.FromNet
+var CompleteResponse = message1_2.CompleteResponse
+var CancelRequest = message1_2.CancelRequest
+var RequestWithReason = message1_2.RequestWithReason
+var ResponseWithReason = message1_2.ResponseWithReason
+var RequestWithTotalSize = message1_2.RequestWithTotalSize
+var RequestWithLabels = message1_2.RequestWithLabels
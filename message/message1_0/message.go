@@ -8,6 +8,7 @@ import (
 	xerrors "golang.org/x/xerrors"
 
 	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/message/types"
 )
 
 // NewTransferRequest creates a transfer request for the 1_0 Data Transfer Protocol.
@@ -41,7 +42,7 @@ func NewTransferResponse(typ uint64, acpt bool, paus bool, xferId uint64, vRes *
 // FromNet can read a network stream to deserialize a GraphSyncMessage
 func FromNet(r io.Reader) (datatransfer.Message, error) {
 	tresp := transferMessage{}
-	err := tresp.UnmarshalCBOR(r)
+	err := tresp.UnmarshalCBOR(types.LimitReader(r))
 	if err != nil {
 		return nil, err
 	}
@@ -103,6 +103,9 @@ func (trq *transferRequest) Selector() (ipld.Node, error) {
 	if trq.Stor == nil {
 		return nil, xerrors.New("No selector present to read")
 	}
+	if err := encoding.CheckDepth(trq.Stor.Raw); err != nil {
+		return nil, xerrors.Errorf("Error decoding selector: %w", err)
+	}
 	builder := basicnode.Prototype.Any.NewBuilder()
 	reader := bytes.NewReader(trq.Stor.Raw)
 	err := dagcbor.Decoder(builder, reader)
@@ -144,3 +147,18 @@ func (trq *transferRequest) IsRestartExistingChannelRequest() bool {
 func (trq *transferRequest) RestartChannelId() (datatransfer.ChannelID, error) {
 	return datatransfer.ChannelID{}, xerrors.New("not supported")
 }
+
+// Reason is always empty, because requests on this protocol cannot carry a reason
+func (trq *transferRequest) Reason() datatransfer.Reason {
+	return datatransfer.Reason{}
+}
+
+// TotalSize is always zero, because requests on this protocol cannot carry it
+func (trq *transferRequest) TotalSize() uint64 {
+	return 0
+}
+
+// Labels is always empty, because requests on this protocol cannot carry them
+func (trq *transferRequest) Labels() map[string]string {
+	return nil
+}
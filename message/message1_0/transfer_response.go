@@ -106,3 +106,8 @@ func (trsp *transferResponse) ToNet(w io.Writer) error {
 	}
 	return msg.MarshalCBOR(w)
 }
+
+// Reason is always empty, because responses on this protocol cannot carry a reason
+func (trsp *transferResponse) Reason() datatransfer.Reason {
+	return datatransfer.Reason{}
+}
@@ -0,0 +1,30 @@
+//go:build go1.18
+// +build go1.18
+
+package message1_1_test
+
+import (
+	"bytes"
+	"testing"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/message/message1_1"
+	"github.com/filecoin-project/go-data-transfer/testutil"
+)
+
+func FuzzFromNet(f *testing.F) {
+	for _, seed := range testutil.EncodeFuzzSeeds(f, datatransfer.ProtocolDataTransfer1_1) {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := message1_1.FromNet(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		testutil.ExerciseMessage(t, msg, fromBytes)
+	})
+}
+
+func fromBytes(data []byte) (datatransfer.Message, error) {
+	return message1_1.FromNet(bytes.NewReader(data))
+}
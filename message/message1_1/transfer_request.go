@@ -139,6 +139,9 @@ func (trq *transferRequest1_1) Selector() (ipld.Node, error) {
 	if trq.Stor == nil {
 		return nil, xerrors.New("No selector present to read")
 	}
+	if err := encoding.CheckDepth(trq.Stor.Raw); err != nil {
+		return nil, xerrors.Errorf("Error decoding selector: %w", err)
+	}
 	builder := basicnode.Prototype.Any.NewBuilder()
 	reader := bytes.NewReader(trq.Stor.Raw)
 	err := dagcbor.Decoder(builder, reader)
@@ -168,3 +171,18 @@ func (trq *transferRequest1_1) ToNet(w io.Writer) error {
 	}
 	return msg.MarshalCBOR(w)
 }
+
+// Reason is always empty, because requests on this protocol cannot carry a reason
+func (trq *transferRequest1_1) Reason() datatransfer.Reason {
+	return datatransfer.Reason{}
+}
+
+// TotalSize is always zero, because requests on this protocol cannot carry it
+func (trq *transferRequest1_1) TotalSize() uint64 {
+	return 0
+}
+
+// Labels is always empty, because requests on this protocol cannot carry them
+func (trq *transferRequest1_1) Labels() map[string]string {
+	return nil
+}
@@ -124,3 +124,8 @@ func (trsp *transferResponse1_1) ToNet(w io.Writer) error {
 	}
 	return msg.MarshalCBOR(w)
 }
+
+// Reason is always empty, because responses on this protocol cannot carry a reason
+func (trsp *transferResponse1_1) Reason() datatransfer.Reason {
+	return datatransfer.Reason{}
+}
@@ -0,0 +1,73 @@
+//go:build go1.18
+// +build go1.18
+
+package message1_2
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-data-transfer/encoding"
+	"github.com/filecoin-project/go-data-transfer/message/types"
+)
+
+// FuzzDeferredFields decodes arbitrary bytes as each of the fields of a
+// message that are only decoded when read: the selector, voucher, voucher
+// result and metadata values
+func FuzzDeferredFields(f *testing.F) {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	seeds := []encoding.Encodable{
+		ssb.Matcher().Node(),
+		ssb.ExploreRecursive(selector.RecursionLimitNone(), ssb.ExploreAll(ssb.ExploreRecursiveEdge())).Node(),
+		&reason1_2{Code: 1, Text: "cancelled"},
+		&labels1_2{"deal": "1", "client": "f01"},
+	}
+	totalSize := uint64Value(1 << 30)
+	timestamp := cbg.CborTime(time.Unix(1600000000, 0))
+	seeds = append(seeds, &totalSize, &timestamp)
+	for _, seed := range seeds {
+		encoded, err := encoding.Encode(seed)
+		require.NoError(f, err)
+		f.Add(encoded)
+	}
+
+	// vouchers are decoded with cbor-gen or as IPLD nodes
+	cbgDecoder, err := encoding.NewDecoder(&reason1_2{})
+	require.NoError(f, err)
+	ipldDecoder, err := encoding.NewDecoder(basicnode.NewString(""))
+	require.NoError(f, err)
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		deferred := &cbg.Deferred{Raw: raw}
+		request := &transferRequest1_2{
+			Type:  uint64(types.NewMessage),
+			Stor:  deferred,
+			Vouch: deferred,
+			Meta: metadata{
+				MetaReason:    deferred,
+				MetaTotalSize: deferred,
+				MetaLabels:    deferred,
+			},
+		}
+		_, _ = request.Selector()
+		_, _ = request.Voucher(cbgDecoder)
+		_, _ = request.Voucher(ipldDecoder)
+		request.Reason()
+		request.TotalSize()
+		request.Labels()
+
+		response := &transferResponse1_2{Type: uint64(types.NewMessage), VRes: deferred}
+		_, _ = response.VoucherResult(cbgDecoder)
+		_, _ = response.VoucherResult(ipldDecoder)
+
+		// a request holding the field must still go on the wire
+		require.NoError(t, request.ToNet(new(bytes.Buffer)))
+	})
+}
@@ -0,0 +1,246 @@
+package message1_2
+
+import (
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	cborgen "github.com/whyrusleeping/cbor-gen"
+	xerrors "golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/encoding"
+	"github.com/filecoin-project/go-data-transfer/message/types"
+)
+
+// NewRequest generates a new request for the data transfer protocol
+func NewRequest(id datatransfer.TransferID, isRestart bool, isPull bool, vtype datatransfer.TypeIdentifier, voucher encoding.Encodable, baseCid cid.Cid, selector ipld.Node) (datatransfer.Request, error) {
+	vbytes, err := encoding.Encode(voucher)
+	if err != nil {
+		return nil, xerrors.Errorf("Creating request: %w", err)
+	}
+	if baseCid == cid.Undef {
+		return nil, xerrors.Errorf("base CID must be defined")
+	}
+	selBytes, err := encoding.Encode(selector)
+	if err != nil {
+		return nil, xerrors.Errorf("Error encoding selector")
+	}
+
+	var typ uint64
+	if isRestart {
+		typ = uint64(types.RestartMessage)
+	} else {
+		typ = uint64(types.NewMessage)
+	}
+
+	return &transferRequest1_2{
+		Type:   typ,
+		Pull:   isPull,
+		Vouch:  &cborgen.Deferred{Raw: vbytes},
+		Stor:   &cborgen.Deferred{Raw: selBytes},
+		BCid:   &baseCid,
+		VTyp:   vtype,
+		XferID: uint64(id),
+	}, nil
+}
+
+// RestartExistingChannelRequest creates a request to ask the other side to restart an existing channel
+func RestartExistingChannelRequest(channelId datatransfer.ChannelID) datatransfer.Request {
+
+	return &transferRequest1_2{Type: uint64(types.RestartExistingChannelRequestMessage),
+		RestartChannel: channelId}
+}
+
+// CancelRequest request generates a request to cancel an in progress request
+func CancelRequest(id datatransfer.TransferID) datatransfer.Request {
+	return &transferRequest1_2{
+		Type:   uint64(types.CancelMessage),
+		XferID: uint64(id),
+	}
+}
+
+// UpdateRequest generates a new request update
+func UpdateRequest(id datatransfer.TransferID, isPaused bool) datatransfer.Request {
+	return &transferRequest1_2{
+		Type:   uint64(types.UpdateMessage),
+		Paus:   isPaused,
+		XferID: uint64(id),
+	}
+}
+
+// VoucherRequest generates a new request for the data transfer protocol
+func VoucherRequest(id datatransfer.TransferID, vtype datatransfer.TypeIdentifier, voucher encoding.Encodable) (datatransfer.Request, error) {
+	vbytes, err := encoding.Encode(voucher)
+	if err != nil {
+		return nil, xerrors.Errorf("Creating request: %w", err)
+	}
+	return &transferRequest1_2{
+		Type:   uint64(types.VoucherMessage),
+		Vouch:  &cborgen.Deferred{Raw: vbytes},
+		VTyp:   vtype,
+		XferID: uint64(id),
+	}, nil
+}
+
+// RestartResponse builds a new Data Transfer response
+func RestartResponse(id datatransfer.TransferID, accepted bool, isPaused bool, voucherResultType datatransfer.TypeIdentifier, voucherResult encoding.Encodable) (datatransfer.Response, error) {
+	vbytes, err := encoding.Encode(voucherResult)
+	if err != nil {
+		return nil, xerrors.Errorf("Creating request: %w", err)
+	}
+	return &transferResponse1_2{
+		Acpt:   accepted,
+		Type:   uint64(types.RestartMessage),
+		Paus:   isPaused,
+		XferID: uint64(id),
+		VTyp:   voucherResultType,
+		VRes:   &cborgen.Deferred{Raw: vbytes},
+	}, nil
+}
+
+// NewResponse builds a new Data Transfer response
+func NewResponse(id datatransfer.TransferID, accepted bool, isPaused bool, voucherResultType datatransfer.TypeIdentifier, voucherResult encoding.Encodable) (datatransfer.Response, error) {
+	vbytes, err := encoding.Encode(voucherResult)
+	if err != nil {
+		return nil, xerrors.Errorf("Creating request: %w", err)
+	}
+	return &transferResponse1_2{
+		Acpt:   accepted,
+		Type:   uint64(types.NewMessage),
+		Paus:   isPaused,
+		XferID: uint64(id),
+		VTyp:   voucherResultType,
+		VRes:   &cborgen.Deferred{Raw: vbytes},
+	}, nil
+}
+
+// VoucherResultResponse builds a new response for a voucher result
+func VoucherResultResponse(id datatransfer.TransferID, accepted bool, isPaused bool, voucherResultType datatransfer.TypeIdentifier, voucherResult encoding.Encodable) (datatransfer.Response, error) {
+	vbytes, err := encoding.Encode(voucherResult)
+	if err != nil {
+		return nil, xerrors.Errorf("Creating request: %w", err)
+	}
+	return &transferResponse1_2{
+		Acpt:   accepted,
+		Type:   uint64(types.VoucherResultMessage),
+		Paus:   isPaused,
+		XferID: uint64(id),
+		VTyp:   voucherResultType,
+		VRes:   &cborgen.Deferred{Raw: vbytes},
+	}, nil
+}
+
+// UpdateResponse returns a new update response
+func UpdateResponse(id datatransfer.TransferID, isPaused bool) datatransfer.Response {
+	return &transferResponse1_2{
+		Type:   uint64(types.UpdateMessage),
+		Paus:   isPaused,
+		XferID: uint64(id),
+	}
+}
+
+// CancelResponse makes a new cancel response message
+func CancelResponse(id datatransfer.TransferID) datatransfer.Response {
+	return &transferResponse1_2{
+		Type:   uint64(types.CancelMessage),
+		XferID: uint64(id),
+	}
+}
+
+// CompleteResponse returns a new complete response message
+func CompleteResponse(id datatransfer.TransferID, isAccepted bool, isPaused bool, voucherResultType datatransfer.TypeIdentifier, voucherResult encoding.Encodable) (datatransfer.Response, error) {
+	vbytes, err := encoding.Encode(voucherResult)
+	if err != nil {
+		return nil, xerrors.Errorf("Creating request: %w", err)
+	}
+	return &transferResponse1_2{
+		Type:   uint64(types.CompleteMessage),
+		Acpt:   isAccepted,
+		Paus:   isPaused,
+		VTyp:   voucherResultType,
+		VRes:   &cborgen.Deferred{Raw: vbytes},
+		XferID: uint64(id),
+	}, nil
+}
+
+// RequestWithReason returns a copy of the given request with the given reason
+// attached. Requests on older protocol versions cannot carry a reason and are
+// returned unchanged, as are requests given an empty reason.
+func RequestWithReason(request datatransfer.Request, reason datatransfer.Reason) (datatransfer.Request, error) {
+	trq, ok := request.(*transferRequest1_2)
+	if !ok {
+		return request, nil
+	}
+	meta, err := trq.Meta.withReason(reason)
+	if err != nil {
+		return nil, err
+	}
+	updated := *trq
+	updated.Meta = meta
+	return &updated, nil
+}
+
+// ResponseWithReason returns a copy of the given response with the given reason
+// attached. Responses on older protocol versions cannot carry a reason and are
+// returned unchanged, as are responses given an empty reason.
+func ResponseWithReason(response datatransfer.Response, reason datatransfer.Reason) (datatransfer.Response, error) {
+	trsp, ok := response.(*transferResponse1_2)
+	if !ok {
+		return response, nil
+	}
+	meta, err := trsp.Meta.withReason(reason)
+	if err != nil {
+		return nil, err
+	}
+	updated := *trsp
+	updated.Meta = meta
+	return &updated, nil
+}
+
+// RequestWithTotalSize returns a copy of the given request with the expected
+// amount of data to be transferred attached. Requests on older protocol
+// versions are returned unchanged.
+func RequestWithTotalSize(request datatransfer.Request, totalSize uint64) (datatransfer.Request, error) {
+	value := uint64Value(totalSize)
+	return requestWithMetadata(request, MetaTotalSize, &value)
+}
+
+// RequestWithLabels returns a copy of the given request with the given channel
+// labels attached. Requests on older protocol versions are returned unchanged.
+func RequestWithLabels(request datatransfer.Request, labels map[string]string) (datatransfer.Request, error) {
+	value := labels1_2(labels)
+	return requestWithMetadata(request, MetaLabels, &value)
+}
+
+func requestWithMetadata(request datatransfer.Request, key string, value cborgen.CBORMarshaler) (datatransfer.Request, error) {
+	trq, ok := request.(*transferRequest1_2)
+	if !ok {
+		return request, nil
+	}
+	meta, err := trq.Meta.with(key, value)
+	if err != nil {
+		return nil, err
+	}
+	updated := *trq
+	updated.Meta = meta
+	return &updated, nil
+}
+
+// FromNet can read a network stream to deserialize a GraphSyncMessage
+func FromNet(r io.Reader) (datatransfer.Message, error) {
+	tresp := transferMessage1_2{}
+	err := tresp.UnmarshalCBOR(types.LimitReader(r))
+	if err != nil {
+		return nil, err
+	}
+
+	if (tresp.IsRequest() && tresp.Request == nil) || (!tresp.IsRequest() && tresp.Response == nil) {
+		return nil, xerrors.Errorf("invalid/malformed message")
+	}
+
+	if tresp.IsRequest() {
+		return tresp.Request, nil
+	}
+	return tresp.Response, nil
+}
@@ -0,0 +1,193 @@
+package message1_2
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"unicode/utf8"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+	xerrors "golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+)
+
+// Keys for the optional fields that can be carried in the metadata of a 1.2
+// message. Peers skip keys they do not recognise, so new optional fields can
+// be added without a new protocol version.
+const (
+	// MetaReason is the reason for rejecting or cancelling a transfer
+	MetaReason = "Reason"
+	// MetaTotalSize is the expected amount of data to be transferred
+	MetaTotalSize = "TotalSize"
+	// MetaLabels are the labels the initiator attached to the channel
+	MetaLabels = "Labels"
+)
+
+// maxLabels is the largest number of labels that can be sent in a message
+const maxLabels = 4096
+
+// metadata holds the optional fields of a 1.2 message. Each value is stored
+// as raw CBOR under its key, so it is only decoded when it is read.
+type metadata map[string]*cbg.Deferred
+
+// with returns a copy of the metadata with the given key set to the encoded
+// value
+func (md metadata) with(key string, value cbg.CBORMarshaler) (metadata, error) {
+	buf := new(bytes.Buffer)
+	if err := value.MarshalCBOR(buf); err != nil {
+		return nil, xerrors.Errorf("encoding %s: %w", key, err)
+	}
+	updated := make(metadata, len(md)+1)
+	for k, v := range md {
+		updated[k] = v
+	}
+	updated[key] = &cbg.Deferred{Raw: buf.Bytes()}
+	return updated, nil
+}
+
+// get decodes the value stored under the given key. It returns false if the
+// key is not present or the value cannot be decoded.
+func (md metadata) get(key string, value cbg.CBORUnmarshaler) bool {
+	raw, ok := md[key]
+	if !ok || raw == nil {
+		return false
+	}
+	return value.UnmarshalCBOR(bytes.NewReader(raw.Raw)) == nil
+}
+
+func (md metadata) reason() datatransfer.Reason {
+	var reason reason1_2
+	if !md.get(MetaReason, &reason) {
+		return datatransfer.Reason{}
+	}
+	return datatransfer.Reason{Code: reason.Code, Text: reason.Text}
+}
+
+// withReason returns a copy of the metadata with the reason set. An empty
+// reason is left out, so messages without a reason carry no reason field.
+func (md metadata) withReason(reason datatransfer.Reason) (metadata, error) {
+	if reason.IsEmpty() {
+		return md, nil
+	}
+	// a reason is advisory, so a text too long to encode is cut short rather
+	// than failing the message
+	text := truncateText(reason.Text, cbg.MaxLength)
+	return md.with(MetaReason, &reason1_2{Code: reason.Code, Text: text})
+}
+
+func (md metadata) totalSize() uint64 {
+	var totalSize uint64Value
+	if !md.get(MetaTotalSize, &totalSize) {
+		return 0
+	}
+	return uint64(totalSize)
+}
+
+func (md metadata) labels() map[string]string {
+	var labels labels1_2
+	if !md.get(MetaLabels, &labels) {
+		return nil
+	}
+	return labels
+}
+
+// truncateText cuts text to at most maxLength bytes, at the start of a rune so
+// that a multi-byte character is not split
+func truncateText(text string, maxLength int) string {
+	if len(text) <= maxLength {
+		return text
+	}
+	cut := maxLength
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut]
+}
+
+//go:generate cbor-gen-for --map-encoding reason1_2
+
+// reason1_2 is the encoding of a datatransfer.Reason in message metadata
+type reason1_2 struct {
+	Code uint64
+	Text string
+}
+
+// uint64Value is the encoding of an unsigned integer in message metadata
+type uint64Value uint64
+
+func (v *uint64Value) MarshalCBOR(w io.Writer) error {
+	return cbg.CborWriteHeader(w, cbg.MajUnsignedInt, uint64(*v))
+}
+
+func (v *uint64Value) UnmarshalCBOR(r io.Reader) error {
+	maj, extra, err := cbg.CborReadHeader(r)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajUnsignedInt {
+		return fmt.Errorf("wrong type for uint64 field")
+	}
+	*v = uint64Value(extra)
+	return nil
+}
+
+// labels1_2 is the encoding of channel labels in message metadata, as a CBOR
+// map from string to string with keys in sorted order
+type labels1_2 map[string]string
+
+func (l *labels1_2) MarshalCBOR(w io.Writer) error {
+	if len(*l) > maxLabels {
+		return xerrors.Errorf("cannot marshal labels: too many labels")
+	}
+	if err := cbg.CborWriteHeader(w, cbg.MajMap, uint64(len(*l))); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(*l))
+	for k := range *l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, s := range []string{k, (*l)[k]} {
+			if len(s) > cbg.MaxLength {
+				return xerrors.Errorf("label %s was too long", k)
+			}
+			if err := cbg.CborWriteHeader(w, cbg.MajTextString, uint64(len(s))); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (l *labels1_2) UnmarshalCBOR(r io.Reader) error {
+	maj, extra, err := cbg.CborReadHeader(r)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("expected a map (major type 5)")
+	}
+	if extra > maxLabels {
+		return fmt.Errorf("labels: map too large")
+	}
+	labels := make(labels1_2, extra)
+	for i := 0; i < int(extra); i++ {
+		k, err := cbg.ReadString(r)
+		if err != nil {
+			return err
+		}
+		v, err := cbg.ReadString(r)
+		if err != nil {
+			return err
+		}
+		labels[k] = v
+	}
+	*l = labels
+	return nil
+}
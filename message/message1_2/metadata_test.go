@@ -0,0 +1,103 @@
+package message1_2
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/message/types"
+)
+
+func TestUnknownMetadataIsIgnored(t *testing.T) {
+	future := new(bytes.Buffer)
+	require.NoError(t, cbg.CborWriteHeader(future, cbg.MajTextString, 6))
+	_, err := future.WriteString("future")
+	require.NoError(t, err)
+
+	reason := datatransfer.Reason{Code: 1, Text: "cancelled"}
+	meta, err := metadata{
+		"SomeFutureField": &cbg.Deferred{Raw: future.Bytes()},
+	}.withReason(reason)
+	require.NoError(t, err)
+	request := &transferRequest1_2{
+		Type:   uint64(types.CancelMessage),
+		XferID: 1,
+		Meta:   meta,
+	}
+
+	wbuf := new(bytes.Buffer)
+	require.NoError(t, request.ToNet(wbuf))
+	deserialized, err := FromNet(wbuf)
+	require.NoError(t, err)
+	require.True(t, deserialized.IsCancel())
+	require.Equal(t, reason, deserialized.Reason())
+}
+
+func TestMalformedMetadata(t *testing.T) {
+	text := new(bytes.Buffer)
+	require.NoError(t, cbg.CborWriteHeader(text, cbg.MajTextString, 4))
+	_, err := text.WriteString("oops")
+	require.NoError(t, err)
+	malformed := &cbg.Deferred{Raw: text.Bytes()}
+
+	request := &transferRequest1_2{
+		Type:   uint64(types.NewMessage),
+		XferID: 1,
+		Meta: metadata{
+			MetaReason:    malformed,
+			MetaTotalSize: malformed,
+			MetaLabels:    malformed,
+		},
+	}
+
+	wbuf := new(bytes.Buffer)
+	require.NoError(t, request.ToNet(wbuf))
+	deserialized, err := FromNet(wbuf)
+	require.NoError(t, err)
+	deserializedRequest, ok := deserialized.(datatransfer.Request)
+	require.True(t, ok)
+	require.True(t, deserializedRequest.Reason().IsEmpty())
+	require.Zero(t, deserializedRequest.TotalSize())
+	require.Nil(t, deserializedRequest.Labels())
+}
+
+func TestLongReasonIsCutAtRuneBoundary(t *testing.T) {
+	// a three byte character straddles the length limit
+	text := strings.Repeat("a", cbg.MaxLength-1) + "€" + "tail"
+	reason := datatransfer.Reason{Code: 1, Text: text}
+	meta, err := metadata{}.withReason(reason)
+	require.NoError(t, err)
+	request := &transferRequest1_2{
+		Type:   uint64(types.CancelMessage),
+		XferID: 1,
+		Meta:   meta,
+	}
+
+	wbuf := new(bytes.Buffer)
+	require.NoError(t, request.ToNet(wbuf))
+	deserialized, err := FromNet(wbuf)
+	require.NoError(t, err)
+	received := deserialized.Reason()
+	require.Equal(t, uint64(1), received.Code)
+	require.Equal(t, text[:cbg.MaxLength-1], received.Text)
+	require.True(t, utf8.ValidString(received.Text))
+}
+
+func TestEmptyReasonIsLeftOut(t *testing.T) {
+	meta, err := metadata{}.withReason(datatransfer.Reason{})
+	require.NoError(t, err)
+	require.Empty(t, meta)
+
+	// an empty reason does not replace a reason already set
+	reason := datatransfer.Reason{Code: 1, Text: "cancelled"}
+	meta, err = meta.withReason(reason)
+	require.NoError(t, err)
+	meta, err = meta.withReason(datatransfer.Reason{})
+	require.NoError(t, err)
+	require.Equal(t, reason, meta.reason())
+}
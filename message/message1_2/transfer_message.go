@@ -0,0 +1,38 @@
+package message1_2
+
+import (
+	"io"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+)
+
+//go:generate cbor-gen-for --map-encoding transferMessage1_2
+
+// transferMessage1_2 is the transfer message for the 1.2 Data Transfer Protocol.
+type transferMessage1_2 struct {
+	IsRq bool
+
+	Request  *transferRequest1_2
+	Response *transferResponse1_2
+}
+
+// ========= datatransfer.Message interface
+
+// IsRequest returns true if this message is a data request
+func (tm *transferMessage1_2) IsRequest() bool {
+	return tm.IsRq
+}
+
+// TransferID returns the TransferID of this message
+func (tm *transferMessage1_2) TransferID() datatransfer.TransferID {
+	if tm.IsRequest() {
+		return tm.Request.TransferID()
+	}
+	return tm.Response.TransferID()
+}
+
+// ToNet serializes a transfer message type. It is simply a wrapper for MarshalCBOR, to provide
+// symmetry with FromNet
+func (tm *transferMessage1_2) ToNet(w io.Writer) error {
+	return tm.MarshalCBOR(w)
+}
@@ -0,0 +1,129 @@
+package message1_2
+
+import (
+	"io"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	xerrors "golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/encoding"
+	"github.com/filecoin-project/go-data-transfer/message/message1_1"
+	"github.com/filecoin-project/go-data-transfer/message/types"
+)
+
+//go:generate cbor-gen-for --map-encoding transferResponse1_2
+
+// transferResponse1_2 is a private struct that satisfies the datatransfer.Response interface
+// It is the response message for the Data Transfer 1.2 Protocol.
+type transferResponse1_2 struct {
+	Type   uint64
+	Acpt   bool
+	Paus   bool
+	XferID uint64
+	VRes   *cbg.Deferred
+	VTyp   datatransfer.TypeIdentifier
+
+	// optional fields, see the Meta* keys
+	Meta metadata
+}
+
+func (trsp *transferResponse1_2) TransferID() datatransfer.TransferID {
+	return datatransfer.TransferID(trsp.XferID)
+}
+
+// IsRequest always returns false in this case because this is a transfer response
+func (trsp *transferResponse1_2) IsRequest() bool {
+	return false
+}
+
+// IsNew returns true if this is the first response sent
+func (trsp *transferResponse1_2) IsNew() bool {
+	return trsp.Type == uint64(types.NewMessage)
+}
+
+// IsUpdate returns true if this response is an update
+func (trsp *transferResponse1_2) IsUpdate() bool {
+	return trsp.Type == uint64(types.UpdateMessage)
+}
+
+// IsPaused returns true if the responder is paused
+func (trsp *transferResponse1_2) IsPaused() bool {
+	return trsp.Paus
+}
+
+// IsCancel returns true if the responder has cancelled this response
+func (trsp *transferResponse1_2) IsCancel() bool {
+	return trsp.Type == uint64(types.CancelMessage)
+}
+
+// IsComplete returns true if the responder has completed this response
+func (trsp *transferResponse1_2) IsComplete() bool {
+	return trsp.Type == uint64(types.CompleteMessage)
+}
+
+func (trsp *transferResponse1_2) IsVoucherResult() bool {
+	return trsp.Type == uint64(types.VoucherResultMessage) || trsp.Type == uint64(types.NewMessage) || trsp.Type == uint64(types.CompleteMessage) ||
+		trsp.Type == uint64(types.RestartMessage)
+}
+
+// 	Accepted returns true if the request is accepted in the response
+func (trsp *transferResponse1_2) Accepted() bool {
+	return trsp.Acpt
+}
+
+func (trsp *transferResponse1_2) VoucherResultType() datatransfer.TypeIdentifier {
+	return trsp.VTyp
+}
+
+func (trsp *transferResponse1_2) VoucherResult(decoder encoding.Decoder) (encoding.Encodable, error) {
+	if trsp.VRes == nil {
+		return nil, xerrors.New("No voucher present to read")
+	}
+	return decoder.DecodeFromCbor(trsp.VRes.Raw)
+}
+
+func (trq *transferResponse1_2) IsRestart() bool {
+	return trq.Type == uint64(types.RestartMessage)
+}
+
+func (trsp *transferResponse1_2) EmptyVoucherResult() bool {
+	return trsp.VTyp == datatransfer.EmptyTypeIdentifier
+}
+
+func (trsp *transferResponse1_2) MessageForProtocol(targetProtocol protocol.ID) (datatransfer.Message, error) {
+	switch targetProtocol {
+	case datatransfer.ProtocolDataTransfer1_2:
+		return trsp, nil
+	case datatransfer.ProtocolDataTransfer1_1, datatransfer.ProtocolDataTransfer1_0:
+		// metadata is dropped, as older protocols cannot carry it
+		lresp := message1_1.NewTransferResponse(
+			trsp.Type,
+			trsp.Acpt,
+			trsp.Paus,
+			trsp.XferID,
+			trsp.VRes,
+			trsp.VTyp,
+		)
+		return lresp.MessageForProtocol(targetProtocol)
+	default:
+		return nil, xerrors.Errorf("protocol %s not supported", targetProtocol)
+	}
+}
+
+// ToNet serializes a transfer response. It's a wrapper for MarshalCBOR to provide
+// symmetry with FromNet
+func (trsp *transferResponse1_2) ToNet(w io.Writer) error {
+	msg := transferMessage1_2{
+		IsRq:     false,
+		Request:  nil,
+		Response: trsp,
+	}
+	return msg.MarshalCBOR(w)
+}
+
+// Reason returns the reason given for rejecting or cancelling the transfer, if any
+func (trsp *transferResponse1_2) Reason() datatransfer.Reason {
+	return trsp.Meta.reason()
+}
@@ -0,0 +1,38 @@
+package types
+
+import (
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+// MaxMessageSize is the largest encoded message that FromNet will read. Real
+// messages are a few KB, most of it the voucher.
+const MaxMessageSize = 4 << 20
+
+// ErrMessageTooLarge is returned when reading a message longer than
+// MaxMessageSize
+var ErrMessageTooLarge = xerrors.Errorf("message is larger than %d bytes", MaxMessageSize)
+
+// LimitReader returns a reader that reads from r, and fails with
+// ErrMessageTooLarge once more than MaxMessageSize bytes have been read
+func LimitReader(r io.Reader) io.Reader {
+	return &limitedReader{r: r, left: MaxMessageSize}
+}
+
+type limitedReader struct {
+	r    io.Reader
+	left int
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.left <= 0 {
+		return 0, ErrMessageTooLarge
+	}
+	if len(p) > l.left {
+		p = p[:l.left]
+	}
+	n, err := l.r.Read(p)
+	l.left -= n
+	return n, err
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
 
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 )
@@ -46,3 +47,21 @@ type Receiver interface {
 
 	ReceiveError(error)
 }
+
+// PeerConnectedReceiver is a Receiver that is also told when a connection is
+// opened to a peer that has protected data transfer channels. Networks check
+// whether their receiver implements it.
+type PeerConnectedReceiver interface {
+	Receiver
+
+	ReceivePeerConnected(p peer.ID)
+}
+
+// PeerAddrBook is a DataTransferNetwork that can be told addresses a peer is
+// known to be reachable at, for when it next connects to the peer. The manager
+// checks whether its network implements it.
+type PeerAddrBook interface {
+	DataTransferNetwork
+
+	AddPeerAddrs(p peer.ID, addrs []ma.Multiaddr)
+}
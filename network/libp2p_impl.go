@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync"
 	"time"
 
 	logging "github.com/ipfs/go-log/v2"
@@ -11,12 +12,15 @@ import (
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
 	"github.com/libp2p/go-libp2p-core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
 	"golang.org/x/xerrors"
 
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-data-transfer/message"
 	"github.com/filecoin-project/go-data-transfer/message/message1_0"
+	"github.com/filecoin-project/go-data-transfer/message/message1_1"
 )
 
 var log = logging.Logger("data_transfer_network")
@@ -39,7 +43,11 @@ const defaultMaxAttemptDuration = 5 * time.Minute
 // The multiplier in the backoff time for each retry
 const defaultBackoffFactor = 5
 
-var defaultDataTransferProtocols = []protocol.ID{datatransfer.ProtocolDataTransfer1_1, datatransfer.ProtocolDataTransfer1_0}
+var defaultDataTransferProtocols = []protocol.ID{
+	datatransfer.ProtocolDataTransfer1_2,
+	datatransfer.ProtocolDataTransfer1_1,
+	datatransfer.ProtocolDataTransfer1_0,
+}
 
 // Option is an option for configuring the libp2p storage market network
 type Option func(*libp2pDataTransferNetwork)
@@ -73,7 +81,8 @@ func RetryParameters(minDuration time.Duration, maxDuration time.Duration, attem
 // NewFromLibp2pHost returns a GraphSyncNetwork supported by underlying Libp2p host.
 func NewFromLibp2pHost(host host.Host, options ...Option) DataTransferNetwork {
 	dataTransferNetwork := libp2pDataTransferNetwork{
-		host: host,
+		host:      host,
+		protected: make(map[peer.ID]map[string]struct{}),
 
 		openStreamTimeout:     defaultOpenStreamTimeout,
 		sendMessageTimeout:    defaultSendMessageTimeout,
@@ -91,12 +100,16 @@ func NewFromLibp2pHost(host host.Host, options ...Option) DataTransferNetwork {
 	return &dataTransferNetwork
 }
 
+var _ PeerAddrBook = (*libp2pDataTransferNetwork)(nil)
+
 // libp2pDataTransferNetwork transforms the libp2p host interface, which sends and receives
 // NetMessage objects, into the graphsync network interface.
 type libp2pDataTransferNetwork struct {
 	host host.Host
 	// inbound messages from the network are forwarded to the receiver
 	receiver Receiver
+	// notifee reports connections to the receiver
+	notifee network.Notifiee
 
 	openStreamTimeout     time.Duration
 	sendMessageTimeout    time.Duration
@@ -105,6 +118,11 @@ type libp2pDataTransferNetwork struct {
 	maxAttemptDuration    time.Duration
 	dtProtocols           []protocol.ID
 	backoffFactor         float64
+
+	// the tags each peer is protected with, so we only report connections
+	// to peers that have data transfer channels
+	protectedLk sync.Mutex
+	protected   map[peer.ID]map[string]struct{}
 }
 
 func (impl *libp2pDataTransferNetwork) openStream(ctx context.Context, id peer.ID, protocols ...protocol.ID) (network.Stream, error) {
@@ -154,6 +172,10 @@ func (dtnet *libp2pDataTransferNetwork) SendMessage(
 		return err
 	}
 
+	if reason := outgoing.Reason(); !reason.IsEmpty() && s.Protocol() != datatransfer.ProtocolDataTransfer1_2 {
+		log.Infof("peer %s speaks %s, which cannot carry reasons, so reason %q for transfer %d is not sent",
+			p, s.Protocol(), reason, outgoing.TransferID())
+	}
 	outgoing, err = outgoing.MessageForProtocol(s.Protocol())
 	if err != nil {
 		return xerrors.Errorf("failed to convert message for protocol: %w", err)
@@ -175,6 +197,34 @@ func (dtnet *libp2pDataTransferNetwork) SetDelegate(r Receiver) {
 	for _, p := range dtnet.dtProtocols {
 		dtnet.host.SetStreamHandler(p, dtnet.handleNewStream)
 	}
+	// replace the notifee registered by an earlier call, so each connection is
+	// only reported once
+	if dtnet.notifee != nil {
+		dtnet.host.Network().StopNotify(dtnet.notifee)
+	}
+	dtnet.notifee = &network.NotifyBundle{
+		ConnectedF: dtnet.handleConnected,
+	}
+	dtnet.host.Network().Notify(dtnet.notifee)
+}
+
+// handleConnected tells the receiver about new connections to peers that have
+// protected data transfer channels
+func (dtnet *libp2pDataTransferNetwork) handleConnected(_ network.Network, conn network.Conn) {
+	p := conn.RemotePeer()
+	dtnet.protectedLk.Lock()
+	_, isProtected := dtnet.protected[p]
+	dtnet.protectedLk.Unlock()
+	if !isProtected {
+		return
+	}
+	receiver, ok := dtnet.receiver.(PeerConnectedReceiver)
+	if !ok {
+		return
+	}
+	log.Debugf("net connected to protected peer %s", p)
+	// notifications are delivered synchronously, so don't block the swarm
+	go receiver.ReceivePeerConnected(p)
 }
 
 func (dtnet *libp2pDataTransferNetwork) ConnectTo(ctx context.Context, p peer.ID) error {
@@ -193,9 +243,12 @@ func (dtnet *libp2pDataTransferNetwork) handleNewStream(s network.Stream) {
 	for {
 		var received datatransfer.Message
 		var err error
-		if s.Protocol() == datatransfer.ProtocolDataTransfer1_1 {
+		switch s.Protocol() {
+		case datatransfer.ProtocolDataTransfer1_2:
 			received, err = message.FromNet(s)
-		} else {
+		case datatransfer.ProtocolDataTransfer1_1:
+			received, err = message1_1.FromNet(s)
+		default:
 			received, err = message1_0.FromNet(s)
 		}
 
@@ -234,11 +287,35 @@ func (dtnet *libp2pDataTransferNetwork) ID() peer.ID {
 	return dtnet.host.ID()
 }
 
+// AddPeerAddrs adds the addresses to the host's peerstore for a short time,
+// long enough to connect to the peer with them
+func (dtnet *libp2pDataTransferNetwork) AddPeerAddrs(p peer.ID, addrs []ma.Multiaddr) {
+	dtnet.host.Peerstore().AddAddrs(p, addrs, peerstore.TempAddrTTL)
+}
+
 func (dtnet *libp2pDataTransferNetwork) Protect(id peer.ID, tag string) {
+	dtnet.protectedLk.Lock()
+	tags, ok := dtnet.protected[id]
+	if !ok {
+		tags = make(map[string]struct{})
+		dtnet.protected[id] = tags
+	}
+	tags[tag] = struct{}{}
+	dtnet.protectedLk.Unlock()
+
 	dtnet.host.ConnManager().Protect(id, tag)
 }
 
 func (dtnet *libp2pDataTransferNetwork) Unprotect(id peer.ID, tag string) bool {
+	dtnet.protectedLk.Lock()
+	if tags, ok := dtnet.protected[id]; ok {
+		delete(tags, tag)
+		if len(tags) == 0 {
+			delete(dtnet.protected, id)
+		}
+	}
+	dtnet.protectedLk.Unlock()
+
 	return dtnet.host.ConnManager().Unprotect(id, tag)
 }
 
@@ -256,6 +333,7 @@ func (dtnet *libp2pDataTransferNetwork) msgToStream(ctx context.Context, s netwo
 	}
 
 	switch s.Protocol() {
+	case datatransfer.ProtocolDataTransfer1_2:
 	case datatransfer.ProtocolDataTransfer1_1:
 	case datatransfer.ProtocolDataTransfer1_0:
 	default:
@@ -14,6 +14,7 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/protocol"
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	ma "github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/xerrors"
@@ -61,6 +62,10 @@ func (r *receiver) ReceiveResponse(
 func (r *receiver) ReceiveError(err error) {
 }
 
+func (r *receiver) ReceivePeerConnected(p peer.ID) {
+	r.connectedPeers <- p
+}
+
 func (r *receiver) ReceiveRestartExistingChannelRequest(ctx context.Context, sender peer.ID, incoming datatransfer.Request) {
 	r.lastSender = sender
 	r.lastRestartRequest = incoming
@@ -180,6 +185,177 @@ func TestMessageSendAndReceive(t *testing.T) {
 
 }
 
+// TestMessageMetadataNegotiation verifies that request metadata and reasons are sent to
+// peers that speak 1.2 and dropped for peers that only speak older protocols
+func TestMessageMetadataNegotiation(t *testing.T) {
+	testCases := map[string]struct {
+		host2Protocols   []protocol.ID
+		expectedMetadata bool
+	}{
+		"1.2 peer": {
+			expectedMetadata: true,
+		},
+		"1.1 peer": {
+			host2Protocols: []protocol.ID{datatransfer.ProtocolDataTransfer1_1},
+		},
+		"1.0 peer": {
+			host2Protocols: []protocol.ID{datatransfer.ProtocolDataTransfer1_0},
+		},
+	}
+	for testCase, data := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			ctx := context.Background()
+			ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			defer cancel()
+			mn := mocknet.New(ctx)
+
+			host1, err := mn.GenPeer()
+			require.NoError(t, err)
+			host2, err := mn.GenPeer()
+			require.NoError(t, err)
+			err = mn.LinkAll()
+			require.NoError(t, err)
+
+			dtnet1 := network.NewFromLibp2pHost(host1)
+			var options []network.Option
+			if data.host2Protocols != nil {
+				options = append(options, network.DataTransferProtocols(data.host2Protocols))
+			}
+			dtnet2 := network.NewFromLibp2pHost(host2, options...)
+			r := &receiver{
+				messageReceived: make(chan struct{}),
+				connectedPeers:  make(chan peer.ID, 2),
+			}
+			dtnet1.SetDelegate(r)
+			dtnet2.SetDelegate(r)
+
+			err = dtnet1.ConnectTo(ctx, host2.ID())
+			require.NoError(t, err)
+
+			baseCid := testutil.GenerateCids(1)[0]
+			selector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+			id := datatransfer.TransferID(rand.Int31())
+			voucher := testutil.NewFakeDTType()
+			labels := map[string]string{"deal": "1234"}
+			request, err := message.NewRequest(id, false, false, voucher.Type(), voucher, baseCid, selector)
+			require.NoError(t, err)
+			request, err = message.RequestWithTotalSize(request, 1024)
+			require.NoError(t, err)
+			request, err = message.RequestWithLabels(request, labels)
+			require.NoError(t, err)
+			require.NoError(t, dtnet1.SendMessage(ctx, host2.ID(), request))
+
+			select {
+			case <-ctx.Done():
+				t.Fatal("did not receive message sent")
+			case <-r.messageReceived:
+			}
+
+			receivedRequest := r.lastRequest
+			require.NotNil(t, receivedRequest)
+			assert.Equal(t, request.TransferID(), receivedRequest.TransferID())
+			assert.True(t, receivedRequest.BaseCid().Equals(request.BaseCid()))
+			if data.expectedMetadata {
+				assert.Equal(t, uint64(1024), receivedRequest.TotalSize())
+				assert.Equal(t, labels, receivedRequest.Labels())
+			} else {
+				assert.Zero(t, receivedRequest.TotalSize())
+				assert.Empty(t, receivedRequest.Labels())
+			}
+
+			// a reason for cancelling is only carried to peers that speak 1.2
+			reason := datatransfer.Reason{Code: 7, Text: "deal not found"}
+			response, err := message.ResponseWithReason(message.CancelResponse(id), reason)
+			require.NoError(t, err)
+			require.NoError(t, dtnet2.SendMessage(ctx, host1.ID(), response))
+
+			select {
+			case <-ctx.Done():
+				t.Fatal("did not receive message sent")
+			case <-r.messageReceived:
+			}
+
+			receivedResponse := r.lastResponse
+			require.NotNil(t, receivedResponse)
+			assert.True(t, receivedResponse.IsCancel())
+			if data.expectedMetadata {
+				assert.Equal(t, reason, receivedResponse.Reason())
+			} else {
+				assert.True(t, receivedResponse.Reason().IsEmpty())
+			}
+		})
+	}
+}
+
+func TestProtectedPeerConnected(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	mn := mocknet.New(ctx)
+
+	host1, err := mn.GenPeer()
+	require.NoError(t, err)
+	host2, err := mn.GenPeer()
+	require.NoError(t, err)
+	host3, err := mn.GenPeer()
+	require.NoError(t, err)
+	err = mn.LinkAll()
+	require.NoError(t, err)
+
+	dtnet1 := network.NewFromLibp2pHost(host1)
+	r := &receiver{
+		messageReceived: make(chan struct{}),
+		connectedPeers:  make(chan peer.ID, 10),
+	}
+	// setting the delegate again does not report connections twice
+	dtnet1.SetDelegate(r)
+	dtnet1.SetDelegate(r)
+
+	// connections to peers without protected channels are not reported
+	require.NoError(t, dtnet1.ConnectTo(ctx, host2.ID()))
+	require.NoError(t, dtnet1.ConnectTo(ctx, host3.ID()))
+	require.NoError(t, mn.DisconnectPeers(host1.ID(), host2.ID()))
+	require.NoError(t, mn.DisconnectPeers(host1.ID(), host3.ID()))
+
+	dtnet1.Protect(host2.ID(), "channel-1")
+	dtnet1.Protect(host3.ID(), "channel-2")
+	dtnet1.Unprotect(host3.ID(), "channel-2")
+
+	require.NoError(t, dtnet1.ConnectTo(ctx, host3.ID()))
+	require.NoError(t, dtnet1.ConnectTo(ctx, host2.ID()))
+	select {
+	case <-ctx.Done():
+		t.Fatal("did not receive connection notification")
+	case p := <-r.connectedPeers:
+		require.Equal(t, host2.ID(), p)
+	}
+	select {
+	case p := <-r.connectedPeers:
+		t.Fatalf("unexpected connection notification for %s", p)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestAddPeerAddrs(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	mn := mocknet.New(ctx)
+
+	host1, err := mn.GenPeer()
+	require.NoError(t, err)
+	dtnet1 := network.NewFromLibp2pHost(host1)
+
+	// addresses given for a peer are added to the host's peerstore
+	other := testutil.GeneratePeers(1)[0]
+	addr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/1234")
+	require.NoError(t, err)
+	addrBook, ok := dtnet1.(network.PeerAddrBook)
+	require.True(t, ok)
+	addrBook.AddPeerAddrs(other, []ma.Multiaddr{addr})
+	require.Equal(t, []ma.Multiaddr{addr}, host1.Peerstore().Addrs(other))
+}
+
 // Wrap a host so that we can mock out errors when calling NewStream
 type wrappedHost struct {
 	host.Host
@@ -0,0 +1,102 @@
+package datatransfer
+
+import (
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// TransportOption configures the transport for a single channel, for example
+// to tell it which store to load blocks from or save blocks to
+type TransportOption func(chid ChannelID, transport Transport) error
+
+// ChannelOptions are the optional parameters for a data transfer channel.
+// TotalSize, RemoveTimeout, Labels, Priority and PeerAddrs are persisted with
+// the channel and reused when it is restarted. TransportOptions are kept in
+// memory and applied again when the channel is restarted, unless the manager
+// has restarted since the channel was opened.
+//
+// A manager has a single transport, chosen when it is constructed, so the
+// transport itself cannot be chosen per channel. TransportOptions configure
+// that transport for the channel instead.
+type ChannelOptions struct {
+	// TotalSize is the expected amount of data to be transferred
+	TotalSize uint64
+	// RemoveTimeout is how long the channel may stay stalled or disconnected
+	// before it is failed (if zero, the manager default is used)
+	RemoveTimeout time.Duration
+	// Labels are user defined key/value pairs attached to the channel
+	Labels map[string]string
+	// Priority orders the channel against other channels with the same peer:
+	// channels with a higher priority are restarted first when the peer
+	// reconnects. Transport configurers may use it too.
+	Priority int64
+	// PeerAddrs are addresses the other peer is known to be reachable at,
+	// given to the network before the manager connects to the peer
+	PeerAddrs []ma.Multiaddr
+	// TransportOptions are applied to the transport once the channel is created
+	TransportOptions []TransportOption
+}
+
+// ChannelOption sets an optional parameter on a data transfer channel
+type ChannelOption func(*ChannelOptions)
+
+// NewChannelOptions applies the given channel options to an empty set of
+// channel options
+func NewChannelOptions(options ...ChannelOption) ChannelOptions {
+	var channelOptions ChannelOptions
+	for _, option := range options {
+		option(&channelOptions)
+	}
+	return channelOptions
+}
+
+// WithTotalSize sets the expected amount of data to be transferred
+func WithTotalSize(totalSize uint64) ChannelOption {
+	return func(options *ChannelOptions) {
+		options.TotalSize = totalSize
+	}
+}
+
+// WithRemoveTimeout overrides the manager's channel remove timeout for this channel
+func WithRemoveTimeout(timeout time.Duration) ChannelOption {
+	return func(options *ChannelOptions) {
+		options.RemoveTimeout = timeout
+	}
+}
+
+// WithLabels attaches the given labels to the channel, in addition to any
+// labels set by earlier options
+func WithLabels(labels map[string]string) ChannelOption {
+	return func(options *ChannelOptions) {
+		if options.Labels == nil {
+			options.Labels = make(map[string]string, len(labels))
+		}
+		for key, value := range labels {
+			options.Labels[key] = value
+		}
+	}
+}
+
+// WithPriority sets the priority of the channel against other channels with
+// the same peer
+func WithPriority(priority int64) ChannelOption {
+	return func(options *ChannelOptions) {
+		options.Priority = priority
+	}
+}
+
+// WithPeerAddrs gives addresses the other peer is known to be reachable at, in
+// addition to any addresses given by earlier options
+func WithPeerAddrs(addrs ...ma.Multiaddr) ChannelOption {
+	return func(options *ChannelOptions) {
+		options.PeerAddrs = append(options.PeerAddrs, addrs...)
+	}
+}
+
+// WithTransportOption adds a transport option to be applied when the channel is opened
+func WithTransportOption(transportOption TransportOption) ChannelOption {
+	return func(options *ChannelOptions) {
+		options.TransportOptions = append(options.TransportOptions, transportOption)
+	}
+}
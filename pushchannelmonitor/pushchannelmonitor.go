@@ -145,6 +145,33 @@ func (m *Monitor) run() {
 	}
 }
 
+// ChannelState is a snapshot of the data-rate monitoring of a push channel
+type ChannelState struct {
+	ChannelID datatransfer.ChannelID `json:"channelID"`
+	// Queued and Sent are the bytes queued and sent on the channel so far
+	Queued uint64 `json:"queued"`
+	Sent   uint64 `json:"sent"`
+	// DataRatePoints is the number of data rate checks recorded in the
+	// current interval
+	DataRatePoints      int `json:"dataRatePoints"`
+	ConsecutiveRestarts int `json:"consecutiveRestarts"`
+	// RestartedAt is when the channel was last restarted, if the restart
+	// or the back-off after it is still in progress
+	RestartedAt time.Time `json:"restartedAt,omitempty"`
+}
+
+// ChannelStates returns the state of every monitored channel
+func (m *Monitor) ChannelStates() []ChannelState {
+	m.lk.RLock()
+	defer m.lk.RUnlock()
+
+	states := make([]ChannelState, 0, len(m.channels))
+	for ch := range m.channels {
+		states = append(states, ch.state())
+	}
+	return states
+}
+
 // check data rate for all monitored channels
 func (m *Monitor) checkDataRate() {
 	m.lk.RLock()
@@ -226,10 +253,10 @@ func (mc *monitoredChannel) start() {
 			// If there's an error, attempt to restart the channel
 			log.Debugf("%s: data transfer error, restarting", mc.chid)
 			go mc.restartChannel()
-		case datatransfer.DataQueued:
+		case datatransfer.DataQueued, datatransfer.DataQueuedProgress:
 			// Keep track of the amount of data queued
 			mc.queued = channelState.Queued()
-		case datatransfer.DataSent:
+		case datatransfer.DataSent, datatransfer.DataSentProgress:
 			// Keep track of the amount of data sent
 			mc.sent = channelState.Sent()
 			// Some data was sent so reset the consecutive restart counter
@@ -238,6 +265,23 @@ func (mc *monitoredChannel) start() {
 	})
 }
 
+func (mc *monitoredChannel) state() ChannelState {
+	mc.statsLk.RLock()
+	state := ChannelState{
+		ChannelID:           mc.chid,
+		Queued:              mc.queued,
+		Sent:                mc.sent,
+		DataRatePoints:      len(mc.dataRatePoints),
+		ConsecutiveRestarts: mc.consecutiveRestarts,
+	}
+	mc.statsLk.RUnlock()
+
+	mc.restartLk.RLock()
+	state.RestartedAt = mc.restartedAt
+	mc.restartLk.RUnlock()
+	return state
+}
+
 type dataRatePoint struct {
 	pending uint64
 	sent    uint64
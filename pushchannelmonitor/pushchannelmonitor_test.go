@@ -9,6 +9,7 @@ import (
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/xerrors"
 
@@ -257,6 +258,38 @@ func TestPushChannelMonitorDataRate(t *testing.T) {
 	}
 }
 
+func TestPushChannelMonitorChannelStates(t *testing.T) {
+	ch1 := datatransfer.ChannelID{
+		Initiator: "initiator",
+		Responder: "responder",
+		ID:        1,
+	}
+	ch := &mockChannelState{chid: ch1}
+	mockAPI := newMockMonitorAPI(ch, false)
+
+	m := NewMonitor(mockAPI, &Config{
+		Interval:               time.Hour,
+		ChecksPerInterval:      10,
+		MinBytesSent:           1,
+		MaxConsecutiveRestarts: 3,
+	})
+	require.Empty(t, m.ChannelStates())
+
+	m.AddChannel(ch1)
+	mockAPI.dataQueued(20)
+	mockAPI.dataSent(10)
+	m.checkDataRate()
+
+	states := m.ChannelStates()
+	require.Len(t, states, 1)
+	require.Equal(t, ChannelState{
+		ChannelID:      ch1,
+		Queued:         20,
+		Sent:           10,
+		DataRatePoints: 1,
+	}, states[0])
+}
+
 func TestPushChannelMonitorMaxConsecutiveRestarts(t *testing.T) {
 	ch1 := datatransfer.ChannelID{
 		Initiator: "initiator",
@@ -510,3 +543,43 @@ func (m *mockChannelState) LastVoucherResult() datatransfer.VoucherResult {
 func (m *mockChannelState) ReceivedCids() []cid.Cid {
 	panic("implement me")
 }
+
+func (m *mockChannelState) ReceivedCidsLen() int {
+	panic("implement me")
+}
+
+func (m *mockChannelState) RemoveTimeout() time.Duration {
+	panic("implement me")
+}
+
+func (m *mockChannelState) Labels() map[string]string {
+	panic("implement me")
+}
+
+func (m *mockChannelState) Priority() int64 {
+	panic("implement me")
+}
+
+func (m *mockChannelState) PeerAddrs() []ma.Multiaddr {
+	panic("implement me")
+}
+
+func (m *mockChannelState) ErrorCode() datatransfer.ErrorCode {
+	panic("implement me")
+}
+
+func (m *mockChannelState) Retryable() bool {
+	panic("implement me")
+}
+
+func (m *mockChannelState) Reason() datatransfer.Reason {
+	panic("implement me")
+}
+
+func (m *mockChannelState) RestartAttempts() uint64 {
+	panic("implement me")
+}
+
+func (m *mockChannelState) LastRestartError() string {
+	panic("implement me")
+}
@@ -15,8 +15,9 @@ import (
 type Processor interface{}
 
 type registryEntry struct {
-	decoder   encoding.Decoder
-	processor Processor
+	decoder     encoding.Decoder
+	processor   Processor
+	jsonEncoder datatransfer.JSONEncoder
 }
 
 // Registry maintans a register of types of encodable objects and a corresponding
@@ -48,7 +49,21 @@ func (r *Registry) Register(entry datatransfer.Registerable, processor Processor
 	if _, ok := r.entries[identifier]; ok {
 		return xerrors.Errorf("identifier already registered: %s", identifier)
 	}
-	r.entries[identifier] = registryEntry{decoder, processor}
+	r.entries[identifier] = registryEntry{decoder: decoder, processor: processor}
+	return nil
+}
+
+// RegisterJSONEncoder sets the JSON encoder for an entry type that is already
+// registered
+func (r *Registry) RegisterJSONEncoder(identifier datatransfer.TypeIdentifier, encoder datatransfer.JSONEncoder) error {
+	r.registryLk.Lock()
+	defer r.registryLk.Unlock()
+	entry, ok := r.entries[identifier]
+	if !ok {
+		return xerrors.Errorf("identifier not registered: %s", identifier)
+	}
+	entry.jsonEncoder = encoder
+	r.entries[identifier] = entry
 	return nil
 }
 
@@ -68,6 +83,14 @@ func (r *Registry) Processor(identifier datatransfer.TypeIdentifier) (Processor,
 	return entry.processor, has
 }
 
+// JSONEncoder gets the JSON encoder for the given identifier, if one was set
+func (r *Registry) JSONEncoder(identifier datatransfer.TypeIdentifier) (datatransfer.JSONEncoder, bool) {
+	r.registryLk.RLock()
+	entry, has := r.entries[identifier]
+	r.registryLk.RUnlock()
+	return entry.jsonEncoder, has && entry.jsonEncoder != nil
+}
+
 // Each iterates through all of the entries in this registry
 func (r *Registry) Each(process func(datatransfer.TypeIdentifier, encoding.Decoder, Processor) error) error {
 	r.registryLk.RLock()
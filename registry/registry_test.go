@@ -5,6 +5,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/filecoin-project/go-data-transfer/encoding"
 	"github.com/filecoin-project/go-data-transfer/registry"
 	"github.com/filecoin-project/go-data-transfer/testutil"
 )
@@ -39,5 +40,20 @@ func TestRegistry(t *testing.T) {
 		require.False(t, has)
 		require.Nil(t, processor)
 	})
-
+	t.Run("it registers json encoders", func(t *testing.T) {
+		encoder, has := r.JSONEncoder("FakeDTType")
+		require.False(t, has)
+		require.Nil(t, encoder)
+		err := r.RegisterJSONEncoder("OtherType", func(encoding.Encodable) ([]byte, error) { return nil, nil })
+		require.EqualError(t, err, "identifier not registered: OtherType")
+		err = r.RegisterJSONEncoder("FakeDTType", func(encodable encoding.Encodable) ([]byte, error) {
+			return []byte(`"fake"`), nil
+		})
+		require.NoError(t, err)
+		encoder, has = r.JSONEncoder("FakeDTType")
+		require.True(t, has)
+		encoded, err := encoder(&testutil.FakeDTType{})
+		require.NoError(t, err)
+		require.Equal(t, `"fake"`, string(encoded))
+	})
 }
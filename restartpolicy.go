@@ -0,0 +1,52 @@
+package datatransfer
+
+import (
+	"math"
+	"time"
+)
+
+// RestartPolicy decides whether, and how soon, the manager should try to
+// restart a channel automatically after it times out, disconnects or hits a
+// network error
+type RestartPolicy interface {
+	// NextRestart returns how long to wait before the next restart attempt on
+	// the channel, given the number of attempts made so far, or false if the
+	// channel should not be restarted again
+	NextRestart(chst ChannelState, attempts uint64) (time.Duration, bool)
+}
+
+// BackoffRestartPolicy is a RestartPolicy that waits exponentially longer
+// between each restart attempt, up to a maximum number of attempts in a row
+// without data moving on the channel
+type BackoffRestartPolicy struct {
+	// MinBackoff is the time to wait before the first attempt
+	MinBackoff time.Duration
+	// MaxBackoff is the most time to wait between attempts (if zero, there
+	// is no maximum)
+	MaxBackoff time.Duration
+	// Factor is what the wait is multiplied by after each attempt (if less
+	// than one, a factor of two is used)
+	Factor float64
+	// MaxAttempts is the maximum number of restart attempts in a row
+	MaxAttempts uint64
+}
+
+// NextRestart returns the backoff before the next attempt, until the maximum
+// number of attempts has been made
+func (p BackoffRestartPolicy) NextRestart(chst ChannelState, attempts uint64) (time.Duration, bool) {
+	if attempts >= p.MaxAttempts {
+		return 0, false
+	}
+	factor := p.Factor
+	if factor < 1 {
+		factor = 2
+	}
+	backoff := float64(p.MinBackoff) * math.Pow(factor, float64(attempts))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		return p.MaxBackoff, true
+	}
+	if backoff > math.MaxInt64 {
+		return time.Duration(math.MaxInt64), true
+	}
+	return time.Duration(backoff), true
+}
@@ -0,0 +1,315 @@
+package scheduler
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+)
+
+var log = logging.Logger("dt-scheduler")
+
+//go:generate cbor-gen-for --map-encoding entry
+
+// entry is a deadline as it is saved in the datastore
+type entry struct {
+	ChannelID datatransfer.ChannelID
+	// Deadline is in nanoseconds since the unix epoch
+	Deadline int64
+}
+
+// Handler is called with the ID of a channel when its deadline passes
+type Handler func(chid datatransfer.ChannelID)
+
+// Option configures a Scheduler
+type Option func(*Scheduler)
+
+// WithClock sets the clock the scheduler uses to tell when deadlines pass
+func WithClock(clock Clock) Option {
+	return func(s *Scheduler) {
+		s.clock = clock
+	}
+}
+
+// Scheduler calls a handler for a channel once the deadline set for it has
+// passed. There is at most one deadline per channel.
+// Deadlines are saved in the datastore and loaded again on Start, so they
+// survive a restart of the process. However many deadlines are pending, a
+// single goroutine and timer wait for the earliest one.
+type Scheduler struct {
+	ds      datastore.Batching
+	handler Handler
+	clock   Clock
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	changed chan struct{}
+
+	lk        sync.Mutex
+	deadlines deadlineHeap
+	byChannel map[datatransfer.ChannelID]*item
+}
+
+// New returns a scheduler that saves deadlines in the given datastore and
+// calls the handler when they pass
+func New(ds datastore.Batching, handler Handler, options ...Option) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scheduler{
+		ds:        ds,
+		handler:   handler,
+		clock:     RealClock(),
+		ctx:       ctx,
+		cancel:    cancel,
+		changed:   make(chan struct{}, 1),
+		byChannel: make(map[datatransfer.ChannelID]*item),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// Start loads the deadlines saved in the datastore and starts waiting for
+// them to pass. Deadlines that passed while the process was not running are
+// handled straight away.
+func (s *Scheduler) Start(ctx context.Context) error {
+	if err := s.load(ctx); err != nil {
+		return err
+	}
+	go s.run()
+	return nil
+}
+
+// Stop stops waiting for deadlines. Pending deadlines stay in the datastore.
+func (s *Scheduler) Stop() {
+	s.cancel()
+}
+
+// Schedule sets the deadline for the given channel, replacing any deadline
+// it already has
+func (s *Scheduler) Schedule(chid datatransfer.ChannelID, deadline time.Time) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	buf := new(bytes.Buffer)
+	e := entry{ChannelID: chid, Deadline: deadline.UnixNano()}
+	if err := e.MarshalCBOR(buf); err != nil {
+		return xerrors.Errorf("marshalling deadline for channel %s: %w", chid, err)
+	}
+	if err := s.ds.Put(channelKey(chid), buf.Bytes()); err != nil {
+		return xerrors.Errorf("saving deadline for channel %s: %w", chid, err)
+	}
+	s.set(chid, deadline)
+
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Cancel removes the deadline for the given channel, if it has one
+func (s *Scheduler) Cancel(chid datatransfer.ChannelID) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	it, ok := s.byChannel[chid]
+	if !ok {
+		return nil
+	}
+	heap.Remove(&s.deadlines, it.index)
+	delete(s.byChannel, chid)
+	if err := s.ds.Delete(channelKey(chid)); err != nil {
+		return xerrors.Errorf("deleting deadline for channel %s: %w", chid, err)
+	}
+	return nil
+}
+
+// Deadline returns the deadline for the given channel, or false if it does
+// not have one
+func (s *Scheduler) Deadline(chid datatransfer.ChannelID) (time.Time, bool) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	it, ok := s.byChannel[chid]
+	if !ok {
+		return time.Time{}, false
+	}
+	return it.deadline, true
+}
+
+// Pending returns the channels that have a deadline that has not been
+// handled yet
+func (s *Scheduler) Pending() []datatransfer.ChannelID {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	chids := make([]datatransfer.ChannelID, 0, len(s.byChannel))
+	for chid := range s.byChannel {
+		chids = append(chids, chid)
+	}
+	return chids
+}
+
+func (s *Scheduler) load(ctx context.Context) error {
+	// hold the lock while loading so that deadlines scheduled or cancelled
+	// in the meantime are not overwritten by the saved ones
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	results, err := s.ds.Query(query.Query{})
+	if err != nil {
+		return xerrors.Errorf("querying deadlines: %w", err)
+	}
+	defer results.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		res, ok := results.NextSync()
+		if !ok {
+			return nil
+		}
+		if res.Error != nil {
+			return xerrors.Errorf("reading deadlines: %w", res.Error)
+		}
+		var e entry
+		if err := e.UnmarshalCBOR(bytes.NewReader(res.Value)); err != nil {
+			return xerrors.Errorf("unmarshalling deadline %s: %w", res.Key, err)
+		}
+		if _, ok := s.byChannel[e.ChannelID]; ok {
+			continue
+		}
+		s.set(e.ChannelID, time.Unix(0, e.Deadline))
+	}
+}
+
+// set adds or updates the deadline for a channel in memory. It must be called
+// with the lock held.
+func (s *Scheduler) set(chid datatransfer.ChannelID, deadline time.Time) {
+	if it, ok := s.byChannel[chid]; ok {
+		it.deadline = deadline
+		heap.Fix(&s.deadlines, it.index)
+		return
+	}
+	it := &item{chid: chid, deadline: deadline}
+	heap.Push(&s.deadlines, it)
+	s.byChannel[chid] = it
+}
+
+func (s *Scheduler) run() {
+	for {
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		s.lk.Lock()
+		var next time.Time
+		pending := len(s.deadlines) > 0
+		if pending {
+			next = s.deadlines[0].deadline
+		}
+		s.lk.Unlock()
+
+		var timer Timer
+		var fired <-chan time.Time
+		if pending {
+			wait := next.Sub(s.clock.Now())
+			if wait <= 0 {
+				s.handleDue()
+				continue
+			}
+			timer = s.clock.NewTimer(wait)
+			fired = timer.C()
+		}
+
+		select {
+		case <-s.ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-s.changed:
+			if timer != nil {
+				timer.Stop()
+			}
+		case <-fired:
+		}
+	}
+}
+
+// handleDue calls the handler for each channel whose deadline has passed
+func (s *Scheduler) handleDue() {
+	now := s.clock.Now()
+	var due []datatransfer.ChannelID
+	s.lk.Lock()
+	for len(s.deadlines) > 0 && !s.deadlines[0].deadline.After(now) {
+		it := heap.Pop(&s.deadlines).(*item)
+		delete(s.byChannel, it.chid)
+		due = append(due, it.chid)
+	}
+	s.lk.Unlock()
+
+	for _, chid := range due {
+		s.handler(chid)
+
+		// the saved deadline is only removed once it has been handled, so it
+		// is handled again on Start if the process stops in between
+		s.lk.Lock()
+		if _, rescheduled := s.byChannel[chid]; !rescheduled {
+			if err := s.ds.Delete(channelKey(chid)); err != nil {
+				log.Errorf("channel %s: deleting handled deadline: %s", chid, err)
+			}
+		}
+		s.lk.Unlock()
+	}
+}
+
+func channelKey(chid datatransfer.ChannelID) datastore.Key {
+	return datastore.NewKey(chid.String())
+}
+
+type item struct {
+	chid     datatransfer.ChannelID
+	deadline time.Time
+	index    int
+}
+
+// deadlineHeap is a min-heap of deadlines, earliest first
+type deadlineHeap []*item
+
+func (h deadlineHeap) Len() int { return len(h) }
+
+func (h deadlineHeap) Less(i, j int) bool { return h[i].deadline.Before(h[j].deadline) }
+
+func (h deadlineHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *deadlineHeap) Push(x interface{}) {
+	it := x.(*item)
+	it.index = len(*h)
+	*h = append(*h, it)
+}
+
+func (h *deadlineHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return it
+}
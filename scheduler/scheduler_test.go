@@ -0,0 +1,127 @@
+package scheduler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	ds_sync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/scheduler"
+	"github.com/filecoin-project/go-data-transfer/testutil"
+)
+
+func TestScheduler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dstore := ds_sync.MutexWrap(ds.NewMapDatastore())
+	clock := testutil.NewMockClock()
+	handled := make(chan datatransfer.ChannelID, 3)
+	s := scheduler.New(dstore, func(chid datatransfer.ChannelID) {
+		handled <- chid
+	}, scheduler.WithClock(clock))
+	require.NoError(t, s.Start(ctx))
+	defer s.Stop()
+
+	chids := generateChannelIDs(3)
+	now := clock.Now()
+	require.NoError(t, s.Schedule(chids[0], now.Add(2*time.Second)))
+	require.NoError(t, s.Schedule(chids[1], now.Add(time.Second)))
+	require.NoError(t, s.Schedule(chids[2], now.Add(3*time.Second)))
+	require.Len(t, s.Pending(), 3)
+
+	// cancelled deadlines are not handled
+	require.NoError(t, s.Cancel(chids[2]))
+	_, ok := s.Deadline(chids[2])
+	require.False(t, ok)
+
+	// rescheduling replaces the existing deadline
+	require.NoError(t, s.Schedule(chids[0], now.Add(4*time.Second)))
+	deadline, ok := s.Deadline(chids[0])
+	require.True(t, ok)
+	require.Equal(t, now.Add(4*time.Second), deadline)
+
+	clock.BlockUntil(1)
+	clock.Add(time.Second)
+	require.Equal(t, chids[1], receive(ctx, t, handled))
+
+	clock.BlockUntil(1)
+	clock.Add(3 * time.Second)
+	require.Equal(t, chids[0], receive(ctx, t, handled))
+
+	require.Eventually(t, func() bool {
+		keys, err := dstore.Query(query.Query{})
+		require.NoError(t, err)
+		entries, err := keys.Rest()
+		require.NoError(t, err)
+		return len(entries) == 0
+	}, time.Second, 10*time.Millisecond)
+	require.Empty(t, s.Pending())
+	select {
+	case chid := <-handled:
+		t.Fatalf("unexpected deadline handled for channel %s", chid)
+	default:
+	}
+}
+
+func TestSchedulerReloadsDeadlines(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dstore := ds_sync.MutexWrap(ds.NewMapDatastore())
+	clock := testutil.NewMockClock()
+	chids := generateChannelIDs(2)
+
+	handledBeforeRestart := make(chan datatransfer.ChannelID, 2)
+	s := scheduler.New(dstore, func(chid datatransfer.ChannelID) {
+		handledBeforeRestart <- chid
+	}, scheduler.WithClock(clock))
+	require.NoError(t, s.Start(ctx))
+	require.NoError(t, s.Schedule(chids[0], clock.Now().Add(time.Minute)))
+	require.NoError(t, s.Schedule(chids[1], clock.Now().Add(time.Hour)))
+	s.Stop()
+
+	// the first deadline passes while the scheduler is stopped
+	clock.Add(2 * time.Minute)
+
+	handled := make(chan datatransfer.ChannelID, 2)
+	s = scheduler.New(dstore, func(chid datatransfer.ChannelID) {
+		handled <- chid
+	}, scheduler.WithClock(clock))
+	require.NoError(t, s.Start(ctx))
+	defer s.Stop()
+
+	require.Equal(t, chids[0], receive(ctx, t, handled))
+	require.Empty(t, handledBeforeRestart)
+	deadline, ok := s.Deadline(chids[1])
+	require.True(t, ok)
+	require.True(t, clock.Now().Add(time.Hour-2*time.Minute).Equal(deadline))
+
+	clock.BlockUntil(1)
+	clock.Add(time.Hour)
+	require.Equal(t, chids[1], receive(ctx, t, handled))
+}
+
+func receive(ctx context.Context, t *testing.T, handled chan datatransfer.ChannelID) datatransfer.ChannelID {
+	select {
+	case <-ctx.Done():
+		t.Fatal("deadline was not handled")
+		return datatransfer.ChannelID{}
+	case chid := <-handled:
+		return chid
+	}
+}
+
+func generateChannelIDs(n int) []datatransfer.ChannelID {
+	peers := testutil.GeneratePeers(2)
+	chids := make([]datatransfer.ChannelID, 0, n)
+	for i := 0; i < n; i++ {
+		chids = append(chids, datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: datatransfer.TransferID(i)})
+	}
+	return chids
+}
@@ -100,3 +100,15 @@ func (ft *FakeTransport) CleanupChannel(chid datatransfer.ChannelID) {
 func (ft *FakeTransport) RecordCustomizedTransfer(chid datatransfer.ChannelID, voucher datatransfer.Voucher) {
 	ft.CustomizedTransfers = append(ft.CustomizedTransfers, CustomizedTransfer{chid, voucher})
 }
+
+// FakeLimitedTransport is a fake transport that only uses a limited number of
+// the cids it is asked not to send again
+type FakeLimitedTransport struct {
+	*FakeTransport
+	MaxDoNotSend int
+}
+
+// MaxDoNotSendCids returns the most do not send cids the transport uses
+func (ft *FakeLimitedTransport) MaxDoNotSendCids() int {
+	return ft.MaxDoNotSend
+}
@@ -0,0 +1,325 @@
+package faults_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/testutil"
+	"github.com/filecoin-project/go-data-transfer/testutil/faults"
+)
+
+func TestNetworkFaults(t *testing.T) {
+	ctx := context.Background()
+	peers := testutil.GeneratePeers(3)
+	send := func(net *testutil.FakeNetwork, wrapped interface {
+		SendMessage(context.Context, peer.ID, datatransfer.Message) error
+	}, p peer.ID, ids ...datatransfer.TransferID) []datatransfer.TransferID {
+		for _, id := range ids {
+			_ = wrapped.SendMessage(ctx, p, testutil.NewDTRequest(t, id))
+		}
+		var sent []datatransfer.TransferID
+		for _, msg := range net.SentMessages {
+			sent = append(sent, msg.Message.TransferID())
+		}
+		return sent
+	}
+
+	testCases := map[string]struct {
+		faults   []faults.Fault
+		to       peer.ID
+		expected []datatransfer.TransferID
+		fired    []int
+	}{
+		"no faults": {
+			to:       peers[1],
+			expected: []datatransfer.TransferID{1, 2, 3, 4},
+		},
+		"drop the second": {
+			faults:   []faults.Fault{{At: faults.SendMessage, Action: faults.Drop, Nth: 2}},
+			to:       peers[1],
+			expected: []datatransfer.TransferID{1, 3, 4},
+			fired:    []int{1},
+		},
+		"drop from the second on": {
+			faults:   []faults.Fault{{At: faults.SendMessage, Action: faults.Drop, Nth: 2, Times: -1}},
+			to:       peers[1],
+			expected: []datatransfer.TransferID{1},
+			fired:    []int{3},
+		},
+		"duplicate two": {
+			faults:   []faults.Fault{{At: faults.SendMessage, Action: faults.Duplicate, Nth: 3, Times: 2}},
+			to:       peers[1],
+			expected: []datatransfer.TransferID{1, 2, 3, 3, 4, 4},
+			fired:    []int{2},
+		},
+		"reorder": {
+			faults:   []faults.Fault{{At: faults.SendMessage, Action: faults.Reorder}},
+			to:       peers[1],
+			expected: []datatransfer.TransferID{2, 1, 3, 4},
+			fired:    []int{1},
+		},
+		"other peer": {
+			faults:   []faults.Fault{{At: faults.SendMessage, Action: faults.Drop, Peer: peers[2], Times: -1}},
+			to:       peers[1],
+			expected: []datatransfer.TransferID{1, 2, 3, 4},
+			fired:    []int{0},
+		},
+		"channel": {
+			faults: []faults.Fault{{
+				At:      faults.SendMessage,
+				Action:  faults.Drop,
+				Channel: &datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: 3},
+			}},
+			to:       peers[1],
+			expected: []datatransfer.TransferID{1, 2, 4},
+			fired:    []int{1},
+		},
+		"message filter": {
+			faults: []faults.Fault{{
+				At:      faults.SendMessage,
+				Action:  faults.Drop,
+				Times:   -1,
+				Message: func(msg datatransfer.Message) bool { return msg.TransferID()%2 == 0 },
+			}},
+			to:       peers[1],
+			expected: []datatransfer.TransferID{1, 3},
+			fired:    []int{2},
+		},
+		"first applicable fault wins": {
+			faults: []faults.Fault{
+				{At: faults.SendMessage, Action: faults.Drop, Nth: 2},
+				{At: faults.SendMessage, Action: faults.Duplicate, Nth: 2, Times: 2},
+			},
+			to:       peers[1],
+			expected: []datatransfer.TransferID{1, 3, 3, 4},
+			fired:    []int{1, 1},
+		},
+		"receive faults don't apply to sends": {
+			faults:   []faults.Fault{{At: faults.ReceiveMessage, Action: faults.Drop, Times: -1}},
+			to:       peers[1],
+			expected: []datatransfer.TransferID{1, 2, 3, 4},
+			fired:    []int{0},
+		},
+	}
+	for testCase, data := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			fn := testutil.NewFakeNetwork(peers[0])
+			script := faults.NewScript(data.faults...)
+			sent := send(fn, faults.Network(fn, script), data.to, 1, 2, 3, 4)
+			require.Equal(t, data.expected, sent)
+			for i, fired := range data.fired {
+				require.Equal(t, fired, script.Fired(i))
+			}
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		fn := testutil.NewFakeNetwork(peers[0])
+		errBoom := xerrors.New("boom")
+		script := faults.NewScript(
+			faults.Fault{At: faults.SendMessage, Action: faults.Error},
+			faults.Fault{At: faults.SendMessage, Action: faults.Error, Nth: 2, Err: errBoom},
+		)
+		net := faults.Network(fn, script)
+		require.True(t, xerrors.Is(net.SendMessage(ctx, peers[1], testutil.NewDTRequest(t, 1)), faults.ErrInjected))
+		require.True(t, xerrors.Is(net.SendMessage(ctx, peers[1], testutil.NewDTRequest(t, 2)), errBoom))
+		require.NoError(t, net.SendMessage(ctx, peers[1], testutil.NewDTRequest(t, 3)))
+		require.Len(t, fn.SentMessages, 1)
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		fn := testutil.NewFakeNetwork(peers[0])
+		script := faults.NewScript(faults.Fault{At: faults.SendMessage, Action: faults.Delay, Delay: 50 * time.Millisecond})
+		net := faults.Network(fn, script)
+		start := time.Now()
+		require.NoError(t, net.SendMessage(ctx, peers[1], testutil.NewDTRequest(t, 1)))
+		require.GreaterOrEqual(t, int64(time.Since(start)), int64(50*time.Millisecond))
+		require.Len(t, fn.SentMessages, 1)
+	})
+
+	t.Run("receive", func(t *testing.T) {
+		fn := testutil.NewFakeNetwork(peers[0])
+		script := faults.NewScript(
+			faults.Fault{At: faults.ReceiveMessage, Action: faults.Drop},
+			faults.Fault{At: faults.ReceiveMessage, Action: faults.Error, Nth: 2},
+		)
+		receiver := &receiver{}
+		faults.Network(fn, script).SetDelegate(receiver)
+		for id := datatransfer.TransferID(1); id <= 3; id++ {
+			fn.Delegate.ReceiveRequest(ctx, peers[1], testutil.NewDTRequest(t, id))
+		}
+		fn.Delegate.ReceiveResponse(ctx, peers[1], testutil.NewDTResponse(t, 4))
+		require.Equal(t, []datatransfer.TransferID{3, 4}, receiver.received)
+		require.Len(t, receiver.errors, 1)
+		require.True(t, xerrors.Is(receiver.errors[0], faults.ErrInjected))
+	})
+}
+
+func TestTransportFaults(t *testing.T) {
+	peers := testutil.GeneratePeers(3)
+	chid := datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: 1}
+	otherChid := datatransfer.ChannelID{Initiator: peers[0], Responder: peers[2], ID: 2}
+	links := make([]ipld.Link, 0, 4)
+	for _, c := range testutil.GenerateCids(4) {
+		links = append(links, cidlink.Link{Cid: c})
+	}
+
+	t.Run("pauseable and unwraps", func(t *testing.T) {
+		ft := testutil.NewFakeTransport()
+		transport := faults.Transport(ft, faults.NewScript())
+		_, ok := transport.(datatransfer.PauseableTransport)
+		require.True(t, ok)
+		require.Equal(t, ft, transport.(interface{ Unwrap() datatransfer.Transport }).Unwrap())
+	})
+
+	t.Run("data received", func(t *testing.T) {
+		ft := testutil.NewFakeTransport()
+		script := faults.NewScript(
+			faults.Fault{At: faults.DataReceived, Action: faults.Error, Nth: 3, Channel: &chid},
+			faults.Fault{At: faults.DataReceived, Action: faults.Drop, Channel: &otherChid, Times: -1},
+		)
+		events := &events{}
+		require.NoError(t, faults.Transport(ft, script).SetEventHandler(events))
+		for i, link := range links {
+			err := ft.EventHandler.OnDataReceived(chid, link, 100)
+			if i == 2 {
+				require.True(t, xerrors.Is(err, faults.ErrInjected))
+			} else {
+				require.NoError(t, err)
+			}
+			require.NoError(t, ft.EventHandler.OnDataReceived(otherChid, link, 100))
+		}
+		require.Equal(t, []ipld.Link{links[0], links[1], links[3]}, events.received[chid])
+		require.Empty(t, events.received[otherChid])
+		require.Equal(t, 1, script.Fired(0))
+		require.Equal(t, 4, script.Fired(1))
+	})
+
+	t.Run("reorder blocks per channel", func(t *testing.T) {
+		ft := testutil.NewFakeTransport()
+		script := faults.NewScript(faults.Fault{At: faults.DataSent, Action: faults.Reorder, Channel: &chid})
+		events := &events{}
+		require.NoError(t, faults.Transport(ft, script).SetEventHandler(events))
+		require.NoError(t, ft.EventHandler.OnDataSent(chid, links[0], 100))
+		require.NoError(t, ft.EventHandler.OnDataSent(otherChid, links[1], 100))
+		require.Empty(t, events.sent[chid])
+		require.NoError(t, ft.EventHandler.OnDataSent(chid, links[2], 100))
+		require.Equal(t, []ipld.Link{links[2], links[0]}, events.sent[chid])
+		require.Equal(t, []ipld.Link{links[1]}, events.sent[otherChid])
+	})
+
+	t.Run("reorder releases after delay", func(t *testing.T) {
+		ft := testutil.NewFakeTransport()
+		script := faults.NewScript(faults.Fault{At: faults.DataSent, Action: faults.Reorder, Delay: 20 * time.Millisecond})
+		events := &events{}
+		require.NoError(t, faults.Transport(ft, script).SetEventHandler(events))
+		require.NoError(t, ft.EventHandler.OnDataSent(chid, links[0], 100))
+		require.Eventually(t, func() bool {
+			return len(events.sentTo(chid)) == 1
+		}, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("duplicate queued", func(t *testing.T) {
+		ft := testutil.NewFakeTransport()
+		script := faults.NewScript(faults.Fault{At: faults.DataQueued, Action: faults.Duplicate})
+		events := &events{}
+		require.NoError(t, faults.Transport(ft, script).SetEventHandler(events))
+		_, err := ft.EventHandler.OnDataQueued(chid, links[0], 100)
+		require.NoError(t, err)
+		require.Equal(t, []ipld.Link{links[0], links[0]}, events.queued[chid])
+	})
+
+	t.Run("channel completed with error", func(t *testing.T) {
+		ft := testutil.NewFakeTransport()
+		script := faults.NewScript(faults.Fault{At: faults.ChannelCompleted, Action: faults.Error, Peer: peers[1]})
+		events := &events{}
+		require.NoError(t, faults.Transport(ft, script).SetEventHandler(events))
+		require.NoError(t, ft.EventHandler.OnChannelCompleted(otherChid, nil))
+		require.NoError(t, ft.EventHandler.OnChannelCompleted(chid, nil))
+		require.NoError(t, events.completed[otherChid])
+		require.True(t, xerrors.Is(events.completed[chid], faults.ErrInjected))
+	})
+}
+
+type receiver struct {
+	received []datatransfer.TransferID
+	errors   []error
+}
+
+func (r *receiver) ReceiveRequest(ctx context.Context, sender peer.ID, incoming datatransfer.Request) {
+	r.received = append(r.received, incoming.TransferID())
+}
+
+func (r *receiver) ReceiveResponse(ctx context.Context, sender peer.ID, incoming datatransfer.Response) {
+	r.received = append(r.received, incoming.TransferID())
+}
+
+func (r *receiver) ReceiveRestartExistingChannelRequest(ctx context.Context, sender peer.ID, incoming datatransfer.Request) {
+	r.received = append(r.received, incoming.TransferID())
+}
+
+func (r *receiver) ReceiveError(err error) {
+	r.errors = append(r.errors, err)
+}
+
+func (r *receiver) ReceivePeerConnected(p peer.ID) {
+}
+
+// events records the block and completion events passed to it
+type events struct {
+	datatransfer.EventsHandler
+	lk        sync.Mutex
+	queued    map[datatransfer.ChannelID][]ipld.Link
+	sent      map[datatransfer.ChannelID][]ipld.Link
+	received  map[datatransfer.ChannelID][]ipld.Link
+	completed map[datatransfer.ChannelID]error
+}
+
+func record(lk *sync.Mutex, m *map[datatransfer.ChannelID][]ipld.Link, chid datatransfer.ChannelID, link ipld.Link) {
+	lk.Lock()
+	defer lk.Unlock()
+	if *m == nil {
+		*m = make(map[datatransfer.ChannelID][]ipld.Link)
+	}
+	(*m)[chid] = append((*m)[chid], link)
+}
+
+func (e *events) sentTo(chid datatransfer.ChannelID) []ipld.Link {
+	e.lk.Lock()
+	defer e.lk.Unlock()
+	return e.sent[chid]
+}
+
+func (e *events) OnDataQueued(chid datatransfer.ChannelID, link ipld.Link, size uint64) (datatransfer.Message, error) {
+	record(&e.lk, &e.queued, chid, link)
+	return nil, nil
+}
+
+func (e *events) OnDataSent(chid datatransfer.ChannelID, link ipld.Link, size uint64) error {
+	record(&e.lk, &e.sent, chid, link)
+	return nil
+}
+
+func (e *events) OnDataReceived(chid datatransfer.ChannelID, link ipld.Link, size uint64) error {
+	record(&e.lk, &e.received, chid, link)
+	return nil
+}
+
+func (e *events) OnChannelCompleted(chid datatransfer.ChannelID, err error) error {
+	e.lk.Lock()
+	defer e.lk.Unlock()
+	if e.completed == nil {
+		e.completed = make(map[datatransfer.ChannelID]error)
+	}
+	e.completed[chid] = err
+	return nil
+}
@@ -0,0 +1,66 @@
+package faults
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/network"
+)
+
+// Network wraps a data transfer network, injecting the script's SendMessage
+// faults into the messages it sends, and its ReceiveMessage faults into the
+// messages it passes to the receiver. Messages a transport carries itself,
+// such as graphsync extensions, don't go through the network.
+func Network(net network.DataTransferNetwork, script *Script) network.DataTransferNetwork {
+	return &faultyNetwork{DataTransferNetwork: net, script: script}
+}
+
+type faultyNetwork struct {
+	network.DataTransferNetwork
+	script *Script
+}
+
+func (n *faultyNetwork) SendMessage(ctx context.Context, p peer.ID, msg datatransfer.Message) error {
+	return n.script.apply(ctx, event{point: SendMessage, peer: p, msg: msg}, func() error {
+		return n.DataTransferNetwork.SendMessage(ctx, p, msg)
+	}, nil)
+}
+
+func (n *faultyNetwork) SetDelegate(receiver network.Receiver) {
+	n.DataTransferNetwork.SetDelegate(&faultyReceiver{Receiver: receiver, script: n.script})
+}
+
+type faultyReceiver struct {
+	network.Receiver
+	script *Script
+}
+
+func (r *faultyReceiver) receive(ctx context.Context, sender peer.ID, msg datatransfer.Message, deliver func()) {
+	_ = r.script.apply(ctx, event{point: ReceiveMessage, peer: sender, msg: msg}, func() error {
+		deliver()
+		return nil
+	}, func(err error) error {
+		r.Receiver.ReceiveError(err)
+		return nil
+	})
+}
+
+func (r *faultyReceiver) ReceiveRequest(ctx context.Context, sender peer.ID, incoming datatransfer.Request) {
+	r.receive(ctx, sender, incoming, func() {
+		r.Receiver.ReceiveRequest(ctx, sender, incoming)
+	})
+}
+
+func (r *faultyReceiver) ReceiveResponse(ctx context.Context, sender peer.ID, incoming datatransfer.Response) {
+	r.receive(ctx, sender, incoming, func() {
+		r.Receiver.ReceiveResponse(ctx, sender, incoming)
+	})
+}
+
+func (r *faultyReceiver) ReceiveRestartExistingChannelRequest(ctx context.Context, sender peer.ID, incoming datatransfer.Request) {
+	r.receive(ctx, sender, incoming, func() {
+		r.Receiver.ReceiveRestartExistingChannelRequest(ctx, sender, incoming)
+	})
+}
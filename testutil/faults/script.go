@@ -0,0 +1,340 @@
+// Package faults injects failures into a data transfer network or transport at
+// exact points, such as dropping the completion message or erroring the 500th
+// block, for testing restart and recovery.
+//
+// A Script holds the faults to inject. Wrap a network with Network and a
+// transport with Transport, passing the same script or separate ones, and
+// hand the wrappers to the data transfer manager:
+//
+//	script := faults.NewScript(faults.Fault{At: faults.DataReceived, Nth: 500, Action: faults.Error})
+//	dt, err := impl.NewDataTransfer(ds, dir, faults.Network(net, script), faults.Transport(transport, script), counter)
+package faults
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+)
+
+var log = logging.Logger("dt-faults")
+
+// ErrInjected is the error of an Error fault that does not set its own
+var ErrInjected = errors.New("injected fault")
+
+// Point is a place where a fault can be injected
+type Point int
+
+const (
+	// SendMessage is a message sent with DataTransferNetwork.SendMessage
+	SendMessage Point = iota
+
+	// ReceiveMessage is a message received from the network, before it is
+	// passed to the receiver
+	ReceiveMessage
+
+	// DataQueued is a block queued for sending by the transport
+	DataQueued
+
+	// DataSent is a block sent by the transport
+	DataSent
+
+	// DataReceived is a block received by the transport
+	DataReceived
+
+	// ChannelCompleted is the transport completing a channel
+	ChannelCompleted
+)
+
+// Action is what a fault does
+type Action int
+
+const (
+	// Drop drops the message, or hides the block or completion from the
+	// manager
+	Drop Action = iota
+
+	// Delay holds the message, block or completion up for the fault's Delay
+	Delay
+
+	// Duplicate passes the message, block or completion on twice
+	Duplicate
+
+	// Reorder holds the message or block back until the next one to or from
+	// the same peer (for messages) or on the same channel (for blocks and
+	// completions) has been passed on, or until the fault's Delay has passed
+	// if it is set. The message a held block returns from OnDataQueued is
+	// lost.
+	Reorder
+
+	// Error fails with the fault's Err. Sending a message returns the error,
+	// a received message is replaced by a call to the receiver's
+	// ReceiveError, a block event returns the error to the transport, and a
+	// channel completes with the error.
+	Error
+)
+
+// Fault is one rule of a script. It applies to the events at its point that
+// match all of its filters, starting at the Nth one.
+type Fault struct {
+	// At is where the fault is injected
+	At Point
+	// Action is what the fault does
+	Action Action
+
+	// Nth is the first matching event the fault applies to, counting from 1.
+	// Zero means the first one.
+	Nth int
+	// Times is how many matching events the fault applies to, from the Nth.
+	// Zero means one, and a negative number means all of them.
+	Times int
+
+	// Channel only matches events on this channel, if it is set. Messages
+	// match on their transfer ID and peer.
+	Channel *datatransfer.ChannelID
+	// Peer only matches messages to or from this peer, and events on
+	// channels with this peer, if it is set
+	Peer peer.ID
+	// Message only matches messages it returns true for, if it is set
+	Message func(datatransfer.Message) bool
+
+	// Delay is how long a Delay fault waits, or the longest a Reorder fault
+	// holds an event back
+	Delay time.Duration
+	// Err is the error of an Error fault, ErrInjected by default
+	Err error
+}
+
+func (f Fault) err() error {
+	if f.Err != nil {
+		return f.Err
+	}
+	return ErrInjected
+}
+
+type scriptedFault struct {
+	Fault
+	seen  int
+	fired int
+}
+
+// applies counts a matching event and returns whether the fault applies to it
+func (f *scriptedFault) applies() bool {
+	f.seen++
+	first := f.Nth
+	if first == 0 {
+		first = 1
+	}
+	if f.seen < first {
+		return false
+	}
+	if f.Times >= 0 {
+		times := f.Times
+		if times == 0 {
+			times = 1
+		}
+		if f.seen >= first+times {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *scriptedFault) matches(e event) bool {
+	if f.At != e.point {
+		return false
+	}
+	if f.Peer != "" {
+		if e.chid != nil {
+			if f.Peer != e.chid.Initiator && f.Peer != e.chid.Responder {
+				return false
+			}
+		} else if f.Peer != e.peer {
+			return false
+		}
+	}
+	if f.Channel != nil {
+		if e.chid != nil {
+			if *e.chid != *f.Channel {
+				return false
+			}
+		} else if e.msg == nil || e.msg.TransferID() != f.Channel.ID ||
+			(e.peer != f.Channel.Initiator && e.peer != f.Channel.Responder) {
+			return false
+		}
+	}
+	if f.Message != nil && (e.msg == nil || !f.Message(e.msg)) {
+		return false
+	}
+	return true
+}
+
+// event is something a fault can be injected into
+type event struct {
+	point Point
+	// peer is the other peer of a message
+	peer peer.ID
+	// msg is the message, for messages
+	msg datatransfer.Message
+	// chid is the channel, for transport events
+	chid *datatransfer.ChannelID
+}
+
+// key identifies the events a Reorder fault reorders among
+func (e event) key() heldKey {
+	key := heldKey{point: e.point, peer: e.peer}
+	if e.chid != nil {
+		key.chid = *e.chid
+	}
+	return key
+}
+
+type heldKey struct {
+	point Point
+	peer  peer.ID
+	chid  datatransfer.ChannelID
+}
+
+// Script is a programmable list of faults. It is safe to use from multiple
+// goroutines, and to add faults to while it is in use.
+type Script struct {
+	lk     sync.Mutex
+	faults []*scriptedFault
+	held   map[heldKey]*heldEvent
+}
+
+// heldEvent is an event a Reorder fault is holding back
+type heldEvent struct {
+	once    sync.Once
+	deliver func() error
+}
+
+func (h *heldEvent) release() {
+	h.once.Do(func() {
+		if err := h.deliver(); err != nil {
+			log.Warnf("delivering reordered event: %s", err)
+		}
+	})
+}
+
+// NewScript returns a script with the given faults
+func NewScript(faults ...Fault) *Script {
+	s := &Script{held: make(map[heldKey]*heldEvent)}
+	s.Add(faults...)
+	return s
+}
+
+// Add adds faults to the script. Their events are counted from when they are
+// added.
+func (s *Script) Add(faults ...Fault) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	for _, f := range faults {
+		s.faults = append(s.faults, &scriptedFault{Fault: f})
+	}
+}
+
+// Fired returns how many times the ith fault added to the script has fired
+func (s *Script) Fired(i int) int {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.faults[i].fired
+}
+
+// fault counts an event against every fault it matches, and returns the
+// first one that applies to it, if any
+func (s *Script) fault(e event) *Fault {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	var applied *Fault
+	for _, f := range s.faults {
+		if f.matches(e) && f.applies() && applied == nil {
+			f.fired++
+			fault := f.Fault
+			applied = &fault
+		}
+	}
+	return applied
+}
+
+// apply passes an event on with deliver, according to the script. onError
+// handles an Error fault, and by default the error is returned.
+func (s *Script) apply(ctx context.Context, e event, deliver func() error, onError func(error) error) error {
+	f := s.fault(e)
+	if f == nil {
+		err := deliver()
+		s.release(e.key())
+		return err
+	}
+	switch f.Action {
+	case Drop:
+		return nil
+	case Delay:
+		timer := time.NewTimer(f.Delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		err := deliver()
+		s.release(e.key())
+		return err
+	case Duplicate:
+		if err := deliver(); err != nil {
+			return err
+		}
+		err := deliver()
+		s.release(e.key())
+		return err
+	case Reorder:
+		s.hold(e.key(), deliver, f.Delay)
+		return nil
+	case Error:
+		if onError != nil {
+			return onError(f.err())
+		}
+		return f.err()
+	default:
+		return deliver()
+	}
+}
+
+// hold holds an event back until the next one with the same key, or until
+// the delay has passed if it is set
+func (s *Script) hold(key heldKey, deliver func() error, delay time.Duration) {
+	held := &heldEvent{deliver: deliver}
+	s.lk.Lock()
+	previous := s.held[key]
+	s.held[key] = held
+	s.lk.Unlock()
+	if previous != nil {
+		previous.release()
+	}
+	if delay > 0 {
+		time.AfterFunc(delay, func() {
+			s.lk.Lock()
+			if s.held[key] == held {
+				delete(s.held, key)
+			}
+			s.lk.Unlock()
+			held.release()
+		})
+	}
+}
+
+// release passes on the event held back with the given key, if any
+func (s *Script) release(key heldKey) {
+	s.lk.Lock()
+	held, ok := s.held[key]
+	delete(s.held, key)
+	s.lk.Unlock()
+	if ok {
+		held.release()
+	}
+}
@@ -0,0 +1,86 @@
+package faults
+
+import (
+	"context"
+
+	"github.com/ipld/go-ipld-prime"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+)
+
+// Transport wraps a transport, injecting the script's DataQueued, DataSent,
+// DataReceived and ChannelCompleted faults into the events it passes to the
+// manager. The wrapper is pauseable if the transport is. Code that needs the
+// wrapped transport itself, such as a transport configurer, can get it with
+// Unwrap.
+func Transport(transport datatransfer.Transport, script *Script) datatransfer.Transport {
+	ft := &faultyTransport{Transport: transport, script: script}
+	if pauseable, ok := transport.(datatransfer.PauseableTransport); ok {
+		return &faultyPauseableTransport{faultyTransport: ft, pauseable: pauseable}
+	}
+	return ft
+}
+
+type faultyTransport struct {
+	datatransfer.Transport
+	script *Script
+}
+
+// Unwrap returns the wrapped transport
+func (t *faultyTransport) Unwrap() datatransfer.Transport {
+	return t.Transport
+}
+
+func (t *faultyTransport) SetEventHandler(events datatransfer.EventsHandler) error {
+	return t.Transport.SetEventHandler(&faultyEvents{EventsHandler: events, script: t.script})
+}
+
+type faultyPauseableTransport struct {
+	*faultyTransport
+	pauseable datatransfer.PauseableTransport
+}
+
+func (t *faultyPauseableTransport) PauseChannel(ctx context.Context, chid datatransfer.ChannelID) error {
+	return t.pauseable.PauseChannel(ctx, chid)
+}
+
+func (t *faultyPauseableTransport) ResumeChannel(ctx context.Context, msg datatransfer.Message, chid datatransfer.ChannelID) error {
+	return t.pauseable.ResumeChannel(ctx, msg, chid)
+}
+
+type faultyEvents struct {
+	datatransfer.EventsHandler
+	script *Script
+}
+
+func (e *faultyEvents) OnDataQueued(chid datatransfer.ChannelID, link ipld.Link, size uint64) (datatransfer.Message, error) {
+	var msg datatransfer.Message
+	err := e.script.apply(context.Background(), event{point: DataQueued, chid: &chid}, func() error {
+		queuedMsg, err := e.EventsHandler.OnDataQueued(chid, link, size)
+		if msg == nil {
+			msg = queuedMsg
+		}
+		return err
+	}, nil)
+	return msg, err
+}
+
+func (e *faultyEvents) OnDataSent(chid datatransfer.ChannelID, link ipld.Link, size uint64) error {
+	return e.script.apply(context.Background(), event{point: DataSent, chid: &chid}, func() error {
+		return e.EventsHandler.OnDataSent(chid, link, size)
+	}, nil)
+}
+
+func (e *faultyEvents) OnDataReceived(chid datatransfer.ChannelID, link ipld.Link, size uint64) error {
+	return e.script.apply(context.Background(), event{point: DataReceived, chid: &chid}, func() error {
+		return e.EventsHandler.OnDataReceived(chid, link, size)
+	}, nil)
+}
+
+func (e *faultyEvents) OnChannelCompleted(chid datatransfer.ChannelID, completeErr error) error {
+	return e.script.apply(context.Background(), event{point: ChannelCompleted, chid: &chid}, func() error {
+		return e.EventsHandler.OnChannelCompleted(chid, completeErr)
+	}, func(err error) error {
+		return e.EventsHandler.OnChannelCompleted(chid, err)
+	})
+}
@@ -0,0 +1,118 @@
+package testutil
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/encoding"
+	"github.com/filecoin-project/go-data-transfer/message"
+)
+
+// NewFuzzSeeds returns one message of every type in the current protocol
+// version, with vouchers, selectors and metadata filled in, to seed fuzz
+// targets for message decoding
+func NewFuzzSeeds(t testing.TB) []datatransfer.Message {
+	baseCid := GenerateCids(1)[0]
+	peers := GeneratePeers(2)
+	voucher := NewFakeDTType()
+	voucherResult := NewFakeDTType()
+	reason := datatransfer.Reason{Code: 7, Text: "not today"}
+
+	var seeds []datatransfer.Message
+	add := func(msg datatransfer.Message, err error) {
+		require.NoError(t, err)
+		seeds = append(seeds, msg)
+	}
+	for _, isRestart := range []bool{false, true} {
+		for _, isPull := range []bool{false, true} {
+			add(message.NewRequest(1, isRestart, isPull, voucher.Type(), voucher, baseCid, AllSelector()))
+		}
+	}
+	add(message.RestartExistingChannelRequest(datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: 1}), nil)
+	add(message.UpdateRequest(1, true), nil)
+	add(message.VoucherRequest(1, voucher.Type(), voucher))
+	add(message.CancelRequest(1), nil)
+	add(message.RequestWithReason(message.CancelRequest(1), reason))
+
+	request, err := message.NewRequest(1, false, true, voucher.Type(), voucher, baseCid, AllSelector())
+	require.NoError(t, err)
+	request, err = message.RequestWithTotalSize(request, 1<<30)
+	require.NoError(t, err)
+	add(message.RequestWithLabels(request, map[string]string{"deal": "1", "client": "f01"}))
+
+	add(message.NewResponse(1, true, false, voucherResult.Type(), voucherResult))
+	add(message.RestartResponse(1, true, true, voucherResult.Type(), voucherResult))
+	add(message.VoucherResultResponse(1, false, false, voucherResult.Type(), voucherResult))
+	add(message.UpdateResponse(1, true), nil)
+	add(message.CancelResponse(1), nil)
+	add(message.CompleteResponse(1, true, false, voucherResult.Type(), voucherResult))
+	add(message.ResponseWithReason(message.CancelResponse(1), reason))
+	return seeds
+}
+
+// EncodeFuzzSeeds returns the encodings of the fuzz seeds that can be sent
+// on the given protocol
+func EncodeFuzzSeeds(t testing.TB, protocol protocol.ID) [][]byte {
+	var encoded [][]byte
+	for _, seed := range NewFuzzSeeds(t) {
+		msg, err := seed.MessageForProtocol(protocol)
+		if err != nil {
+			// not every message can be sent on older protocols
+			continue
+		}
+		buf := new(bytes.Buffer)
+		require.NoError(t, msg.ToNet(buf))
+		encoded = append(encoded, buf.Bytes())
+	}
+	return encoded
+}
+
+// ExerciseMessage reads every field of a decoded message, as the receiving
+// manager might, and checks it still encodes to something that decodes, so
+// fuzz targets reach the lazily decoded parts of a message such as its
+// voucher, selector and metadata
+func ExerciseMessage(t testing.TB, msg datatransfer.Message, fromNet func([]byte) (datatransfer.Message, error)) {
+	decoder, err := encoding.NewDecoder(&FakeDTType{})
+	require.NoError(t, err)
+
+	msg.IsRequest()
+	msg.IsRestart()
+	msg.IsNew()
+	msg.IsUpdate()
+	msg.IsPaused()
+	msg.IsCancel()
+	msg.TransferID()
+	msg.Reason()
+	switch msg := msg.(type) {
+	case datatransfer.Request:
+		msg.IsPull()
+		msg.IsVoucher()
+		msg.VoucherType()
+		_, _ = msg.Voucher(decoder)
+		msg.BaseCid()
+		_, _ = msg.Selector()
+		msg.IsRestartExistingChannelRequest()
+		_, _ = msg.RestartChannelId()
+		msg.TotalSize()
+		msg.Labels()
+	case datatransfer.Response:
+		msg.IsVoucherResult()
+		msg.IsComplete()
+		msg.Accepted()
+		msg.VoucherResultType()
+		_, _ = msg.VoucherResult(decoder)
+		msg.EmptyVoucherResult()
+	}
+	for _, protocol := range []protocol.ID{datatransfer.ProtocolDataTransfer1_0, datatransfer.ProtocolDataTransfer1_1, datatransfer.ProtocolDataTransfer1_2} {
+		_, _ = msg.MessageForProtocol(protocol)
+	}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, msg.ToNet(buf))
+	_, err = fromNet(buf.Bytes())
+	require.NoError(t, err)
+}
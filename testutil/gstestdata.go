@@ -60,6 +60,7 @@ const unixfsChunkSize uint64 = 1 << 10
 const unixfsLinksPerLevel = 1024
 
 var extsForProtocol = map[protocol.ID]graphsync.ExtensionName{
+	datatransfer.ProtocolDataTransfer1_2: extension.ExtensionDataTransfer1_2,
 	datatransfer.ProtocolDataTransfer1_1: extension.ExtensionDataTransfer1_1,
 	datatransfer.ProtocolDataTransfer1_0: extension.ExtensionDataTransfer1_0,
 }
@@ -290,7 +291,7 @@ func (gsData *GraphsyncTestingData) VerifyFileTransferred(t *testing.T, link ipl
 
 // VerifyHasFile verifies the presence of the given file with the given ipld.Link and file contents (fileBytes)
 // exists in the given blockstore identified by dagService
-func VerifyHasFile(ctx context.Context, t *testing.T, dagService ipldformat.DAGService, link ipld.Link, fileBytes []byte) {
+func VerifyHasFile(ctx context.Context, t testing.TB, dagService ipldformat.DAGService, link ipld.Link, fileBytes []byte) {
 	c := link.(cidlink.Link).Cid
 
 	// load the root of the UnixFS DAG from the new blockstore
@@ -0,0 +1,93 @@
+package testutil
+
+import (
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-data-transfer/scheduler"
+)
+
+// MockClock is a clock whose time only moves when Add is called
+type MockClock struct {
+	lk      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiting []*mockTimer
+}
+
+var _ scheduler.Clock = (*MockClock)(nil)
+
+// NewMockClock returns a mock clock set to an arbitrary fixed time
+func NewMockClock() *MockClock {
+	mc := &MockClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	mc.cond = sync.NewCond(&mc.lk)
+	return mc
+}
+
+// Now returns the current mock time
+func (mc *MockClock) Now() time.Time {
+	mc.lk.Lock()
+	defer mc.lk.Unlock()
+	return mc.now
+}
+
+// NewTimer returns a timer that fires once the mock time has moved on by d
+func (mc *MockClock) NewTimer(d time.Duration) scheduler.Timer {
+	mc.lk.Lock()
+	defer mc.lk.Unlock()
+	t := &mockTimer{mc: mc, fireAt: mc.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- mc.now
+		return t
+	}
+	mc.waiting = append(mc.waiting, t)
+	mc.cond.Broadcast()
+	return t
+}
+
+// Add moves the mock time on by d and fires any timers that are due
+func (mc *MockClock) Add(d time.Duration) {
+	mc.lk.Lock()
+	defer mc.lk.Unlock()
+	mc.now = mc.now.Add(d)
+	waiting := mc.waiting[:0]
+	for _, t := range mc.waiting {
+		if t.fireAt.After(mc.now) {
+			waiting = append(waiting, t)
+			continue
+		}
+		t.c <- mc.now
+	}
+	mc.waiting = waiting
+}
+
+// BlockUntil waits until at least n timers are waiting to fire
+func (mc *MockClock) BlockUntil(n int) {
+	mc.lk.Lock()
+	defer mc.lk.Unlock()
+	for len(mc.waiting) < n {
+		mc.cond.Wait()
+	}
+}
+
+type mockTimer struct {
+	mc     *MockClock
+	fireAt time.Time
+	c      chan time.Time
+}
+
+func (t *mockTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *mockTimer) Stop() bool {
+	t.mc.lk.Lock()
+	defer t.mc.lk.Unlock()
+	for i, w := range t.mc.waiting {
+		if w == t {
+			t.mc.waiting = append(t.mc.waiting[:i], t.mc.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -49,6 +49,11 @@ func (sv *StubbedValidator) StubErrorPush() {
 	sv.pushError = errors.New("something went wrong")
 }
 
+// StubErrorPushWithReason sets ValidatePush to reject with the given reason
+func (sv *StubbedValidator) StubErrorPushWithReason(reason datatransfer.Reason) {
+	sv.pushError = reason
+}
+
 // StubSuccessPush sets ValidatePush to succeed
 func (sv *StubbedValidator) StubSuccessPush() {
 	sv.pushError = nil
@@ -82,6 +87,11 @@ func (sv *StubbedValidator) StubErrorPull() {
 	sv.pullError = errors.New("something went wrong")
 }
 
+// StubErrorPullWithReason sets ValidatePull to reject with the given reason
+func (sv *StubbedValidator) StubErrorPullWithReason(reason datatransfer.Reason) {
+	sv.pullError = reason
+}
+
 // StubSuccessPull sets ValidatePull to succeed
 func (sv *StubbedValidator) StubSuccessPull() {
 	sv.pullError = nil
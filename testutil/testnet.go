@@ -2,8 +2,10 @@ package testutil
 
 import (
 	"context"
+	"sync"
 
 	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
 
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-data-transfer/network"
@@ -15,14 +17,26 @@ type FakeSentMessage struct {
 	Message datatransfer.Message
 }
 
+// FakePeerAddrs is a recording of addresses given for a peer on the FakeNetwork
+type FakePeerAddrs struct {
+	PeerID peer.ID
+	Addrs  []ma.Multiaddr
+}
+
 // FakeNetwork is a network that satisfies the DataTransferNetwork interface but
 // does not actually do anything
 type FakeNetwork struct {
 	PeerID       peer.ID
 	SentMessages []FakeSentMessage
+	PeerAddrs    []FakePeerAddrs
 	Delegate     network.Receiver
+
+	protectedLk sync.Mutex
+	protected   map[peer.ID]map[string]struct{}
 }
 
+var _ network.PeerAddrBook = (*FakeNetwork)(nil)
+
 // NewFakeNetwork returns a new fake data transfer network instance
 func NewFakeNetwork(id peer.ID) *FakeNetwork {
 	return &FakeNetwork{PeerID: id}
@@ -50,11 +64,41 @@ func (fn *FakeNetwork) ID() peer.ID {
 	return fn.PeerID
 }
 
-// Protect does nothing on the fake network
+// Protect records that the peer is protected with the tag
 func (fn *FakeNetwork) Protect(id peer.ID, tag string) {
+	fn.protectedLk.Lock()
+	defer fn.protectedLk.Unlock()
+	if fn.protected == nil {
+		fn.protected = make(map[peer.ID]map[string]struct{})
+	}
+	if fn.protected[id] == nil {
+		fn.protected[id] = make(map[string]struct{})
+	}
+	fn.protected[id][tag] = struct{}{}
 }
 
-// Unprotect does nothing on the fake network
+// Unprotect removes the tag from the peer, and returns whether the peer is
+// still protected with other tags
 func (fn *FakeNetwork) Unprotect(id peer.ID, tag string) bool {
-	return false
+	fn.protectedLk.Lock()
+	defer fn.protectedLk.Unlock()
+	delete(fn.protected[id], tag)
+	if len(fn.protected[id]) == 0 {
+		delete(fn.protected, id)
+		return false
+	}
+	return true
+}
+
+// IsProtected returns whether the peer is protected with the tag
+func (fn *FakeNetwork) IsProtected(id peer.ID, tag string) bool {
+	fn.protectedLk.Lock()
+	defer fn.protectedLk.Unlock()
+	_, ok := fn.protected[id][tag]
+	return ok
+}
+
+// AddPeerAddrs records the addresses given for a peer
+func (fn *FakeNetwork) AddPeerAddrs(p peer.ID, addrs []ma.Multiaddr) {
+	fn.PeerAddrs = append(fn.PeerAddrs, FakePeerAddrs{p, addrs})
 }
@@ -3,6 +3,7 @@ package testutil
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"fmt"
 	"testing"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/ipld/go-ipld-prime/traversal/selector"
 	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
 	"github.com/jbenet/go-random"
+	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/stretchr/testify/require"
 
@@ -67,6 +69,20 @@ func GeneratePeers(n int) []peer.ID {
 	return peerIds
 }
 
+// GenerateValidPeers creates n peer ids from new keys. Unlike the ids from
+// GeneratePeers, they can be parsed back from their string form.
+func GenerateValidPeers(t testing.TB, n int) []peer.ID {
+	peerIds := make([]peer.ID, 0, n)
+	for i := 0; i < n; i++ {
+		_, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		p, err := peer.IDFromPublicKey(pub)
+		require.NoError(t, err)
+		peerIds = append(peerIds, p)
+	}
+	return peerIds
+}
+
 // ContainsPeer returns true if a peer is found n a list of peers.
 func ContainsPeer(peers []peer.ID, p peer.ID) bool {
 	for _, n := range peers {
@@ -100,6 +100,17 @@ type Transport interface {
 	Shutdown(ctx context.Context) error
 }
 
+// DoNotSendLimitedTransport is a transport that only passes on a limited
+// number of the cids the other peer should not send when a channel is
+// restarted. The manager reads no more cids than that from the channel's
+// list of received cids.
+type DoNotSendLimitedTransport interface {
+	Transport
+	// MaxDoNotSendCids returns the most cids passed to OpenChannel that the
+	// transport uses
+	MaxDoNotSendCids() int
+}
+
 // PauseableTransport is a transport that can also pause and resume channels
 type PauseableTransport interface {
 	Transport
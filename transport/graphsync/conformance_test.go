@@ -0,0 +1,26 @@
+package graphsync_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-data-transfer/testutil"
+	"github.com/filecoin-project/go-data-transfer/transport/transporttest"
+)
+
+func TestConformance(t *testing.T) {
+	transporttest.Run(t, func(ctx context.Context, t *testing.T) (transporttest.Peer, transporttest.Peer) {
+		gsData := testutil.NewGraphsyncTestingData(ctx, t, nil, nil)
+		sender := transporttest.Peer{
+			ID:         gsData.Host1.ID(),
+			Transport:  gsData.SetupGSTransportHost1(),
+			DAGService: gsData.DagService1,
+		}
+		receiver := transporttest.Peer{
+			ID:         gsData.Host2.ID(),
+			Transport:  gsData.SetupGSTransportHost2(),
+			DAGService: gsData.DagService2,
+		}
+		return sender, receiver
+	})
+}
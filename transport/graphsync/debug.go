@@ -0,0 +1,83 @@
+package graphsync
+
+import (
+	"sort"
+
+	"github.com/ipfs/go-graphsync"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+)
+
+// DebugState is a snapshot of the transport's bookkeeping, for debugging
+// stuck transfers
+type DebugState struct {
+	// GraphsyncRequests are the graphsync requests the transport knows the
+	// channel for (graphsyncRequestMap)
+	GraphsyncRequests []DebugRequest `json:"graphsyncRequestMap"`
+	// Pending are the channels whose outgoing graphsync request has not
+	// completed yet
+	Pending []datatransfer.ChannelID `json:"pending"`
+	// RequestorCancelled are the channels whose graphsync request was
+	// cancelled by the requesting peer (requestorCancelledMap)
+	RequestorCancelled []datatransfer.ChannelID `json:"requestorCancelledMap"`
+	// Stores are the channels with a registered graphsync store
+	Stores []datatransfer.ChannelID `json:"stores"`
+	// PendingExtensions are the channels with extensions waiting to be sent
+	// with the next graphsync response
+	PendingExtensions []datatransfer.ChannelID `json:"pendingExtensions"`
+}
+
+// DebugRequest is a graphsync request and the channel it belongs to
+type DebugRequest struct {
+	RequestID graphsync.RequestID    `json:"requestID"`
+	Peer      peer.ID                `json:"peer"`
+	ChannelID datatransfer.ChannelID `json:"channelID"`
+}
+
+// DebugState returns a snapshot of the transport's bookkeeping as a
+// DebugState, which can be encoded as JSON. Requests are sorted by peer and
+// request ID, and channels by channel ID, so snapshots can be compared.
+func (t *Transport) DebugState() interface{} {
+	t.dataLock.RLock()
+	defer t.dataLock.RUnlock()
+
+	state := DebugState{
+		GraphsyncRequests:  make([]DebugRequest, 0, len(t.graphsyncRequestMap)),
+		Pending:            make([]datatransfer.ChannelID, 0, len(t.pending)),
+		RequestorCancelled: make([]datatransfer.ChannelID, 0, len(t.requestorCancelledMap)),
+		Stores:             make([]datatransfer.ChannelID, 0, len(t.stores)),
+		PendingExtensions:  make([]datatransfer.ChannelID, 0, len(t.pendingExtensions)),
+	}
+	for key, chid := range t.graphsyncRequestMap {
+		state.GraphsyncRequests = append(state.GraphsyncRequests, DebugRequest{RequestID: key.requestID, Peer: key.p, ChannelID: chid})
+	}
+	for chid := range t.pending {
+		state.Pending = append(state.Pending, chid)
+	}
+	for chid := range t.requestorCancelledMap {
+		state.RequestorCancelled = append(state.RequestorCancelled, chid)
+	}
+	for chid := range t.stores {
+		state.Stores = append(state.Stores, chid)
+	}
+	for chid := range t.pendingExtensions {
+		state.PendingExtensions = append(state.PendingExtensions, chid)
+	}
+	sort.Slice(state.GraphsyncRequests, func(i, j int) bool {
+		ri, rj := state.GraphsyncRequests[i], state.GraphsyncRequests[j]
+		if ri.Peer != rj.Peer {
+			return ri.Peer < rj.Peer
+		}
+		return ri.RequestID < rj.RequestID
+	})
+	sortChannelIDs(state.Pending)
+	sortChannelIDs(state.RequestorCancelled)
+	sortChannelIDs(state.Stores)
+	sortChannelIDs(state.PendingExtensions)
+	return state
+}
+
+func sortChannelIDs(chids []datatransfer.ChannelID) {
+	sort.Slice(chids, func(i, j int) bool { return chids[i].String() < chids[j].String() })
+}
@@ -0,0 +1,47 @@
+//go:build go1.18
+// +build go1.18
+
+package extension_test
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-graphsync"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/testutil"
+	"github.com/filecoin-project/go-data-transfer/transport/graphsync/extension"
+)
+
+var extensionNames = []graphsync.ExtensionName{
+	extension.ExtensionDataTransfer1_2,
+	extension.ExtensionDataTransfer1_1,
+	extension.ExtensionDataTransfer1_0,
+}
+
+func FuzzGetTransferData(f *testing.F) {
+	for i, name := range extensionNames {
+		for _, seed := range testutil.EncodeFuzzSeeds(f, extension.ProtocolMap[name]) {
+			f.Add(uint8(i), seed)
+		}
+	}
+	f.Fuzz(func(t *testing.T, nameIndex uint8, data []byte) {
+		name := extensionNames[int(nameIndex)%len(extensionNames)]
+		msg, err := extension.GetTransferData(extended{name: data})
+		if err != nil {
+			return
+		}
+		testutil.ExerciseMessage(t, msg, func(data []byte) (datatransfer.Message, error) {
+			return extension.GetTransferData(extended{name: data})
+		})
+	})
+}
+
+// extended is a set of graphsync extensions, as sent with a request or
+// response
+type extended map[graphsync.ExtensionName][]byte
+
+func (e extended) Extension(name graphsync.ExtensionName) ([]byte, bool) {
+	data, ok := e[name]
+	return data, ok
+}
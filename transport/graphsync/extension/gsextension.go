@@ -11,17 +11,26 @@ import (
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-data-transfer/message"
 	"github.com/filecoin-project/go-data-transfer/message/message1_0"
+	"github.com/filecoin-project/go-data-transfer/message/message1_1"
 )
 
 const (
-	// ExtensionDataTransfer1_1 is the identifier for the current data transfer extension to graphsync
+	// ExtensionDataTransfer1_2 is the identifier for the current data transfer extension to graphsync
+	ExtensionDataTransfer1_2 = graphsync.ExtensionName("fil/data-transfer/1.2")
+	// ExtensionDataTransfer1_1 is the identifier for the 1.1 data transfer extension to graphsync
 	ExtensionDataTransfer1_1 = graphsync.ExtensionName("fil/data-transfer/1.1")
 	// ExtensionDataTransfer1_0 is the identifier for the legacy data transfer extension to graphsync
 	ExtensionDataTransfer1_0 = graphsync.ExtensionName("fil/data-transfer")
+	// ExtensionHaveList1_2 marks a graphsync request that carries part of the
+	// list of blocks the requestor already has for a channel, in its
+	// do-not-send-cids extension, rather than asking for data. Its data is the
+	// channel ID. Peers that speak 1.2 understand it.
+	ExtensionHaveList1_2 = graphsync.ExtensionName("fil/data-transfer/1.2/have-list")
 )
 
 // ProtocolMap maps graphsync extensions to their libp2p protocols
 var ProtocolMap = map[graphsync.ExtensionName]protocol.ID{
+	ExtensionDataTransfer1_2: datatransfer.ProtocolDataTransfer1_2,
 	ExtensionDataTransfer1_1: datatransfer.ProtocolDataTransfer1_1,
 	ExtensionDataTransfer1_0: datatransfer.ProtocolDataTransfer1_0,
 }
@@ -65,22 +74,27 @@ type GsExtended interface {
 //    * nil + error if the extendedData fails to unmarshal
 //    * unmarshaled ExtensionDataTransferData + nil if all goes well
 func GetTransferData(extendedData GsExtended) (datatransfer.Message, error) {
-	extName := ExtensionDataTransfer1_1
-	data, ok := extendedData.Extension(extName)
-	if !ok {
-		extName = ExtensionDataTransfer1_0
-		data, ok = extendedData.Extension(extName)
-		if !ok {
-			return nil, nil
+	// use the newest version of the extension present
+	for _, extName := range extensionsByPreference {
+		data, ok := extendedData.Extension(extName)
+		if ok {
+			reader := bytes.NewReader(data)
+			return decoders[extName](reader)
 		}
 	}
-	reader := bytes.NewReader(data)
-	return decoders[extName](reader)
+	return nil, nil
+}
+
+var extensionsByPreference = []graphsync.ExtensionName{
+	ExtensionDataTransfer1_2,
+	ExtensionDataTransfer1_1,
+	ExtensionDataTransfer1_0,
 }
 
 type decoder func(io.Reader) (datatransfer.Message, error)
 
 var decoders = map[graphsync.ExtensionName]decoder{
-	ExtensionDataTransfer1_1: message.FromNet,
+	ExtensionDataTransfer1_2: message.FromNet,
+	ExtensionDataTransfer1_1: message1_1.FromNet,
 	ExtensionDataTransfer1_0: message1_0.FromNet,
 }
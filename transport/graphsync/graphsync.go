@@ -1,6 +1,7 @@
 package graphsync
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"sync"
@@ -8,8 +9,12 @@ import (
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-graphsync"
 	"github.com/ipfs/go-graphsync/cidset"
+	"github.com/ipfs/go-graphsync/dedupkey"
 	logging "github.com/ipfs/go-log/v2"
 	ipld "github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
 	peer "github.com/libp2p/go-libp2p-core/peer"
 	"golang.org/x/xerrors"
 
@@ -26,11 +31,38 @@ type graphsyncKey struct {
 	p         peer.ID
 }
 
-var defaultSupportedExtensions = []graphsync.ExtensionName{extension.ExtensionDataTransfer1_1, extension.ExtensionDataTransfer1_0}
+var defaultSupportedExtensions = []graphsync.ExtensionName{
+	extension.ExtensionDataTransfer1_2,
+	extension.ExtensionDataTransfer1_1,
+	extension.ExtensionDataTransfer1_0,
+}
+
+// defaultMaxDoNotSendCids keeps the list of cids the other peer should not
+// send well under the libp2p message size limit (about 2.5MiB for typical
+// CIDs)
+const defaultMaxDoNotSendCids = 65536
+
+// haveListSelector only selects the root of the DAG. The data sender pauses a
+// have-list request on the root, once it has taken in the have-list.
+var haveListSelector = builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
 
 // Option is an option for setting up the graphsync transport
 type Option func(*Transport)
 
+// MaxDoNotSendCids sets the most cids that are sent in a single graphsync
+// request when a channel is restarted, as graphsync requests larger than the
+// libp2p message size limit cannot be sent at all.
+//
+// If more cids have been received and the other peer speaks 1.2, the rest are
+// sent ahead of the request in have-list requests of up to as many cids
+// each. Otherwise only the first ones are sent, and the other peer sends the
+// rest again.
+func MaxDoNotSendCids(max int) Option {
+	return func(t *Transport) {
+		t.maxDoNotSendCids = max
+	}
+}
+
 // SupportedExtensions sets what data transfer extensions are supported
 func SupportedExtensions(supportedExtensions []graphsync.ExtensionName) Option {
 	return func(t *Transport) {
@@ -54,6 +86,8 @@ type Transport struct {
 	stores                map[datatransfer.ChannelID]struct{}
 	supportedExtensions   []graphsync.ExtensionName
 	unregisterFuncs       []graphsync.UnregisterHookFunc
+	maxDoNotSendCids      int
+	haveListRequests      map[graphsyncKey]struct{}
 }
 
 // NewTransport makes a new hooks manager with the given hook events interface
@@ -68,7 +102,9 @@ func NewTransport(peerID peer.ID, gs graphsync.GraphExchange, options ...Option)
 		channelIDMap:          make(map[datatransfer.ChannelID]graphsyncKey),
 		pending:               make(map[datatransfer.ChannelID]chan struct{}),
 		stores:                make(map[datatransfer.ChannelID]struct{}),
+		haveListRequests:      make(map[graphsyncKey]struct{}),
 		supportedExtensions:   defaultSupportedExtensions,
+		maxDoNotSendCids:      defaultMaxDoNotSendCids,
 	}
 	for _, option := range options {
 		option(t)
@@ -107,25 +143,168 @@ func (t *Transport) OpenChannel(ctx context.Context,
 	t.contextCancelMap[channelID] = internalCancel
 	t.dataLock.Unlock()
 
-	if len(doNotSendCids) != 0 {
-		set := cid.NewSet()
-		for _, c := range doNotSendCids {
-			set.Add(c)
+	// the cids that don't fit in the request are sent ahead of it in
+	// have-lists, if the other peer takes them
+	var haveList []cid.Cid
+	if len(doNotSendCids) > t.maxDoNotSendCids {
+		if t.supportsHaveLists() {
+			haveList = doNotSendCids[t.maxDoNotSendCids:]
+		} else {
+			log.Warnf("channel %s: %d cids already received, only asking peer not to send the first %d",
+				channelID, len(doNotSendCids), t.maxDoNotSendCids)
 		}
-		bz, err := cidset.EncodeCidSet(set)
+		doNotSendCids = doNotSendCids[:t.maxDoNotSendCids]
+	}
+	if len(doNotSendCids) != 0 {
+		doNotSendExt, err := doNotSendExtension(doNotSendCids)
 		if err != nil {
-			return xerrors.Errorf("failed to encode cid set: %w", err)
+			return err
 		}
-		doNotSendExt := graphsync.ExtensionData{Name: graphsync.ExtensionDoNotSendCIDs,
-			Data: bz}
 		exts = append(exts, doNotSendExt)
 	}
+
+	if len(haveList) != 0 {
+		haveRoot := hasRoot(root, doNotSendCids) || hasRoot(root, haveList)
+		go t.requestWithHaveList(ctx, internalCtx, dataSender, channelID, root, stor, exts, len(doNotSendCids), haveRoot, haveList)
+		return nil
+	}
 	responseChan, errChan := t.gs.Request(internalCtx, dataSender, root, stor, exts...)
 
 	go t.executeGsRequest(ctx, internalCtx, channelID, responseChan, errChan)
 	return nil
 }
 
+// requestWithHaveList sends the have-list ahead of the channel's request, or
+// sends the request with only the do-not-send cids that fit in it if the data
+// sender does not take have-lists
+func (t *Transport) requestWithHaveList(ctx context.Context, internalCtx context.Context, dataSender peer.ID, channelID datatransfer.ChannelID,
+	root ipld.Link, stor ipld.Node, exts []graphsync.ExtensionData, doNotSendCount int, haveRoot bool, haveList []cid.Cid) {
+	haveListCtx, haveListCancel := context.WithCancel(internalCtx)
+	// the have-list requests are only needed until the request is done
+	defer haveListCancel()
+	sent, err := t.sendHaveList(haveListCtx, dataSender, channelID, root, haveRoot, haveList)
+	if err != nil {
+		log.Warnf("channel %s: failed to send have-list: %s", channelID, err)
+	}
+	if internalCtx.Err() != nil {
+		log.Warnf("graphsync request cancelled for channel %s", channelID)
+		return
+	}
+	if sent {
+		// graphsync only skips the have-list's blocks on requests with the
+		// same dedup key
+		dedupExt, err := dedupExtension(channelID)
+		if err != nil {
+			log.Errorf("channel %s: %s", channelID, err)
+			return
+		}
+		exts = append(exts, dedupExt)
+	} else {
+		haveListCancel()
+		log.Warnf("channel %s: peer does not take have-lists, only asking it not to send the first %d of %d cids already received",
+			channelID, doNotSendCount, doNotSendCount+len(haveList))
+	}
+	responseChan, errChan := t.gs.Request(internalCtx, dataSender, root, stor, exts...)
+	t.executeGsRequest(ctx, internalCtx, channelID, responseChan, errChan)
+}
+
+// supportsHaveLists returns true if the transport speaks 1.2, which
+// have-lists are part of
+func (t *Transport) supportsHaveLists() bool {
+	for _, ext := range t.supportedExtensions {
+		if ext == extension.ExtensionDataTransfer1_2 {
+			return true
+		}
+	}
+	return false
+}
+
+// sendHaveList tells the data sender about cids we already have, in have-list
+// requests of up to maxDoNotSendCids cids each. The sender takes in each
+// have-list before pausing the request on the root block, so once the root
+// comes back the sender skips the have-list's blocks on the channel's request.
+// The have-list requests stay open until ctx is cancelled. It returns false if
+// the sender does not take have-lists.
+func (t *Transport) sendHaveList(ctx context.Context, dataSender peer.ID, channelID datatransfer.ChannelID, root ipld.Link, haveRoot bool, haveList []cid.Cid) (bool, error) {
+	buf := new(bytes.Buffer)
+	if err := channelID.MarshalCBOR(buf); err != nil {
+		return false, xerrors.Errorf("failed to encode channel ID: %w", err)
+	}
+	haveListExt := graphsync.ExtensionData{Name: extension.ExtensionHaveList1_2, Data: buf.Bytes()}
+	dedupExt, err := dedupExtension(channelID)
+	if err != nil {
+		return false, err
+	}
+
+	// send one have-list at a time, so that each fits in a message
+	for len(haveList) != 0 {
+		n := len(haveList)
+		if n > t.maxDoNotSendCids {
+			n = t.maxDoNotSendCids
+		}
+		chunk := haveList[:n:n]
+		if haveRoot {
+			// so that the root is not sent on the have-list request either
+			chunk = append(chunk, root.(cidlink.Link).Cid)
+		}
+		doNotSendExt, err := doNotSendExtension(chunk)
+		if err != nil {
+			return false, err
+		}
+		haveList = haveList[n:]
+
+		responseChan, errChan := t.gs.Request(ctx, dataSender, root, haveListSelector, haveListExt, dedupExt, doNotSendExt)
+		select {
+		case <-ctx.Done():
+			go t.consumeResponses(responseChan, errChan)
+			return false, nil
+		case _, ok := <-responseChan:
+			go t.consumeResponses(responseChan, errChan)
+			if !ok {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// hasRoot returns true if the root is among the given cids. The root is
+// almost always the first block received.
+func hasRoot(root ipld.Link, cids []cid.Cid) bool {
+	rootLink, ok := root.(cidlink.Link)
+	if !ok {
+		return false
+	}
+	for _, c := range cids {
+		if c.Equals(rootLink.Cid) {
+			return true
+		}
+	}
+	return false
+}
+
+func doNotSendExtension(cids []cid.Cid) (graphsync.ExtensionData, error) {
+	set := cid.NewSet()
+	for _, c := range cids {
+		set.Add(c)
+	}
+	bz, err := cidset.EncodeCidSet(set)
+	if err != nil {
+		return graphsync.ExtensionData{}, xerrors.Errorf("failed to encode cid set: %w", err)
+	}
+	return graphsync.ExtensionData{Name: graphsync.ExtensionDoNotSendCIDs, Data: bz}, nil
+}
+
+// dedupExtension returns the dedup key extension for a channel's requests. It
+// is the same key graphsync uses when the channel has its own store.
+func dedupExtension(channelID datatransfer.ChannelID) (graphsync.ExtensionData, error) {
+	bz, err := dedupkey.EncodeDedupKey("data-transfer-" + channelID.String())
+	if err != nil {
+		return graphsync.ExtensionData{}, xerrors.Errorf("failed to encode dedup key: %w", err)
+	}
+	return graphsync.ExtensionData{Name: graphsync.ExtensionDeDupByKey, Data: bz}, nil
+}
+
 func (t *Transport) consumeResponses(responseChan <-chan graphsync.ResponseProgress, errChan <-chan error) error {
 	var lastError error
 	for range responseChan {
@@ -140,6 +319,12 @@ func (t *Transport) executeGsRequest(ctx context.Context, internalCtx context.Co
 	lastError := t.consumeResponses(responseChan, errChan)
 
 	if _, ok := lastError.(graphsync.RequestContextCancelledErr); ok {
+		// closing or reopening the channel, or shutting down the transport,
+		// cancels the request without the request timing out
+		if internalCtx.Err() != nil && ctx.Err() == nil {
+			log.Warnf("graphsync request cancelled for channel %s", channelID)
+			return
+		}
 		log.Warnf("graphsync request context cancelled, channel Id: %v", channelID)
 		if err := t.events.OnRequestTimedOut(ctx, channelID); err != nil {
 			log.Error(err)
@@ -272,11 +457,12 @@ func (t *Transport) CloseChannel(ctx context.Context, chid datatransfer.ChannelI
 		cancelFn()
 		return nil
 	}
-	t.dataLock.Lock()
-	if _, ok := t.requestorCancelledMap[chid]; ok {
+	t.dataLock.RLock()
+	_, requestorCancelled := t.requestorCancelledMap[chid]
+	t.dataLock.RUnlock()
+	if requestorCancelled {
 		return nil
 	}
-	t.dataLock.Unlock()
 	return t.gs.CancelResponse(gsKey.p, gsKey.requestID)
 }
 
@@ -340,7 +526,42 @@ func (t *Transport) UseStore(channelID datatransfer.ChannelID, loader ipld.Loade
 	return nil
 }
 
+// UseStoreOption returns a channel transport option that tells the graphsync
+// transport to use the given loader and storer for the channel
+func UseStoreOption(loader ipld.Loader, storer ipld.Storer) datatransfer.TransportOption {
+	return func(chid datatransfer.ChannelID, transport datatransfer.Transport) error {
+		// see through wrappers, such as fault injection, to the transport
+		for {
+			wrapper, ok := transport.(interface{ Unwrap() datatransfer.Transport })
+			if !ok {
+				break
+			}
+			transport = wrapper.Unwrap()
+		}
+		gsTransport, ok := transport.(*Transport)
+		if !ok {
+			return datatransfer.ErrUnsupported
+		}
+		return gsTransport.UseStore(chid, loader, storer)
+	}
+}
+
 func (t *Transport) gsOutgoingRequestHook(p peer.ID, request graphsync.RequestData, hookActions graphsync.OutgoingRequestHookActions) {
+	if data, ok := request.Extension(extension.ExtensionHaveList1_2); ok {
+		var chid datatransfer.ChannelID
+		if err := chid.UnmarshalCBOR(bytes.NewReader(data)); err != nil {
+			return
+		}
+		// load the root the have-list request selects from the channel's store
+		t.dataLock.RLock()
+		_, ok := t.stores[chid]
+		t.dataLock.RUnlock()
+		if ok {
+			hookActions.UsePersistenceOption("data-transfer-" + chid.String())
+		}
+		return
+	}
+
 	message, _ := extension.GetTransferData(request)
 
 	// extension not found; probably not our request.
@@ -420,6 +641,16 @@ func (t *Transport) gsBlockSentHook(p peer.ID, request graphsync.RequestData, bl
 }
 
 func (t *Transport) gsOutgoingBlockHook(p peer.ID, request graphsync.RequestData, block graphsync.BlockData, hookActions graphsync.OutgoingBlockHookActions) {
+	t.dataLock.RLock()
+	_, isHaveList := t.haveListRequests[graphsyncKey{request.ID(), p}]
+	t.dataLock.RUnlock()
+	if isHaveList {
+		// the have-list has been taken in by now, so hold on to it until the
+		// requestor cancels the request
+		hookActions.PauseResponse()
+		return
+	}
+
 	// When a data transfer is restarted, the requester sends a list of CIDs
 	// that it already has. Graphsync calls the outgoing block hook for all
 	// blocks even if they are in the list (meaning, they aren't actually going
@@ -470,6 +701,9 @@ func (t *Transport) gsReqRecdHook(p peer.ID, request graphsync.RequestData, hook
 
 	// extension not found; probably not our request.
 	if msg == nil {
+		if data, ok := request.Extension(extension.ExtensionHaveList1_2); ok {
+			t.haveListRequestHook(p, request, data, hookActions)
+		}
 		return
 	}
 
@@ -498,7 +732,9 @@ func (t *Transport) gsReqRecdHook(p peer.ID, request graphsync.RequestData, hook
 		}
 	}
 
-	if err != nil && err != datatransfer.ErrPause {
+	// a request resumed after the requestor paused it is a new graphsync
+	// request, which is not paused, so ErrResume needs no action
+	if err != nil && err != datatransfer.ErrPause && err != datatransfer.ErrResume {
 		hookActions.TerminateWithError(err)
 		return
 	}
@@ -527,12 +763,42 @@ func (t *Transport) gsReqRecdHook(p peer.ID, request graphsync.RequestData, hook
 	hookActions.ValidateRequest()
 }
 
+// haveListRequestHook accepts a have-list request for a channel with the
+// requestor. Graphsync skips the blocks in its do-not-send-cids extension on
+// the channel's request for as long as the have-list request is open.
+func (t *Transport) haveListRequestHook(p peer.ID, request graphsync.RequestData, data []byte, hookActions graphsync.IncomingRequestHookActions) {
+	// left unvalidated, graphsync rejects the request, and the requestor
+	// falls back to a single do-not-send list
+	if !t.supportsHaveLists() {
+		return
+	}
+	var chid datatransfer.ChannelID
+	if err := chid.UnmarshalCBOR(bytes.NewReader(data)); err != nil {
+		hookActions.TerminateWithError(xerrors.Errorf("failed to decode have-list channel ID: %w", err))
+		return
+	}
+	if !(chid.Initiator == p && chid.Responder == t.peerID) && !(chid.Responder == p && chid.Initiator == t.peerID) {
+		hookActions.TerminateWithError(errors.New("have-list for a channel between other peers"))
+		return
+	}
+
+	t.dataLock.Lock()
+	t.haveListRequests[graphsyncKey{request.ID(), p}] = struct{}{}
+	_, ok := t.stores[chid]
+	if ok {
+		hookActions.UsePersistenceOption("data-transfer-" + chid.String())
+	}
+	t.dataLock.Unlock()
+	hookActions.ValidateRequest()
+}
+
 // gsCompletedResponseListener is a graphsync.OnCompletedResponseListener. We use it learn when the data transfer is complete
 // for the side that is responding to a graphsync request
 func (t *Transport) gsCompletedResponseListener(p peer.ID, request graphsync.RequestData, status graphsync.ResponseStatusCode) {
-	t.dataLock.RLock()
+	t.dataLock.Lock()
+	delete(t.haveListRequests, graphsyncKey{request.ID(), p})
 	chid, ok := t.graphsyncRequestMap[graphsyncKey{request.ID(), p}]
-	t.dataLock.RUnlock()
+	t.dataLock.Unlock()
 
 	if !ok {
 		return
@@ -618,10 +884,14 @@ func (t *Transport) gsRequestUpdatedHook(p peer.ID, request graphsync.RequestDat
 		}
 	}
 
-	if err != nil && err != datatransfer.ErrPause {
+	if err != nil && err != datatransfer.ErrPause && err != datatransfer.ErrResume {
 		hookActions.TerminateWithError(err)
+		return
 	}
 
+	if err == datatransfer.ErrResume {
+		hookActions.UnpauseResponse()
+	}
 }
 
 // gsIncomingResponseHook is a graphsync.OnIncomingResponseHook. We use it to pass on responses
@@ -689,6 +959,8 @@ func (t *Transport) gsRequestorCancelledListener(p peer.ID, request graphsync.Re
 	t.dataLock.Lock()
 	defer t.dataLock.Unlock()
 
+	delete(t.haveListRequests, graphsyncKey{request.ID(), p})
+
 	chid, ok := t.graphsyncRequestMap[graphsyncKey{request.ID(), p}]
 	if ok {
 		t.requestorCancelledMap[chid] = struct{}{}
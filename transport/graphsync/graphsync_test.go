@@ -9,8 +9,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-graphsync"
 	"github.com/ipfs/go-graphsync/cidset"
+	"github.com/ipfs/go-graphsync/dedupkey"
 	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	peer "github.com/libp2p/go-libp2p-core/peer"
@@ -237,7 +239,7 @@ func TestManager(t *testing.T) {
 				require.Equal(t, 1, events.OnRequestReceivedCallCount)
 				require.Equal(t, 0, events.OnResponseReceivedCallCount)
 				require.Equal(t, events.RequestReceivedChannelID, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
-				dtRequestData, _ := gsData.request.Extension(extension.ExtensionDataTransfer1_1)
+				dtRequestData, _ := gsData.request.Extension(extension.ExtensionDataTransfer1_2)
 				assertDecodesToMessage(t, dtRequestData, events.RequestReceivedRequest)
 				require.True(t, gsData.incomingRequestHookActions.Validated)
 				assertHasOutgoingMessage(t, gsData.incomingRequestHookActions.SentExtensions, events.RequestReceivedResponse)
@@ -255,7 +257,7 @@ func TestManager(t *testing.T) {
 				require.Equal(t, 0, events.OnRequestReceivedCallCount)
 				require.Equal(t, 1, events.OnResponseReceivedCallCount)
 				require.Equal(t, events.ResponseReceivedChannelID, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self})
-				dtResponseData, _ := gsData.request.Extension(extension.ExtensionDataTransfer1_1)
+				dtResponseData, _ := gsData.request.Extension(extension.ExtensionDataTransfer1_2)
 				assertDecodesToMessage(t, dtResponseData, events.ResponseReceivedResponse)
 				require.True(t, gsData.incomingRequestHookActions.Validated)
 				require.NoError(t, gsData.incomingRequestHookActions.TerminationError)
@@ -286,7 +288,7 @@ func TestManager(t *testing.T) {
 				require.Equal(t, 1, events.OnRequestReceivedCallCount)
 				require.Equal(t, 0, events.OnResponseReceivedCallCount)
 				require.Equal(t, events.RequestReceivedChannelID, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
-				dtRequestData, _ := gsData.request.Extension(extension.ExtensionDataTransfer1_1)
+				dtRequestData, _ := gsData.request.Extension(extension.ExtensionDataTransfer1_2)
 				assertDecodesToMessage(t, dtRequestData, events.RequestReceivedRequest)
 				require.False(t, gsData.incomingRequestHookActions.Validated)
 				assertHasOutgoingMessage(t, gsData.incomingRequestHookActions.SentExtensions, events.RequestReceivedResponse)
@@ -653,8 +655,8 @@ func TestManager(t *testing.T) {
 				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
 
 				ext := requestReceived.Extensions
-				require.Len(t, ext, 3)
-				doNotSend := ext[2]
+				require.Len(t, ext, 4)
+				doNotSend := ext[3]
 
 				name := doNotSend.Name
 				require.Equal(t, graphsync.ExtensionDoNotSendCIDs, name)
@@ -1106,7 +1108,7 @@ func (dtc *dtConfig) extensions(t *testing.T, transferID datatransfer.TransferID
 	extensions := make(map[graphsync.ExtensionName][]byte)
 	if !dtc.dtExtensionMissing {
 		if dtc.dtExtensionMalformed {
-			extensions[extension.ExtensionDataTransfer1_1] = testutil.RandomBytes(100)
+			extensions[extension.ExtensionDataTransfer1_2] = testutil.RandomBytes(100)
 		} else {
 			var msg datatransfer.Message
 			if dtc.dtIsResponse {
@@ -1117,7 +1119,7 @@ func (dtc *dtConfig) extensions(t *testing.T, transferID datatransfer.TransferID
 			buf := new(bytes.Buffer)
 			err := msg.ToNet(buf)
 			require.NoError(t, err)
-			extensions[extension.ExtensionDataTransfer1_1] = buf.Bytes()
+			extensions[extension.ExtensionDataTransfer1_2] = buf.Bytes()
 		}
 	}
 	return extensions
@@ -1168,8 +1170,339 @@ func assertHasOutgoingMessage(t *testing.T, extensions []graphsync.ExtensionData
 	err := expected.ToNet(buf)
 	require.NoError(t, err)
 	expectedExt := graphsync.ExtensionData{
-		Name: extension.ExtensionDataTransfer1_1,
+		Name: extension.ExtensionDataTransfer1_2,
 		Data: buf.Bytes(),
 	}
 	require.Contains(t, extensions, expectedExt)
 }
+
+func TestOpenChannelSendsHaveList(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(2)
+	transferID := datatransfer.TransferID(rand.Uint64())
+	chid := datatransfer.ChannelID{ID: transferID, Responder: peers[1], Initiator: peers[0]}
+	fgs := testutil.NewFakeGraphSync()
+	fgs.LeaveRequestsOpen()
+	outgoing := testutil.NewDTRequest(t, transferID)
+	transport := NewTransport(peers[0], fgs, MaxDoNotSendCids(3))
+	events := &fakeEvents{}
+	require.NoError(t, transport.SetEventHandler(events))
+
+	// the root is received first
+	root := outgoing.BaseCid()
+	cids := append([]cid.Cid{root}, testutil.GenerateCids(7)...)
+	stor, _ := outgoing.Selector()
+	err := transport.OpenChannel(ctx, peers[1], chid, cidlink.Link{Cid: root}, stor, cids, outgoing)
+	require.NoError(t, err)
+
+	// the cids that don't fit in the request go ahead of it in have-lists,
+	// each sent once the previous one is taken in. They all include the
+	// root, which the have-list requests select.
+	haveList1 := fgs.AssertRequestReceived(ctx, t)
+	assertHaveList(t, chid, haveList1, append([]cid.Cid{root}, cids[3:6]...))
+	haveList1.ResponseChan <- graphsync.ResponseProgress{}
+	haveList2 := fgs.AssertRequestReceived(ctx, t)
+	assertHaveList(t, chid, haveList2, append([]cid.Cid{root}, cids[6:]...))
+	haveList2.ResponseChan <- graphsync.ResponseProgress{}
+
+	request := fgs.AssertRequestReceived(ctx, t)
+	require.NotNil(t, request.DTMessage(t))
+	assertDoNotSendCids(t, request.Extensions, cids[:3])
+	assertHasDedupKey(t, request.Extensions, chid)
+	require.NoError(t, haveList1.Ctx.Err())
+	require.NoError(t, haveList2.Ctx.Err())
+
+	// the have-lists are cancelled once the request is done
+	close(request.ResponseChan)
+	close(request.ResponseErrChan)
+	require.Eventually(t, func() bool {
+		return haveList1.Ctx.Err() != nil && haveList2.Ctx.Err() != nil
+	}, 2*time.Second, 100*time.Millisecond)
+}
+
+func TestOpenChannelWithoutHaveList(t *testing.T) {
+	testCases := map[string]struct {
+		supportedExtensions []graphsync.ExtensionName
+		expectHaveList      bool
+	}{
+		"peer does not take have-lists": {
+			expectHaveList: true,
+		},
+		"transport does not speak 1.2": {
+			supportedExtensions: []graphsync.ExtensionName{extension.ExtensionDataTransfer1_1, extension.ExtensionDataTransfer1_0},
+		},
+	}
+	for testCase, data := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			peers := testutil.GeneratePeers(2)
+			transferID := datatransfer.TransferID(rand.Uint64())
+			chid := datatransfer.ChannelID{ID: transferID, Responder: peers[1], Initiator: peers[0]}
+			// the fake graphsync closes requests without responding, like a
+			// peer that rejects have-lists
+			fgs := testutil.NewFakeGraphSync()
+			outgoing := testutil.NewDTRequest(t, transferID)
+			options := []Option{MaxDoNotSendCids(3)}
+			if data.supportedExtensions != nil {
+				options = append(options, SupportedExtensions(data.supportedExtensions))
+			}
+			transport := NewTransport(peers[0], fgs, options...)
+			require.NoError(t, transport.SetEventHandler(&fakeEvents{}))
+
+			cids := testutil.GenerateCids(5)
+			stor, _ := outgoing.Selector()
+			err := transport.OpenChannel(ctx, peers[1], chid, cidlink.Link{Cid: outgoing.BaseCid()}, stor, cids, outgoing)
+			require.NoError(t, err)
+
+			if data.expectHaveList {
+				haveList := fgs.AssertRequestReceived(ctx, t)
+				assertHaveList(t, chid, haveList, cids[3:])
+			}
+
+			// only the cids that fit in the request are sent
+			request := fgs.AssertRequestReceived(ctx, t)
+			assertDoNotSendCids(t, request.Extensions, cids[:3])
+			for _, ext := range request.Extensions {
+				require.NotEqual(t, extension.ExtensionHaveList1_2, ext.Name)
+				require.NotEqual(t, graphsync.ExtensionDeDupByKey, ext.Name)
+			}
+		})
+	}
+}
+
+func TestHaveListRequestHook(t *testing.T) {
+	peers := testutil.GeneratePeers(3)
+	transferID := datatransfer.TransferID(rand.Uint64())
+	testCases := map[string]struct {
+		supportedExtensions []graphsync.ExtensionName
+		chid                datatransfer.ChannelID
+		expectValidated     bool
+		expectTerminated    bool
+	}{
+		"accepts have-list for pull channel": {
+			chid:            datatransfer.ChannelID{ID: transferID, Initiator: peers[1], Responder: peers[0]},
+			expectValidated: true,
+		},
+		"accepts have-list for push channel": {
+			chid:            datatransfer.ChannelID{ID: transferID, Initiator: peers[0], Responder: peers[1]},
+			expectValidated: true,
+		},
+		"rejects have-list for channel with another peer": {
+			chid:             datatransfer.ChannelID{ID: transferID, Initiator: peers[2], Responder: peers[0]},
+			expectTerminated: true,
+		},
+		"leaves have-list unvalidated when transport does not speak 1.2": {
+			supportedExtensions: []graphsync.ExtensionName{extension.ExtensionDataTransfer1_1, extension.ExtensionDataTransfer1_0},
+			chid:                datatransfer.ChannelID{ID: transferID, Initiator: peers[1], Responder: peers[0]},
+		},
+	}
+	for testCase, data := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			fgs := testutil.NewFakeGraphSync()
+			var options []Option
+			if data.supportedExtensions != nil {
+				options = append(options, SupportedExtensions(data.supportedExtensions))
+			}
+			transport := NewTransport(peers[0], fgs, options...)
+			events := &fakeEvents{}
+			require.NoError(t, transport.SetEventHandler(events))
+
+			buf := new(bytes.Buffer)
+			require.NoError(t, data.chid.MarshalCBOR(buf))
+			request := testutil.NewFakeRequest(graphsync.RequestID(rand.Int31()), map[graphsync.ExtensionName][]byte{
+				extension.ExtensionHaveList1_2: buf.Bytes(),
+			})
+			requestActions := &testutil.FakeIncomingRequestHookActions{}
+			fgs.IncomingRequestHook(peers[1], request, requestActions)
+			require.Equal(t, data.expectValidated, requestActions.Validated)
+			require.Equal(t, data.expectTerminated, requestActions.TerminationError != nil)
+			require.Zero(t, events.OnRequestReceivedCallCount)
+			require.Zero(t, events.OnResponseReceivedCallCount)
+			if !data.expectValidated {
+				return
+			}
+
+			// the have-list is held on to, paused on its first block
+			blockActions := &testutil.FakeOutgoingBlockHookActions{}
+			fgs.OutgoingBlockHook(peers[1], request, testutil.NewFakeBlockData(), blockActions)
+			require.True(t, blockActions.Paused)
+			require.False(t, events.OnDataQueuedCalled)
+
+			// until the requestor cancels it
+			fgs.RequestorCancelledListener(peers[1], request)
+			blockActions = &testutil.FakeOutgoingBlockHookActions{}
+			fgs.OutgoingBlockHook(peers[1], request, testutil.NewFakeBlockData(), blockActions)
+			require.False(t, blockActions.Paused)
+		})
+	}
+}
+
+func assertHaveList(t *testing.T, chid datatransfer.ChannelID, request testutil.ReceivedGraphSyncRequest, cids []cid.Cid) {
+	var haveListChid datatransfer.ChannelID
+	var found bool
+	for _, ext := range request.Extensions {
+		if ext.Name == extension.ExtensionHaveList1_2 {
+			require.NoError(t, haveListChid.UnmarshalCBOR(bytes.NewReader(ext.Data)))
+			found = true
+		}
+	}
+	require.True(t, found, "request is not a have-list")
+	require.Equal(t, chid, haveListChid)
+	assertDoNotSendCids(t, request.Extensions, cids)
+	assertHasDedupKey(t, request.Extensions, chid)
+}
+
+func assertDoNotSendCids(t *testing.T, extensions []graphsync.ExtensionData, cids []cid.Cid) {
+	var doNotSend []byte
+	for _, ext := range extensions {
+		if ext.Name == graphsync.ExtensionDoNotSendCIDs {
+			doNotSend = ext.Data
+		}
+	}
+	require.NotNil(t, doNotSend)
+	cs, err := cidset.DecodeCidSet(doNotSend)
+	require.NoError(t, err)
+	require.Equal(t, len(cids), cs.Len())
+	for _, c := range cids {
+		require.True(t, cs.Has(c))
+	}
+}
+
+func assertHasDedupKey(t *testing.T, extensions []graphsync.ExtensionData, chid datatransfer.ChannelID) {
+	expected, err := dedupkey.EncodeDedupKey("data-transfer-" + chid.String())
+	require.NoError(t, err)
+	require.Contains(t, extensions, graphsync.ExtensionData{Name: graphsync.ExtensionDeDupByKey, Data: expected})
+}
+
+func TestErrResumeUnpausesResponse(t *testing.T) {
+	testCases := map[string]struct {
+		requestReceivedErrors []error
+		update                bool
+		expectRequestPaused   bool
+		expectUpdateUnpaused  bool
+	}{
+		"request for a channel the requestor resumed is validated": {
+			requestReceivedErrors: []error{datatransfer.ErrResume},
+		},
+		"update resuming a paused response unpauses it": {
+			requestReceivedErrors: []error{datatransfer.ErrPause, datatransfer.ErrResume},
+			update:                true,
+			expectRequestPaused:   true,
+			expectUpdateUnpaused:  true,
+		},
+	}
+	for testCase, data := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			peers := testutil.GeneratePeers(2)
+			transferID := datatransfer.TransferID(rand.Uint64())
+			requestID := graphsync.RequestID(rand.Int31())
+			fgs := testutil.NewFakeGraphSync()
+			transport := NewTransport(peers[0], fgs)
+			events := &fakeEvents{OnRequestReceivedErrors: data.requestReceivedErrors}
+			require.NoError(t, transport.SetEventHandler(events))
+
+			requestConfig := gsRequestConfig{}
+			request := requestConfig.makeRequest(t, transferID, requestID)
+			requestActions := &testutil.FakeIncomingRequestHookActions{}
+			fgs.IncomingRequestHook(peers[1], request, requestActions)
+			require.NoError(t, requestActions.TerminationError)
+			require.True(t, requestActions.Validated)
+			require.Equal(t, data.expectRequestPaused, requestActions.Paused)
+			if !data.update {
+				return
+			}
+
+			updateActions := &testutil.FakeRequestUpdatedActions{}
+			fgs.RequestUpdatedHook(peers[1], request, requestConfig.makeRequest(t, transferID, requestID), updateActions)
+			require.Equal(t, 2, events.OnRequestReceivedCallCount)
+			require.NoError(t, updateActions.TerminationError)
+			require.Equal(t, data.expectUpdateUnpaused, updateActions.Unpaused)
+		})
+	}
+}
+
+func TestInternalCancelDoesNotTimeOut(t *testing.T) {
+	testCases := map[string]func(ctx context.Context, t *testing.T, transport *Transport, chid datatransfer.ChannelID, open func()){
+		"closing the channel": func(ctx context.Context, t *testing.T, transport *Transport, chid datatransfer.ChannelID, open func()) {
+			require.NoError(t, transport.CloseChannel(ctx, chid))
+		},
+		"reopening the channel": func(ctx context.Context, t *testing.T, transport *Transport, chid datatransfer.ChannelID, open func()) {
+			open()
+		},
+		"shutting down the transport": func(ctx context.Context, t *testing.T, transport *Transport, chid datatransfer.ChannelID, open func()) {
+			require.NoError(t, transport.Shutdown(ctx))
+		},
+	}
+	for testCase, cancelRequest := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			peers := testutil.GeneratePeers(2)
+			transferID := datatransfer.TransferID(rand.Uint64())
+			chid := datatransfer.ChannelID{ID: transferID, Initiator: peers[0], Responder: peers[1]}
+			fgs := testutil.NewFakeGraphSync()
+			fgs.LeaveRequestsOpen()
+			transport := NewTransport(peers[0], fgs)
+			events := &fakeEvents{}
+			require.NoError(t, transport.SetEventHandler(events))
+
+			request := testutil.NewDTRequest(t, transferID)
+			stor, _ := request.Selector()
+			open := func() {
+				require.NoError(t, transport.OpenChannel(ctx, peers[1], chid, cidlink.Link{Cid: request.BaseCid()}, stor, nil, request))
+			}
+			open()
+			requestReceived := fgs.AssertRequestReceived(ctx, t)
+			requestConfig := gsRequestConfig{}
+			fgs.OutgoingRequestHook(peers[1], requestConfig.makeRequest(t, transferID, graphsync.RequestID(rand.Int31())), &testutil.FakeOutgoingRequestHookActions{})
+
+			cancelRequest(ctx, t, transport, chid, open)
+			require.Eventually(t, func() bool {
+				return requestReceived.Ctx.Err() != nil
+			}, 2*time.Second, 10*time.Millisecond)
+
+			// graphsync reports the request's context was cancelled, which
+			// is not a time out when the transport cancelled it
+			close(requestReceived.ResponseChan)
+			requestReceived.ResponseErrChan <- graphsync.RequestContextCancelledErr{}
+			close(requestReceived.ResponseErrChan)
+			require.Never(t, func() bool {
+				return events.OnRequestTimedOutCalled
+			}, 200*time.Millisecond, 10*time.Millisecond)
+			require.False(t, events.OnChannelCompletedCalled)
+		})
+	}
+}
+
+func TestCloseChannelCancelledByRequestorReleasesLock(t *testing.T) {
+	peers := testutil.GeneratePeers(2)
+	transferID := datatransfer.TransferID(rand.Uint64())
+	chid := datatransfer.ChannelID{ID: transferID, Initiator: peers[1], Responder: peers[0]}
+	fgs := testutil.NewFakeGraphSync()
+	transport := NewTransport(peers[0], fgs)
+	require.NoError(t, transport.SetEventHandler(&fakeEvents{}))
+
+	requestConfig := gsRequestConfig{}
+	request := requestConfig.makeRequest(t, transferID, graphsync.RequestID(rand.Int31()))
+	fgs.IncomingRequestHook(peers[1], request, &testutil.FakeIncomingRequestHookActions{})
+	fgs.RequestorCancelledListener(peers[1], request)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, transport.CloseChannel(ctx, chid))
+	fgs.AssertNoCancelResponseReceived(t)
+
+	// the transport can still be used after closing the channel
+	cleanedUp := make(chan struct{})
+	go func() {
+		transport.CleanupChannel(chid)
+		close(cleanedUp)
+	}()
+	select {
+	case <-cleanedUp:
+	case <-time.After(time.Second):
+		t.Fatal("transport still locked after closing channel")
+	}
+}
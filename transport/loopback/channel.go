@@ -0,0 +1,425 @@
+package loopback
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-graphsync/ipldutil"
+	ipld "github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+)
+
+// maxInFlight is how many blocks the sender sends ahead of the receiver
+const maxInFlight = 16
+
+// side is one end of a channel
+type side int
+
+const (
+	senderSide side = iota
+	receiverSide
+)
+
+func (s side) other() side {
+	return 1 - s
+}
+
+// block is a block sent to the receiver
+type block struct {
+	link ipld.Link
+	data []byte
+}
+
+// channel moves the blocks under a root from the sender's transport to the
+// receiver's. The sender traverses the DAG on one goroutine, and the receiver
+// stores the blocks it is sent on another, so that each end calls its events
+// handler in order. Messages from one end to the other are handled by the
+// other end's goroutine.
+type channel struct {
+	chid      datatransfer.ChannelID
+	parentCtx context.Context
+	ctx       context.Context
+	cancel    context.CancelFunc
+	sender    *Transport
+	receiver  *Transport
+	root      ipld.Link
+	selector  ipld.Node
+	doNotSend *cid.Set
+	msg       datatransfer.Message
+	loader    ipld.Loader
+	storer    ipld.Storer
+
+	lk sync.Mutex
+	// changed is closed and replaced whenever the state below changes
+	changed chan struct{}
+	paused  [2]bool
+	inbox   [2][]datatransfer.Message
+	wire    []block
+	// sent is set when the sender stops sending, with the error it stopped
+	// with, if any
+	sent    bool
+	sendErr error
+	// closed is set when the channel is stopped or completes
+	closed bool
+}
+
+func newChannel(ctx context.Context, chid datatransfer.ChannelID, sender, receiver *Transport,
+	root ipld.Link, selector ipld.Node, doNotSend *cid.Set, msg datatransfer.Message) *channel {
+	channelCtx, cancel := context.WithCancel(ctx)
+	return &channel{
+		chid:      chid,
+		parentCtx: ctx,
+		ctx:       channelCtx,
+		cancel:    cancel,
+		sender:    sender,
+		receiver:  receiver,
+		root:      root,
+		selector:  selector,
+		doNotSend: doNotSend,
+		msg:       msg,
+		changed:   make(chan struct{}),
+	}
+}
+
+func (c *channel) sideOf(t *Transport) side {
+	if t == c.sender {
+		return senderSide
+	}
+	return receiverSide
+}
+
+func (c *channel) events(s side) datatransfer.EventsHandler {
+	if s == senderSide {
+		return c.sender.events
+	}
+	return c.receiver.events
+}
+
+// notify wakes the goroutines waiting for the channel to change. The lock
+// must be held.
+func (c *channel) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// wait waits for the channel to change after changed was read
+func (c *channel) wait(changed chan struct{}) error {
+	select {
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	case <-changed:
+		return nil
+	}
+}
+
+func (c *channel) setPaused(s side, paused bool) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.paused[s] = paused
+	c.notify()
+}
+
+// resume resumes one end, sending a message, if any, to the other end
+func (c *channel) resume(s side, msg datatransfer.Message) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.paused[s] = false
+	if msg != nil {
+		c.inbox[s.other()] = append(c.inbox[s.other()], msg)
+	}
+	c.notify()
+}
+
+func (c *channel) post(to side, msg datatransfer.Message) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.inbox[to] = append(c.inbox[to], msg)
+	c.notify()
+}
+
+// stop stops the channel without completing it
+func (c *channel) stop() {
+	c.lk.Lock()
+	c.closed = true
+	c.lk.Unlock()
+	c.cancel()
+}
+
+// disconnect stops the channel, if it is still running, and tells both ends
+// the request disconnected
+func (c *channel) disconnect() {
+	c.lk.Lock()
+	if c.closed {
+		c.lk.Unlock()
+		return
+	}
+	c.closed = true
+	c.lk.Unlock()
+	c.cancel()
+
+	log.Warnf("channel %s disconnected", c.chid)
+	for _, s := range []side{receiverSide, senderSide} {
+		if err := c.events(s).OnRequestDisconnected(context.Background(), c.chid); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// deliver calls the handler at one end for a message from the other end,
+// sending the reply, if any, back. Pausing and resuming act on the end the
+// message is delivered to.
+func (c *channel) deliver(to side, msg datatransfer.Message) error {
+	events := c.events(to)
+	var err error
+	if msg.IsRequest() {
+		request, ok := msg.(datatransfer.Request)
+		if !ok {
+			return nil
+		}
+		var response datatransfer.Response
+		response, err = events.OnRequestReceived(c.chid, request)
+		if response != nil {
+			c.post(to.other(), response)
+		}
+	} else {
+		response, ok := msg.(datatransfer.Response)
+		if !ok {
+			return nil
+		}
+		err = events.OnResponseReceived(c.chid, response)
+	}
+	switch err {
+	case nil:
+		return nil
+	case datatransfer.ErrPause:
+		c.setPaused(to, true)
+		return nil
+	case datatransfer.ErrResume:
+		c.setPaused(to, false)
+		return nil
+	default:
+		return err
+	}
+}
+
+// deliverInbox delivers the messages waiting for one end
+func (c *channel) deliverInbox(to side) error {
+	c.lk.Lock()
+	msgs := c.inbox[to]
+	c.inbox[to] = nil
+	c.lk.Unlock()
+	for _, msg := range msgs {
+		if c.ctx.Err() != nil {
+			return c.ctx.Err()
+		}
+		if err := c.deliver(to, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run opens the channel on the receiver and receives data until the channel
+// completes or stops
+func (c *channel) run() {
+	if c.ctx.Err() != nil {
+		return
+	}
+	if err := c.receiver.events.OnChannelOpened(c.chid); err != nil {
+		log.Warnf("channel %s: not receiving data: %s", c.chid, err)
+		c.stop()
+		return
+	}
+	go c.send()
+
+	err := c.receive()
+	c.lk.Lock()
+	closed := c.closed
+	c.closed = true
+	c.lk.Unlock()
+	defer c.cancel()
+
+	if c.ctx.Err() != nil {
+		if !closed && c.parentCtx.Err() != nil {
+			log.Warnf("channel %s: request context cancelled", c.chid)
+			if err := c.receiver.events.OnRequestTimedOut(c.parentCtx, c.chid); err != nil {
+				log.Error(err)
+			}
+		}
+		return
+	}
+	if err != nil {
+		err = xerrors.Errorf("loopback channel failed to complete: %w", err)
+	}
+	if err := c.receiver.events.OnChannelCompleted(c.chid, err); err != nil {
+		log.Error(err)
+	}
+}
+
+// receive stores the blocks the sender sends, and handles the messages it
+// sends, until the sender is done and every block is stored
+func (c *channel) receive() error {
+	for {
+		if err := c.deliverInbox(receiverSide); err != nil {
+			return err
+		}
+		c.lk.Lock()
+		if len(c.inbox[receiverSide]) > 0 {
+			c.lk.Unlock()
+			continue
+		}
+		if !c.paused[receiverSide] && len(c.wire) > 0 {
+			b := c.wire[0]
+			c.wire = c.wire[1:]
+			c.notify()
+			c.lk.Unlock()
+			if err := c.receiveBlock(b); err != nil {
+				return err
+			}
+			continue
+		}
+		if !c.paused[receiverSide] && c.sent {
+			err := c.sendErr
+			c.lk.Unlock()
+			return err
+		}
+		changed := c.changed
+		c.lk.Unlock()
+		if err := c.wait(changed); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *channel) receiveBlock(b block) error {
+	if c.ctx.Err() != nil {
+		return c.ctx.Err()
+	}
+	w, commit, err := c.storer(ipld.LinkContext{})
+	if err != nil {
+		return xerrors.Errorf("storing block %s: %w", b.link, err)
+	}
+	if _, err := w.Write(b.data); err != nil {
+		return xerrors.Errorf("storing block %s: %w", b.link, err)
+	}
+	if err := commit(b.link); err != nil {
+		return xerrors.Errorf("storing block %s: %w", b.link, err)
+	}
+	if c.ctx.Err() != nil {
+		return c.ctx.Err()
+	}
+	err = c.receiver.events.OnDataReceived(c.chid, b.link, uint64(len(b.data)))
+	if err == datatransfer.ErrPause {
+		c.setPaused(receiverSide, true)
+		return nil
+	}
+	return err
+}
+
+// send delivers the message opening the channel to the sender and, if it
+// accepts, sends the blocks the selector selects under the root
+func (c *channel) send() {
+	if err := c.deliver(senderSide, c.msg); err != nil {
+		c.finishSending(xerrors.Errorf("data sender rejected channel: %w", err))
+		return
+	}
+	c.loader = c.sender.storeFor(c.chid).loader
+
+	err := c.traverse()
+	if c.ctx.Err() != nil {
+		return
+	}
+	if err := c.sender.events.OnChannelCompleted(c.chid, err); err != nil {
+		log.Error(err)
+	}
+	c.finishSending(err)
+}
+
+func (c *channel) finishSending(err error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.sent = true
+	c.sendErr = err
+	c.notify()
+}
+
+func (c *channel) traverse() error {
+	sel, err := ipldutil.ParseSelector(c.selector)
+	if err != nil {
+		return xerrors.Errorf("parsing selector: %w", err)
+	}
+	return ipldutil.Traverse(c.ctx, c.load, nil, c.root, sel, func(traversal.Progress, ipld.Node, traversal.VisitReason) error {
+		return nil
+	})
+}
+
+// load loads a block for the traversal, sending it to the receiver unless
+// the receiver asked not to be sent it
+func (c *channel) load(lnk ipld.Link, lnkCtx ipld.LinkContext) (io.Reader, error) {
+	if err := c.waitToSend(); err != nil {
+		return nil, err
+	}
+	r, err := c.loader(lnk, lnkCtx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if cl, ok := lnk.(cidlink.Link); ok && c.doNotSend.Has(cl.Cid) {
+		return bytes.NewReader(data), nil
+	}
+
+	size := uint64(len(data))
+	msg, err := c.sender.events.OnDataQueued(c.chid, lnk, size)
+	if msg != nil {
+		c.post(receiverSide, msg)
+	}
+	if err == datatransfer.ErrPause {
+		c.setPaused(senderSide, true)
+	} else if err != nil {
+		return nil, err
+	}
+
+	c.lk.Lock()
+	c.wire = append(c.wire, block{lnk, data})
+	c.notify()
+	c.lk.Unlock()
+	if err := c.sender.events.OnDataSent(c.chid, lnk, size); err != nil {
+		log.Error(err)
+	}
+	return bytes.NewReader(data), nil
+}
+
+// waitToSend handles the messages sent to the sender, and waits until the
+// sender is not paused and the receiver is not too far behind
+func (c *channel) waitToSend() error {
+	for {
+		if err := c.deliverInbox(senderSide); err != nil {
+			return err
+		}
+		c.lk.Lock()
+		pending := len(c.inbox[senderSide]) > 0
+		if !pending && !c.paused[senderSide] && len(c.wire) < maxInFlight {
+			c.lk.Unlock()
+			return nil
+		}
+		changed := c.changed
+		c.lk.Unlock()
+		if pending {
+			continue
+		}
+		if err := c.wait(changed); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package loopback_test
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-graphsync/storeutil"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/dttest"
+	"github.com/filecoin-project/go-data-transfer/impl"
+	"github.com/filecoin-project/go-data-transfer/testutil"
+	"github.com/filecoin-project/go-data-transfer/transport/loopback"
+	"github.com/filecoin-project/go-data-transfer/transport/transporttest"
+)
+
+func TestConformance(t *testing.T) {
+	transporttest.Run(t, func(ctx context.Context, t *testing.T) (transporttest.Peer, transporttest.Peer) {
+		router := loopback.NewRouter()
+		peers := testutil.GeneratePeers(2)
+		return newPeer(peers[0], router), newPeer(peers[1], router)
+	})
+}
+
+func newPeer(p peer.ID, router *loopback.Router) transporttest.Peer {
+	bs := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	return transporttest.Peer{
+		ID:         p,
+		Transport:  loopback.NewTransport(p, router, storeutil.LoaderForBlockstore(bs), storeutil.StorerForBlockstore(bs)),
+		DAGService: merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs))),
+	}
+}
+
+func TestManagers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := dttest.New(ctx, t, 2, dttest.Loopback(loopback.NewRouter()), dttest.PreloadDAGs(1, 256<<10))
+	sender, receiver := h.Nodes[0], h.Nodes[1]
+
+	t.Run("push", func(t *testing.T) {
+		chid := h.Push(t, sender, receiver, sender.DAGs[0].Root)
+		receiver.WaitForStatus(t, chid, datatransfer.Completed)
+		sender.WaitForStatus(t, chid, datatransfer.Completed)
+		receiver.RequireDAG(t, sender.DAGs[0])
+		receiver.Events.RequireSequence(t, chid, datatransfer.Open, datatransfer.Accept, datatransfer.DataReceived, datatransfer.CleanupComplete)
+		sender.Events.RequireSequence(t, chid, datatransfer.Open, datatransfer.Accept, datatransfer.DataQueued, datatransfer.DataSent, datatransfer.CleanupComplete)
+	})
+
+	t.Run("pull", func(t *testing.T) {
+		dag := receiver.RandomDAG(t, 256<<10)
+		chid := h.Pull(t, sender, receiver, dag.Root)
+		sender.WaitForStatus(t, chid, datatransfer.Completed)
+		sender.RequireDAG(t, dag)
+		sender.Events.RequireSequence(t, chid, datatransfer.Open, datatransfer.Accept, datatransfer.DataReceived, datatransfer.CleanupComplete)
+		sender.Events.RequireNoEvent(t, chid, datatransfer.Error)
+	})
+}
+
+func TestRestartOnReconnect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	router := loopback.NewRouter()
+	h := dttest.New(ctx, t, 2, dttest.Loopback(router), dttest.PreloadDAGs(1, 256<<10),
+		dttest.ManagerOptions(impl.RestartOnReconnect(10*time.Millisecond, 100*time.Millisecond, 5)))
+	sender, receiver := h.Nodes[0], h.Nodes[1]
+
+	// the receiver stalls storing a block until the peers have disconnected
+	stalled := make(chan struct{})
+	release := make(chan struct{})
+	var stall sync.Once
+	var blocks int32
+	storer := func(lnkCtx ipld.LinkContext) (io.Writer, ipld.StoreCommitter, error) {
+		if atomic.AddInt32(&blocks, 1) == 4 {
+			stall.Do(func() {
+				close(stalled)
+				<-release
+			})
+		}
+		return receiver.Storer(lnkCtx)
+	}
+	chid := h.Pull(t, receiver, sender, sender.DAGs[0].Root,
+		datatransfer.WithTransportOption(loopback.UseStoreOption(receiver.Loader, storer)))
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("receiver did not store any blocks")
+	case <-stalled:
+	}
+	router.Disconnect(receiver.Peer, sender.Peer)
+	receiver.Events.WaitForEvent(t, chid, datatransfer.Disconnected)
+	close(release)
+
+	router.Connect(receiver.Peer, sender.Peer)
+	receiver.WaitForStatus(t, chid, datatransfer.Completed)
+	receiver.RequireDAG(t, sender.DAGs[0])
+	receiver.Events.RequireSequence(t, chid, datatransfer.Open, datatransfer.Disconnected, datatransfer.Restart, datatransfer.CleanupComplete)
+}
+
+func TestNetwork(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	router := loopback.NewRouter()
+	peers := testutil.GeneratePeers(3)
+	net1 := loopback.NewNetwork(peers[0], router)
+	net2 := loopback.NewNetwork(peers[1], router)
+	receiver := newReceiver()
+	net2.SetDelegate(receiver)
+
+	require.Equal(t, peers[0], net1.ID())
+	require.NoError(t, net1.ConnectTo(ctx, peers[1]))
+	require.Error(t, net1.ConnectTo(ctx, peers[2]), "peer not on the router")
+	require.Error(t, net1.SendMessage(ctx, peers[2], testutil.NewDTRequest(t, 1)))
+
+	// messages are received in the order they are sent
+	for id := datatransfer.TransferID(1); id <= 10; id++ {
+		require.NoError(t, net1.SendMessage(ctx, peers[1], testutil.NewDTRequest(t, id)))
+	}
+	for id := datatransfer.TransferID(1); id <= 10; id++ {
+		select {
+		case <-ctx.Done():
+			t.Fatal("message not received")
+		case msg := <-receiver.messages:
+			require.Equal(t, id, msg.TransferID())
+		}
+	}
+
+	router.Disconnect(peers[0], peers[1])
+	err := net1.SendMessage(ctx, peers[1], testutil.NewDTRequest(t, 11))
+	require.Equal(t, datatransfer.ErrorDisconnected, datatransfer.ErrorCodeFor(err))
+	require.Error(t, net1.ConnectTo(ctx, peers[1]))
+
+	// reconnecting tells the receiver only about peers it protects
+	net2.Protect(peers[0], "channel")
+	router.Connect(peers[0], peers[1])
+	select {
+	case <-ctx.Done():
+		t.Fatal("receiver not told the peer connected")
+	case p := <-receiver.connected:
+		require.Equal(t, peers[0], p)
+	}
+	require.False(t, net2.Unprotect(peers[0], "channel"))
+	router.Disconnect(peers[0], peers[1])
+	router.Connect(peers[0], peers[1])
+	require.NoError(t, net1.SendMessage(ctx, peers[1], testutil.NewDTRequest(t, 12)))
+	select {
+	case <-ctx.Done():
+		t.Fatal("message not received")
+	case msg := <-receiver.messages:
+		require.Equal(t, datatransfer.TransferID(12), msg.TransferID())
+	}
+	require.Empty(t, receiver.connected)
+}
+
+type receiver struct {
+	messages  chan datatransfer.Message
+	connected chan peer.ID
+}
+
+func newReceiver() *receiver {
+	return &receiver{
+		messages:  make(chan datatransfer.Message, 16),
+		connected: make(chan peer.ID, 16),
+	}
+}
+
+func (r *receiver) ReceiveRequest(ctx context.Context, sender peer.ID, incoming datatransfer.Request) {
+	r.messages <- incoming
+}
+
+func (r *receiver) ReceiveResponse(ctx context.Context, sender peer.ID, incoming datatransfer.Response) {
+	r.messages <- incoming
+}
+
+func (r *receiver) ReceiveRestartExistingChannelRequest(ctx context.Context, sender peer.ID, incoming datatransfer.Request) {
+	r.messages <- incoming
+}
+
+func (r *receiver) ReceiveError(err error) {
+}
+
+func (r *receiver) ReceivePeerConnected(p peer.ID) {
+	r.connected <- p
+}
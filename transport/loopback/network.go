@@ -0,0 +1,168 @@
+package loopback
+
+import (
+	"context"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/network"
+)
+
+// delivery is a message waiting to be delivered to a network's receiver
+type delivery struct {
+	from peer.ID
+	msg  datatransfer.Message
+}
+
+// Network is a data transfer network that delivers messages to the networks
+// of other peers on the same router
+type Network struct {
+	id     peer.ID
+	router *Router
+
+	lk        sync.Mutex
+	receiver  network.Receiver
+	protected map[peer.ID]map[string]struct{}
+	queue     []delivery
+	draining  bool
+}
+
+var _ network.DataTransferNetwork = (*Network)(nil)
+
+// NewNetwork returns a network for the given peer, and adds it to the router
+func NewNetwork(id peer.ID, router *Router) *Network {
+	n := &Network{
+		id:        id,
+		router:    router,
+		protected: make(map[peer.ID]map[string]struct{}),
+	}
+	router.addNetwork(n)
+	return n
+}
+
+// SendMessage queues a message for the other peer's receiver. Messages to a
+// peer are received in the order they are sent, but after SendMessage
+// returns.
+func (n *Network) SendMessage(ctx context.Context, p peer.ID, msg datatransfer.Message) error {
+	to, err := n.router.network(n.id, p)
+	if err != nil {
+		return err
+	}
+	return to.enqueue(n.id, msg)
+}
+
+func (n *Network) enqueue(from peer.ID, msg datatransfer.Message) error {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+	if n.receiver == nil {
+		return xerrors.Errorf("peer %s is not receiving data transfer messages", n.id)
+	}
+	n.queue = append(n.queue, delivery{from, msg})
+	if !n.draining {
+		n.draining = true
+		go n.drain()
+	}
+	return nil
+}
+
+// drain delivers queued messages to the receiver until there are none left
+func (n *Network) drain() {
+	for {
+		n.lk.Lock()
+		if len(n.queue) == 0 {
+			n.draining = false
+			n.lk.Unlock()
+			return
+		}
+		d := n.queue[0]
+		n.queue = n.queue[1:]
+		receiver := n.receiver
+		n.lk.Unlock()
+
+		// messages in flight when the peers disconnect are lost
+		if !n.router.connected(d.from, n.id) {
+			log.Debugf("dropping message from disconnected peer %s", d.from)
+			continue
+		}
+		ctx := context.Background()
+		if d.msg.IsRequest() {
+			request, ok := d.msg.(datatransfer.Request)
+			if !ok {
+				continue
+			}
+			if request.IsRestartExistingChannelRequest() {
+				receiver.ReceiveRestartExistingChannelRequest(ctx, d.from, request)
+			} else {
+				receiver.ReceiveRequest(ctx, d.from, request)
+			}
+			continue
+		}
+		response, ok := d.msg.(datatransfer.Response)
+		if ok {
+			receiver.ReceiveResponse(ctx, d.from, response)
+		}
+	}
+}
+
+// SetDelegate sets the receiver for messages sent to this peer
+func (n *Network) SetDelegate(r network.Receiver) {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+	n.receiver = r
+}
+
+// ConnectTo checks the peer is on the router and connected to this one
+func (n *Network) ConnectTo(ctx context.Context, p peer.ID) error {
+	_, err := n.router.network(n.id, p)
+	return err
+}
+
+// ID returns the peer ID of this network
+func (n *Network) ID() peer.ID {
+	return n.id
+}
+
+// Protect tags a peer as having data transfer channels with this one
+func (n *Network) Protect(id peer.ID, tag string) {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+	tags, ok := n.protected[id]
+	if !ok {
+		tags = make(map[string]struct{})
+		n.protected[id] = tags
+	}
+	tags[tag] = struct{}{}
+}
+
+// Unprotect removes a tag from a peer, returning whether the peer is still
+// protected by other tags
+func (n *Network) Unprotect(id peer.ID, tag string) bool {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+	tags, ok := n.protected[id]
+	if !ok {
+		return false
+	}
+	delete(tags, tag)
+	if len(tags) == 0 {
+		delete(n.protected, id)
+		return false
+	}
+	return true
+}
+
+// peerConnected tells the receiver a peer connected, if it is protected and
+// the receiver wants to know
+func (n *Network) peerConnected(p peer.ID) {
+	n.lk.Lock()
+	_, isProtected := n.protected[p]
+	receiver, ok := n.receiver.(network.PeerConnectedReceiver)
+	n.lk.Unlock()
+	if !isProtected || !ok {
+		return
+	}
+	go receiver.ReceivePeerConnected(p)
+}
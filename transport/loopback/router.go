@@ -0,0 +1,146 @@
+// Package loopback moves data transfer messages and blocks between data
+// transfer managers in the same process, without libp2p.
+//
+// Each manager gets a Network and a Transport registered under its peer ID on
+// a shared Router. Messages sent on a Network are delivered to the other
+// peer's network in the order they were sent, and channels opened on a
+// Transport traverse the sender's blocks and store them with the receiver's
+// storer directly, calling both transports' events handlers as graphsync
+// would:
+//
+//	router := loopback.NewRouter()
+//	net := loopback.NewNetwork(p, router)
+//	transport := loopback.NewTransport(p, router, loader, storer)
+//	manager, err := impl.NewDataTransfer(ds, dir, net, transport, counter)
+//
+// Channels can be paused, resumed and restarted as with graphsync. The router
+// can disconnect two peers, stopping the channels between them as a network
+// failure would, and connect them again, so that managers restart channels
+// on reconnect.
+package loopback
+
+import (
+	"sync"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+)
+
+var log = logging.Logger("dt_loopback")
+
+// link is the connection between two peers, ordered so that either peer can
+// look it up
+type link struct {
+	a, b peer.ID
+}
+
+func linkBetween(a, b peer.ID) link {
+	if b < a {
+		a, b = b, a
+	}
+	return link{a, b}
+}
+
+// Router connects the networks and transports of peers in the same process.
+// Every pair of peers on a router is connected until Disconnect is called.
+type Router struct {
+	lk           sync.Mutex
+	networks     map[peer.ID]*Network
+	transports   map[peer.ID]*Transport
+	disconnected map[link]struct{}
+}
+
+// NewRouter returns a router with no peers on it
+func NewRouter() *Router {
+	return &Router{
+		networks:     make(map[peer.ID]*Network),
+		transports:   make(map[peer.ID]*Transport),
+		disconnected: make(map[link]struct{}),
+	}
+}
+
+// Disconnect disconnects two peers. Messages between them are not
+// delivered and cannot be sent, channels between them cannot be opened, and
+// channels that are open between them stop, telling both events handlers
+// that the request disconnected.
+func (r *Router) Disconnect(a, b peer.ID) {
+	r.lk.Lock()
+	r.disconnected[linkBetween(a, b)] = struct{}{}
+	transport := r.transports[a]
+	r.lk.Unlock()
+
+	if transport != nil {
+		transport.disconnect(b)
+	}
+}
+
+// Connect connects two peers that were disconnected, telling each network's
+// receiver the other peer connected if it has protected channels with it
+func (r *Router) Connect(a, b peer.ID) {
+	r.lk.Lock()
+	l := linkBetween(a, b)
+	_, wasDisconnected := r.disconnected[l]
+	delete(r.disconnected, l)
+	na, nb := r.networks[a], r.networks[b]
+	r.lk.Unlock()
+
+	if !wasDisconnected {
+		return
+	}
+	if na != nil {
+		na.peerConnected(b)
+	}
+	if nb != nil {
+		nb.peerConnected(a)
+	}
+}
+
+func (r *Router) connected(a, b peer.ID) bool {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	_, disconnected := r.disconnected[linkBetween(a, b)]
+	return !disconnected
+}
+
+func (r *Router) addNetwork(n *Network) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.networks[n.id] = n
+}
+
+func (r *Router) addTransport(t *Transport) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.transports[t.id] = t
+}
+
+// network returns the network of a peer that can be reached from another
+func (r *Router) network(from, to peer.ID) (*Network, error) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	n, ok := r.networks[to]
+	if !ok {
+		return nil, xerrors.Errorf("peer %s has no loopback network", to)
+	}
+	if _, disconnected := r.disconnected[linkBetween(from, to)]; disconnected {
+		return nil, xerrors.Errorf("peer %s: %w", to, datatransfer.ErrDisconnected)
+	}
+	return n, nil
+}
+
+// transport returns the transport of a peer that can be reached from another
+func (r *Router) transport(from, to peer.ID) (*Transport, error) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	t, ok := r.transports[to]
+	if !ok {
+		return nil, xerrors.Errorf("peer %s has no loopback transport", to)
+	}
+	if _, disconnected := r.disconnected[linkBetween(from, to)]; disconnected {
+		return nil, xerrors.Errorf("peer %s: %w", to, datatransfer.ErrDisconnected)
+	}
+	return t, nil
+}
@@ -0,0 +1,268 @@
+package loopback
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+)
+
+// store is the loader and storer a channel uses instead of the transport's
+type store struct {
+	loader ipld.Loader
+	storer ipld.Storer
+}
+
+// Transport is a data transfer transport that moves blocks directly between
+// the transports of peers on the same router. It loads the blocks it sends
+// with its loader and stores the blocks it receives with its storer, unless
+// a channel is given its own with UseStore.
+type Transport struct {
+	id     peer.ID
+	router *Router
+	loader ipld.Loader
+	storer ipld.Storer
+	events datatransfer.EventsHandler
+
+	lk       sync.RWMutex
+	channels map[datatransfer.ChannelID]*channel
+	stores   map[datatransfer.ChannelID]store
+	shutdown bool
+}
+
+var _ datatransfer.PauseableTransport = (*Transport)(nil)
+
+// NewTransport returns a transport for the given peer, and adds it to the
+// router
+func NewTransport(id peer.ID, router *Router, loader ipld.Loader, storer ipld.Storer) *Transport {
+	t := &Transport{
+		id:       id,
+		router:   router,
+		loader:   loader,
+		storer:   storer,
+		channels: make(map[datatransfer.ChannelID]*channel),
+		stores:   make(map[datatransfer.ChannelID]store),
+	}
+	router.addTransport(t)
+	return t
+}
+
+// OpenChannel opens a channel for the other peer to send data to us on. If
+// the channel is already open, it is stopped and opened again, as when it is
+// restarted.
+func (t *Transport) OpenChannel(ctx context.Context,
+	dataSender peer.ID,
+	channelID datatransfer.ChannelID,
+	root ipld.Link,
+	stor ipld.Node,
+	doNotSendCids []cid.Cid,
+	msg datatransfer.Message) error {
+	if t.events == nil {
+		return datatransfer.ErrHandlerNotSet
+	}
+	if dataSender == t.id {
+		return xerrors.Errorf("cannot open channel %s to own peer", channelID)
+	}
+	sender, err := t.router.transport(t.id, dataSender)
+	if err != nil {
+		return err
+	}
+	if sender.events == nil {
+		return xerrors.Errorf("peer %s: %w", dataSender, datatransfer.ErrHandlerNotSet)
+	}
+
+	doNotSend := cid.NewSet()
+	for _, c := range doNotSendCids {
+		doNotSend.Add(c)
+	}
+	c := newChannel(ctx, channelID, sender, t, root, stor, doNotSend, msg)
+	c.storer = t.storeFor(channelID).storer
+
+	t.lk.Lock()
+	if t.shutdown {
+		t.lk.Unlock()
+		return xerrors.Errorf("cannot open channel %s: transport shut down", channelID)
+	}
+	// if the channel is already open, stop it first
+	existing := t.channels[channelID]
+	t.channels[channelID] = c
+	t.lk.Unlock()
+	if existing != nil {
+		existing.stop()
+	}
+
+	if err := sender.addChannel(c); err != nil {
+		t.lk.Lock()
+		if t.channels[channelID] == c {
+			delete(t.channels, channelID)
+		}
+		t.lk.Unlock()
+		return err
+	}
+
+	go c.run()
+	return nil
+}
+
+// addChannel adds a channel this transport sends data on, replacing any
+// channel with the same ID
+func (t *Transport) addChannel(c *channel) error {
+	t.lk.Lock()
+	if t.shutdown {
+		t.lk.Unlock()
+		return xerrors.Errorf("peer %s: transport shut down", t.id)
+	}
+	existing := t.channels[c.chid]
+	t.channels[c.chid] = c
+	t.lk.Unlock()
+	if existing != nil {
+		existing.stop()
+	}
+	return nil
+}
+
+func (t *Transport) channel(chid datatransfer.ChannelID) (*channel, error) {
+	t.lk.RLock()
+	defer t.lk.RUnlock()
+	c, ok := t.channels[chid]
+	if !ok {
+		return nil, datatransfer.ErrChannelNotFound
+	}
+	return c, nil
+}
+
+// PauseChannel pauses sending or receiving data on the given channel
+func (t *Transport) PauseChannel(ctx context.Context, chid datatransfer.ChannelID) error {
+	if t.events == nil {
+		return datatransfer.ErrHandlerNotSet
+	}
+	c, err := t.channel(chid)
+	if err != nil {
+		return err
+	}
+	c.setPaused(c.sideOf(t), true)
+	return nil
+}
+
+// ResumeChannel resumes sending or receiving data on the given channel,
+// sending the message, if any, to the other peer
+func (t *Transport) ResumeChannel(ctx context.Context, msg datatransfer.Message, chid datatransfer.ChannelID) error {
+	if t.events == nil {
+		return datatransfer.ErrHandlerNotSet
+	}
+	c, err := t.channel(chid)
+	if err != nil {
+		return err
+	}
+	c.resume(c.sideOf(t), msg)
+	return nil
+}
+
+// CloseChannel stops the given channel, without completing it
+func (t *Transport) CloseChannel(ctx context.Context, chid datatransfer.ChannelID) error {
+	if t.events == nil {
+		return datatransfer.ErrHandlerNotSet
+	}
+	c, err := t.channel(chid)
+	if err != nil {
+		return err
+	}
+	c.stop()
+	return nil
+}
+
+// CleanupChannel stops the given channel and forgets it
+func (t *Transport) CleanupChannel(chid datatransfer.ChannelID) {
+	t.lk.Lock()
+	c, ok := t.channels[chid]
+	delete(t.channels, chid)
+	delete(t.stores, chid)
+	t.lk.Unlock()
+	if ok {
+		c.stop()
+	}
+}
+
+// SetEventHandler sets the handler for events on channels
+func (t *Transport) SetEventHandler(events datatransfer.EventsHandler) error {
+	if t.events != nil {
+		return datatransfer.ErrHandlerAlreadySet
+	}
+	t.events = events
+	return nil
+}
+
+// Shutdown stops every channel on the transport. Channels cannot be opened
+// to or from the transport once it is shut down.
+func (t *Transport) Shutdown(ctx context.Context) error {
+	t.lk.Lock()
+	t.shutdown = true
+	channels := make([]*channel, 0, len(t.channels))
+	for _, c := range t.channels {
+		channels = append(channels, c)
+	}
+	t.lk.Unlock()
+	for _, c := range channels {
+		c.stop()
+	}
+	return nil
+}
+
+// disconnect stops the channels between this transport and a peer, telling
+// both ends they disconnected
+func (t *Transport) disconnect(p peer.ID) {
+	t.lk.RLock()
+	var channels []*channel
+	for _, c := range t.channels {
+		if c.sender.id == p || c.receiver.id == p {
+			channels = append(channels, c)
+		}
+	}
+	t.lk.RUnlock()
+	for _, c := range channels {
+		c.disconnect()
+	}
+}
+
+// UseStore tells the transport to use the given loader and storer for this
+// channel
+func (t *Transport) UseStore(channelID datatransfer.ChannelID, loader ipld.Loader, storer ipld.Storer) error {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	t.stores[channelID] = store{loader, storer}
+	return nil
+}
+
+func (t *Transport) storeFor(chid datatransfer.ChannelID) store {
+	t.lk.RLock()
+	defer t.lk.RUnlock()
+	if s, ok := t.stores[chid]; ok {
+		return s
+	}
+	return store{t.loader, t.storer}
+}
+
+// UseStoreOption returns a channel transport option that tells the loopback
+// transport to use the given loader and storer for the channel
+func UseStoreOption(loader ipld.Loader, storer ipld.Storer) datatransfer.TransportOption {
+	return func(chid datatransfer.ChannelID, transport datatransfer.Transport) error {
+		// see through wrappers, such as fault injection, to the transport
+		for {
+			wrapper, ok := transport.(interface{ Unwrap() datatransfer.Transport })
+			if !ok {
+				break
+			}
+			transport = wrapper.Unwrap()
+		}
+		loopbackTransport, ok := transport.(*Transport)
+		if !ok {
+			return datatransfer.ErrUnsupported
+		}
+		return loopbackTransport.UseStore(chid, loader, storer)
+	}
+}
@@ -0,0 +1,239 @@
+package transporttest
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+)
+
+// names of the EventsHandler callbacks, as recorded
+const (
+	channelOpened       = "OnChannelOpened"
+	responseReceived    = "OnResponseReceived"
+	dataReceived        = "OnDataReceived"
+	dataQueued          = "OnDataQueued"
+	dataSent            = "OnDataSent"
+	requestReceived     = "OnRequestReceived"
+	channelCompleted    = "OnChannelCompleted"
+	requestTimedOut     = "OnRequestTimedOut"
+	requestDisconnected = "OnRequestDisconnected"
+)
+
+// event is a call a transport made to its events handler
+type event struct {
+	name string
+	chid datatransfer.ChannelID
+	link ipld.Link
+	msg  datatransfer.Message
+	err  error
+}
+
+// recorder is an events handler that records every call made to it, and
+// returns what the test sets up. The hooks must be set before the recorder is
+// given to a transport.
+type recorder struct {
+	ctx context.Context
+
+	// requestReceived returns the reply to a request
+	requestReceived func(request datatransfer.Request) (datatransfer.Response, error)
+	// responseReceived returns the error for a response
+	responseReceived func(response datatransfer.Response) error
+	// dataQueued returns the message and error for the nth block queued
+	dataQueued func(n int) (datatransfer.Message, error)
+	// dataReceived returns the error for the nth block received
+	dataReceived func(n int) error
+
+	lk     sync.Mutex
+	events []event
+	// changed is closed and replaced whenever an event is recorded
+	changed chan struct{}
+}
+
+func newRecorder(ctx context.Context) *recorder {
+	return &recorder{ctx: ctx, changed: make(chan struct{})}
+}
+
+// record records an event, returning how many events with its name have now
+// been recorded
+func (r *recorder) record(e event) int {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.events = append(r.events, e)
+	close(r.changed)
+	r.changed = make(chan struct{})
+	n := 0
+	for _, recorded := range r.events {
+		if recorded.name == e.name {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *recorder) OnChannelOpened(chid datatransfer.ChannelID) error {
+	r.record(event{name: channelOpened, chid: chid})
+	return nil
+}
+
+func (r *recorder) OnResponseReceived(chid datatransfer.ChannelID, msg datatransfer.Response) error {
+	r.record(event{name: responseReceived, chid: chid, msg: msg})
+	if r.responseReceived != nil {
+		return r.responseReceived(msg)
+	}
+	return nil
+}
+
+func (r *recorder) OnDataReceived(chid datatransfer.ChannelID, link ipld.Link, size uint64) error {
+	n := r.record(event{name: dataReceived, chid: chid, link: link})
+	if r.dataReceived != nil {
+		return r.dataReceived(n)
+	}
+	return nil
+}
+
+func (r *recorder) OnDataQueued(chid datatransfer.ChannelID, link ipld.Link, size uint64) (datatransfer.Message, error) {
+	n := r.record(event{name: dataQueued, chid: chid, link: link})
+	if r.dataQueued != nil {
+		return r.dataQueued(n)
+	}
+	return nil, nil
+}
+
+func (r *recorder) OnDataSent(chid datatransfer.ChannelID, link ipld.Link, size uint64) error {
+	r.record(event{name: dataSent, chid: chid, link: link})
+	return nil
+}
+
+func (r *recorder) OnRequestReceived(chid datatransfer.ChannelID, msg datatransfer.Request) (datatransfer.Response, error) {
+	r.record(event{name: requestReceived, chid: chid, msg: msg})
+	if r.requestReceived != nil {
+		return r.requestReceived(msg)
+	}
+	return nil, nil
+}
+
+func (r *recorder) OnChannelCompleted(chid datatransfer.ChannelID, err error) error {
+	r.record(event{name: channelCompleted, chid: chid, err: err})
+	return nil
+}
+
+func (r *recorder) OnRequestTimedOut(ctx context.Context, chid datatransfer.ChannelID) error {
+	r.record(event{name: requestTimedOut, chid: chid})
+	return nil
+}
+
+func (r *recorder) OnRequestDisconnected(ctx context.Context, chid datatransfer.ChannelID) error {
+	r.record(event{name: requestDisconnected, chid: chid})
+	return nil
+}
+
+// all returns the events recorded so far
+func (r *recorder) all() []event {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return append([]event(nil), r.events...)
+}
+
+// withName returns the events with the given name recorded so far
+func (r *recorder) withName(name string) []event {
+	var named []event
+	for _, e := range r.all() {
+		if e.name == name {
+			named = append(named, e)
+		}
+	}
+	return named
+}
+
+// waitFor waits until an event with the given name matches, returning the
+// first one that does, and fails the test if the test context ends first.
+// A nil match matches any event with the name.
+func (r *recorder) waitFor(t *testing.T, name string, match func(event) bool) event {
+	checked := 0
+	for {
+		r.lk.Lock()
+		events := r.events
+		changed := r.changed
+		r.lk.Unlock()
+		for ; checked < len(events); checked++ {
+			e := events[checked]
+			if e.name == name && (match == nil || match(e)) {
+				return e
+			}
+		}
+		select {
+		case <-r.ctx.Done():
+			require.FailNowf(t, "event did not happen", "waiting for %s, events so far: %s", name, formatEvents(r.all()))
+		case <-changed:
+		}
+	}
+}
+
+// waitForCount waits until n events with the given name have been recorded
+func (r *recorder) waitForCount(t *testing.T, name string, n int) {
+	seen := 0
+	r.waitFor(t, name, func(event) bool {
+		seen++
+		return seen == n
+	})
+}
+
+// requireNoneFor requires that no event recorded for the given time matches
+func (r *recorder) requireNoneFor(t *testing.T, wait time.Duration, match func(event) bool) {
+	checked := len(r.all())
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		r.lk.Lock()
+		events := r.events
+		changed := r.changed
+		r.lk.Unlock()
+		for ; checked < len(events); checked++ {
+			require.Falsef(t, match(events[checked]), "unexpected %s, events so far: %s", events[checked].name, formatEvents(events))
+		}
+		select {
+		case <-timer.C:
+			return
+		case <-r.ctx.Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+// named matches events with any of the given names
+func named(names ...string) func(event) bool {
+	return func(e event) bool {
+		for _, name := range names {
+			if e.name == name {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// anyEvent matches every event
+func anyEvent(event) bool {
+	return true
+}
+
+// succeeded matches a channel completing without an error
+func succeeded(e event) bool {
+	return e.name == channelCompleted && e.err == nil
+}
+
+func formatEvents(events []event) string {
+	names := make([]string, 0, len(events))
+	for _, e := range events {
+		names = append(names, e.name)
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
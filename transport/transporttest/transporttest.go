@@ -0,0 +1,566 @@
+// Package transporttest is a conformance suite for implementations of
+// datatransfer.Transport.
+//
+// Run transfers a DAG between two peers made by a Factory, with an events
+// handler on each transport that records the calls made to it, and checks
+// that:
+//
+//   - the receiver, which always opens the channel, is told the channel is
+//     opened first, then of the sender's response (for a pull) and each block
+//     received, and last that the channel completed
+//   - the sender is told of the request (for a pull) or response (for a push)
+//     first, then of each block queued and, after it is queued, sent, and last
+//     that the channel completed
+//   - returning ErrPause from OnRequestReceived, OnDataQueued or
+//     OnDataReceived pauses the channel until ResumeChannel is called, and
+//     returning ErrResume for an update request resumes it, if the transport
+//     is also a datatransfer.PauseableTransport
+//   - the sender does not send the cids the receiver asks it not to
+//   - closing a channel from either end stops it, cleaning up a channel
+//     forgets it, and shutting a transport down stops its channels
+//
+// A transport's conformance test runs the suite with a factory for it:
+//
+//	func TestConformance(t *testing.T) {
+//		transporttest.Run(t, func(ctx context.Context, t *testing.T) (transporttest.Peer, transporttest.Peer) {
+//			...
+//			return sender, receiver
+//		})
+//	}
+//
+// Tests that need a channel to stay paused are skipped for transports that
+// cannot pause channels.
+package transporttest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/dttest"
+	"github.com/filecoin-project/go-data-transfer/message"
+	"github.com/filecoin-project/go-data-transfer/testutil"
+)
+
+const (
+	// dagSize is the size of the file transferred in each test: 64 blocks
+	// under a root
+	dagSize = 64 << 10
+	// pauseAt is the block a test pauses a channel at
+	pauseAt = 3
+	// quietPeriod is how long a test waits to check something does not happen
+	quietPeriod = 250 * time.Millisecond
+	// testTimeout bounds how long each test waits for events
+	testTimeout = 20 * time.Second
+	// transferID is the ID of the channel in each test
+	transferID = datatransfer.TransferID(1)
+)
+
+var errRejected = errors.New("request rejected by test")
+
+// Peer is one end of a transfer
+type Peer struct {
+	ID peer.ID
+	// Transport is the peer's transport, without an events handler set
+	Transport datatransfer.Transport
+	// DAGService is the store the transport sends data from and stores the
+	// data it receives in
+	DAGService ipldformat.DAGService
+}
+
+// Factory makes the two peers for a test, able to open channels to each
+// other. The context ends when the test does.
+type Factory func(ctx context.Context, t *testing.T) (sender Peer, receiver Peer)
+
+// Run runs the conformance suite against transports made by the factory
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, h *harness)
+	}{
+		{"HandlerRequired", testHandlerRequired},
+		{"PullCallbackOrder", testPullCallbackOrder},
+		{"PushCallbackOrder", testPushCallbackOrder},
+		{"RejectedRequest", testRejectedRequest},
+		{"PauseOnRequestReceived", testPauseOnRequestReceived},
+		{"PauseOnDataQueued", testPauseOnDataQueued},
+		{"PauseOnDataReceived", testPauseOnDataReceived},
+		{"ResumeOnUpdate", testResumeOnUpdate},
+		{"DoNotSendCids", testDoNotSendCids},
+		{"CleanupChannel", testCleanupChannel},
+		{"ReceiverClosesChannel", testReceiverClosesChannel},
+		{"SenderClosesChannel", testSenderClosesChannel},
+		{"Shutdown", testShutdown},
+		{"ShutdownMidTransfer", testShutdownMidTransfer},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+			defer cancel()
+			test.run(t, newHarness(ctx, t, factory))
+		})
+	}
+}
+
+func testHandlerRequired(t *testing.T, h *harness) {
+	chid := datatransfer.ChannelID{Initiator: h.receiver.ID, Responder: h.sender.ID, ID: transferID}
+	err := h.receiver.Transport.OpenChannel(h.ctx, h.sender.ID, chid, h.dag.Root, testutil.AllSelector(), nil, h.request(t))
+	requireIs(t, err, datatransfer.ErrHandlerNotSet)
+	requireIs(t, h.receiver.Transport.CloseChannel(h.ctx, chid), datatransfer.ErrHandlerNotSet)
+	if receiver, ok := h.receiver.Transport.(datatransfer.PauseableTransport); ok {
+		requireIs(t, receiver.PauseChannel(h.ctx, chid), datatransfer.ErrHandlerNotSet)
+		requireIs(t, receiver.ResumeChannel(h.ctx, nil, chid), datatransfer.ErrHandlerNotSet)
+	}
+
+	h.start(t)
+	requireIs(t, h.sender.Transport.SetEventHandler(newRecorder(h.ctx)), datatransfer.ErrHandlerAlreadySet)
+	requireIs(t, h.receiver.Transport.SetEventHandler(newRecorder(h.ctx)), datatransfer.ErrHandlerAlreadySet)
+}
+
+func testPullCallbackOrder(t *testing.T, h *harness) {
+	h.start(t)
+	chid := h.pull(t, nil)
+	h.complete(t)
+
+	h.requireReceiverEvents(t, chid)
+	receiverEvents := h.receiverEvents.all()
+	responses := h.receiverEvents.withName(responseReceived)
+	require.Len(t, responses, 1, "receiver should be told of the sender's response once")
+	require.True(t, responses[0].msg.(datatransfer.Response).Accepted())
+	for _, e := range receiverEvents {
+		if e.name == dataReceived {
+			require.Failf(t, "data received before the response", "events: %s", formatEvents(receiverEvents))
+		}
+		if e.name == responseReceived {
+			break
+		}
+	}
+
+	h.requireSenderEvents(t, chid, requestReceived)
+	request := h.senderEvents.all()[0].msg.(datatransfer.Request)
+	require.True(t, request.IsNew())
+	require.True(t, request.IsPull())
+	require.Equal(t, transferID, request.TransferID())
+}
+
+func testPushCallbackOrder(t *testing.T, h *harness) {
+	h.start(t)
+	chid := h.push(t)
+	h.complete(t)
+
+	h.requireReceiverEvents(t, chid)
+	h.requireSenderEvents(t, chid, responseReceived)
+	response := h.senderEvents.all()[0].msg.(datatransfer.Response)
+	require.True(t, response.Accepted())
+	require.Equal(t, transferID, response.TransferID())
+}
+
+func testRejectedRequest(t *testing.T, h *harness) {
+	rejection := h.response(t, false, false)
+	h.senderEvents.requestReceived = func(request datatransfer.Request) (datatransfer.Response, error) {
+		return rejection, errRejected
+	}
+	h.start(t)
+	h.pull(t, nil)
+
+	completed := h.receiverEvents.waitFor(t, channelCompleted, nil)
+	require.Error(t, completed.err, "a rejected channel should complete with an error")
+	responses := h.receiverEvents.withName(responseReceived)
+	require.Len(t, responses, 1, "receiver should be told of the rejection")
+	require.False(t, responses[0].msg.(datatransfer.Response).Accepted())
+	require.Empty(t, h.receiverEvents.withName(dataReceived))
+
+	h.senderEvents.requireNoneFor(t, quietPeriod, succeeded)
+	require.Empty(t, h.senderEvents.withName(dataQueued))
+	require.Empty(t, h.senderEvents.withName(dataSent))
+}
+
+func testPauseOnRequestReceived(t *testing.T, h *harness) {
+	sender := pauseable(t, h.sender)
+	h.pauseOnNewRequest(t)
+	h.start(t)
+	chid := h.pull(t, nil)
+
+	paused := h.receiverEvents.waitFor(t, responseReceived, nil)
+	require.True(t, paused.msg.IsPaused())
+	h.senderEvents.requireNoneFor(t, quietPeriod, named(dataQueued, dataSent, channelCompleted))
+	require.Empty(t, h.senderEvents.withName(dataQueued))
+	require.Empty(t, h.receiverEvents.withName(dataReceived))
+
+	require.NoError(t, sender.ResumeChannel(h.ctx, message.UpdateResponse(transferID, false), chid))
+	h.complete(t)
+}
+
+func testPauseOnDataQueued(t *testing.T, h *harness) {
+	sender := pauseable(t, h.sender)
+	h.senderEvents.dataQueued = func(n int) (datatransfer.Message, error) {
+		if n == pauseAt {
+			return nil, datatransfer.ErrPause
+		}
+		return nil, nil
+	}
+	h.start(t)
+	chid := h.pull(t, nil)
+
+	h.senderEvents.waitForCount(t, dataQueued, pauseAt)
+	h.senderEvents.requireNoneFor(t, quietPeriod, named(dataQueued, channelCompleted))
+	require.Empty(t, h.receiverEvents.withName(channelCompleted))
+
+	require.NoError(t, sender.ResumeChannel(h.ctx, message.UpdateResponse(transferID, false), chid))
+	h.complete(t)
+}
+
+func testPauseOnDataReceived(t *testing.T, h *harness) {
+	receiver := pauseable(t, h.receiver)
+	h.receiverEvents.dataReceived = func(n int) error {
+		if n == pauseAt {
+			return datatransfer.ErrPause
+		}
+		return nil
+	}
+	h.start(t)
+	chid := h.pull(t, nil)
+
+	h.receiverEvents.waitForCount(t, dataReceived, pauseAt)
+	h.receiverEvents.requireNoneFor(t, quietPeriod, named(channelCompleted))
+
+	require.NoError(t, receiver.ResumeChannel(h.ctx, message.UpdateRequest(transferID, false), chid))
+	h.complete(t)
+}
+
+func testResumeOnUpdate(t *testing.T, h *harness) {
+	pauseable(t, h.sender)
+	receiver := pauseable(t, h.receiver)
+	senderPaused := make(chan struct{})
+	h.senderEvents.dataQueued = func(n int) (datatransfer.Message, error) {
+		if n == pauseAt {
+			close(senderPaused)
+			return nil, datatransfer.ErrPause
+		}
+		return nil, nil
+	}
+	accepted := h.response(t, true, false)
+	h.senderEvents.requestReceived = func(request datatransfer.Request) (datatransfer.Response, error) {
+		if request.IsNew() {
+			return accepted, nil
+		}
+		if request.IsUpdate() && !request.IsPaused() {
+			return nil, datatransfer.ErrResume
+		}
+		return nil, nil
+	}
+	// the receiver pauses too, once the sender has, so that it sends the
+	// sender an update when it resumes
+	h.receiverEvents.dataReceived = func(n int) error {
+		if n == 1 {
+			select {
+			case <-senderPaused:
+			case <-h.ctx.Done():
+			}
+			return datatransfer.ErrPause
+		}
+		return nil
+	}
+	h.start(t)
+	chid := h.pull(t, nil)
+
+	h.receiverEvents.waitForCount(t, dataReceived, 1)
+	h.senderEvents.requireNoneFor(t, quietPeriod, named(dataQueued, channelCompleted))
+
+	require.NoError(t, receiver.ResumeChannel(h.ctx, message.UpdateRequest(transferID, false), chid))
+	h.senderEvents.waitFor(t, requestReceived, func(e event) bool {
+		return e.msg.IsUpdate()
+	})
+	h.complete(t)
+}
+
+func testDoNotSendCids(t *testing.T, h *harness) {
+	// the receiver already has the first half of the leaves
+	half := 1 + len(h.cids)/2
+	doNotSend := h.cids[1:half]
+	for _, c := range doNotSend {
+		nd, err := h.sender.DAGService.Get(h.ctx, c)
+		require.NoError(t, err)
+		require.NoError(t, h.receiver.DAGService.Add(h.ctx, nd))
+	}
+	h.start(t)
+	h.pull(t, doNotSend)
+	h.complete(t)
+
+	toSend := append([]cid.Cid{h.cids[0]}, h.cids[half:]...)
+	events := h.senderEvents.all()
+	requireEachBlockOnce(t, events, dataQueued, toSend)
+	requireEachBlockOnce(t, events, dataSent, toSend)
+}
+
+func testCleanupChannel(t *testing.T, h *harness) {
+	h.start(t)
+	chid := h.pull(t, nil)
+	h.complete(t)
+
+	for _, p := range []Peer{h.sender, h.receiver} {
+		p.Transport.CleanupChannel(chid)
+		requireIs(t, p.Transport.CloseChannel(h.ctx, chid), datatransfer.ErrChannelNotFound)
+		if transport, ok := p.Transport.(datatransfer.PauseableTransport); ok {
+			requireIs(t, transport.PauseChannel(h.ctx, chid), datatransfer.ErrChannelNotFound)
+			requireIs(t, transport.ResumeChannel(h.ctx, nil, chid), datatransfer.ErrChannelNotFound)
+		}
+		// cleaning up a channel twice does nothing
+		p.Transport.CleanupChannel(chid)
+	}
+}
+
+func testReceiverClosesChannel(t *testing.T, h *harness) {
+	pauseable(t, h.sender)
+	h.pauseOnDataQueued()
+	h.start(t)
+	chid := h.pull(t, nil)
+	h.senderEvents.waitForCount(t, dataQueued, pauseAt)
+
+	require.NoError(t, h.receiver.Transport.CloseChannel(h.ctx, chid))
+	h.receiverEvents.requireNoneFor(t, quietPeriod, func(e event) bool {
+		// closing a channel is not a time out
+		return succeeded(e) || e.name == requestTimedOut
+	})
+	h.senderEvents.requireNoneFor(t, quietPeriod, named(dataQueued, channelCompleted))
+}
+
+func testSenderClosesChannel(t *testing.T, h *harness) {
+	pauseable(t, h.sender)
+	h.pauseOnDataQueued()
+	h.start(t)
+	chid := h.pull(t, nil)
+	h.senderEvents.waitForCount(t, dataQueued, pauseAt)
+
+	require.NoError(t, h.sender.Transport.CloseChannel(h.ctx, chid))
+	h.senderEvents.requireNoneFor(t, quietPeriod, named(dataQueued, channelCompleted))
+	h.receiverEvents.requireNoneFor(t, quietPeriod, succeeded)
+}
+
+func testShutdown(t *testing.T, h *harness) {
+	h.start(t)
+	h.pull(t, nil)
+	h.complete(t)
+
+	require.NoError(t, h.receiver.Transport.Shutdown(h.ctx))
+	require.NoError(t, h.sender.Transport.Shutdown(h.ctx))
+}
+
+func testShutdownMidTransfer(t *testing.T, h *harness) {
+	sender := pauseable(t, h.sender)
+	h.pauseOnNewRequest(t)
+	h.start(t)
+	chid := h.pull(t, nil)
+	h.receiverEvents.waitFor(t, responseReceived, nil)
+
+	// once shut down, the receiver's handler is not called again, even when
+	// the sender resumes sending
+	require.NoError(t, h.receiver.Transport.Shutdown(h.ctx))
+	require.NoError(t, sender.ResumeChannel(h.ctx, message.UpdateResponse(transferID, false), chid))
+	h.receiverEvents.requireNoneFor(t, quietPeriod, anyEvent)
+}
+
+// harness is the peers, events and data for a test
+type harness struct {
+	ctx            context.Context
+	sender         Peer
+	receiver       Peer
+	senderEvents   *recorder
+	receiverEvents *recorder
+	dag            dttest.DAG
+	// cids are the cids of the blocks in the DAG, root first
+	cids    []cid.Cid
+	voucher *testutil.FakeDTType
+	result  *testutil.FakeDTType
+}
+
+func newHarness(ctx context.Context, t *testing.T, factory Factory) *harness {
+	sender, receiver := factory(ctx, t)
+	h := &harness{
+		ctx:            ctx,
+		sender:         sender,
+		receiver:       receiver,
+		senderEvents:   newRecorder(ctx),
+		receiverEvents: newRecorder(ctx),
+		voucher:        testutil.NewFakeDTType(),
+		result:         testutil.NewFakeDTType(),
+	}
+	h.dag = dttest.RandomDAG(ctx, t, sender.DAGService, dagSize, dttest.DefaultDAGParams)
+	seen := cid.NewSet()
+	err := merkledag.Walk(ctx, merkledag.GetLinksWithDAG(sender.DAGService), h.root(), func(c cid.Cid) bool {
+		if !seen.Visit(c) {
+			return false
+		}
+		h.cids = append(h.cids, c)
+		return true
+	})
+	require.NoError(t, err)
+
+	// by default the sender accepts requests, and carries on after updates
+	accepted := h.response(t, true, false)
+	h.senderEvents.requestReceived = func(request datatransfer.Request) (datatransfer.Response, error) {
+		if request.IsNew() {
+			return accepted, nil
+		}
+		return nil, nil
+	}
+	return h
+}
+
+func (h *harness) root() cid.Cid {
+	return h.dag.Root.(cidlink.Link).Cid
+}
+
+// request makes the request to pull the DAG
+func (h *harness) request(t *testing.T) datatransfer.Request {
+	request, err := message.NewRequest(transferID, false, true, h.voucher.Type(), h.voucher, h.root(), testutil.AllSelector())
+	require.NoError(t, err)
+	return request
+}
+
+// response makes a response to the request for the DAG
+func (h *harness) response(t *testing.T, accepted bool, paused bool) datatransfer.Response {
+	response, err := message.NewResponse(transferID, accepted, paused, h.result.Type(), h.result)
+	require.NoError(t, err)
+	return response
+}
+
+// pauseOnNewRequest has the sender accept new requests paused
+func (h *harness) pauseOnNewRequest(t *testing.T) {
+	paused := h.response(t, true, true)
+	h.senderEvents.requestReceived = func(request datatransfer.Request) (datatransfer.Response, error) {
+		if request.IsNew() {
+			return paused, datatransfer.ErrPause
+		}
+		return nil, nil
+	}
+}
+
+// pauseOnDataQueued has the sender pause once it queues pauseAt blocks
+func (h *harness) pauseOnDataQueued() {
+	h.senderEvents.dataQueued = func(n int) (datatransfer.Message, error) {
+		if n == pauseAt {
+			return nil, datatransfer.ErrPause
+		}
+		return nil, nil
+	}
+}
+
+// start sets the events handlers on the transports
+func (h *harness) start(t *testing.T) {
+	require.NoError(t, h.sender.Transport.SetEventHandler(h.senderEvents))
+	require.NoError(t, h.receiver.Transport.SetEventHandler(h.receiverEvents))
+}
+
+// pull has the receiver open a channel to pull the DAG from the sender,
+// asking it not to send the given cids
+func (h *harness) pull(t *testing.T, doNotSendCids []cid.Cid) datatransfer.ChannelID {
+	chid := datatransfer.ChannelID{Initiator: h.receiver.ID, Responder: h.sender.ID, ID: transferID}
+	err := h.receiver.Transport.OpenChannel(h.ctx, h.sender.ID, chid, h.dag.Root, testutil.AllSelector(), doNotSendCids, h.request(t))
+	require.NoError(t, err)
+	return chid
+}
+
+// push has the receiver open a channel for the sender to push the DAG on, as
+// it does once it accepts the sender's push request
+func (h *harness) push(t *testing.T) datatransfer.ChannelID {
+	chid := datatransfer.ChannelID{Initiator: h.sender.ID, Responder: h.receiver.ID, ID: transferID}
+	err := h.receiver.Transport.OpenChannel(h.ctx, h.sender.ID, chid, h.dag.Root, testutil.AllSelector(), nil, h.response(t, true, false))
+	require.NoError(t, err)
+	return chid
+}
+
+// complete waits for both ends of the channel to complete without an error,
+// and checks the receiver has the DAG
+func (h *harness) complete(t *testing.T) {
+	for _, events := range []*recorder{h.receiverEvents, h.senderEvents} {
+		completed := events.waitFor(t, channelCompleted, nil)
+		require.NoError(t, completed.err)
+	}
+	testutil.VerifyHasFile(h.ctx, t, h.receiver.DAGService, h.dag.Root, h.dag.Data)
+}
+
+// requireReceiverEvents requires the receiver's handler to have been told of
+// the channel opening first, each block once, and the channel completing last
+func (h *harness) requireReceiverEvents(t *testing.T, chid datatransfer.ChannelID) {
+	h.receiverEvents.requireNoneFor(t, quietPeriod, anyEvent)
+	events := h.receiverEvents.all()
+	requireEnds(t, events, chid, channelOpened)
+	requireEachBlockOnce(t, events, dataReceived, h.cids)
+}
+
+// requireSenderEvents requires the sender's handler to have been told of the
+// given event first, of each block being queued and then sent once, and the
+// channel completing last
+func (h *harness) requireSenderEvents(t *testing.T, chid datatransfer.ChannelID, first string) {
+	h.senderEvents.requireNoneFor(t, quietPeriod, anyEvent)
+	events := h.senderEvents.all()
+	requireEnds(t, events, chid, first)
+	requireEachBlockOnce(t, events, dataQueued, h.cids)
+	requireEachBlockOnce(t, events, dataSent, h.cids)
+	queued := cid.NewSet()
+	for _, e := range events {
+		switch e.name {
+		case dataQueued:
+			queued.Add(e.link.(cidlink.Link).Cid)
+		case dataSent:
+			c := e.link.(cidlink.Link).Cid
+			require.Truef(t, queued.Has(c), "block %s sent before it was queued", c)
+		}
+	}
+}
+
+// requireEnds requires the events to all be on the channel, to start with the
+// given event, and to end with the channel completing without an error
+func requireEnds(t *testing.T, events []event, chid datatransfer.ChannelID, first string) {
+	require.NotEmpty(t, events)
+	require.Equalf(t, first, events[0].name, "events: %s", formatEvents(events))
+	last := events[len(events)-1]
+	require.Equalf(t, channelCompleted, last.name, "events: %s", formatEvents(events))
+	require.NoError(t, last.err)
+	completions := 0
+	for _, e := range events {
+		require.Equal(t, chid, e.chid)
+		if e.name == channelCompleted {
+			completions++
+		}
+	}
+	require.Equalf(t, 1, completions, "events: %s", formatEvents(events))
+}
+
+// requireEachBlockOnce requires there to be one event with the given name for
+// each of the cids
+func requireEachBlockOnce(t *testing.T, events []event, name string, cids []cid.Cid) {
+	var got []cid.Cid
+	for _, e := range events {
+		if e.name == name {
+			got = append(got, e.link.(cidlink.Link).Cid)
+		}
+	}
+	require.ElementsMatchf(t, cids, got, "blocks in %s", name)
+}
+
+func requireIs(t *testing.T, err error, target error) {
+	require.Truef(t, xerrors.Is(err, target), "expected %q, got %v", target, err)
+}
+
+// pauseable returns the peer's transport as a PauseableTransport, or skips
+// the test if it is not one
+func pauseable(t *testing.T, p Peer) datatransfer.PauseableTransport {
+	transport, ok := p.Transport.(datatransfer.PauseableTransport)
+	if !ok {
+		t.Skip("transport cannot pause channels")
+	}
+	return transport
+}
@@ -2,10 +2,15 @@ package datatransfer
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-data-transfer/encoding"
 )
@@ -53,6 +58,27 @@ func (c ChannelID) String() string {
 	return fmt.Sprintf("%s-%s-%d", c.Initiator, c.Responder, c.ID)
 }
 
+// ParseChannelID parses a channel ID in the form written by String
+func ParseChannelID(s string) (ChannelID, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 3 {
+		return ChannelID{}, xerrors.Errorf("channel id %q is not <initiator>-<responder>-<transfer id>", s)
+	}
+	initiator, err := peer.Decode(parts[0])
+	if err != nil {
+		return ChannelID{}, xerrors.Errorf("parsing initiator: %w", err)
+	}
+	responder, err := peer.Decode(parts[1])
+	if err != nil {
+		return ChannelID{}, xerrors.Errorf("parsing responder: %w", err)
+	}
+	id, err := strconv.ParseUint(parts[2], 10, 64)
+	if err != nil {
+		return ChannelID{}, xerrors.Errorf("parsing transfer id: %w", err)
+	}
+	return ChannelID{Initiator: initiator, Responder: responder, ID: TransferID(id)}, nil
+}
+
 // OtherParty returns the peer on the other side of the request, depending
 // on whether this peer is the initiator or responder
 func (c ChannelID) OtherParty(thisPeer peer.ID) peer.ID {
@@ -130,6 +156,44 @@ type ChannelState interface {
 	// ReceivedCids returns the cids received so far on the channel
 	ReceivedCids() []cid.Cid
 
+	// ReceivedCidsLen returns the number of cids received so far on the
+	// channel, without reading them all
+	ReceivedCidsLen() int
+
 	// Queued returns the number of bytes read from the node and queued for sending
 	Queued() uint64
+
+	// RemoveTimeout returns the remove timeout the channel was opened with
+	// (zero means the manager default applies)
+	RemoveTimeout() time.Duration
+
+	// Labels returns the user defined labels attached to this channel
+	Labels() map[string]string
+
+	// Priority returns the priority the channel was opened with
+	Priority() int64
+
+	// PeerAddrs returns the addresses of the other peer the channel was
+	// opened with
+	PeerAddrs() []ma.Multiaddr
+
+	// ErrorCode returns the kind of error the channel failed with, or
+	// NoError if it has not failed
+	ErrorCode() ErrorCode
+
+	// Retryable returns whether restarting the channel may recover from
+	// the error it failed with
+	Retryable() bool
+
+	// Reason returns the reason given by either party for rejecting or
+	// cancelling the transfer, if any
+	Reason() Reason
+
+	// RestartAttempts returns the number of times the manager has tried to
+	// restart the channel automatically since data last moved on it
+	RestartAttempts() uint64
+
+	// LastRestartError returns the error from the last automatic restart
+	// attempt, or an empty string if it succeeded
+	LastRestartError() string
 }